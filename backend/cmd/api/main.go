@@ -2,9 +2,14 @@ package main
 
 import (
 	"car-rental-backend/internal/database"
+	"car-rental-backend/internal/events"
 	"car-rental-backend/internal/handlers"
+	"car-rental-backend/internal/jobs"
+	"car-rental-backend/internal/mail"
+	"car-rental-backend/internal/messaging"
 	"car-rental-backend/internal/middleware"
 	"car-rental-backend/internal/models"
+	"car-rental-backend/internal/realtime"
 	"car-rental-backend/internal/seeder"
 	"log"
 	"net/http"
@@ -23,10 +28,38 @@ func main() {
 		seeder.Seed()
 	}
 
-	r := gin.Default()
+	// Bring existing tenant databases up to the current schema before the jobs use them
+	database.MigrateAllTenants()
+
+	mailer := mail.FromEnv()
+	handlers.SetMailer(mailer)
+	if !handlers.SharedBlacklistEnabled() {
+		log.Println("[RISK] BLACKLIST_HASH_SECRET not set, the shared blacklist is disabled")
+	}
+	messenger := messaging.FromEnv()
+	handlers.SetMessenger(messenger)
+	handlers.SubscribeNotifications(events.Default)
+	handlers.SubscribeWebhooks(events.Default)
+	hub := realtime.NewHub()
+	handlers.SetRealtime(hub, realtime.FromEnv(database.DB, hub))
+	handlers.SubscribeRealtime(events.Default)
+
+	// Background jobs
+	jobs.StartDunning(jobs.OutboxSender{})
+	jobs.StartRecurringExpenses()
+	jobs.StartWeeklyReports()
+	jobs.StartMailOutbox(mailer)
+	jobs.StartMessaging(messenger)
+	jobs.StartServiceReminders()
+	jobs.StartWebhooks()
+	jobs.StartPlatformStats()
+	jobs.MigrateReceipts()
+
+	r := gin.New()
 
 	// Middleware
-	// gin.Default() already includes gin.Logger() and gin.Recovery()
+	// Same as gin.Default(), but the access log redacts event stream tokens
+	r.Use(gin.LoggerWithFormatter(middleware.LogFormatter), gin.Recovery())
 	r.Use(middleware.CORSMiddleware())
 	r.Use(middleware.RateLimitMiddleware())
 	// Note: TenantMiddleware is NOT applied globally anymore
@@ -37,18 +70,32 @@ func main() {
 	{
 		// auth.POST("/register", handlers.Register) // Disabled: Public registration is closed
 		auth.POST("/login", handlers.Login)
+		auth.POST("/forgot-password", handlers.ForgotPassword)
+		auth.POST("/reset-password", handlers.ResetPassword)
 	}
 
+	// The event stream also accepts a stream token in the URL, as EventSource can't send headers
+	stream := r.Group("/api/v1")
+	stream.Use(middleware.StreamAuthMiddleware())
+	stream.Use(middleware.TenantContextMiddleware())
+	stream.GET("/events", handlers.StreamEvents)
+
 	protected := r.Group("/api/v1")
 	protected.Use(middleware.AuthMiddleware())
 	protected.Use(middleware.TenantContextMiddleware())
 	{
 		protected.GET("/me", handlers.Me)
+		protected.GET("/me/report-preferences", handlers.GetReportPreferences)
+		protected.PUT("/me/report-preferences", handlers.UpdateReportPreferences)
+		protected.GET("/dashboard", handlers.GetDashboard)
 
 		protected.GET("/cars", handlers.GetCars)
 		protected.POST("/cars", handlers.CreateCar)
 		protected.PUT("/cars/:id", handlers.UpdateCar)
 		protected.DELETE("/cars/:id", handlers.DeleteCar)
+		protected.GET("/cars/:id/blocks", handlers.GetCarBlocks)
+		protected.POST("/cars/:id/blocks", handlers.CreateCarBlock)
+		protected.DELETE("/cars/:id/blocks/:blockId", handlers.DeleteCarBlock)
 
 		protected.GET("/bookings", handlers.GetBookings)
 		protected.POST("/bookings", handlers.CreateBooking)
@@ -56,6 +103,36 @@ func main() {
 
 		protected.GET("/customers", handlers.GetCustomers)
 		protected.POST("/customers", handlers.CreateCustomer)
+		protected.GET("/customers/analytics", handlers.GetCustomerAnalytics)
+		protected.GET("/customers/segments", handlers.GetCustomerSegments)
+		protected.GET("/customers/flags", handlers.GetCustomerFlags)
+		protected.POST("/customers/flags", handlers.CreateCustomerFlag)
+		protected.PUT("/customers/flags/:flagId/lift", handlers.LiftCustomerFlag)
+		protected.GET("/customers/risk-settings", handlers.GetRiskSettings)
+		protected.PUT("/customers/risk-settings", handlers.UpdateRiskSettings)
+		protected.GET("/loyalty/settings", handlers.GetLoyaltySettings)
+		protected.PUT("/loyalty/settings", handlers.UpdateLoyaltySettings)
+		protected.GET("/promo-codes", handlers.GetPromoCodes)
+		protected.POST("/promo-codes", handlers.CreatePromoCode)
+		protected.PUT("/promo-codes/:id", handlers.UpdatePromoCode)
+		protected.GET("/customers/:id", handlers.GetCustomer)
+		protected.PUT("/customers/:id", handlers.UpdateCustomer)
+		protected.DELETE("/customers/:id", handlers.DeleteCustomer)
+		protected.GET("/customers/:id/duplicates", handlers.GetCustomerDuplicates)
+		protected.POST("/customers/:id/merge", handlers.MergeCustomers)
+		protected.GET("/customers/:id/documents", handlers.GetCustomerDocuments)
+		protected.POST("/customers/:id/documents", handlers.UploadCustomerDocument)
+		protected.GET("/customers/:id/documents/:docId/file", handlers.GetCustomerDocumentFile)
+		protected.DELETE("/customers/:id/documents/:docId", handlers.DeleteCustomerDocument)
+		protected.GET("/customers/:id/flags", handlers.GetCustomerFlags)
+		protected.POST("/customers/:id/flags", handlers.CreateCustomerFlag)
+		protected.GET("/customers/:id/loyalty", handlers.GetCustomerLoyalty)
+		protected.GET("/customers/:id/timeline", handlers.GetCustomerTimeline)
+		protected.POST("/customers/:id/notes", handlers.CreateCustomerNote)
+		protected.DELETE("/customers/:id/notes/:noteId", handlers.DeleteCustomerNote)
+		protected.POST("/customers/:id/loyalty/adjust", handlers.AdjustCustomerLoyalty)
+		protected.GET("/customers/:id/incidents", handlers.GetCustomerIncidents)
+		protected.POST("/customers/:id/incidents", handlers.CreateCustomerIncident)
 
 		// Staff management
 		protected.GET("/staff", handlers.GetStaff)
@@ -66,15 +143,75 @@ func main() {
 
 		protected.GET("/financials/expenses", handlers.GetExpenses)
 		protected.POST("/financials/expenses", handlers.CreateExpense)
+		protected.GET("/financials/expenses/:id", handlers.GetExpense)
+		protected.PUT("/financials/expenses/:id", handlers.UpdateExpense)
+		protected.DELETE("/financials/expenses/:id", handlers.DeleteExpense)
+		protected.POST("/financials/expenses/:id/receipt", handlers.UploadExpenseReceipt)
+		protected.GET("/financials/expenses/:id/receipt", handlers.GetExpenseReceipt)
+		protected.GET("/financials/expense-categories", handlers.GetExpenseCategories)
+		protected.POST("/financials/expense-categories", handlers.CreateExpenseCategory)
+		protected.PUT("/financials/expense-categories/:id", handlers.UpdateExpenseCategory)
+		protected.DELETE("/financials/expense-categories/:id", handlers.DeleteExpenseCategory)
+		protected.GET("/financials/recurring-expenses", handlers.GetRecurringExpenses)
+		protected.POST("/financials/recurring-expenses", handlers.CreateRecurringExpense)
+		protected.PUT("/financials/recurring-expenses/:id", handlers.UpdateRecurringExpense)
+		protected.DELETE("/financials/recurring-expenses/:id", handlers.DeleteRecurringExpense)
 		protected.GET("/financials/invoices", handlers.GetInvoices)
 		protected.POST("/financials/invoices", handlers.GenerateInvoice)
 		protected.GET("/financials/stats", handlers.GetRevenueStats)
+		protected.GET("/financials/pnl", handlers.GetProfitAndLoss)
+		protected.GET("/financials/cashflow", handlers.GetCashFlow)
+		protected.GET("/financials/invoices/:id/lines", handlers.GetInvoiceLines)
+		protected.GET("/emails", handlers.GetEmailOutbox)
+		protected.POST("/emails/:id/retry", handlers.RetryEmail)
+		protected.GET("/messaging/settings", handlers.GetMessagingSettings)
+		protected.PUT("/messaging/settings", handlers.UpdateMessagingSettings)
+		protected.GET("/messaging/messages", handlers.GetMessageOutbox)
+		protected.POST("/messaging/messages/:id/retry", handlers.RetryMessage)
+
+		// Outgoing webhooks
+		protected.GET("/webhooks", handlers.GetWebhookEndpoints)
+		protected.POST("/webhooks", middleware.RoleMiddleware("admin"), handlers.CreateWebhookEndpoint)
+		protected.GET("/webhooks/events", handlers.GetWebhookEvents)
+		protected.GET("/webhooks/deliveries", middleware.RoleMiddleware("admin"), handlers.GetWebhookDeliveries)
+		protected.POST("/webhooks/deliveries/:id/replay", middleware.RoleMiddleware("admin"), handlers.ReplayWebhookDelivery)
+		protected.PUT("/webhooks/:id", middleware.RoleMiddleware("admin"), handlers.UpdateWebhookEndpoint)
+		protected.DELETE("/webhooks/:id", middleware.RoleMiddleware("admin"), handlers.DeleteWebhookEndpoint)
+		protected.POST("/webhooks/:id/rotate-secret", middleware.RoleMiddleware("admin"), handlers.RotateWebhookSecret)
+		protected.GET("/financials/invoices/:id/payments", handlers.GetInvoicePayments)
+		protected.POST("/financials/invoices/:id/payments", handlers.RecordPayment)
+		protected.GET("/financials/aging", handlers.GetReceivablesAging)
+		protected.GET("/financials/dunning", handlers.GetDunningSettings)
+		protected.PUT("/financials/dunning", handlers.UpdateDunningSettings)
+		protected.GET("/financials/dunning/reminders", handlers.GetDunningReminders)
+		protected.GET("/financials/currency", handlers.GetCurrencySettings)
+		protected.PUT("/financials/currency", middleware.RoleMiddleware("admin"), handlers.UpdateCurrencySettings)
+		protected.GET("/financials/exchange-rates", handlers.GetExchangeRates)
+		protected.POST("/financials/exchange-rates", handlers.CreateExchangeRate)
+		protected.POST("/financials/exchange-rates/import", handlers.ImportExchangeRates)
+		protected.DELETE("/financials/exchange-rates/:id", handlers.DeleteExchangeRate)
+		protected.GET("/financials/accounting", handlers.GetAccountingSettings)
+		// Only the tenant's admin may move or lift the period lock
+		protected.PUT("/financials/accounting", middleware.RoleMiddleware("admin"), handlers.UpdateAccountingSettings)
+		protected.PUT("/financials/accounting/close", middleware.RoleMiddleware("admin"), handlers.ClosePeriod)
+		protected.GET("/financials/journal", handlers.ExportJournal)
 
 		protected.GET("/notifications", handlers.GetNotifications)
+		protected.GET("/notifications/unread-count", handlers.GetUnreadNotificationCount)
+		protected.PUT("/notifications/read-all", handlers.MarkAllNotificationsRead)
 		protected.PUT("/notifications/:id/read", handlers.MarkNotificationRead)
+		protected.PUT("/notifications/:id/archive", handlers.ArchiveNotification)
+		protected.PUT("/notifications/:id/unarchive", handlers.UnarchiveNotification)
+		protected.DELETE("/notifications/:id", handlers.DeleteNotification)
+		protected.POST("/events/token", handlers.CreateStreamToken)
+		protected.GET("/notifications/preferences", handlers.GetNotificationPreferences)
+		protected.PUT("/notifications/preferences", handlers.UpdateNotificationPreferences)
 
 		protected.GET("/reports/utilization", handlers.GetFleetUtilization)
 		protected.GET("/reports/revenue-by-car", handlers.GetRevenueByCar)
+		protected.GET("/reports/profitability", handlers.GetVehicleProfitability)
+		protected.GET("/reports/forecast", handlers.GetRevenueForecast)
+		protected.GET("/reports/weekly", handlers.GetWeeklyReport)
 
 		// Image upload
 		protected.POST("/cars/upload-image", handlers.UploadCarImage)
@@ -97,12 +234,36 @@ func main() {
 	public := r.Group("/api/v1/public")
 	{
 		public.POST("/booking-request", handlers.CreatePublicBookingRequest)
+		public.POST("/promo-codes/validate", handlers.ValidatePublicPromoCode)
+		public.GET("/messaging/:channel/status", handlers.VerifyMessagingWebhook)
+		public.POST("/messaging/:channel/status", handlers.MessagingStatusWebhook)
 		// Subdomain-based public routes
 		public.GET("/landing/:subdomain", handlers.GetPublicLandingBySubdomain)
 		public.GET("/cars/:subdomain", handlers.GetPublicCarsBySubdomain)
 		public.GET("/cars/:subdomain/:carId", handlers.GetPublicCarDetail)
 	}
 
+	// Customer portal: login with a one-time code or magic link (tenant from X-Subdomain)
+	portal := r.Group("/api/v1/portal")
+	{
+		portal.POST("/login", handlers.PortalLogin)
+		portal.POST("/verify", handlers.PortalVerify)
+	}
+
+	// Customer portal routes (customer token required)
+	portalAuth := r.Group("/api/v1/portal")
+	portalAuth.Use(middleware.CustomerAuthMiddleware())
+	{
+		portalAuth.GET("/me", handlers.GetPortalMe)
+		portalAuth.GET("/requests", handlers.GetPortalRequests)
+		portalAuth.POST("/requests/:id/cancel", handlers.CancelPortalRequest)
+		portalAuth.GET("/bookings", handlers.GetPortalBookings)
+		portalAuth.POST("/bookings/:id/cancel", handlers.RequestPortalCancellation)
+		portalAuth.GET("/invoices", handlers.GetPortalInvoices)
+		portalAuth.GET("/invoices/:id/download", handlers.DownloadPortalInvoice)
+		portalAuth.POST("/documents", handlers.UploadPortalDocument)
+	}
+
 	// Site routes - admin preview (requires auth, uses tenant context)
 	site := r.Group("/api/v1/site")
 	site.Use(middleware.AuthMiddleware())
@@ -130,6 +291,8 @@ func main() {
 		admin.POST("/tenants/:id/impersonate", handlers.ImpersonateTenant)
 		admin.DELETE("/tenants/:id", handlers.DeleteTenant)
 		admin.GET("/stats", handlers.GetAdminStats)
+		admin.GET("/stats/tenants", handlers.GetTenantStats)
+		admin.POST("/stats/refresh", handlers.RefreshPlatformStatsHandler)
 		admin.PATCH("/tenants/:id/subscription", handlers.UpdateTenantSubscription)
 	}
 
@@ -135,6 +135,31 @@ func MigrateTenantDB(dbName string) error {
 	return nil
 }
 
+// MigrateAllTenants applies the schema to every tenant database, so that tenants created before a schema
+// change get its new tables and columns. Failures are logged and the other tenants are still migrated.
+func MigrateAllTenants() {
+	rows, err := DB.Query(context.Background(), "SELECT db_name FROM tenants")
+	if err != nil {
+		log.Printf("Unable to list tenants to migrate: %v\n", err)
+		return
+	}
+	var dbNames []string
+	for rows.Next() {
+		var dbName string
+		if err := rows.Scan(&dbName); err == nil {
+			dbNames = append(dbNames, dbName)
+		}
+	}
+	rows.Close()
+
+	for _, dbName := range dbNames {
+		if err := MigrateTenantDB(dbName); err != nil {
+			log.Printf("Unable to migrate tenant DB %s: %v\n", dbName, err)
+		}
+	}
+	fmt.Printf("Migrated %d tenant databases\n", len(dbNames))
+}
+
 func GetTenantDB(dbName string) (*pgxpool.Pool, error) {
 	poolMutex.RLock()
 	pool, exists := tenantPools[dbName]
@@ -179,3 +179,580 @@ CREATE TABLE IF NOT EXISTS booking_requests (
 
 CREATE INDEX IF NOT EXISTS idx_booking_requests_tenant ON booking_requests(tenant_id);
 CREATE INDEX IF NOT EXISTS idx_booking_requests_status ON booking_requests(status);
+
+-- Payments received against invoices
+CREATE TABLE IF NOT EXISTS payments (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id),
+    invoice_id UUID REFERENCES invoices(id) ON DELETE CASCADE,
+    amount DECIMAL(10, 2) NOT NULL,
+    method VARCHAR(30) DEFAULT 'cash',
+    reference TEXT,
+    paid_at DATE NOT NULL DEFAULT CURRENT_DATE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_payments_invoice_id ON payments(invoice_id);
+CREATE INDEX IF NOT EXISTS idx_invoices_due_date ON invoices(due_date);
+
+-- Dunning (overdue invoice reminder) settings per tenant
+CREATE TABLE IF NOT EXISTS dunning_settings (
+    tenant_id UUID PRIMARY KEY,
+    enabled BOOLEAN DEFAULT true,
+    intervals JSONB DEFAULT '[1, 7, 14, 30]'::jsonb, -- days overdue at which a reminder is sent
+    send_email BOOLEAN DEFAULT true,
+    send_sms BOOLEAN DEFAULT false,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Log of dunning reminders already sent, one row per invoice/stage/channel
+CREATE TABLE IF NOT EXISTS dunning_reminders (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id),
+    invoice_id UUID REFERENCES invoices(id) ON DELETE CASCADE,
+    stage INT NOT NULL,
+    channel VARCHAR(10) NOT NULL, -- email, sms
+    recipient VARCHAR(255),
+    status VARCHAR(20) DEFAULT 'sent', -- pending, sent, failed
+    error TEXT,
+    sent_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (invoice_id, stage, channel)
+);
+
+-- Multi-currency: tenant base currency and exchange rates
+CREATE TABLE IF NOT EXISTS financial_settings (
+    tenant_id UUID PRIMARY KEY,
+    base_currency VARCHAR(3) NOT NULL DEFAULT 'MAD',
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- rate = how many units of base_currency one unit of currency is worth on rate_date
+CREATE TABLE IF NOT EXISTS exchange_rates (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id),
+    currency VARCHAR(3) NOT NULL,
+    base_currency VARCHAR(3) NOT NULL,
+    rate DECIMAL(18, 8) NOT NULL CHECK (rate > 0),
+    rate_date DATE NOT NULL,
+    source VARCHAR(20) DEFAULT 'manual', -- manual, import
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (currency, base_currency, rate_date)
+);
+
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS exchange_rate DECIMAL(18, 8);
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS currency VARCHAR(3) DEFAULT 'MAD';
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS exchange_rate DECIMAL(18, 8);
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS currency VARCHAR(3);
+
+-- fx_rate returns the most recent rate on or before on_date (1 for same currency, NULL if unknown)
+CREATE OR REPLACE FUNCTION fx_rate(from_currency VARCHAR, to_currency VARCHAR, on_date DATE) RETURNS DECIMAL AS $$
+    SELECT CASE
+        WHEN from_currency IS NULL OR from_currency = to_currency THEN 1
+        ELSE (SELECT rate FROM exchange_rates
+              WHERE currency = from_currency AND base_currency = to_currency AND rate_date <= on_date
+              ORDER BY rate_date DESC LIMIT 1)
+    END
+$$ LANGUAGE SQL STABLE;
+
+-- Stored booking/invoice rates are only valid for the base currency they were fixed in
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS exchange_rate_base VARCHAR(3);
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS exchange_rate_base VARCHAR(3);
+UPDATE bookings SET exchange_rate_base = COALESCE((SELECT base_currency FROM financial_settings LIMIT 1), 'MAD')
+ WHERE exchange_rate IS NOT NULL AND exchange_rate_base IS NULL;
+UPDATE invoices SET exchange_rate_base = COALESCE((SELECT base_currency FROM financial_settings LIMIT 1), 'MAD')
+ WHERE exchange_rate IS NOT NULL AND exchange_rate_base IS NULL;
+
+-- Expenses without a currency were entered in the base currency; new ones always store theirs
+UPDATE expenses SET currency = COALESCE((SELECT base_currency FROM financial_settings LIMIT 1), 'MAD')
+ WHERE currency IS NULL;
+ALTER TABLE expenses ALTER COLUMN currency SET NOT NULL;
+
+-- stored_rate returns a stored rate when it was fixed in to_currency, NULL otherwise (fall back to fx_rate)
+CREATE OR REPLACE FUNCTION stored_rate(rate DECIMAL, rate_base VARCHAR, to_currency VARCHAR) RETURNS DECIMAL AS $$
+    SELECT CASE WHEN rate_base = to_currency THEN rate END
+$$ LANGUAGE SQL IMMUTABLE;
+
+-- Expense categories (replaces free-text expenses.category, which is kept as the category name)
+CREATE TABLE IF NOT EXISTS expense_categories (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id),
+    name VARCHAR(100) UNIQUE NOT NULL,
+    description TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Recurring expenses (leasing, insurance...) generate an expense on each due date
+CREATE TABLE IF NOT EXISTS recurring_expenses (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id),
+    category_id UUID REFERENCES expense_categories(id),
+    car_id UUID REFERENCES cars(id) ON DELETE SET NULL,
+    amount DECIMAL(10, 2) NOT NULL,
+    currency VARCHAR(3),
+    description TEXT,
+    frequency VARCHAR(20) DEFAULT 'monthly' CHECK (frequency IN ('weekly', 'monthly', 'quarterly', 'yearly')),
+    start_date DATE NOT NULL,
+    end_date DATE,
+    next_run_date DATE NOT NULL,
+    active BOOLEAN DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS category_id UUID REFERENCES expense_categories(id);
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS car_id UUID REFERENCES cars(id) ON DELETE SET NULL;
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS receipt_url TEXT;
+-- Receipt file, relative to DOCUMENTS_DIR; receipt_url is then the authenticated download route
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS receipt_path TEXT;
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS recurring_expense_id UUID REFERENCES recurring_expenses(id) ON DELETE SET NULL;
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW();
+
+-- Like expenses, a recurring expense keeps the currency it was created in, even if the base currency changes
+UPDATE recurring_expenses SET currency = COALESCE((SELECT base_currency FROM financial_settings LIMIT 1), 'MAD')
+ WHERE currency IS NULL;
+ALTER TABLE recurring_expenses ALTER COLUMN currency SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_expenses_date ON expenses(date);
+CREATE INDEX IF NOT EXISTS idx_expenses_car_id ON expenses(car_id);
+
+-- Vehicle acquisition data used for depreciation and ROI (amounts in the car's currency)
+ALTER TABLE cars ADD COLUMN IF NOT EXISTS purchase_price DECIMAL(12, 2);
+ALTER TABLE cars ADD COLUMN IF NOT EXISTS purchase_date DATE;
+ALTER TABLE cars ADD COLUMN IF NOT EXISTS expected_resale_value DECIMAL(12, 2);
+ALTER TABLE cars ADD COLUMN IF NOT EXISTS expected_resale_date DATE;
+
+-- Car blocks take a car out of service for a date range (maintenance, repair, private use)
+CREATE TABLE IF NOT EXISTS car_blocks (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id),
+    car_id UUID REFERENCES cars(id) ON DELETE CASCADE,
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL,
+    reason VARCHAR(50) DEFAULT 'maintenance',
+    notes TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CHECK (end_date >= start_date)
+);
+
+CREATE INDEX IF NOT EXISTS idx_car_blocks_car_id ON car_blocks(car_id);
+CREATE INDEX IF NOT EXISTS idx_bookings_car_dates ON bookings(car_id, start_date, end_date);
+
+-- Accounting export settings (chart of accounts codes, journals) and period close lock
+CREATE TABLE IF NOT EXISTS accounting_settings (
+    tenant_id UUID PRIMARY KEY,
+    receivable_account VARCHAR(20) DEFAULT '411000',
+    revenue_account VARCHAR(20) DEFAULT '706000',
+    cash_account VARCHAR(20) DEFAULT '530000',
+    bank_account VARCHAR(20) DEFAULT '512000',
+    expense_account VARCHAR(20) DEFAULT '606000',
+    expense_accounts JSONB DEFAULT '{}', -- expense category name -> account code
+    sales_journal VARCHAR(10) DEFAULT 'VE',
+    purchase_journal VARCHAR(10) DEFAULT 'HA',
+    bank_journal VARCHAR(10) DEFAULT 'BQ',
+    cash_journal VARCHAR(10) DEFAULT 'CA',
+    closed_until DATE, -- Financial records dated on or before this day can no longer be changed
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Exchange differences between invoicing and payment of foreign currency invoices
+ALTER TABLE accounting_settings ADD COLUMN IF NOT EXISTS fx_gain_account VARCHAR(20) DEFAULT '766000';
+ALTER TABLE accounting_settings ADD COLUMN IF NOT EXISTS fx_loss_account VARCHAR(20) DEFAULT '666000';
+
+-- Next scheduled service, shown on the dashboard when it is close
+ALTER TABLE cars ADD COLUMN IF NOT EXISTS next_service_date DATE;
+
+-- Incidents recorded against customers (damage, late returns, fines...)
+CREATE TABLE IF NOT EXISTS customer_incidents (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id),
+    customer_id UUID REFERENCES customers(id) ON DELETE CASCADE,
+    booking_id UUID REFERENCES bookings(id) ON DELETE SET NULL,
+    type VARCHAR(30) DEFAULT 'other' CHECK (type IN ('damage', 'late_return', 'traffic_fine', 'no_show', 'other')),
+    description TEXT,
+    amount DECIMAL(10, 2),
+    occurred_at DATE DEFAULT CURRENT_DATE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_customer_incidents_customer ON customer_incidents(customer_id);
+CREATE INDEX IF NOT EXISTS idx_bookings_customer_id ON bookings(customer_id);
+
+-- Report emails each user opted in to
+CREATE TABLE IF NOT EXISTS report_preferences (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    tenant_id UUID REFERENCES tenants(id),
+    weekly_report BOOLEAN DEFAULT false,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Log of report emails already sent, one row per user/report/period
+CREATE TABLE IF NOT EXISTS report_deliveries (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    report VARCHAR(30) NOT NULL, -- weekly
+    period_start DATE NOT NULL,
+    recipient VARCHAR(255),
+    status VARCHAR(20) DEFAULT 'sent', -- pending, sent (queued in the email outbox), failed
+    error TEXT,
+    sent_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (user_id, report, period_start)
+);
+
+-- Master DB: per-tenant activity snapshot for platform analytics, refreshed by a background job
+CREATE TABLE IF NOT EXISTS tenant_stats_snapshots (
+    tenant_id UUID PRIMARY KEY REFERENCES tenants(id) ON DELETE CASCADE,
+    cars INT NOT NULL DEFAULT 0,
+    bookings INT NOT NULL DEFAULT 0,
+    bookings_30d INT NOT NULL DEFAULT 0,
+    booking_requests INT NOT NULL DEFAULT 0,
+    booking_requests_30d INT NOT NULL DEFAULT 0,
+    gmv DECIMAL(14, 2) NOT NULL DEFAULT 0,
+    gmv_30d DECIMAL(14, 2) NOT NULL DEFAULT 0,
+    currency VARCHAR(3) NOT NULL DEFAULT 'MAD',
+    active_users_30d INT NOT NULL DEFAULT 0,
+    last_activity_at TIMESTAMP WITH TIME ZONE,
+    active BOOLEAN NOT NULL DEFAULT false,
+    error TEXT, -- Last refresh failure, cleared on success
+    refreshed_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Customer management: license number, soft delete and merges
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS license_number VARCHAR(50);
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS merged_into UUID REFERENCES customers(id);
+CREATE INDEX IF NOT EXISTS idx_customers_created_at ON customers(created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_customers_email_lower ON customers(LOWER(email));
+
+-- Customer identity documents (CIN, passport, driving license). Files are stored outside /uploads.
+CREATE TABLE IF NOT EXISTS customer_documents (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id),
+    customer_id UUID REFERENCES customers(id) ON DELETE CASCADE,
+    type VARCHAR(30) NOT NULL CHECK (type IN ('cin', 'passport', 'driving_license', 'other')),
+    number VARCHAR(100),
+    expires_at DATE,
+    file_path TEXT NOT NULL, -- Relative to DOCUMENTS_DIR
+    original_name TEXT,
+    content_type VARCHAR(100),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_customer_documents_customer ON customer_documents(customer_id);
+
+-- Customer risk flags: blacklist (blocks bookings), extra deposit or watch (warnings)
+CREATE TABLE IF NOT EXISTS customer_flags (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id),
+    customer_id UUID REFERENCES customers(id) ON DELETE SET NULL,
+    level VARCHAR(20) NOT NULL CHECK (level IN ('blacklist', 'extra_deposit', 'watch')),
+    reason TEXT NOT NULL,
+    phone VARCHAR(20),      -- Last digits of the phone number
+    email VARCHAR(255),     -- Lowercased
+    id_number VARCHAR(100), -- CIN, passport or license number, uppercased alphanumerics
+    created_by UUID,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    lifted_at TIMESTAMP WITH TIME ZONE,
+    lifted_by UUID
+);
+
+CREATE INDEX IF NOT EXISTS idx_customer_flags_customer ON customer_flags(customer_id) WHERE lifted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS risk_settings (
+    tenant_id UUID PRIMARY KEY,
+    share_blacklist BOOLEAN DEFAULT false, -- Share and check hashed blacklist identifiers with other tenants
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Master DB: hashed identifiers of blacklisted customers shared by opted-in tenants
+CREATE TABLE IF NOT EXISTS shared_blacklist (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE,
+    flag_id UUID NOT NULL,
+    identifier_hash VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (flag_id, identifier_hash)
+);
+
+CREATE INDEX IF NOT EXISTS idx_shared_blacklist_hash ON shared_blacklist(identifier_hash);
+
+-- Customer portal: one-time login codes / magic links, sent to an email or phone
+CREATE TABLE IF NOT EXISTS portal_login_codes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id),
+    contact_type VARCHAR(10) NOT NULL CHECK (contact_type IN ('email', 'phone')),
+    contact VARCHAR(255) NOT NULL, -- Normalized email or last phone digits
+    code_hash VARCHAR(64) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    attempts INT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_portal_login_codes_contact ON portal_login_codes(contact_type, contact);
+
+-- Cancellation requested by the customer from the portal, for staff to act on
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS cancellation_requested_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS cancellation_reason TEXT;
+
+-- Promo codes redeemable on staff bookings and public booking requests
+CREATE TABLE IF NOT EXISTS promo_codes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id),
+    code VARCHAR(50) NOT NULL, -- Stored upper case
+    description TEXT,
+    discount_type VARCHAR(20) NOT NULL CHECK (discount_type IN ('percentage', 'fixed')),
+    value DECIMAL(10, 2) NOT NULL CHECK (value > 0),
+    currency VARCHAR(3), -- Currency of fixed discounts; they only apply to bookings in that currency
+    valid_from DATE,
+    valid_until DATE,
+    max_uses INT, -- NULL = unlimited
+    max_uses_per_customer INT,
+    used_count INT NOT NULL DEFAULT 0,
+    categories TEXT[] NOT NULL DEFAULT '{}', -- Car categories the code applies to, empty = all
+    active BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (tenant_id, code)
+);
+
+-- A use of a promo code by a booking or a pending booking request
+CREATE TABLE IF NOT EXISTS promo_redemptions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id),
+    promo_code_id UUID REFERENCES promo_codes(id) ON DELETE CASCADE,
+    booking_id UUID REFERENCES bookings(id) ON DELETE CASCADE,
+    booking_request_id UUID REFERENCES booking_requests(id) ON DELETE CASCADE,
+    customer_id UUID REFERENCES customers(id),
+    customer_phone VARCHAR(20), -- Last phone digits, for per-customer limits on public requests
+    amount DECIMAL(10, 2) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_promo_redemptions_code ON promo_redemptions(promo_code_id);
+
+-- Loyalty program settings per tenant (amounts in the base currency)
+CREATE TABLE IF NOT EXISTS loyalty_settings (
+    tenant_id UUID PRIMARY KEY,
+    enabled BOOLEAN DEFAULT false,
+    points_per_unit DECIMAL(10, 4) DEFAULT 1, -- Points earned per unit of base currency of a completed booking
+    point_value DECIMAL(10, 4) DEFAULT 0.1, -- Discount in base currency per redeemed point
+    min_redeem_points INT DEFAULT 100,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Loyalty points ledger; a customer's balance is the sum of their entries
+CREATE TABLE IF NOT EXISTS loyalty_transactions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id),
+    customer_id UUID REFERENCES customers(id),
+    booking_id UUID REFERENCES bookings(id) ON DELETE SET NULL,
+    points INT NOT NULL, -- Positive when earned, negative when redeemed
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('earned', 'redeemed', 'refunded', 'reversed', 'adjusted')),
+    note TEXT,
+    created_by UUID,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_loyalty_transactions_customer ON loyalty_transactions(customer_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_loyalty_earned_once ON loyalty_transactions(booking_id) WHERE kind = 'earned';
+
+-- Discounts applied to a booking; price_per_day is the price after discounts
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS promo_code_id UUID REFERENCES promo_codes(id);
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS promo_discount DECIMAL(10, 2) NOT NULL DEFAULT 0;
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS loyalty_points_redeemed INT NOT NULL DEFAULT 0;
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS loyalty_discount DECIMAL(10, 2) NOT NULL DEFAULT 0;
+
+ALTER TABLE booking_requests ADD COLUMN IF NOT EXISTS promo_code_id UUID REFERENCES promo_codes(id);
+ALTER TABLE booking_requests ADD COLUMN IF NOT EXISTS promo_discount DECIMAL(10, 2) NOT NULL DEFAULT 0;
+
+-- Invoice lines: the rental and the discounts that make up the invoice amount
+CREATE TABLE IF NOT EXISTS invoice_lines (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id),
+    invoice_id UUID REFERENCES invoices(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('rental', 'promo', 'loyalty', 'other')),
+    description TEXT NOT NULL,
+    amount DECIMAL(10, 2) NOT NULL, -- Negative for discounts
+    position INT NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_invoice_lines_invoice_id ON invoice_lines(invoice_id);
+
+-- Free-text notes staff keep on a customer, shown on the customer timeline
+CREATE TABLE IF NOT EXISTS customer_notes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id),
+    customer_id UUID REFERENCES customers(id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    created_by UUID,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_customer_notes_customer ON customer_notes(customer_id);
+
+-- Outgoing emails, delivered with retries by the mail outbox job
+CREATE TABLE IF NOT EXISTS email_outbox (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id),
+    template VARCHAR(50),
+    customer_id UUID REFERENCES customers(id) ON DELETE SET NULL,
+    recipients TEXT[] NOT NULL,
+    subject TEXT NOT NULL,
+    text_body TEXT,
+    html_body TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'failed')),
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_error TEXT,
+    sent_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_outbox_due ON email_outbox(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_email_outbox_customer ON email_outbox(customer_id);
+
+-- Staff password reset links (only the token hash is stored)
+CREATE TABLE IF NOT EXISTS password_resets (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Text messages to customers (SMS or WhatsApp)
+CREATE TABLE IF NOT EXISTS messaging_settings (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) UNIQUE,
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    channel VARCHAR(20) NOT NULL DEFAULT 'sms' CHECK (channel IN ('sms', 'whatsapp')),
+    sender VARCHAR(50), -- SMS sender ID or WhatsApp phone number ID, instead of the platform default
+    country_code VARCHAR(4) NOT NULL DEFAULT '212', -- Prefix for local numbers starting with 0
+    send_request_received BOOLEAN NOT NULL DEFAULT TRUE,
+    send_booking_confirmed BOOLEAN NOT NULL DEFAULT TRUE,
+    send_pickup_reminder BOOLEAN NOT NULL DEFAULT TRUE,
+    send_return_reminder BOOLEAN NOT NULL DEFAULT TRUE,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- international_phone returns a phone number as international digits, like internationalPhone in Go:
+-- local numbers (leading 0) get the tenant's country code, numbers written with + or 00 keep theirs
+CREATE OR REPLACE FUNCTION international_phone(phone TEXT) RETURNS TEXT AS $$
+    SELECT CASE
+        WHEN btrim(phone) LIKE '+%' THEN d
+        WHEN d LIKE '00%' THEN substr(d, 3)
+        WHEN d LIKE '0%' THEN COALESCE((SELECT country_code FROM messaging_settings LIMIT 1), '212') || substr(d, 2)
+        ELSE d
+    END
+    FROM (SELECT regexp_replace(COALESCE(phone, ''), '\D', '', 'g') AS d) n
+$$ LANGUAGE SQL STABLE;
+
+-- Outgoing text messages, delivered with retries by the messaging job and updated by provider delivery reports
+CREATE TABLE IF NOT EXISTS message_outbox (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id),
+    channel VARCHAR(20) NOT NULL CHECK (channel IN ('sms', 'whatsapp')),
+    template VARCHAR(50),
+    customer_id UUID REFERENCES customers(id) ON DELETE SET NULL,
+    booking_id UUID REFERENCES bookings(id) ON DELETE SET NULL,
+    booking_request_id UUID REFERENCES booking_requests(id) ON DELETE SET NULL,
+    recipient VARCHAR(20) NOT NULL,
+    sender VARCHAR(50),
+    body TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'delivered', 'failed')),
+    provider_message_id VARCHAR(255),
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_error TEXT,
+    sent_at TIMESTAMP WITH TIME ZONE,
+    delivered_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_outbox_due ON message_outbox(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_message_outbox_provider_id ON message_outbox(provider_message_id);
+CREATE INDEX IF NOT EXISTS idx_message_outbox_customer ON message_outbox(customer_id);
+
+-- Main database only: which tenant sent each provider message, so that delivery reports go straight to it
+CREATE TABLE IF NOT EXISTS message_routes (
+    provider_message_id VARCHAR(255) PRIMARY KEY,
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_message_routes_created ON message_routes(created_at);
+-- Each booking gets each reminder once per channel
+CREATE UNIQUE INDEX IF NOT EXISTS idx_message_outbox_reminder ON message_outbox(booking_id, template, channel)
+    WHERE template IN ('pickup_reminder', 'return_reminder');
+
+-- Notifications created from domain events link back to what happened
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS event_type VARCHAR(50);
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS entity_id VARCHAR(64);
+
+-- Event notifications a user turned off (every event is notified by default)
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    event_type VARCHAR(50) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE(user_id, event_type)
+);
+
+-- Service date a car's "service due" notification was sent for, so it is sent once per service
+ALTER TABLE cars ADD COLUMN IF NOT EXISTS service_due_notified_for DATE;
+
+-- Notification inbox: users archive their own notifications, and keep their own read/archived/deleted state of
+-- the tenant-wide ones in receipts
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS idx_notifications_user_created ON notifications(user_id, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS notification_receipts (
+    notification_id UUID REFERENCES notifications(id) ON DELETE CASCADE,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    read_at TIMESTAMP WITH TIME ZONE,
+    archived_at TIMESTAMP WITH TIME ZONE,
+    deleted_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (notification_id, user_id)
+);
+
+-- Outgoing webhooks: tenant endpoints notified of events with signed payloads
+CREATE TABLE IF NOT EXISTS webhook_endpoints (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id),
+    url TEXT NOT NULL,
+    description TEXT,
+    events TEXT[] NOT NULL,
+    secret VARCHAR(100) NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_by UUID,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Delivery log: one row per event and endpoint (and per replay), posted with retries by the webhooks job
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id),
+    endpoint_id UUID REFERENCES webhook_endpoints(id) ON DELETE CASCADE,
+    event_id UUID NOT NULL,
+    event_type VARCHAR(50) NOT NULL,
+    payload TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_attempt_at TIMESTAMP WITH TIME ZONE,
+    response_status INT,
+    response_body TEXT,
+    duration_ms BIGINT,
+    last_error TEXT,
+    replay_of UUID REFERENCES webhook_deliveries(id) ON DELETE SET NULL,
+    delivered_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_endpoint ON webhook_deliveries(endpoint_id, created_at DESC);
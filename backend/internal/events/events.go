@@ -0,0 +1,95 @@
+package events
+
+import (
+	"car-rental-backend/internal/models"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Domain events published by handlers and jobs
+const (
+	BookingCreated              = "booking.created"
+	BookingStatusChanged        = "booking.status_changed"
+	BookingRequestCreated       = "booking_request.created"
+	BookingRequestStatusChanged = "booking_request.status_changed" // By staff, or cancelled from the portal
+	InvoiceOverdue              = "invoice.overdue"
+	CarServiceDue               = "car.service_due"
+	StaffAdded                  = "staff.added"
+	InvoicePaid                 = "invoice.paid"
+	CarUpdated                  = "car.updated"
+	RecurringExpenseMoved       = "recurring_expense.moved"
+)
+
+// Event is something that happened in a tenant. Data holds the event's fields, as returned to API clients.
+type Event struct {
+	Type       string
+	Tenant     *models.Tenant
+	DB         *pgxpool.Pool // The tenant database
+	ActorID    string        // User who caused it; empty for customers and jobs
+	EntityID   string        // ID of the booking, request, invoice, car or user it is about
+	Data       map[string]interface{}
+	OccurredAt time.Time
+}
+
+// Handler reacts to an event. Handlers run synchronously in the publisher's goroutine, so they should be quick
+// and must not publish the same event again.
+type Handler func(e Event)
+
+// Bus delivers published events to the handlers subscribed to their type
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler // By event type; "*" for every event
+}
+
+func NewBus() *Bus {
+	return &Bus{handlers: map[string][]Handler{}}
+}
+
+// Subscribe registers a handler for an event type, or for every event with "*"
+func (b *Bus) Subscribe(eventType string, h Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[eventType] = append(b.handlers[eventType], h)
+}
+
+// Publish runs the handlers of the event. A panicking handler is logged and doesn't stop the others.
+func (b *Bus) Publish(e Event) {
+	if e.OccurredAt.IsZero() {
+		e.OccurredAt = time.Now()
+	}
+	if e.Data == nil {
+		e.Data = map[string]interface{}{}
+	}
+	b.mu.RLock()
+	handlers := append(append([]Handler(nil), b.handlers[e.Type]...), b.handlers["*"]...)
+	b.mu.RUnlock()
+
+	for _, h := range handlers {
+		run(h, e)
+	}
+}
+
+func run(h Handler, e Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[EVENTS] Handler for %s panicked: %v", e.Type, r)
+		}
+	}()
+	h(e)
+}
+
+// Default is the bus handlers and jobs publish to
+var Default = NewBus()
+
+// Subscribe registers a handler on the default bus
+func Subscribe(eventType string, h Handler) {
+	Default.Subscribe(eventType, h)
+}
+
+// Publish publishes an event on the default bus
+func Publish(e Event) {
+	Default.Publish(e)
+}
@@ -0,0 +1,63 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+)
+
+// csvFlushEvery is how many rows are buffered before they are sent to the client
+const csvFlushEvery = 500
+
+type csvWriter struct {
+	w           *csv.Writer
+	locale      Locale
+	rows        int
+	spreadsheet bool
+}
+
+// newCSVWriter writes CSV for spreadsheets, or plain delimited text for other software (FEC), which must get
+// the values exactly as they are
+func newCSVWriter(w io.Writer, locale Locale, spreadsheet bool) *csvWriter {
+	// A UTF-8 BOM lets Excel detect the encoding of accented names
+	if spreadsheet {
+		io.WriteString(w, "\xEF\xBB\xBF")
+	}
+	cw := csv.NewWriter(w)
+	cw.Comma = locale.Separator
+	return &csvWriter{w: cw, locale: locale, spreadsheet: spreadsheet}
+}
+
+func (cw *csvWriter) Row(values ...interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		record[i] = cw.locale.format(v)
+		if _, text := v.(string); text && cw.spreadsheet {
+			record[i] = escapeFormula(record[i])
+		}
+	}
+	if err := cw.w.Write(record); err != nil {
+		return err
+	}
+	cw.rows++
+	if cw.rows%csvFlushEvery == 0 {
+		cw.w.Flush()
+		return cw.w.Error()
+	}
+	return nil
+}
+
+// escapeFormula stops spreadsheets from running text cells as formulas: names and notes come from the public
+// booking form, so a cell starting with =, +, -, @, tab or carriage return is prefixed with a quote.
+// Numbers are not strings here and keep their sign.
+func escapeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
@@ -0,0 +1,139 @@
+// Package export streams tabular reports as CSV or XLSX files
+package export
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported formats for ?format=
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+	FormatFEC  = "fec" // Tab-separated accounting file (French "Fichier des Écritures Comptables" layout)
+)
+
+// Writer writes one row at a time so large exports never have to be held in memory.
+// Values may be string, int, float64, *float64, time.Time, *time.Time, bool or nil.
+type Writer interface {
+	Row(values ...interface{}) error
+	Close() error
+}
+
+// Locale controls how numbers and dates are rendered
+type Locale struct {
+	Decimal    string // Decimal separator
+	Separator  rune   // CSV field separator
+	DateLayout string
+}
+
+var (
+	defaultLocale = Locale{Decimal: ".", Separator: ',', DateLayout: "2006-01-02"}
+
+	// fecLocale is fixed by the FEC specification, whatever the user's language
+	fecLocale = Locale{Decimal: ",", Separator: '\t', DateLayout: "20060102"}
+
+	// Locales where the comma is the decimal separator use ";" between CSV fields, as spreadsheets expect
+	locales = map[string]Locale{
+		"en-us": {Decimal: ".", Separator: ',', DateLayout: "01/02/2006"},
+		"en-gb": {Decimal: ".", Separator: ',', DateLayout: "02/01/2006"},
+		"fr":    {Decimal: ",", Separator: ';', DateLayout: "02/01/2006"},
+		"es":    {Decimal: ",", Separator: ';', DateLayout: "02/01/2006"},
+		"it":    {Decimal: ",", Separator: ';', DateLayout: "02/01/2006"},
+		"pt":    {Decimal: ",", Separator: ';', DateLayout: "02/01/2006"},
+		"de":    {Decimal: ",", Separator: ';', DateLayout: "02.01.2006"},
+		"nl":    {Decimal: ",", Separator: ';', DateLayout: "02-01-2006"},
+		"ar":    {Decimal: ",", Separator: ';', DateLayout: "02/01/2006"},
+	}
+)
+
+// LocaleFor returns the locale for a language tag such as "fr", "fr-MA" or an Accept-Language
+// header value. Unknown languages use ISO dates and a dot as decimal separator.
+func LocaleFor(tag string) Locale {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, ",;"); i >= 0 {
+		tag = strings.TrimSpace(tag[:i])
+	}
+	tag = strings.ReplaceAll(tag, "_", "-")
+	if l, ok := locales[tag]; ok {
+		return l
+	}
+	if i := strings.Index(tag, "-"); i > 0 {
+		if l, ok := locales[tag[:i]]; ok {
+			return l
+		}
+	}
+	return defaultLocale
+}
+
+// FormatNumber renders a number with two decimals and the locale's decimal separator
+func (l Locale) FormatNumber(f float64) string {
+	s := strconv.FormatFloat(f, 'f', 2, 64)
+	if l.Decimal != "." {
+		s = strings.Replace(s, ".", l.Decimal, 1)
+	}
+	return s
+}
+
+// format renders a value as text for the locale
+func (l Locale) format(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return l.FormatNumber(v)
+	case *float64:
+		if v == nil {
+			return ""
+		}
+		return l.FormatNumber(*v)
+	case time.Time:
+		return v.Format(l.DateLayout)
+	case *time.Time:
+		if v == nil {
+			return ""
+		}
+		return v.Format(l.DateLayout)
+	case bool:
+		if v {
+			return "yes"
+		}
+		return "no"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// New returns a writer for format ("csv", "xlsx" or "fec"). sheet names the XLSX worksheet.
+// FEC files always use their own locale.
+func New(format string, w io.Writer, sheet string, locale Locale) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w, locale, true), nil
+	case FormatFEC:
+		return newCSVWriter(w, fecLocale, false), nil
+	case FormatXLSX:
+		return newXLSXWriter(w, sheet, locale)
+	default:
+		return nil, fmt.Errorf("unsupported export format %q", format)
+	}
+}
+
+// ContentType returns the MIME type of an export format
+func ContentType(format string) string {
+	switch format {
+	case FormatXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case FormatFEC:
+		return "text/plain; charset=utf-8"
+	default:
+		return "text/csv; charset=utf-8"
+	}
+}
@@ -0,0 +1,509 @@
+package handlers
+
+import (
+	"car-rental-backend/internal/audit"
+	"car-rental-backend/internal/export"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// AccountingSettings holds the account codes and journals used by the accounting export
+type AccountingSettings struct {
+	ReceivableAccount string            `json:"receivable_account"`
+	RevenueAccount    string            `json:"revenue_account"`
+	CashAccount       string            `json:"cash_account"`
+	BankAccount       string            `json:"bank_account"`
+	ExpenseAccount    string            `json:"expense_account"`  // Default for expense categories without their own account
+	ExpenseAccounts   map[string]string `json:"expense_accounts"` // Expense category name -> account code
+	FXGainAccount     string            `json:"fx_gain_account"`
+	FXLossAccount     string            `json:"fx_loss_account"`
+	SalesJournal      string            `json:"sales_journal"`
+	PurchaseJournal   string            `json:"purchase_journal"`
+	BankJournal       string            `json:"bank_journal"`
+	CashJournal       string            `json:"cash_journal"`
+	ClosedUntil       *time.Time        `json:"closed_until"` // Read-only here, see ClosePeriod
+}
+
+type ClosePeriodRequest struct {
+	ClosedUntil *string `json:"closed_until"` // YYYY-MM-DD, null to reopen every period
+}
+
+// JournalLine is one debit or credit line of a journal entry, in the base currency
+type JournalLine struct {
+	JournalCode  string    `json:"journal_code"`
+	JournalLabel string    `json:"journal_label"`
+	EntryNumber  int       `json:"entry_number"`
+	EntryDate    time.Time `json:"entry_date"`
+	Account      string    `json:"account"`
+	AccountLabel string    `json:"account_label"`
+	AuxAccount   string    `json:"aux_account,omitempty"` // Customer sub-account on receivable lines
+	AuxLabel     string    `json:"aux_label,omitempty"`
+	PieceRef     string    `json:"piece_ref"`
+	PieceDate    time.Time `json:"piece_date"`
+	Label        string    `json:"label"`
+	Debit        float64   `json:"debit"`
+	Credit       float64   `json:"credit"`
+	Amount       *float64  `json:"amount,omitempty"` // Original amount when not in the base currency
+	Currency     string    `json:"currency,omitempty"`
+}
+
+// defaultAccountingSettings follow the French chart of accounts (PCG)
+var defaultAccountingSettings = AccountingSettings{
+	ReceivableAccount: "411000",
+	RevenueAccount:    "706000",
+	CashAccount:       "530000",
+	BankAccount:       "512000",
+	ExpenseAccount:    "606000",
+	ExpenseAccounts:   map[string]string{},
+	FXGainAccount:     "766000",
+	FXLossAccount:     "666000",
+	SalesJournal:      "VE",
+	PurchaseJournal:   "HA",
+	BankJournal:       "BQ",
+	CashJournal:       "CA",
+}
+
+// fecColumns are the column names of the FEC layout, also used for the CSV and XLSX journal exports
+var fecColumns = []string{"JournalCode", "JournalLib", "EcritureNum", "EcritureDate", "CompteNum", "CompteLib",
+	"CompAuxNum", "CompAuxLib", "PieceRef", "PieceDate", "EcritureLib", "Debit", "Credit",
+	"EcritureLet", "DateLet", "ValidDate", "Montantdevise", "Idevise"}
+
+// loadAccountingSettings returns the tenant's accounting settings, or the defaults if none are saved
+func loadAccountingSettings(db *pgxpool.Pool, tenantID string) (AccountingSettings, error) {
+	s := defaultAccountingSettings
+	s.ExpenseAccounts = map[string]string{}
+	var expenseAccountsJSON []byte
+	err := db.QueryRow(context.Background(),
+		`SELECT receivable_account, revenue_account, cash_account, bank_account, expense_account, expense_accounts,
+		        COALESCE(fx_gain_account, $2), COALESCE(fx_loss_account, $3),
+		        sales_journal, purchase_journal, bank_journal, cash_journal, closed_until
+		 FROM accounting_settings WHERE tenant_id = $1`, tenantID, s.FXGainAccount, s.FXLossAccount).Scan(
+		&s.ReceivableAccount, &s.RevenueAccount, &s.CashAccount, &s.BankAccount, &s.ExpenseAccount, &expenseAccountsJSON,
+		&s.FXGainAccount, &s.FXLossAccount,
+		&s.SalesJournal, &s.PurchaseJournal, &s.BankJournal, &s.CashJournal, &s.ClosedUntil)
+	if err == pgx.ErrNoRows {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if len(expenseAccountsJSON) > 0 {
+		if err := json.Unmarshal(expenseAccountsJSON, &s.ExpenseAccounts); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// ensurePeriodOpen answers 409 and returns false when one of the dates falls in a closed accounting period
+func ensurePeriodOpen(c *gin.Context, db *pgxpool.Pool, tenantID string, dates ...time.Time) bool {
+	var closedUntil *time.Time
+	err := db.QueryRow(context.Background(),
+		"SELECT closed_until FROM accounting_settings WHERE tenant_id = $1", tenantID).Scan(&closedUntil)
+	if err != nil || closedUntil == nil {
+		return true
+	}
+	for _, d := range dates {
+		day := time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.UTC)
+		if !day.After(*closedUntil) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":        "The accounting period is closed until " + closedUntil.Format("2006-01-02"),
+				"closed_until": closedUntil.Format("2006-01-02"),
+			})
+			return false
+		}
+	}
+	return true
+}
+
+// GetAccountingSettings returns the account codes, journals and closing date
+func GetAccountingSettings(c *gin.Context) {
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	settings, err := loadAccountingSettings(db, tenant.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch accounting settings: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// UpdateAccountingSettings updates the account codes and journals. Empty fields keep their default.
+func UpdateAccountingSettings(c *gin.Context) {
+	var req AccountingSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	d := defaultAccountingSettings
+	for _, f := range []struct {
+		value *string
+		def   string
+	}{
+		{&req.ReceivableAccount, d.ReceivableAccount}, {&req.RevenueAccount, d.RevenueAccount},
+		{&req.CashAccount, d.CashAccount}, {&req.BankAccount, d.BankAccount}, {&req.ExpenseAccount, d.ExpenseAccount},
+		{&req.FXGainAccount, d.FXGainAccount}, {&req.FXLossAccount, d.FXLossAccount},
+		{&req.SalesJournal, d.SalesJournal}, {&req.PurchaseJournal, d.PurchaseJournal},
+		{&req.BankJournal, d.BankJournal}, {&req.CashJournal, d.CashJournal},
+	} {
+		*f.value = strings.TrimSpace(*f.value)
+		if *f.value == "" {
+			*f.value = f.def
+		}
+	}
+	if req.ExpenseAccounts == nil {
+		req.ExpenseAccounts = map[string]string{}
+	}
+	expenseAccountsJSON, _ := json.Marshal(req.ExpenseAccounts)
+
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	_, err = db.Exec(context.Background(),
+		`INSERT INTO accounting_settings (tenant_id, receivable_account, revenue_account, cash_account, bank_account,
+		     expense_account, expense_accounts, sales_journal, purchase_journal, bank_journal, cash_journal,
+		     fx_gain_account, fx_loss_account, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, NOW())
+		 ON CONFLICT (tenant_id) DO UPDATE SET
+		 receivable_account = $2, revenue_account = $3, cash_account = $4, bank_account = $5,
+		 expense_account = $6, expense_accounts = $7, sales_journal = $8, purchase_journal = $9,
+		 bank_journal = $10, cash_journal = $11, fx_gain_account = $12, fx_loss_account = $13, updated_at = NOW()`,
+		tenant.ID, req.ReceivableAccount, req.RevenueAccount, req.CashAccount, req.BankAccount,
+		req.ExpenseAccount, expenseAccountsJSON, req.SalesJournal, req.PurchaseJournal, req.BankJournal, req.CashJournal,
+		req.FXGainAccount, req.FXLossAccount)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update accounting settings: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Accounting settings updated successfully"})
+}
+
+// ClosePeriod locks invoices, payments and expenses dated on or before closed_until.
+// Sending null reopens all periods.
+func ClosePeriod(c *gin.Context) {
+	var req ClosePeriodRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var closedUntil *time.Time
+	if req.ClosedUntil != nil && *req.ClosedUntil != "" {
+		d, err := time.Parse("2006-01-02", *req.ClosedUntil)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid closed_until, expected YYYY-MM-DD"})
+			return
+		}
+		closedUntil = &d
+	}
+
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	_, err = db.Exec(context.Background(),
+		`INSERT INTO accounting_settings (tenant_id, closed_until, updated_at) VALUES ($1, $2, NOW())
+		 ON CONFLICT (tenant_id) DO UPDATE SET closed_until = $2, updated_at = NOW()`,
+		tenant.ID, closedUntil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close period: " + err.Error()})
+		return
+	}
+
+	audit.LogAudit(c, "CLOSE_PERIOD", gin.H{"closed_until": req.ClosedUntil})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Accounting period updated successfully", "closed_until": closedUntil})
+}
+
+// ExportJournal exports invoices, credit notes (negative invoices), payments and expenses of ?from=&to=
+// as balanced journal entries in the base currency. ?format=fec (default), csv, xlsx or json.
+func ExportJournal(c *gin.Context) {
+	format := c.DefaultQuery("format", export.FormatFEC)
+	if format != export.FormatFEC && format != export.FormatCSV && format != export.FormatXLSX && format != "json" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format. Allowed: fec, csv, xlsx, json"})
+		return
+	}
+
+	from, to, err := parseDateRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	settings, err := loadAccountingSettings(db, tenant.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch accounting settings: " + err.Error()})
+		return
+	}
+	baseCurrency := GetBaseCurrency(db, tenant.ID)
+
+	lines, missingRates, err := buildJournal(db, settings, baseCurrency, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build journal: " + err.Error()})
+		return
+	}
+	// An accounting export must be complete, so unconverted amounts are an error rather than skipped
+	if len(missingRates) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":         "Missing exchange rates, add them before exporting",
+			"missing_rates": missingRates,
+		})
+		return
+	}
+
+	if format == "json" {
+		if lines == nil {
+			lines = []JournalLine{}
+		}
+		c.JSON(http.StatusOK, gin.H{"from": from.Format("2006-01-02"), "to": to.Format("2006-01-02"),
+			"base_currency": baseCurrency, "lines": lines})
+		return
+	}
+
+	var w export.Writer
+	if format == export.FormatFEC {
+		c.Header("Content-Type", export.ContentType(format))
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%sFEC%s.txt"`, tenant.Subdomain, to.Format("20060102")))
+		c.Status(http.StatusOK)
+		w, err = export.New(format, c.Writer, "", export.Locale{})
+		if err == nil {
+			header := make([]interface{}, len(fecColumns))
+			for i, col := range fecColumns {
+				header[i] = col
+			}
+			err = w.Row(header...)
+		}
+	} else {
+		w, err = startExport(c, format, tenant, "journal",
+			fmt.Sprintf("Journal %s to %s (%s)", from.Format("2006-01-02"), to.Format("2006-01-02"), baseCurrency), fecColumns...)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start export: " + err.Error()})
+		return
+	}
+
+	for _, l := range lines {
+		if err := w.Row(l.JournalCode, l.JournalLabel, l.EntryNumber, l.EntryDate, l.Account, l.AccountLabel,
+			l.AuxAccount, l.AuxLabel, l.PieceRef, l.PieceDate, l.Label, l.Debit, l.Credit,
+			"", "", l.EntryDate, l.Amount, l.Currency); err != nil {
+			return
+		}
+	}
+	finishExport(w)
+}
+
+// journalDocument is an invoice, payment or expense to be turned into a two-line entry
+type journalDocument struct {
+	kind         string // invoice, payment, expense
+	id           string
+	date         time.Time
+	amount       float64 // Original currency
+	currency     string
+	rate         *float64
+	invoiceRate  *float64 // Payments: rate the invoice was booked at, for the receivable line
+	label        string
+	customerID   string
+	customerName string
+	method       string // Payments: cash or other
+	category     string // Expenses
+}
+
+// buildJournal loads the documents of the period and returns their journal lines in date order,
+// with the currencies that could not be converted to the base currency
+func buildJournal(db *pgxpool.Pool, s AccountingSettings, baseCurrency string, from, to time.Time) ([]JournalLine, []string, error) {
+	var docs []journalDocument
+	queries := []struct {
+		kind  string
+		query string
+	}{
+		{"invoice", `
+			SELECT i.id, i.created_at::date, i.amount, COALESCE(i.currency, 'MAD'),
+			       COALESCE(stored_rate(i.exchange_rate, i.exchange_rate_base, $3), fx_rate(COALESCE(i.currency, 'MAD'), $3, i.created_at::date)),
+			       NULL::numeric,
+			       COALESCE(cust.id::text, ''), COALESCE(cust.first_name || ' ' || cust.last_name, 'Unknown'), '', ''
+			FROM invoices i
+			JOIN bookings b ON i.booking_id = b.id
+			LEFT JOIN customers cust ON b.customer_id = cust.id
+			WHERE i.created_at::date BETWEEN $1 AND $2 AND i.status != 'Cancelled'`},
+		{"payment", `
+			SELECT p.id, p.paid_at, p.amount, COALESCE(i.currency, 'MAD'),
+			       fx_rate(COALESCE(i.currency, 'MAD'), $3, p.paid_at),
+			       COALESCE(stored_rate(i.exchange_rate, i.exchange_rate_base, $3), fx_rate(COALESCE(i.currency, 'MAD'), $3, i.created_at::date)),
+			       COALESCE(cust.id::text, ''), COALESCE(cust.first_name || ' ' || cust.last_name, 'Unknown'),
+			       COALESCE(p.method, 'cash'), ''
+			FROM payments p
+			JOIN invoices i ON p.invoice_id = i.id
+			JOIN bookings b ON i.booking_id = b.id
+			LEFT JOIN customers cust ON b.customer_id = cust.id
+			WHERE p.paid_at BETWEEN $1 AND $2`},
+		{"expense", `
+			SELECT e.id, e.date, e.amount, COALESCE(e.currency, $3),
+			       fx_rate(COALESCE(e.currency, $3), $3, e.date), NULL::numeric,
+			       '', COALESCE(NULLIF(e.description, ''), e.category), '', e.category
+			FROM expenses e
+			WHERE e.date BETWEEN $1 AND $2`},
+	}
+	for _, q := range queries {
+		rows, err := db.Query(context.Background(), q.query, from, to, baseCurrency)
+		if err != nil {
+			return nil, nil, err
+		}
+		for rows.Next() {
+			d := journalDocument{kind: q.kind}
+			var extra string
+			if err := rows.Scan(&d.id, &d.date, &d.amount, &d.currency, &d.rate, &d.invoiceRate, &d.customerID, &extra, &d.method, &d.category); err != nil {
+				rows.Close()
+				return nil, nil, err
+			}
+			if q.kind == "expense" {
+				d.label = extra
+			} else {
+				d.customerName = extra
+			}
+			docs = append(docs, d)
+		}
+		rows.Close()
+	}
+
+	sort.SliceStable(docs, func(i, j int) bool { return docs[i].date.Before(docs[j].date) })
+
+	var lines []JournalLine
+	missing := map[string]bool{}
+	for n, d := range docs {
+		if d.rate == nil {
+			missing[d.currency] = true
+			continue
+		}
+		if d.kind == "payment" && d.invoiceRate == nil {
+			missing[d.currency] = true
+			continue
+		}
+		amount := roundCents(d.amount * *d.rate)
+		var original *float64
+		var currency string
+		if d.currency != baseCurrency {
+			a := d.amount
+			original, currency = &a, d.currency
+		}
+		ref := shortRef(d.id)
+		entry := JournalLine{EntryNumber: n + 1, EntryDate: d.date, PieceRef: ref, PieceDate: d.date, Amount: original, Currency: currency}
+		customerAux, customerLabel := "", d.customerName
+		if d.customerID != "" {
+			customerAux = "C" + strings.ToUpper(shortRef(d.customerID))
+		}
+
+		var debit, credit JournalLine
+		switch d.kind {
+		case "invoice":
+			entry.JournalCode, entry.JournalLabel = s.SalesJournal, "Sales"
+			entry.Label = "Invoice " + ref + " " + d.customerName
+			if amount < 0 {
+				entry.Label = "Credit note " + ref + " " + d.customerName
+			}
+			debit, credit = entry, entry
+			debit.Account, debit.AccountLabel, debit.AuxAccount, debit.AuxLabel = s.ReceivableAccount, "Customers", customerAux, customerLabel
+			credit.Account, credit.AccountLabel = s.RevenueAccount, "Rental revenue"
+		case "payment":
+			entry.JournalCode, entry.JournalLabel = s.BankJournal, "Bank"
+			account, accountLabel := s.BankAccount, "Bank"
+			if d.method == "cash" {
+				entry.JournalCode, entry.JournalLabel = s.CashJournal, "Cash"
+				account, accountLabel = s.CashAccount, "Cash"
+			}
+			entry.Label = "Payment " + d.customerName
+			debit, credit = entry, entry
+			debit.Account, debit.AccountLabel = account, accountLabel
+			credit.Account, credit.AccountLabel, credit.AuxAccount, credit.AuxLabel = s.ReceivableAccount, "Customers", customerAux, customerLabel
+
+			// The receivable is settled at the rate the invoice was booked at; the difference with the amount
+			// received at today's rate is an exchange gain or loss
+			settled := roundCents(d.amount * *d.invoiceRate)
+			lines = append(lines, postLine(debit, amount, true), postLine(credit, settled, false))
+			if diff := roundCents(amount - settled); diff != 0 {
+				fx := entry
+				fx.Amount, fx.Currency = nil, ""
+				fx.Account, fx.AccountLabel = s.FXGainAccount, "Exchange gain"
+				if diff < 0 {
+					fx.Account, fx.AccountLabel = s.FXLossAccount, "Exchange loss"
+				}
+				lines = append(lines, postLine(fx, diff, false))
+			}
+			continue
+		case "expense":
+			entry.JournalCode, entry.JournalLabel = s.PurchaseJournal, "Purchases"
+			entry.Label = d.label
+			account := s.ExpenseAccount
+			if a, ok := s.ExpenseAccounts[d.category]; ok && a != "" {
+				account = a
+			}
+			debit, credit = entry, entry
+			debit.Account, debit.AccountLabel = account, d.category
+			credit.Account, credit.AccountLabel = s.BankAccount, "Bank"
+		}
+
+		lines = append(lines, postLine(debit, amount, true), postLine(credit, amount, false))
+	}
+
+	var missingRates []string
+	for cur := range missing {
+		missingRates = append(missingRates, cur)
+	}
+	sort.Strings(missingRates)
+	return lines, missingRates, nil
+}
+
+// postLine puts an amount on the debit or credit side of a line. A negative amount (credit note, refund,
+// exchange loss) goes on the other side so that both columns stay positive.
+func postLine(l JournalLine, amount float64, debit bool) JournalLine {
+	if amount < 0 {
+		amount, debit = -amount, !debit
+	}
+	if debit {
+		l.Debit = amount
+	} else {
+		l.Credit = amount
+	}
+	return l
+}
+
+// shortRef returns the first 8 characters of an id, used as document reference
+func shortRef(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+func roundCents(f float64) float64 {
+	if f < 0 {
+		return -roundCents(-f)
+	}
+	return float64(int64(f*100+0.5)) / 100
+}
@@ -2,8 +2,12 @@ package handlers
 
 import (
 	"car-rental-backend/internal/database"
+	"car-rental-backend/internal/events"
+	"car-rental-backend/internal/mail"
+	"car-rental-backend/internal/messaging"
 	"car-rental-backend/internal/models"
 	"context"
+	"log"
 	"net/http"
 	"time"
 
@@ -19,6 +23,7 @@ type Booking struct {
 	EndDate      time.Time `json:"end_date"`
 	Status       string    `json:"status"`
 	TotalPrice   float64   `json:"total_price"`
+	Currency     string    `json:"currency"`
 	CarMake      string    `json:"car_make,omitempty"`  // Joined field
 	CarModel     string    `json:"car_model,omitempty"` // Joined field
 }
@@ -29,6 +34,9 @@ type CreateBookingRequest struct {
 	StartDate    time.Time `json:"start_date" binding:"required"`
 	EndDate      time.Time `json:"end_date" binding:"required"`
 	TotalPrice   float64   `json:"total_price" binding:"required"`
+	// Optional discounts, deducted from total_price
+	PromoCode     string `json:"promo_code"`
+	LoyaltyPoints int    `json:"loyalty_points" binding:"gte=0"`
 }
 
 type UpdateBookingStatusRequest struct {
@@ -46,7 +54,13 @@ func getTenantDBFromContext(c *gin.Context) (*pgxpool.Pool, *models.Tenant, erro
 	return db, tenant, err
 }
 
+// GetBookings lists bookings, or downloads them with ?format=csv|xlsx
 func GetBookings(c *gin.Context) {
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
+
 	db, tenant, err := getTenantDBFromContext(c)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
@@ -58,6 +72,7 @@ func GetBookings(c *gin.Context) {
 		SELECT 
 			b.id, b.car_id, b.start_date, b.end_date, b.status, 
 			b.price_per_day * ((b.end_date - b.start_date) + 1) as total_price,
+			COALESCE(b.currency, 'MAD'),
 			c.brand, c.model,
 			COALESCE(cust.first_name || ' ' || cust.last_name, 'Unknown') as customer_name
 		FROM bookings b
@@ -73,16 +88,34 @@ func GetBookings(c *gin.Context) {
 	}
 	defer rows.Close()
 
+	exp, err := startExport(c, format, tenant, "bookings", "Bookings",
+		"Booking ID", "Customer", "Car", "Start date", "End date", "Status", "Total price", "Currency")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start export: " + err.Error()})
+		return
+	}
+
 	var bookings []Booking
 	for rows.Next() {
 		var b Booking
-		if err := rows.Scan(&b.ID, &b.CarID, &b.StartDate, &b.EndDate, &b.Status, &b.TotalPrice, &b.CarMake, &b.CarModel, &b.CustomerName); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan booking: " + err.Error()})
+		if err := rows.Scan(&b.ID, &b.CarID, &b.StartDate, &b.EndDate, &b.Status, &b.TotalPrice, &b.Currency, &b.CarMake, &b.CarModel, &b.CustomerName); err != nil {
+			rowsFailed(c, exp, "Failed to scan booking", err)
 			return
 		}
+		if exp != nil {
+			if err := exp.Row(b.ID, b.CustomerName, b.CarMake+" "+b.CarModel, b.StartDate, b.EndDate, b.Status, b.TotalPrice, b.Currency); err != nil {
+				return
+			}
+			continue
+		}
 		bookings = append(bookings, b)
 	}
 
+	if exp != nil {
+		finishExport(exp)
+		return
+	}
+
 	if bookings == nil {
 		bookings = []Booking{}
 	}
@@ -103,6 +136,21 @@ func CreateBooking(c *gin.Context) {
 		return
 	}
 
+	// Bookings are priced in the car's currency; the rate to the base currency is fixed at booking date
+	var currency string
+	err = db.QueryRow(context.Background(),
+		"SELECT COALESCE(currency, 'MAD') FROM cars WHERE id = $1", req.CarID).Scan(&currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Car not found"})
+		return
+	}
+	var exchangeRate interface{}
+	baseCurrency := GetBaseCurrency(db, tenant.ID)
+	rate, rateKnown := lookupExchangeRate(db, currency, baseCurrency, time.Now())
+	if rateKnown {
+		exchangeRate = rate
+	}
+
 	// Find or create customer by name
 	var customerID string
 	nameParts := splitName(req.CustomerName)
@@ -114,7 +162,7 @@ func CreateBooking(c *gin.Context) {
 
 	// Try to find existing customer
 	err = db.QueryRow(context.Background(),
-		"SELECT id FROM customers WHERE tenant_id = $1 AND first_name = $2 AND last_name = $3",
+		"SELECT id FROM customers WHERE tenant_id = $1 AND first_name = $2 AND last_name = $3 AND deleted_at IS NULL",
 		tenant.ID, firstName, lastName).Scan(&customerID)
 
 	if err != nil {
@@ -128,24 +176,136 @@ func CreateBooking(c *gin.Context) {
 		}
 	}
 
+	risk, err := CheckCustomerRisk(db, tenant.ID, customerID, "", "", "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check customer risk: " + err.Error()})
+		return
+	}
+	if risk.Blocked {
+		c.JSON(http.StatusForbidden, gin.H{"error": risk.Reason, "risk": risk})
+		return
+	}
+
+	// Discounts: the promo code applies to the full price, loyalty points to what is left
+	promo := PromoCheck{}
+	if req.PromoCode != "" {
+		promo, err = CheckPromoCode(db, tenant.ID, req.PromoCode, req.CarID, customerID, "", req.TotalPrice, currency)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check promo code: " + err.Error()})
+			return
+		}
+		if !promo.Valid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": promo.Reason, "promo": promo})
+			return
+		}
+	}
+	var loyaltySettings LoyaltySettings
+	loyaltyAmount := 0.0
+	if req.LoyaltyPoints > 0 {
+		if loyaltySettings, err = LoadLoyaltySettings(db, tenant.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch loyalty settings: " + err.Error()})
+			return
+		}
+		if !rateKnown {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No exchange rate to the base currency for " + currency + ", loyalty points cannot be redeemed"})
+			return
+		}
+		loyaltyAmount = loyaltyDiscount(loyaltySettings, req.LoyaltyPoints, rate)
+		if loyaltyAmount > req.TotalPrice-promo.Discount {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The points are worth more than the remaining price of the booking"})
+			return
+		}
+	}
+	totalPrice := req.TotalPrice - promo.Discount - loyaltyAmount
+
 	// Calculate price per day from total price and date range
 	days := int(req.EndDate.Sub(req.StartDate).Hours()/24) + 1
 	if days < 1 {
 		days = 1
 	}
-	pricePerDay := req.TotalPrice / float64(days)
+	pricePerDay := totalPrice / float64(days)
+
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback(ctx)
 
 	var bookingID string
-	err = db.QueryRow(context.Background(),
-		"INSERT INTO bookings (tenant_id, car_id, customer_id, start_date, end_date, price_per_day, status) VALUES ($1, $2, $3, $4, $5, $6, 'pending') RETURNING id",
-		tenant.ID, req.CarID, customerID, req.StartDate, req.EndDate, pricePerDay).Scan(&bookingID)
+	err = tx.QueryRow(ctx,
+		`INSERT INTO bookings (tenant_id, car_id, customer_id, start_date, end_date, price_per_day, currency, exchange_rate, exchange_rate_base, status,
+		                       promo_code_id, promo_discount, loyalty_points_redeemed, loyalty_discount)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, 'pending', $10, $11, $12, $13) RETURNING id`,
+		tenant.ID, req.CarID, customerID, req.StartDate, req.EndDate, pricePerDay, currency, exchangeRate, baseCurrency,
+		nullIfEmpty(promo.PromoCodeID), promo.Discount, req.LoyaltyPoints, loyaltyAmount).Scan(&bookingID)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create booking: " + err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"message": "Booking created successfully", "id": bookingID})
+	if promo.Valid {
+		redeemed, err := redeemPromoCode(tx, tenant.ID, promo, bookingID, "", customerID, "")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to redeem promo code: " + err.Error()})
+			return
+		}
+		if !redeemed {
+			c.JSON(http.StatusConflict, gin.H{"error": "This promo code has reached its usage limit"})
+			return
+		}
+	}
+	if req.LoyaltyPoints > 0 {
+		reason, err := redeemLoyaltyPoints(tx, tenant.ID, customerID, bookingID, c.GetString("user_id"), req.LoyaltyPoints, loyaltySettings)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to redeem loyalty points: " + err.Error()})
+			return
+		}
+		if reason != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": reason})
+			return
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create booking: " + err.Error()})
+		return
+	}
+
+	events.Publish(events.Event{
+		Type:     events.BookingCreated,
+		Tenant:   tenant,
+		DB:       db,
+		ActorID:  c.GetString("user_id"),
+		EntityID: bookingID,
+		Data: map[string]interface{}{
+			"id":            bookingID,
+			"car_id":        req.CarID,
+			"customer_id":   customerID,
+			"customer_name": req.CustomerName,
+			"start_date":    req.StartDate.Format("2006-01-02"),
+			"end_date":      req.EndDate.Format("2006-01-02"),
+			"total":         roundCents(totalPrice),
+			"currency":      currency,
+		},
+	})
+
+	// Missing or expiring documents don't block the booking, staff are warned instead
+	warnings, err := customerDocumentWarnings(db, customerID, req.EndDate)
+	if err != nil {
+		warnings = []string{}
+	}
+	warnings = append(risk.Warnings, warnings...)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "Booking created successfully",
+		"id":       bookingID,
+		"total":    roundCents(totalPrice),
+		"discount": roundCents(promo.Discount + loyaltyAmount),
+		"warnings": warnings,
+	})
 }
 
 // splitName splits a full name into first and last name parts
@@ -191,7 +351,7 @@ func UpdateBookingStatus(c *gin.Context) {
 		return
 	}
 
-	db, _, err := getTenantDBFromContext(c)
+	db, tenant, err := getTenantDBFromContext(c)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
 		return
@@ -203,5 +363,30 @@ func UpdateBookingStatus(c *gin.Context) {
 		return
 	}
 
+	events.Publish(events.Event{
+		Type:     events.BookingStatusChanged,
+		Tenant:   tenant,
+		DB:       db,
+		ActorID:  c.GetString("user_id"),
+		EntityID: bookingID,
+		Data:     map[string]interface{}{"id": bookingID, "status": req.Status},
+	})
+
+	// Confirmed bookings are emailed and texted to the customer, completed bookings earn loyalty points and cancelling
+	// gives back the points and promo code use
+	switch req.Status {
+	case "confirmed":
+		logEmailError(mail.TemplateBookingConfirmation, bookingID, queueBookingConfirmation(db, tenant, bookingID))
+		logMessageError(messaging.TemplateBookingConfirmed, bookingID, queueBookingMessage(db, tenant, bookingID, messaging.TemplateBookingConfirmed))
+	case "completed":
+		if err := AwardLoyaltyPoints(db, tenant.ID, bookingID); err != nil {
+			log.Printf("[LOYALTY] Failed to award points for booking %s: %v", bookingID, err)
+		}
+	case "cancelled":
+		if err := ReleaseBookingRewards(db, tenant.ID, bookingID); err != nil {
+			log.Printf("[LOYALTY] Failed to release rewards of booking %s: %v", bookingID, err)
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Booking status updated"})
 }
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"car-rental-backend/internal/audit"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CarBlock takes a car out of service between two dates (inclusive)
+type CarBlock struct {
+	ID        string    `json:"id"`
+	CarID     string    `json:"car_id"`
+	StartDate time.Time `json:"start_date"`
+	EndDate   time.Time `json:"end_date"`
+	Reason    string    `json:"reason"`
+	Notes     string    `json:"notes"`
+}
+
+type CreateCarBlockRequest struct {
+	StartDate time.Time `json:"start_date" binding:"required"`
+	EndDate   time.Time `json:"end_date" binding:"required"`
+	Reason    string    `json:"reason"` // maintenance (default), repair, private...
+	Notes     string    `json:"notes"`
+}
+
+// GetCarBlocks lists the blocks of a car
+func GetCarBlocks(c *gin.Context) {
+	carID := c.Param("id")
+	db, _, err := getTenantDBFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	rows, err := db.Query(context.Background(),
+		`SELECT id, car_id, start_date, end_date, COALESCE(reason, 'maintenance'), COALESCE(notes, '')
+		 FROM car_blocks WHERE car_id = $1 ORDER BY start_date DESC`, carID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch car blocks: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var blocks []CarBlock
+	for rows.Next() {
+		var b CarBlock
+		if err := rows.Scan(&b.ID, &b.CarID, &b.StartDate, &b.EndDate, &b.Reason, &b.Notes); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan car block: " + err.Error()})
+			return
+		}
+		blocks = append(blocks, b)
+	}
+
+	if blocks == nil {
+		blocks = []CarBlock{}
+	}
+
+	c.JSON(http.StatusOK, blocks)
+}
+
+// CreateCarBlock blocks a car for a date range
+func CreateCarBlock(c *gin.Context) {
+	carID := c.Param("id")
+	var req CreateCarBlockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.EndDate.Before(req.StartDate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "end_date must not be before start_date"})
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "maintenance"
+	}
+
+	db, tenant, err := getTenantDBFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	var blockID string
+	err = db.QueryRow(context.Background(),
+		`INSERT INTO car_blocks (tenant_id, car_id, start_date, end_date, reason, notes)
+		 SELECT $1, id, $3, $4, $5, $6 FROM cars WHERE id = $2 RETURNING id`,
+		tenant.ID, carID, req.StartDate, req.EndDate, req.Reason, req.Notes).Scan(&blockID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Car not found"})
+		return
+	}
+
+	audit.LogAudit(c, "CREATE_CAR_BLOCK", gin.H{"car_id": carID, "block_id": blockID, "reason": req.Reason})
+
+	c.JSON(http.StatusCreated, gin.H{"id": blockID, "message": "Car block created successfully"})
+}
+
+// DeleteCarBlock removes a block, making the car available again for that range
+func DeleteCarBlock(c *gin.Context) {
+	carID := c.Param("id")
+	blockID := c.Param("blockId")
+	db, _, err := getTenantDBFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	result, err := db.Exec(context.Background(), "DELETE FROM car_blocks WHERE id = $1 AND car_id = $2", blockID, carID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete car block: " + err.Error()})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Car block not found"})
+		return
+	}
+
+	audit.LogAudit(c, "DELETE_CAR_BLOCK", gin.H{"car_id": carID, "block_id": blockID})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Car block deleted successfully"})
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"car-rental-backend/internal/audit"
 	"car-rental-backend/internal/database"
+	"car-rental-backend/internal/events"
 	"car-rental-backend/internal/models"
 	"context"
 	"encoding/json"
@@ -35,6 +36,13 @@ type Car struct {
 	Seats        int      `json:"seats"`
 	Description  string   `json:"description"`
 	CreatedAt    string   `json:"created_at"`
+
+	// Acquisition data used by the profitability report
+	PurchasePrice       *float64   `json:"purchase_price"`
+	PurchaseDate        *time.Time `json:"purchase_date"`
+	ExpectedResaleValue *float64   `json:"expected_resale_value"`
+	ExpectedResaleDate  *time.Time `json:"expected_resale_date"`
+	NextServiceDate     *time.Time `json:"next_service_date"`
 }
 
 type CreateCarRequest struct {
@@ -51,6 +59,12 @@ type CreateCarRequest struct {
 	Seats        int      `json:"seats"`
 	Description  string   `json:"description"`
 	Status       string   `json:"status"`
+
+	PurchasePrice       *float64   `json:"purchase_price"`
+	PurchaseDate        *time.Time `json:"purchase_date"`
+	ExpectedResaleValue *float64   `json:"expected_resale_value"`
+	ExpectedResaleDate  *time.Time `json:"expected_resale_date"`
+	NextServiceDate     *time.Time `json:"next_service_date"`
 }
 
 // ... existing getTenantDB ...
@@ -69,7 +83,7 @@ func GetCars(c *gin.Context) {
 		return
 	}
 
-	rows, err := db.Query(context.Background(), "SELECT id, brand, model, year, license_plate, status, price_per_day, currency, image_url, images, transmission, fuel_type, seats, description, created_at FROM cars ORDER BY created_at DESC")
+	rows, err := db.Query(context.Background(), "SELECT id, brand, model, year, license_plate, status, price_per_day, currency, image_url, images, transmission, fuel_type, seats, description, created_at, purchase_price, purchase_date, expected_resale_value, expected_resale_date, next_service_date FROM cars ORDER BY created_at DESC")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cars: " + err.Error()})
 		return
@@ -88,7 +102,7 @@ func GetCars(c *gin.Context) {
 		var description *string
 		var createdAt time.Time
 
-		if err := rows.Scan(&car.ID, &car.Brand, &car.Model, &car.Year, &car.LicensePlate, &car.Status, &car.PricePerDay, &currency, &imageURL, &images, &transmission, &fuelType, &seats, &description, &createdAt); err != nil {
+		if err := rows.Scan(&car.ID, &car.Brand, &car.Model, &car.Year, &car.LicensePlate, &car.Status, &car.PricePerDay, &currency, &imageURL, &images, &transmission, &fuelType, &seats, &description, &createdAt, &car.PurchasePrice, &car.PurchaseDate, &car.ExpectedResaleValue, &car.ExpectedResaleDate, &car.NextServiceDate); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan car: " + err.Error()})
 			return
 		}
@@ -164,9 +178,11 @@ func CreateCar(c *gin.Context) {
 	imagesJSON, _ := json.Marshal(req.Images)
 	var carID string
 	err = db.QueryRow(context.Background(),
-		`INSERT INTO cars (tenant_id, brand, model, year, license_plate, price_per_day, currency, image_url, images, transmission, fuel_type, seats, description) 
-		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING id`,
+		`INSERT INTO cars (tenant_id, brand, model, year, license_plate, price_per_day, currency, image_url, images, transmission, fuel_type, seats, description,
+		                   purchase_price, purchase_date, expected_resale_value, expected_resale_date, next_service_date) 
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18) RETURNING id`,
 		tenant.ID, req.Brand, req.Model, req.Year, req.LicensePlate, req.PricePerDay, req.Currency, req.ImageURL, string(imagesJSON), req.Transmission, req.FuelType, req.Seats, req.Description,
+		req.PurchasePrice, req.PurchaseDate, req.ExpectedResaleValue, req.ExpectedResaleDate, req.NextServiceDate,
 	).Scan(&carID)
 
 	if err != nil {
@@ -193,6 +209,12 @@ type UpdateCarRequest struct {
 	Seats        *int      `json:"seats"`
 	Description  *string   `json:"description"`
 	Status       *string   `json:"status"`
+
+	PurchasePrice       *float64   `json:"purchase_price"`
+	PurchaseDate        *time.Time `json:"purchase_date"`
+	ExpectedResaleValue *float64   `json:"expected_resale_value"`
+	ExpectedResaleDate  *time.Time `json:"expected_resale_date"`
+	NextServiceDate     *time.Time `json:"next_service_date"`
 }
 
 func UpdateCar(c *gin.Context) {
@@ -287,6 +309,31 @@ func UpdateCar(c *gin.Context) {
 		args = append(args, *req.Status)
 		argIndex++
 	}
+	if req.PurchasePrice != nil {
+		setClauses = append(setClauses, fmt.Sprintf("purchase_price = $%d", argIndex))
+		args = append(args, *req.PurchasePrice)
+		argIndex++
+	}
+	if req.PurchaseDate != nil {
+		setClauses = append(setClauses, fmt.Sprintf("purchase_date = $%d", argIndex))
+		args = append(args, *req.PurchaseDate)
+		argIndex++
+	}
+	if req.ExpectedResaleValue != nil {
+		setClauses = append(setClauses, fmt.Sprintf("expected_resale_value = $%d", argIndex))
+		args = append(args, *req.ExpectedResaleValue)
+		argIndex++
+	}
+	if req.ExpectedResaleDate != nil {
+		setClauses = append(setClauses, fmt.Sprintf("expected_resale_date = $%d", argIndex))
+		args = append(args, *req.ExpectedResaleDate)
+		argIndex++
+	}
+	if req.NextServiceDate != nil {
+		setClauses = append(setClauses, fmt.Sprintf("next_service_date = $%d", argIndex))
+		args = append(args, *req.NextServiceDate)
+		argIndex++
+	}
 
 	// If no fields to update, return error
 	if len(setClauses) == 0 {
@@ -313,6 +360,32 @@ func UpdateCar(c *gin.Context) {
 
 	audit.LogAudit(c, "UPDATE_CAR", gin.H{"car_id": carID})
 
+	var car Car
+	err = db.QueryRow(context.Background(),
+		`SELECT id, brand, model, year, license_plate, status, price_per_day, COALESCE(currency, 'MAD')
+		 FROM cars WHERE id = $1`, carID).Scan(&car.ID, &car.Brand, &car.Model, &car.Year, &car.LicensePlate,
+		&car.Status, &car.PricePerDay, &car.Currency)
+	if err == nil {
+		events.Publish(events.Event{
+			Type:     events.CarUpdated,
+			Tenant:   tenant,
+			DB:       db,
+			ActorID:  c.GetString("user_id"),
+			EntityID: carID,
+			Data: map[string]interface{}{
+				"id":            car.ID,
+				"brand":         car.Brand,
+				"model":         car.Model,
+				"year":          car.Year,
+				"license_plate": car.LicensePlate,
+				"status":        car.Status,
+				"price_per_day": car.PricePerDay,
+				"currency":      car.Currency,
+				"fields":        updatedCarFields(setClauses),
+			},
+		})
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Car updated successfully"})
 }
 
@@ -435,3 +508,14 @@ func generateRandomString(length int) string {
 	}
 	return string(b)
 }
+
+// updatedCarFields returns the columns an UpdateCar query sets, for the car.updated event
+func updatedCarFields(setClauses []string) []string {
+	fields := []string{}
+	for _, clause := range setClauses {
+		if column, _, ok := strings.Cut(clause, " = "); ok {
+			fields = append(fields, column)
+		}
+	}
+	return fields
+}
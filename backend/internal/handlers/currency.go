@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// defaultBaseCurrency is used until a tenant picks its own base currency
+const defaultBaseCurrency = "MAD"
+
+// ExchangeRate is the value of one unit of Currency in BaseCurrency on RateDate
+type ExchangeRate struct {
+	ID           string    `json:"id"`
+	Currency     string    `json:"currency"`
+	BaseCurrency string    `json:"base_currency"`
+	Rate         float64   `json:"rate"`
+	RateDate     time.Time `json:"rate_date"`
+	Source       string    `json:"source"`
+}
+
+type CreateExchangeRateRequest struct {
+	Currency string  `json:"currency" binding:"required,len=3"`
+	Rate     float64 `json:"rate" binding:"required,gt=0"`
+	RateDate string  `json:"rate_date" binding:"required"` // YYYY-MM-DD
+}
+
+type UpdateCurrencySettingsRequest struct {
+	BaseCurrency string `json:"base_currency" binding:"required,len=3"`
+}
+
+// GetBaseCurrency returns the tenant's base currency (MAD when not configured)
+func GetBaseCurrency(db *pgxpool.Pool, tenantID string) string {
+	var base string
+	err := db.QueryRow(context.Background(),
+		"SELECT base_currency FROM financial_settings WHERE tenant_id = $1", tenantID).Scan(&base)
+	if err != nil || base == "" {
+		return defaultBaseCurrency
+	}
+	return base
+}
+
+// lookupExchangeRate returns the rate to convert currency into base on the given date.
+// ok is false when no rate has been entered yet for that currency.
+func lookupExchangeRate(db *pgxpool.Pool, currency, base string, on time.Time) (rate float64, ok bool) {
+	var r *float64
+	err := db.QueryRow(context.Background(), "SELECT fx_rate($1, $2, $3)", currency, base, on).Scan(&r)
+	if err != nil || r == nil {
+		return 0, false
+	}
+	return *r, true
+}
+
+// GetCurrencySettings returns the tenant's base currency
+func GetCurrencySettings(c *gin.Context) {
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"base_currency": GetBaseCurrency(db, tenant.ID)})
+}
+
+// UpdateCurrencySettings changes the tenant's base currency used by reports
+func UpdateCurrencySettings(c *gin.Context) {
+	var req UpdateCurrencySettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	base := strings.ToUpper(req.BaseCurrency)
+	_, err = db.Exec(context.Background(),
+		`INSERT INTO financial_settings (tenant_id, base_currency, updated_at) VALUES ($1, $2, NOW())
+		 ON CONFLICT (tenant_id) DO UPDATE SET base_currency = $2, updated_at = NOW()`,
+		tenant.ID, base)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update currency settings: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Base currency updated successfully", "base_currency": base})
+}
+
+// GetExchangeRates lists exchange rates into the tenant's base currency (optionally ?currency=)
+func GetExchangeRates(c *gin.Context) {
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	rows, err := db.Query(context.Background(),
+		`SELECT id, currency, base_currency, rate, rate_date, COALESCE(source, 'manual')
+		 FROM exchange_rates
+		 WHERE base_currency = $1 AND ($2 = '' OR currency = $2)
+		 ORDER BY rate_date DESC, currency`,
+		GetBaseCurrency(db, tenant.ID), strings.ToUpper(c.Query("currency")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch exchange rates: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var rates []ExchangeRate
+	for rows.Next() {
+		var r ExchangeRate
+		if err := rows.Scan(&r.ID, &r.Currency, &r.BaseCurrency, &r.Rate, &r.RateDate, &r.Source); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan exchange rate: " + err.Error()})
+			return
+		}
+		rates = append(rates, r)
+	}
+
+	if rates == nil {
+		rates = []ExchangeRate{}
+	}
+
+	c.JSON(http.StatusOK, rates)
+}
+
+// CreateExchangeRate records (or replaces) a manually entered rate for a date
+func CreateExchangeRate(c *gin.Context) {
+	var req CreateExchangeRateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rateDate, err := time.Parse("2006-01-02", req.RateDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rate_date, expected YYYY-MM-DD"})
+		return
+	}
+
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	id, err := saveExchangeRate(db, tenant.ID, strings.ToUpper(req.Currency), GetBaseCurrency(db, tenant.ID), req.Rate, rateDate, "manual")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save exchange rate: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Exchange rate saved successfully", "id": id})
+}
+
+// ImportExchangeRates imports rates from an uploaded CSV file ("file") with the columns
+// currency,rate,date (YYYY-MM-DD). A header row is allowed. Existing rates for the same day are replaced.
+func ImportExchangeRates(c *gin.Context) {
+	file, _, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+		return
+	}
+	defer file.Close()
+
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+	base := GetBaseCurrency(db, tenant.ID)
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	imported := 0
+	var errors []string
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid CSV at line %d: %v", line, err)})
+			return
+		}
+		if len(record) < 3 {
+			errors = append(errors, fmt.Sprintf("line %d: expected currency,rate,date", line))
+			continue
+		}
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "currency") {
+			continue
+		}
+
+		currency := strings.ToUpper(strings.TrimSpace(record[0]))
+		rate, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if err != nil || rate <= 0 || len(currency) != 3 {
+			errors = append(errors, fmt.Sprintf("line %d: invalid currency or rate", line))
+			continue
+		}
+		rateDate, err := time.Parse("2006-01-02", strings.TrimSpace(record[2]))
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("line %d: invalid date, expected YYYY-MM-DD", line))
+			continue
+		}
+
+		if _, err := saveExchangeRate(db, tenant.ID, currency, base, rate, rateDate, "import"); err != nil {
+			errors = append(errors, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+		imported++
+	}
+
+	if errors == nil {
+		errors = []string{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Exchange rates imported", "imported": imported, "errors": errors})
+}
+
+// DeleteExchangeRate removes an exchange rate
+func DeleteExchangeRate(c *gin.Context) {
+	id := c.Param("id")
+	db, _, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	result, err := db.Exec(context.Background(), "DELETE FROM exchange_rates WHERE id = $1", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete exchange rate: " + err.Error()})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Exchange rate not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Exchange rate deleted successfully"})
+}
+
+func saveExchangeRate(db *pgxpool.Pool, tenantID, currency, base string, rate float64, rateDate time.Time, source string) (string, error) {
+	if currency == base {
+		return "", fmt.Errorf("%s is the base currency", currency)
+	}
+	var id string
+	err := db.QueryRow(context.Background(),
+		`INSERT INTO exchange_rates (tenant_id, currency, base_currency, rate, rate_date, source)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 ON CONFLICT (currency, base_currency, rate_date) DO UPDATE SET rate = $4, source = $6
+		 RETURNING id`,
+		tenantID, currency, base, rate, rateDate, source).Scan(&id)
+	return id, err
+}
@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"car-rental-backend/internal/audit"
+	"car-rental-backend/internal/models"
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// CustomerMetrics summarizes a customer's rental history. Amounts are in the base currency.
+type CustomerMetrics struct {
+	CustomerID          string     `json:"customer_id"`
+	Name                string     `json:"name"`
+	Email               string     `json:"email"`
+	Phone               string     `json:"phone"`
+	Rentals             int        `json:"rentals"`
+	Cancellations       int        `json:"cancellations"`
+	Incidents           int        `json:"incidents"`
+	TotalSpent          float64    `json:"total_spent"`
+	AverageRentalDays   float64    `json:"average_rental_days"`
+	FirstRental         *time.Time `json:"first_rental"`
+	LastRental          *time.Time `json:"last_rental"`
+	DaysSinceLastRental *int       `json:"days_since_last_rental"`
+	Segments            []string   `json:"segments"` // vip, dormant, one_time
+}
+
+type CustomerSegmentSummary struct {
+	Segment    string  `json:"segment"`
+	Customers  int     `json:"customers"`
+	TotalSpent float64 `json:"total_spent"`
+}
+
+// CustomerIncident is a problem recorded against a customer
+type CustomerIncident struct {
+	ID          string    `json:"id"`
+	CustomerID  string    `json:"customer_id"`
+	BookingID   string    `json:"booking_id"`
+	Type        string    `json:"type"`
+	Description string    `json:"description"`
+	Amount      *float64  `json:"amount"`
+	OccurredAt  time.Time `json:"occurred_at"`
+}
+
+type CreateCustomerIncidentRequest struct {
+	BookingID   string     `json:"booking_id"`
+	Type        string     `json:"type" binding:"required,oneof=damage late_return traffic_fine no_show other"`
+	Description string     `json:"description"`
+	Amount      *float64   `json:"amount"`
+	OccurredAt  *time.Time `json:"occurred_at"`
+}
+
+// Customer segments
+const (
+	SegmentVIP     = "vip"      // Top spenders (top vipPercentile) with repeat rentals
+	SegmentDormant = "dormant"  // No rental for dormantAfterDays
+	SegmentOneTime = "one_time" // A single rental
+)
+
+const (
+	vipPercentile    = 0.10
+	vipMinRentals    = 2
+	dormantAfterDays = 180
+)
+
+var customerSegments = []string{SegmentVIP, SegmentDormant, SegmentOneTime}
+
+// loadCustomerMetrics computes the metrics and segments of every customer.
+// dormantDays overrides the default inactivity period of the dormant segment.
+func loadCustomerMetrics(c *gin.Context, dormantDays int) ([]CustomerMetrics, error) {
+	db, tenant, err := getTenantDBForCustomers(c)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT cust.id, cust.first_name || ' ' || cust.last_name, COALESCE(cust.email, ''), COALESCE(cust.phone, ''),
+		       COALESCE(bk.rentals, 0), COALESCE(bk.cancellations, 0), COALESCE(inc.incidents, 0),
+		       COALESCE(inv.spent, 0), COALESCE(bk.avg_days, 0), bk.first_rental, bk.last_rental
+		FROM customers cust
+		LEFT JOIN (
+			SELECT customer_id,
+			       COUNT(*) FILTER (WHERE status IN `+profitableBookingStatuses+`) as rentals,
+			       COUNT(*) FILTER (WHERE status = 'cancelled') as cancellations,
+			       AVG(end_date - start_date + 1) FILTER (WHERE status IN `+profitableBookingStatuses+`)::float8 as avg_days,
+			       MIN(start_date) FILTER (WHERE status IN `+profitableBookingStatuses+`) as first_rental,
+			       MAX(start_date) FILTER (WHERE status IN `+profitableBookingStatuses+`) as last_rental
+			FROM bookings
+			GROUP BY customer_id
+		) bk ON bk.customer_id = cust.id
+		LEFT JOIN (
+			SELECT b.customer_id,
+			       SUM(i.amount * COALESCE(stored_rate(i.exchange_rate, i.exchange_rate_base, $1), fx_rate(COALESCE(i.currency, 'MAD'), $1, i.created_at::date))) as spent
+			FROM invoices i
+			JOIN bookings b ON i.booking_id = b.id
+			WHERE i.status != 'Cancelled'
+			GROUP BY b.customer_id
+		) inv ON inv.customer_id = cust.id
+		LEFT JOIN (
+			SELECT customer_id, COUNT(*) as incidents FROM customer_incidents GROUP BY customer_id
+		) inc ON inc.customer_id = cust.id
+		WHERE cust.deleted_at IS NULL`, GetBaseCurrency(db, tenant.ID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	var customers []CustomerMetrics
+	for rows.Next() {
+		var m CustomerMetrics
+		if err := rows.Scan(&m.CustomerID, &m.Name, &m.Email, &m.Phone, &m.Rentals, &m.Cancellations, &m.Incidents,
+			&m.TotalSpent, &m.AverageRentalDays, &m.FirstRental, &m.LastRental); err != nil {
+			return nil, err
+		}
+		m.AverageRentalDays = math.Round(m.AverageRentalDays*10) / 10
+		if m.LastRental != nil {
+			days := int(today.Sub(*m.LastRental).Hours() / 24)
+			if days < 0 {
+				days = 0 // Upcoming rental
+			}
+			m.DaysSinceLastRental = &days
+		}
+		customers = append(customers, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// VIPs are the top spenders among customers who spent something
+	var spends []float64
+	for _, m := range customers {
+		if m.TotalSpent > 0 {
+			spends = append(spends, m.TotalSpent)
+		}
+	}
+	vipThreshold := math.Inf(1)
+	if len(spends) > 0 {
+		sort.Sort(sort.Reverse(sort.Float64Slice(spends)))
+		vipThreshold = spends[int(float64(len(spends)-1)*vipPercentile)]
+	}
+
+	for i := range customers {
+		m := &customers[i]
+		m.Segments = []string{}
+		if m.Rentals >= vipMinRentals && m.TotalSpent >= vipThreshold {
+			m.Segments = append(m.Segments, SegmentVIP)
+		}
+		if m.DaysSinceLastRental != nil && *m.DaysSinceLastRental >= dormantDays {
+			m.Segments = append(m.Segments, SegmentDormant)
+		}
+		if m.Rentals == 1 {
+			m.Segments = append(m.Segments, SegmentOneTime)
+		}
+	}
+	return customers, nil
+}
+
+// GetCustomerAnalytics returns per-customer metrics.
+// ?segment=vip|dormant|one_time filters the list, ?sort=total_spent (default)|rentals|last_rental orders it,
+// ?dormant_days= changes the dormant period and ?format=csv|xlsx downloads it for a campaign.
+func GetCustomerAnalytics(c *gin.Context) {
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
+	segment := c.Query("segment")
+	if segment != "" && segment != SegmentVIP && segment != SegmentDormant && segment != SegmentOneTime {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid segment. Allowed: vip, dormant, one_time"})
+		return
+	}
+	dormantDays, ok := dormantDaysParam(c)
+	if !ok {
+		return
+	}
+
+	customers, err := loadCustomerMetrics(c, dormantDays)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute customer metrics: " + err.Error()})
+		return
+	}
+
+	filtered := []CustomerMetrics{}
+	for _, m := range customers {
+		if segment == "" || hasSegment(m, segment) {
+			filtered = append(filtered, m)
+		}
+	}
+
+	switch c.DefaultQuery("sort", "total_spent") {
+	case "total_spent":
+		sort.SliceStable(filtered, func(i, j int) bool { return filtered[i].TotalSpent > filtered[j].TotalSpent })
+	case "rentals":
+		sort.SliceStable(filtered, func(i, j int) bool { return filtered[i].Rentals > filtered[j].Rentals })
+	case "last_rental":
+		sort.SliceStable(filtered, func(i, j int) bool {
+			a, b := filtered[i].LastRental, filtered[j].LastRental
+			return a != nil && (b == nil || a.After(*b))
+		})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort. Allowed: total_spent, rentals, last_rental"})
+		return
+	}
+
+	if format == "" {
+		c.JSON(http.StatusOK, filtered)
+		return
+	}
+
+	title := "Customers"
+	if segment != "" {
+		title = "Customers - " + segment
+	}
+	exp, err := startExport(c, format, c.MustGet("tenant").(*models.Tenant), "customers", title,
+		"Customer ID", "Name", "Email", "Phone", "Rentals", "Cancellations", "Incidents", "Total spent",
+		"Average rental days", "Last rental", "Segments")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start export: " + err.Error()})
+		return
+	}
+	for _, m := range filtered {
+		if err := exp.Row(m.CustomerID, m.Name, m.Email, m.Phone, m.Rentals, m.Cancellations, m.Incidents,
+			m.TotalSpent, m.AverageRentalDays, m.LastRental, strings.Join(m.Segments, ", ")); err != nil {
+			return
+		}
+	}
+	finishExport(exp)
+}
+
+// GetCustomerSegments returns the number of customers and their total spend per segment
+func GetCustomerSegments(c *gin.Context) {
+	dormantDays, ok := dormantDaysParam(c)
+	if !ok {
+		return
+	}
+
+	customers, err := loadCustomerMetrics(c, dormantDays)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute customer metrics: " + err.Error()})
+		return
+	}
+
+	summaries := make([]CustomerSegmentSummary, len(customerSegments))
+	for i, s := range customerSegments {
+		summaries[i].Segment = s
+		for _, m := range customers {
+			if hasSegment(m, s) {
+				summaries[i].Customers++
+				summaries[i].TotalSpent += m.TotalSpent
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"total_customers": len(customers), "segments": summaries})
+}
+
+// GetCustomerIncidents lists the incidents recorded for a customer
+func GetCustomerIncidents(c *gin.Context) {
+	customerID := c.Param("id")
+	db, _, err := getTenantDBForCustomers(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	rows, err := db.Query(context.Background(),
+		`SELECT id, customer_id, COALESCE(booking_id::text, ''), type, COALESCE(description, ''), amount, occurred_at
+		 FROM customer_incidents WHERE customer_id = $1 ORDER BY occurred_at DESC`, customerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch incidents: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var incidents []CustomerIncident
+	for rows.Next() {
+		var i CustomerIncident
+		if err := rows.Scan(&i.ID, &i.CustomerID, &i.BookingID, &i.Type, &i.Description, &i.Amount, &i.OccurredAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan incident: " + err.Error()})
+			return
+		}
+		incidents = append(incidents, i)
+	}
+
+	if incidents == nil {
+		incidents = []CustomerIncident{}
+	}
+
+	c.JSON(http.StatusOK, incidents)
+}
+
+// CreateCustomerIncident records an incident against a customer
+func CreateCustomerIncident(c *gin.Context) {
+	customerID := c.Param("id")
+	var req CreateCustomerIncidentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, tenant, err := getTenantDBForCustomers(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	occurredAt := time.Now()
+	if req.OccurredAt != nil {
+		occurredAt = *req.OccurredAt
+	}
+
+	var incidentID string
+	err = db.QueryRow(context.Background(),
+		`INSERT INTO customer_incidents (tenant_id, customer_id, booking_id, type, description, amount, occurred_at)
+		 SELECT $1, id, $3, $4, $5, $6, $7 FROM customers WHERE id = $2 AND deleted_at IS NULL RETURNING id`,
+		tenant.ID, customerID, nullIfEmpty(req.BookingID), req.Type, req.Description, req.Amount, occurredAt).Scan(&incidentID)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record incident: " + err.Error()})
+		return
+	}
+
+	audit.LogAudit(c, "CREATE_CUSTOMER_INCIDENT", gin.H{"customer_id": customerID, "type": req.Type})
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Incident recorded successfully", "id": incidentID})
+}
+
+// dormantDaysParam reads ?dormant_days= (default dormantAfterDays)
+func dormantDaysParam(c *gin.Context) (int, bool) {
+	v := c.Query("dormant_days")
+	if v == "" {
+		return dormantAfterDays, true
+	}
+	days, err := strconv.Atoi(v)
+	if err != nil || days < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dormant_days must be a positive number of days"})
+		return 0, false
+	}
+	return days, true
+}
+
+func hasSegment(m CustomerMetrics, segment string) bool {
+	for _, s := range m.Segments {
+		if s == segment {
+			return true
+		}
+	}
+	return false
+}
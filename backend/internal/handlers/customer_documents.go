@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"car-rental-backend/internal/audit"
+	"car-rental-backend/internal/models"
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// CustomerDocument is an identity document kept for a renter. The file itself is only
+// served through GetCustomerDocumentFile, never from the public /uploads route.
+type CustomerDocument struct {
+	ID           string     `json:"id"`
+	CustomerID   string     `json:"customer_id"`
+	Type         string     `json:"type"` // cin, passport, driving_license, other
+	Number       string     `json:"number"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	OriginalName string     `json:"original_name"`
+	ContentType  string     `json:"content_type"`
+	Expired      bool       `json:"expired"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// documentExpiryWarningDays is how long before expiry a document is reported on new bookings
+const documentExpiryWarningDays = 30
+
+var customerDocumentTypes = map[string]bool{"cin": true, "passport": true, "driving_license": true, "other": true}
+
+// documentsDir returns the private directory documents are stored in (DOCUMENTS_DIR, default ./storage/documents)
+func documentsDir() string {
+	if dir := os.Getenv("DOCUMENTS_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join("storage", "documents")
+}
+
+// GetCustomerDocuments lists the documents of a customer
+func GetCustomerDocuments(c *gin.Context) {
+	db, _, err := getTenantDBForCustomers(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT id, customer_id, type, COALESCE(number, ''), expires_at, COALESCE(original_name, ''),
+		       COALESCE(content_type, ''), COALESCE(expires_at < CURRENT_DATE, false), created_at
+		FROM customer_documents
+		WHERE customer_id = $1
+		ORDER BY created_at DESC`, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch documents: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	documents := []CustomerDocument{}
+	for rows.Next() {
+		var d CustomerDocument
+		if err := rows.Scan(&d.ID, &d.CustomerID, &d.Type, &d.Number, &d.ExpiresAt, &d.OriginalName,
+			&d.ContentType, &d.Expired, &d.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan document: " + err.Error()})
+			return
+		}
+		documents = append(documents, d)
+	}
+
+	c.JSON(http.StatusOK, documents)
+}
+
+// UploadCustomerDocument stores a document scan. Multipart form: file, type, number and expires_at (YYYY-MM-DD).
+func UploadCustomerDocument(c *gin.Context) {
+	customerID := c.Param("id")
+	db, tenant, err := getTenantDBForCustomers(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	var exists bool
+	err = db.QueryRow(context.Background(),
+		"SELECT true FROM customers WHERE id = $1 AND deleted_at IS NULL", customerID).Scan(&exists)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+
+	documentID, ok := storeCustomerDocument(c, db, tenant, customerID)
+	if !ok {
+		return
+	}
+
+	audit.LogAudit(c, "UPLOAD_CUSTOMER_DOCUMENT", gin.H{"customer_id": customerID, "document_id": documentID, "type": c.PostForm("type")})
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Document uploaded successfully", "id": documentID})
+}
+
+// storeCustomerDocument saves the uploaded document of the request for a customer.
+// It writes the error response itself and returns false when the upload is refused or fails.
+func storeCustomerDocument(c *gin.Context, db *pgxpool.Pool, tenant *models.Tenant, customerID string) (string, bool) {
+	docType := c.PostForm("type")
+	if !customerDocumentTypes[docType] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document type. Allowed: cin, passport, driving_license, other"})
+		return "", false
+	}
+	var expiresAt *time.Time
+	if v := c.PostForm("expires_at"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expires_at format, expected YYYY-MM-DD"})
+			return "", false
+		}
+		expiresAt = &t
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No document file provided"})
+		return "", false
+	}
+	ext := strings.ToLower(filepath.Ext(file.Filename))
+	if ext != ".pdf" && ext != ".png" && ext != ".jpg" && ext != ".jpeg" && ext != ".webp" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file type. Allowed: pdf, png, jpg, jpeg, webp"})
+		return "", false
+	}
+
+	// One directory per tenant, so a path from one tenant's database never points into another's files
+	relPath := filepath.Join(tenant.ID, fmt.Sprintf("%s_%d%s", generateRandomString(16), time.Now().UnixNano(), ext))
+	fullPath := filepath.Join(documentsDir(), relPath)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0700); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create document directory"})
+		return "", false
+	}
+	if err := c.SaveUploadedFile(file, fullPath); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save document"})
+		return "", false
+	}
+
+	var documentID string
+	err = db.QueryRow(context.Background(),
+		`INSERT INTO customer_documents (tenant_id, customer_id, type, number, expires_at, file_path, original_name, content_type)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		tenant.ID, customerID, docType, nullIfEmpty(c.PostForm("number")), expiresAt, relPath,
+		filepath.Base(file.Filename), mime.TypeByExtension(ext)).Scan(&documentID)
+	if err != nil {
+		os.Remove(fullPath)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save document: " + err.Error()})
+		return "", false
+	}
+	return documentID, true
+}
+
+// GetCustomerDocumentFile streams a document file to authenticated staff of the tenant
+func GetCustomerDocumentFile(c *gin.Context) {
+	db, tenant, err := getTenantDBForCustomers(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	var relPath, originalName string
+	err = db.QueryRow(context.Background(),
+		"SELECT file_path, COALESCE(original_name, '') FROM customer_documents WHERE id = $1 AND customer_id = $2",
+		c.Param("docId"), c.Param("id")).Scan(&relPath, &originalName)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+
+	fullPath, ok := documentPath(tenant.ID, relPath)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+	if _, err := os.Stat(fullPath); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document file missing"})
+		return
+	}
+
+	audit.LogAudit(c, "VIEW_CUSTOMER_DOCUMENT", gin.H{"customer_id": c.Param("id"), "document_id": c.Param("docId")})
+
+	c.Header("Cache-Control", "private, no-store")
+	if c.Query("download") == "true" {
+		c.FileAttachment(fullPath, originalName)
+		return
+	}
+	c.File(fullPath)
+}
+
+// DeleteCustomerDocument deletes a document and its file
+func DeleteCustomerDocument(c *gin.Context) {
+	db, tenant, err := getTenantDBForCustomers(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	var relPath string
+	err = db.QueryRow(context.Background(),
+		"DELETE FROM customer_documents WHERE id = $1 AND customer_id = $2 RETURNING file_path",
+		c.Param("docId"), c.Param("id")).Scan(&relPath)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+
+	if fullPath, ok := documentPath(tenant.ID, relPath); ok {
+		os.Remove(fullPath)
+	}
+
+	audit.LogAudit(c, "DELETE_CUSTOMER_DOCUMENT", gin.H{"customer_id": c.Param("id"), "document_id": c.Param("docId")})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Document deleted successfully"})
+}
+
+// documentPath resolves a stored relative path, refusing anything outside the tenant's directory
+func documentPath(tenantID, relPath string) (string, bool) {
+	tenantDir := filepath.Join(documentsDir(), tenantID)
+	fullPath := filepath.Join(documentsDir(), filepath.Clean(relPath))
+	if !strings.HasPrefix(fullPath, tenantDir+string(filepath.Separator)) {
+		return "", false
+	}
+	return fullPath, true
+}
+
+// customerDocumentWarnings reports missing, expired or soon-expiring documents of a customer for a rental ending on endDate
+func customerDocumentWarnings(db *pgxpool.Pool, customerID string, endDate time.Time) ([]string, error) {
+	rows, err := db.Query(context.Background(),
+		"SELECT type, expires_at FROM customer_documents WHERE customer_id = $1 AND type != 'other'", customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	hasIdentity, hasLicense := false, false
+	warnings := []string{}
+	for rows.Next() {
+		var docType string
+		var expiresAt *time.Time
+		if err := rows.Scan(&docType, &expiresAt); err != nil {
+			return nil, err
+		}
+		if docType == "driving_license" {
+			hasLicense = true
+		} else {
+			hasIdentity = true
+		}
+		if expiresAt == nil {
+			continue
+		}
+		label := strings.ReplaceAll(docType, "_", " ")
+		switch {
+		case expiresAt.Before(today):
+			warnings = append(warnings, fmt.Sprintf("Customer %s expired on %s", label, expiresAt.Format("2006-01-02")))
+		case expiresAt.Before(endDate):
+			warnings = append(warnings, fmt.Sprintf("Customer %s expires on %s, before the end of the rental", label, expiresAt.Format("2006-01-02")))
+		case expiresAt.Before(today.AddDate(0, 0, documentExpiryWarningDays)):
+			warnings = append(warnings, fmt.Sprintf("Customer %s expires on %s", label, expiresAt.Format("2006-01-02")))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !hasIdentity {
+		warnings = append(warnings, "No identity document (CIN or passport) on file for this customer")
+	}
+	if !hasLicense {
+		warnings = append(warnings, "No driving license on file for this customer")
+	}
+	return warnings, nil
+}
@@ -0,0 +1,513 @@
+package handlers
+
+import (
+	"car-rental-backend/internal/audit"
+	"car-rental-backend/internal/database"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Risk levels
+const (
+	RiskBlacklist    = "blacklist"     // Bookings are refused
+	RiskExtraDeposit = "extra_deposit" // Bookings are allowed, staff are told to take a higher deposit
+	RiskWatch        = "watch"         // Bookings are allowed with a warning
+)
+
+// CustomerFlag marks a customer, or identifiers of someone who is not a customer yet, as risky
+type CustomerFlag struct {
+	ID         string     `json:"id"`
+	CustomerID string     `json:"customer_id"`
+	Customer   string     `json:"customer"`
+	Level      string     `json:"level"`
+	Reason     string     `json:"reason"`
+	Phone      string     `json:"phone"`
+	Email      string     `json:"email"`
+	IDNumber   string     `json:"id_number"`
+	CreatedBy  string     `json:"created_by"`
+	Author     string     `json:"author"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LiftedAt   *time.Time `json:"lifted_at"`
+}
+
+type CreateCustomerFlagRequest struct {
+	CustomerID string `json:"customer_id"` // Ignored on /customers/:id/flags
+	Level      string `json:"level" binding:"required,oneof=blacklist extra_deposit watch"`
+	Reason     string `json:"reason" binding:"required"`
+	Phone      string `json:"phone"`
+	Email      string `json:"email"`
+	IDNumber   string `json:"id_number"`
+}
+
+type RiskSettings struct {
+	ShareBlacklist bool `json:"share_blacklist"`
+}
+
+// RiskCheck is the outcome of checking a renter against the flags
+type RiskCheck struct {
+	Blocked    bool           `json:"blocked"`
+	Reason     string         `json:"reason,omitempty"`
+	Warnings   []string       `json:"warnings"`
+	Flags      []CustomerFlag `json:"flags"`
+	SharedHits int            `json:"shared_hits"` // Other tenants that blacklisted the same identifiers
+}
+
+// riskIdentifiers are the normalized identifiers a renter is matched on
+type riskIdentifiers struct {
+	phones, emails, idNumbers []string
+}
+
+const customerFlagColumns = `f.id, COALESCE(f.customer_id::text, ''), COALESCE(cust.first_name || ' ' || cust.last_name, ''),
+	f.level, f.reason, COALESCE(f.phone, ''), COALESCE(f.email, ''), COALESCE(f.id_number, ''),
+	COALESCE(f.created_by::text, ''), COALESCE(u.first_name || ' ' || u.last_name, ''), f.created_at, f.lifted_at`
+
+func scanCustomerFlag(row pgx.Row, f *CustomerFlag) error {
+	return row.Scan(&f.ID, &f.CustomerID, &f.Customer, &f.Level, &f.Reason, &f.Phone, &f.Email, &f.IDNumber,
+		&f.CreatedBy, &f.Author, &f.CreatedAt, &f.LiftedAt)
+}
+
+// GetCustomerFlags lists flags. ?customer_id= restricts to a customer; lifted flags are included with ?all=true.
+func GetCustomerFlags(c *gin.Context) {
+	db, _, err := getTenantDBForCustomers(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	customerID := c.Param("id")
+	if customerID == "" {
+		customerID = c.Query("customer_id")
+	}
+
+	query := `SELECT ` + customerFlagColumns + `
+		FROM customer_flags f
+		LEFT JOIN customers cust ON f.customer_id = cust.id
+		LEFT JOIN users u ON f.created_by = u.id
+		WHERE ($1 = '' OR f.customer_id::text = $1) AND ($2 OR f.lifted_at IS NULL)
+		ORDER BY f.created_at DESC`
+	rows, err := db.Query(context.Background(), query, customerID, c.Query("all") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch flags: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	flags := []CustomerFlag{}
+	for rows.Next() {
+		var f CustomerFlag
+		if err := scanCustomerFlag(rows, &f); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan flag: " + err.Error()})
+			return
+		}
+		flags = append(flags, f)
+	}
+
+	c.JSON(http.StatusOK, flags)
+}
+
+// CreateCustomerFlag flags a customer (from :id or customer_id) or bare identifiers. The customer's phone,
+// email, license and document numbers are copied onto the flag so the person is recognized if they come
+// back under a new customer record.
+func CreateCustomerFlag(c *gin.Context) {
+	var req CreateCustomerFlagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if id := c.Param("id"); id != "" {
+		req.CustomerID = id
+	}
+
+	db, tenant, err := getTenantDBForCustomers(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	ids := riskIdentifiers{}
+	ids.add(req.Phone, req.Email, req.IDNumber)
+	if req.CustomerID != "" {
+		customerIDs, err := loadCustomerIdentifiers(db, req.CustomerID)
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch customer: " + err.Error()})
+			return
+		}
+		ids.merge(customerIDs)
+	} else if ids.empty() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A customer or at least one of phone, email and id_number is required"})
+		return
+	}
+
+	userID := c.GetString("user_id")
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction: " + err.Error()})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// One row per identifier set; a customer with several documents gets one row per extra number
+	var flagIDs []string
+	for i := 0; i < ids.rows(); i++ {
+		var flagID string
+		err = tx.QueryRow(ctx,
+			`INSERT INTO customer_flags (tenant_id, customer_id, level, reason, phone, email, id_number, created_by)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+			tenant.ID, nullIfEmpty(req.CustomerID), req.Level, req.Reason,
+			nullIfEmpty(ids.at(ids.phones, i)), nullIfEmpty(ids.at(ids.emails, i)), nullIfEmpty(ids.at(ids.idNumbers, i)),
+			nullIfEmpty(userID)).Scan(&flagID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create flag: " + err.Error()})
+			return
+		}
+		flagIDs = append(flagIDs, flagID)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit flag: " + err.Error()})
+		return
+	}
+
+	if req.Level == RiskBlacklist && loadRiskSettings(db, tenant.ID).ShareBlacklist {
+		shareBlacklist(tenant.ID, flagIDs[0], ids)
+	}
+
+	audit.LogAudit(c, "FLAG_CUSTOMER", gin.H{"customer_id": req.CustomerID, "level": req.Level, "reason": req.Reason})
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Flag created successfully", "id": flagIDs[0]})
+}
+
+// LiftCustomerFlag lifts a flag (and the other rows created with it for the same customer and reason)
+func LiftCustomerFlag(c *gin.Context) {
+	flagID := c.Param("flagId")
+	db, tenant, err := getTenantDBForCustomers(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	userID := c.GetString("user_id")
+	rows, err := db.Query(context.Background(), `
+		UPDATE customer_flags SET lifted_at = NOW(), lifted_by = $2
+		WHERE lifted_at IS NULL AND (id = $1 OR id IN (
+			SELECT o.id FROM customer_flags f JOIN customer_flags o
+			  ON o.customer_id = f.customer_id AND o.reason = f.reason AND o.level = f.level AND o.created_at = f.created_at
+			WHERE f.id = $1))
+		RETURNING id`, flagID, nullIfEmpty(userID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to lift flag: " + err.Error()})
+		return
+	}
+	var lifted []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			lifted = append(lifted, id)
+		}
+	}
+	rows.Close()
+
+	if len(lifted) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Flag not found"})
+		return
+	}
+
+	database.DB.Exec(context.Background(),
+		"DELETE FROM shared_blacklist WHERE tenant_id = $1 AND flag_id = ANY($2::uuid[])", tenant.ID, lifted)
+
+	audit.LogAudit(c, "LIFT_CUSTOMER_FLAG", gin.H{"flag_id": flagID})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Flag lifted successfully"})
+}
+
+// GetRiskSettings returns the tenant's blacklist sharing settings
+func GetRiskSettings(c *gin.Context) {
+	db, tenant, err := getTenantDBForCustomers(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+	c.JSON(http.StatusOK, loadRiskSettings(db, tenant.ID))
+}
+
+// UpdateRiskSettings opts the tenant in or out of the shared blacklist. Opting in shares the hashes of the
+// current blacklist; opting out withdraws them.
+func UpdateRiskSettings(c *gin.Context) {
+	var req RiskSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.ShareBlacklist && !SharedBlacklistEnabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "The shared blacklist is not configured on this server"})
+		return
+	}
+
+	db, tenant, err := getTenantDBForCustomers(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	_, err = db.Exec(context.Background(),
+		`INSERT INTO risk_settings (tenant_id, share_blacklist, updated_at)
+		 VALUES ($1, $2, NOW())
+		 ON CONFLICT (tenant_id) DO UPDATE SET share_blacklist = $2, updated_at = NOW()`,
+		tenant.ID, req.ShareBlacklist)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update risk settings: " + err.Error()})
+		return
+	}
+
+	database.DB.Exec(context.Background(), "DELETE FROM shared_blacklist WHERE tenant_id = $1", tenant.ID)
+	if req.ShareBlacklist {
+		rows, err := db.Query(context.Background(), `
+			SELECT id, COALESCE(phone, ''), COALESCE(email, ''), COALESCE(id_number, '')
+			FROM customer_flags WHERE level = 'blacklist' AND lifted_at IS NULL`)
+		if err == nil {
+			for rows.Next() {
+				var flagID, phone, email, idNumber string
+				if rows.Scan(&flagID, &phone, &email, &idNumber) == nil {
+					ids := riskIdentifiers{}
+					ids.add(phone, email, idNumber)
+					shareBlacklist(tenant.ID, flagID, ids)
+				}
+			}
+			rows.Close()
+		}
+	}
+
+	audit.LogAudit(c, "UPDATE_RISK_SETTINGS", gin.H{"share_blacklist": req.ShareBlacklist})
+
+	c.JSON(http.StatusOK, req)
+}
+
+func loadRiskSettings(db *pgxpool.Pool, tenantID string) RiskSettings {
+	var s RiskSettings
+	db.QueryRow(context.Background(),
+		"SELECT share_blacklist FROM risk_settings WHERE tenant_id = $1", tenantID).Scan(&s.ShareBlacklist)
+	return s
+}
+
+// CheckCustomerRisk matches a renter against the active flags by customer ID and by the given identifiers
+// (plus the customer's own). Blacklist flags block; other levels and hits from other tenants only warn.
+func CheckCustomerRisk(db *pgxpool.Pool, tenantID, customerID, phone, email, idNumber string) (RiskCheck, error) {
+	check := RiskCheck{Warnings: []string{}, Flags: []CustomerFlag{}}
+
+	ids := riskIdentifiers{}
+	ids.add(phone, email, idNumber)
+	if customerID != "" {
+		customerIDs, err := loadCustomerIdentifiers(db, customerID)
+		if err != nil && err != pgx.ErrNoRows {
+			return check, err
+		}
+		ids.merge(customerIDs)
+	}
+	if customerID == "" && ids.empty() {
+		return check, nil
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT `+customerFlagColumns+`
+		FROM customer_flags f
+		LEFT JOIN customers cust ON f.customer_id = cust.id
+		LEFT JOIN users u ON f.created_by = u.id
+		WHERE f.lifted_at IS NULL
+		  AND (f.customer_id::text = $1 OR f.phone = ANY($2) OR f.email = ANY($3) OR f.id_number = ANY($4))
+		ORDER BY f.created_at DESC`, customerID, ids.phones, ids.emails, ids.idNumbers)
+	if err != nil {
+		return check, err
+	}
+	defer rows.Close()
+
+	seen := map[string]bool{}
+	for rows.Next() {
+		var f CustomerFlag
+		if err := scanCustomerFlag(rows, &f); err != nil {
+			return check, err
+		}
+		check.Flags = append(check.Flags, f)
+		if seen[f.Level+f.Reason] {
+			continue
+		}
+		seen[f.Level+f.Reason] = true
+		switch f.Level {
+		case RiskBlacklist:
+			if !check.Blocked {
+				check.Blocked = true
+				check.Reason = "Customer is blacklisted: " + f.Reason
+			}
+		case RiskExtraDeposit:
+			check.Warnings = append(check.Warnings, "Extra deposit required: "+f.Reason)
+		default:
+			check.Warnings = append(check.Warnings, "Customer on watch list: "+f.Reason)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return check, err
+	}
+
+	if SharedBlacklistEnabled() && loadRiskSettings(db, tenantID).ShareBlacklist {
+		hashes := ids.hashes()
+		if len(hashes) > 0 {
+			err := database.DB.QueryRow(context.Background(),
+				"SELECT COUNT(DISTINCT tenant_id) FROM shared_blacklist WHERE tenant_id != $1 AND identifier_hash = ANY($2)",
+				tenantID, hashes).Scan(&check.SharedHits)
+			if err != nil {
+				log.Printf("[RISK] Failed to check shared blacklist: %v", err)
+			} else if check.SharedHits > 0 {
+				check.Warnings = append(check.Warnings,
+					fmt.Sprintf("Blacklisted by %d other rental agencies on the platform", check.SharedHits))
+			}
+		}
+	}
+
+	return check, nil
+}
+
+// loadCustomerIdentifiers returns the phone, email, license and document numbers of a customer
+func loadCustomerIdentifiers(db *pgxpool.Pool, customerID string) (riskIdentifiers, error) {
+	ids := riskIdentifiers{}
+	var phone, email, license string
+	err := db.QueryRow(context.Background(),
+		"SELECT COALESCE(phone, ''), COALESCE(email, ''), COALESCE(license_number, '') FROM customers WHERE id = $1",
+		customerID).Scan(&phone, &email, &license)
+	if err != nil {
+		return ids, err
+	}
+	ids.add(phone, email, license)
+
+	rows, err := db.Query(context.Background(),
+		"SELECT number FROM customer_documents WHERE customer_id = $1 AND COALESCE(number, '') != ''", customerID)
+	if err != nil {
+		return ids, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var number string
+		if err := rows.Scan(&number); err != nil {
+			return ids, err
+		}
+		ids.add("", "", number)
+	}
+	return ids, rows.Err()
+}
+
+// shareBlacklist publishes the hashed identifiers of a blacklist flag to the master database
+func shareBlacklist(tenantID, flagID string, ids riskIdentifiers) {
+	for _, h := range ids.hashes() {
+		_, err := database.DB.Exec(context.Background(),
+			`INSERT INTO shared_blacklist (tenant_id, flag_id, identifier_hash) VALUES ($1, $2, $3)
+			 ON CONFLICT (flag_id, identifier_hash) DO NOTHING`, tenantID, flagID, h)
+		if err != nil {
+			log.Printf("[RISK] Failed to share blacklist entry: %v", err)
+			return
+		}
+	}
+}
+
+func (ids *riskIdentifiers) add(phone, email, idNumber string) {
+	if p := normalizePhone(phone); len(p) >= phoneMatchDigits {
+		ids.phones = appendUnique(ids.phones, p[len(p)-phoneMatchDigits:])
+	}
+	if e := strings.ToLower(strings.TrimSpace(email)); e != "" {
+		ids.emails = appendUnique(ids.emails, e)
+	}
+	if n := normalizeIDNumber(idNumber); n != "" {
+		ids.idNumbers = appendUnique(ids.idNumbers, n)
+	}
+}
+
+func (ids *riskIdentifiers) merge(other riskIdentifiers) {
+	for _, p := range other.phones {
+		ids.phones = appendUnique(ids.phones, p)
+	}
+	for _, e := range other.emails {
+		ids.emails = appendUnique(ids.emails, e)
+	}
+	for _, n := range other.idNumbers {
+		ids.idNumbers = appendUnique(ids.idNumbers, n)
+	}
+}
+
+func (ids riskIdentifiers) empty() bool {
+	return len(ids.phones) == 0 && len(ids.emails) == 0 && len(ids.idNumbers) == 0
+}
+
+// rows is the number of flag rows needed to store every identifier
+func (ids riskIdentifiers) rows() int {
+	n := 1
+	for _, l := range []int{len(ids.phones), len(ids.emails), len(ids.idNumbers)} {
+		if l > n {
+			n = l
+		}
+	}
+	return n
+}
+
+func (ids riskIdentifiers) at(values []string, i int) string {
+	if i < len(values) {
+		return values[i]
+	}
+	return ""
+}
+
+// SharedBlacklistEnabled reports whether BLACKLIST_HASH_SECRET is set. Without it the identifier hashes could be
+// reversed by brute force, so nothing is published to or looked up in the shared blacklist.
+func SharedBlacklistEnabled() bool {
+	return os.Getenv("BLACKLIST_HASH_SECRET") != ""
+}
+
+// hashes returns the salted SHA-256 of every identifier, so tenants can match renters without sharing them.
+// BLACKLIST_HASH_SECRET must be the same on every instance of the platform; without it there are no hashes.
+func (ids riskIdentifiers) hashes() []string {
+	secret := os.Getenv("BLACKLIST_HASH_SECRET")
+	if secret == "" {
+		return nil
+	}
+	var hashes []string
+	for kind, values := range map[string][]string{"phone": ids.phones, "email": ids.emails, "id": ids.idNumbers} {
+		for _, v := range values {
+			sum := sha256.Sum256([]byte(secret + "|" + kind + "|" + v))
+			hashes = append(hashes, hex.EncodeToString(sum[:]))
+		}
+	}
+	return hashes
+}
+
+// normalizeIDNumber keeps the uppercased letters and digits of a document number
+func normalizeIDNumber(number string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(number) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func appendUnique(values []string, v string) []string {
+	for _, existing := range values {
+		if existing == v {
+			return values
+		}
+	}
+	return append(values, v)
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"car-rental-backend/internal/audit"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TimelineEvent is one entry of a customer's history
+type TimelineEvent struct {
+	Type       string    `json:"type"`
+	ID         string    `json:"id"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Title      string    `json:"title"`
+	Details    string    `json:"details"`
+	Status     string    `json:"status"`
+	Amount     *float64  `json:"amount"`
+	Currency   string    `json:"currency"`
+}
+
+type CreateCustomerNoteRequest struct {
+	Body string `json:"body" binding:"required"`
+}
+
+const (
+	defaultTimelinePageSize = 50
+	maxTimelinePageSize     = 200
+)
+
+// timelineSource selects the events of one type. Queries return type, id, occurred_at, title, details, status,
+// amount and currency, with $1 the customer ID, $2 the last digits of their phone and $3 their lowercased email.
+type timelineSource struct {
+	Type  string
+	Query string
+}
+
+var timelineSources = []timelineSource{
+	{"booking", `
+		SELECT 'booking', b.id::text, b.created_at,
+		       'Booking ' || c.brand || ' ' || c.model || ', ' || to_char(b.start_date, 'YYYY-MM-DD') || ' to ' || to_char(b.end_date, 'YYYY-MM-DD'),
+		       COALESCE('Cancellation requested: ' || b.cancellation_reason, ''), b.status,
+		       (b.price_per_day * (b.end_date - b.start_date + 1))::float8, COALESCE(b.currency, 'MAD')
+		FROM bookings b JOIN cars c ON b.car_id = c.id
+		WHERE b.customer_id = $1`},
+	{"booking_request", `
+		SELECT 'booking_request', r.id::text, r.created_at,
+		       'Booking request ' || COALESCE(c.brand || ' ' || c.model, '') || ', ' || to_char(r.pickup_date, 'YYYY-MM-DD') || ' to ' || to_char(r.return_date, 'YYYY-MM-DD'),
+		       COALESCE(r.message, ''), r.status, NULL::float8, ''
+		FROM booking_requests r LEFT JOIN cars c ON r.car_id = c.id
+		WHERE ($2 != '' AND right(regexp_replace(r.customer_phone, '\D', '', 'g'), ` + strconv.Itoa(phoneMatchDigits) + `) = $2)
+		   OR ($3 != '' AND LOWER(TRIM(r.customer_email)) = $3)`},
+	{"invoice", `
+		SELECT 'invoice', i.id::text, i.created_at, 'Invoice ' || LEFT(i.id::text, 8),
+		       'Due ' || to_char(i.due_date, 'YYYY-MM-DD'), i.status, i.amount::float8, COALESCE(i.currency, 'MAD')
+		FROM invoices i JOIN bookings b ON i.booking_id = b.id
+		WHERE b.customer_id = $1`},
+	{"payment", `
+		SELECT 'payment', p.id::text, p.created_at, 'Payment for invoice ' || LEFT(i.id::text, 8),
+		       COALESCE(p.method, '') || COALESCE(' ' || p.reference, ''), '', p.amount::float8, COALESCE(i.currency, 'MAD')
+		FROM payments p JOIN invoices i ON p.invoice_id = i.id JOIN bookings b ON i.booking_id = b.id
+		WHERE b.customer_id = $1`},
+	{"message", `
+		SELECT 'message', d.id::text, d.sent_at, 'Payment reminder by ' || d.channel,
+		       COALESCE(d.recipient, '') || COALESCE(': ' || d.error, ''), COALESCE(d.status, ''), NULL::float8, ''
+		FROM dunning_reminders d JOIN invoices i ON d.invoice_id = i.id JOIN bookings b ON i.booking_id = b.id
+		WHERE b.customer_id = $1`},
+	{"email", `
+		SELECT 'email', e.id::text, e.created_at, e.subject, array_to_string(e.recipients, ', '), e.status, NULL::float8, ''
+		FROM email_outbox e
+		WHERE e.customer_id = $1`},
+	{"text_message", `
+		SELECT 'text_message', m.id::text, m.created_at, replace(m.template, '_', ' ') || ' by ' || m.channel,
+		       m.body || COALESCE(' (' || m.last_error || ')', ''), m.status, NULL::float8, ''
+		FROM message_outbox m
+		WHERE m.customer_id = $1`},
+	{"note", `
+		SELECT 'note', n.id::text, n.created_at, 'Note', n.body, '', NULL::float8, ''
+		FROM customer_notes n
+		WHERE n.customer_id = $1`},
+	{"incident", `
+		SELECT 'incident', ci.id::text, ci.created_at, 'Incident: ' || replace(ci.type, '_', ' '),
+		       COALESCE(ci.description, ''), '', ci.amount::float8, ''
+		FROM customer_incidents ci
+		WHERE ci.customer_id = $1`},
+	{"flag", `
+		SELECT 'flag', f.id::text, f.created_at, 'Flagged: ' || replace(f.level, '_', ' '), f.reason,
+		       CASE WHEN f.lifted_at IS NULL THEN 'active' ELSE 'lifted' END, NULL::float8, ''
+		FROM customer_flags f
+		WHERE f.customer_id = $1`},
+	{"loyalty", `
+		SELECT 'loyalty', l.id::text, l.created_at, 'Loyalty points ' || l.kind, COALESCE(l.note, ''), '', l.points::float8, 'points'
+		FROM loyalty_transactions l
+		WHERE l.customer_id = $1`},
+}
+
+// GetCustomerTimeline returns a customer's bookings, requests, invoices, payments, messages, notes, incidents and
+// flags, newest first. Filter with ?types=booking,invoice,...; paginate with ?limit= and ?cursor=.
+func GetCustomerTimeline(c *gin.Context) {
+	customerID := c.Param("id")
+
+	limit := defaultTimelinePageSize
+	if v := c.Query("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > maxTimelinePageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxTimelinePageSize)})
+			return
+		}
+		limit = l
+	}
+
+	types := map[string]bool{}
+	if v := c.Query("types"); v != "" {
+		for _, t := range strings.Split(v, ",") {
+			types[strings.TrimSpace(t)] = true
+		}
+	}
+	parts := []string{}
+	for _, source := range timelineSources {
+		if len(types) == 0 || types[source.Type] {
+			parts = append(parts, source.Query)
+		}
+	}
+	if len(parts) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown timeline types"})
+		return
+	}
+
+	db, _, err := getTenantDBForCustomers(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	var phone, email string
+	err = db.QueryRow(context.Background(),
+		"SELECT COALESCE(phone, ''), LOWER(TRIM(COALESCE(email, ''))) FROM customers WHERE id = $1", customerID).Scan(&phone, &email)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+	if digits := normalizePhone(phone); len(digits) >= phoneMatchDigits {
+		phone = digits[len(digits)-phoneMatchDigits:]
+	} else {
+		phone = ""
+	}
+
+	args := []interface{}{customerID, phone, email}
+	where := ""
+	if cursor := c.Query("cursor"); cursor != "" {
+		occurredAt, id, ok := decodeCursor(cursor)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		where = "WHERE (occurred_at, id) < ($4, $5)"
+		args = append(args, occurredAt, id)
+	}
+	args = append(args, limit+1)
+
+	// The params CTE types $1-$3 even when the selected sources don't all use them
+	query := fmt.Sprintf(`
+		WITH params AS (SELECT $1::uuid, $2::text, $3::text)
+		SELECT type, id, occurred_at, title, details, status, amount, currency
+		FROM (%s) AS timeline (type, id, occurred_at, title, details, status, amount, currency)
+		%s
+		ORDER BY occurred_at DESC, id DESC
+		LIMIT $%d`, strings.Join(parts, "\n\t\tUNION ALL\n"), where, len(args))
+
+	rows, err := db.Query(context.Background(), query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch timeline: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	events := []TimelineEvent{}
+	for rows.Next() {
+		var e TimelineEvent
+		if err := rows.Scan(&e.Type, &e.ID, &e.OccurredAt, &e.Title, &e.Details, &e.Status, &e.Amount, &e.Currency); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan timeline event: " + err.Error()})
+			return
+		}
+		events = append(events, e)
+	}
+
+	var nextCursor *string
+	if len(events) > limit {
+		events = events[:limit]
+		last := events[limit-1]
+		cursor := encodeCursor(last.OccurredAt, last.ID)
+		nextCursor = &cursor
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": events, "next_cursor": nextCursor})
+}
+
+// CreateCustomerNote adds a staff note to a customer's timeline
+func CreateCustomerNote(c *gin.Context) {
+	customerID := c.Param("id")
+	var req CreateCustomerNoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if strings.TrimSpace(req.Body) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Note cannot be empty"})
+		return
+	}
+
+	db, tenant, err := getTenantDBForCustomers(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	var id string
+	err = db.QueryRow(context.Background(),
+		`INSERT INTO customer_notes (tenant_id, customer_id, body, created_by)
+		 SELECT $1, id, $3, $4 FROM customers WHERE id = $2 AND deleted_at IS NULL
+		 RETURNING id`,
+		tenant.ID, customerID, strings.TrimSpace(req.Body), nullIfEmpty(c.GetString("user_id"))).Scan(&id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+
+	audit.LogAudit(c, "CREATE_CUSTOMER_NOTE", gin.H{"customer_id": customerID, "note_id": id})
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Note added successfully", "id": id})
+}
+
+// DeleteCustomerNote deletes a staff note
+func DeleteCustomerNote(c *gin.Context) {
+	db, _, err := getTenantDBForCustomers(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	result, err := db.Exec(context.Background(),
+		"DELETE FROM customer_notes WHERE id = $1 AND customer_id = $2", c.Param("noteId"), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete note: " + err.Error()})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		return
+	}
+
+	audit.LogAudit(c, "DELETE_CUSTOMER_NOTE", gin.H{"customer_id": c.Param("id"), "note_id": c.Param("noteId")})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Note deleted successfully"})
+}
@@ -1,23 +1,32 @@
 package handlers
 
 import (
+	"car-rental-backend/internal/audit"
 	"car-rental-backend/internal/database"
 	"car-rental-backend/internal/models"
 	"context"
+	"encoding/base64"
+	"fmt"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type Customer struct {
-	ID            string `json:"id"`
-	FirstName     string `json:"first_name"`
-	LastName      string `json:"last_name"`
-	Email         string `json:"email"`
-	Phone         string `json:"phone"`
-	LicenseNumber string `json:"license_number"`
-	Address       string `json:"address"`
+	ID            string    `json:"id"`
+	FirstName     string    `json:"first_name"`
+	LastName      string    `json:"last_name"`
+	Email         string    `json:"email"`
+	Phone         string    `json:"phone"`
+	LicenseNumber string    `json:"license_number"`
+	Address       string    `json:"address"`
+	CreatedAt     time.Time `json:"created_at"`
 }
 
 type CreateCustomerRequest struct {
@@ -29,6 +38,30 @@ type CreateCustomerRequest struct {
 	Address       string `json:"address"`
 }
 
+type UpdateCustomerRequest struct {
+	FirstName     *string `json:"first_name"`
+	LastName      *string `json:"last_name"`
+	Email         *string `json:"email"`
+	Phone         *string `json:"phone"`
+	LicenseNumber *string `json:"license_number"`
+	Address       *string `json:"address"`
+}
+
+type MergeCustomersRequest struct {
+	SourceIDs []string `json:"source_ids" binding:"required,min=1"` // Customers merged into :id and then deleted
+}
+
+const (
+	defaultCustomerPageSize = 50
+	maxCustomerPageSize     = 200
+	// phoneMatchDigits is how many trailing digits identify a phone number, so that
+	// "+212 6 12 34 56 78", "00212612345678" and "0612345678" match
+	phoneMatchDigits = 9
+)
+
+const customerColumns = `id, first_name, last_name, COALESCE(email, ''), COALESCE(phone, ''),
+	COALESCE(license_number, ''), COALESCE(address, ''), created_at`
+
 // Helper to get tenant DB connection (reused logic)
 func getTenantDBForCustomers(c *gin.Context) (*pgxpool.Pool, *models.Tenant, error) {
 	tenantCtx, exists := c.Get("tenant")
@@ -40,47 +73,112 @@ func getTenantDBForCustomers(c *gin.Context) (*pgxpool.Pool, *models.Tenant, err
 	return db, tenant, err
 }
 
+func scanCustomer(row pgx.Row, cust *Customer) error {
+	return row.Scan(&cust.ID, &cust.FirstName, &cust.LastName, &cust.Email, &cust.Phone,
+		&cust.LicenseNumber, &cust.Address, &cust.CreatedAt)
+}
+
+// GetCustomers lists customers, newest first. ?q= searches name, email, phone and license number.
+// Results are paginated: ?limit= (default 50, max 200) and ?cursor= taken from the previous page's next_cursor.
 func GetCustomers(c *gin.Context) {
+	limit := defaultCustomerPageSize
+	if v := c.Query("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > maxCustomerPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxCustomerPageSize)})
+			return
+		}
+		limit = l
+	}
+
 	db, _, err := getTenantDBForCustomers(c)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
 		return
 	}
 
-	rows, err := db.Query(context.Background(), "SELECT id, first_name, last_name, email, phone, address FROM customers ORDER BY created_at DESC")
+	query := "SELECT " + customerColumns + " FROM customers WHERE deleted_at IS NULL"
+	args := []interface{}{}
+	argIndex := 1
+
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		pattern := "%" + q + "%"
+		query += fmt.Sprintf(` AND (first_name || ' ' || last_name ILIKE $%d OR email ILIKE $%d OR phone ILIKE $%d OR license_number ILIKE $%d`,
+			argIndex, argIndex, argIndex, argIndex)
+		args = append(args, pattern)
+		argIndex++
+		if digits := normalizePhone(q); len(digits) >= 4 {
+			query += fmt.Sprintf(" OR regexp_replace(phone, '\\D', '', 'g') LIKE $%d", argIndex)
+			args = append(args, "%"+digits+"%")
+			argIndex++
+		}
+		query += ")"
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		createdAt, id, ok := decodeCursor(cursor)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		query += fmt.Sprintf(" AND (created_at, id) < ($%d, $%d)", argIndex, argIndex+1)
+		args = append(args, createdAt, id)
+		argIndex += 2
+	}
+
+	// One extra row tells whether there is a next page
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", argIndex)
+	args = append(args, limit+1)
+
+	rows, err := db.Query(context.Background(), query, args...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch customers: " + err.Error()})
 		return
 	}
 	defer rows.Close()
 
-	var customers []Customer
+	customers := []Customer{}
 	for rows.Next() {
 		var cust Customer
-		var email, phone, address *string
-		if err := rows.Scan(&cust.ID, &cust.FirstName, &cust.LastName, &email, &phone, &address); err != nil {
+		if err := scanCustomer(rows, &cust); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan customer: " + err.Error()})
 			return
 		}
-		if email != nil {
-			cust.Email = *email
-		}
-		if phone != nil {
-			cust.Phone = *phone
-		}
-		if address != nil {
-			cust.Address = *address
-		}
 		customers = append(customers, cust)
 	}
 
-	if customers == nil {
-		customers = []Customer{}
+	var nextCursor *string
+	if len(customers) > limit {
+		customers = customers[:limit]
+		last := customers[limit-1]
+		cursor := encodeCursor(last.CreatedAt, last.ID)
+		nextCursor = &cursor
 	}
 
-	c.JSON(http.StatusOK, customers)
+	c.JSON(http.StatusOK, gin.H{"data": customers, "next_cursor": nextCursor})
 }
 
+// GetCustomer returns a single customer
+func GetCustomer(c *gin.Context) {
+	db, _, err := getTenantDBForCustomers(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	var cust Customer
+	err = scanCustomer(db.QueryRow(context.Background(),
+		"SELECT "+customerColumns+" FROM customers WHERE id = $1 AND deleted_at IS NULL", c.Param("id")), &cust)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, cust)
+}
+
+// CreateCustomer creates a customer. If another customer has the same email or phone number the
+// request is refused with 409 and the matches; ?force=true creates the customer anyway.
 func CreateCustomer(c *gin.Context) {
 	var req CreateCustomerRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -94,10 +192,25 @@ func CreateCustomer(c *gin.Context) {
 		return
 	}
 
+	if c.Query("force") != "true" {
+		duplicates, err := findDuplicateCustomers(db, req.Email, req.Phone, "")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check duplicates: " + err.Error()})
+			return
+		}
+		if len(duplicates) > 0 {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":      "A customer with the same email or phone already exists",
+				"duplicates": duplicates,
+			})
+			return
+		}
+	}
+
 	var customerID string
 	err = db.QueryRow(context.Background(),
-		"INSERT INTO customers (tenant_id, first_name, last_name, email, phone, address) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
-		tenant.ID, req.FirstName, req.LastName, req.Email, req.Phone, req.Address).Scan(&customerID)
+		"INSERT INTO customers (tenant_id, first_name, last_name, email, phone, license_number, address) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id",
+		tenant.ID, req.FirstName, req.LastName, req.Email, req.Phone, nullIfEmpty(req.LicenseNumber), req.Address).Scan(&customerID)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create customer: " + err.Error()})
@@ -106,3 +219,337 @@ func CreateCustomer(c *gin.Context) {
 
 	c.JSON(http.StatusCreated, gin.H{"message": "Customer created successfully", "id": customerID})
 }
+
+// UpdateCustomer updates the provided fields of a customer
+func UpdateCustomer(c *gin.Context) {
+	customerID := c.Param("id")
+	var req UpdateCustomerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, _, err := getTenantDBForCustomers(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	setClauses := []string{}
+	args := []interface{}{}
+	argIndex := 1
+
+	for _, f := range []struct {
+		column string
+		value  *string
+	}{
+		{"first_name", req.FirstName},
+		{"last_name", req.LastName},
+		{"email", req.Email},
+		{"phone", req.Phone},
+		{"license_number", req.LicenseNumber},
+		{"address", req.Address},
+	} {
+		if f.value != nil {
+			setClauses = append(setClauses, fmt.Sprintf("%s = $%d", f.column, argIndex))
+			args = append(args, *f.value)
+			argIndex++
+		}
+	}
+
+	if len(setClauses) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields to update"})
+		return
+	}
+
+	setClauses = append(setClauses, "updated_at = NOW()")
+	query := fmt.Sprintf("UPDATE customers SET %s WHERE id = $%d AND deleted_at IS NULL",
+		strings.Join(setClauses, ", "), argIndex)
+	args = append(args, customerID)
+
+	result, err := db.Exec(context.Background(), query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update customer: " + err.Error()})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+
+	audit.LogAudit(c, "UPDATE_CUSTOMER", gin.H{"customer_id": customerID})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Customer updated successfully"})
+}
+
+// DeleteCustomer soft-deletes a customer. Their bookings and invoices are kept.
+func DeleteCustomer(c *gin.Context) {
+	customerID := c.Param("id")
+	db, _, err := getTenantDBForCustomers(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	result, err := db.Exec(context.Background(),
+		"UPDATE customers SET deleted_at = NOW(), updated_at = NOW() WHERE id = $1 AND deleted_at IS NULL", customerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete customer: " + err.Error()})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+
+	audit.LogAudit(c, "DELETE_CUSTOMER", gin.H{"customer_id": customerID})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Customer deleted successfully"})
+}
+
+// GetCustomerDuplicates lists the other customers sharing the email or phone number of a customer
+func GetCustomerDuplicates(c *gin.Context) {
+	customerID := c.Param("id")
+	db, _, err := getTenantDBForCustomers(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	var cust Customer
+	err = scanCustomer(db.QueryRow(context.Background(),
+		"SELECT "+customerColumns+" FROM customers WHERE id = $1 AND deleted_at IS NULL", customerID), &cust)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+
+	duplicates, err := findDuplicateCustomers(db, cust.Email, cust.Phone, customerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check duplicates: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, duplicates)
+}
+
+// MergeCustomers merges the source customers into :id. Their bookings (and so their invoices), incidents,
+// documents and risk flags are moved to the target, missing contact details are copied over, and the sources
+// are soft-deleted with merged_into pointing to the target.
+func MergeCustomers(c *gin.Context) {
+	targetID := c.Param("id")
+	var req MergeCustomersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sourceIDs := []string{}
+	seen := map[string]bool{}
+	for _, id := range req.SourceIDs {
+		id = strings.ToLower(strings.TrimSpace(id))
+		if !isUUID(id) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid source customer ID: " + id})
+			return
+		}
+		if id == strings.ToLower(targetID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A customer cannot be merged into itself"})
+			return
+		}
+		if !seen[id] {
+			seen[id] = true
+			sourceIDs = append(sourceIDs, id)
+		}
+	}
+	req.SourceIDs = sourceIDs
+
+	db, _, err := getTenantDBForCustomers(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction: " + err.Error()})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	err = tx.QueryRow(ctx, "SELECT true FROM customers WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", targetID).Scan(&exists)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+
+	var found []string
+	err = tx.QueryRow(ctx,
+		"SELECT COALESCE(array_agg(id::text), '{}') FROM customers WHERE id = ANY($1::uuid[]) AND deleted_at IS NULL",
+		req.SourceIDs).Scan(&found)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch customers: " + err.Error()})
+		return
+	}
+	if len(found) != len(req.SourceIDs) {
+		existing := map[string]bool{}
+		for _, id := range found {
+			existing[id] = true
+		}
+		for _, id := range req.SourceIDs {
+			if !existing[id] {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Source customer not found: " + id, "id": id})
+				return
+			}
+		}
+	}
+
+	// Fill the target's empty fields from the most recent source that has them
+	_, err = tx.Exec(ctx, `
+		UPDATE customers t SET
+			email = COALESCE(NULLIF(t.email, ''), (SELECT s.email FROM customers s WHERE s.id = ANY($2::uuid[]) AND COALESCE(s.email, '') != '' ORDER BY s.created_at DESC LIMIT 1)),
+			phone = COALESCE(NULLIF(t.phone, ''), (SELECT s.phone FROM customers s WHERE s.id = ANY($2::uuid[]) AND COALESCE(s.phone, '') != '' ORDER BY s.created_at DESC LIMIT 1)),
+			license_number = COALESCE(NULLIF(t.license_number, ''), (SELECT s.license_number FROM customers s WHERE s.id = ANY($2::uuid[]) AND COALESCE(s.license_number, '') != '' ORDER BY s.created_at DESC LIMIT 1)),
+			address = COALESCE(NULLIF(t.address, ''), (SELECT s.address FROM customers s WHERE s.id = ANY($2::uuid[]) AND COALESCE(s.address, '') != '' ORDER BY s.created_at DESC LIMIT 1)),
+			updated_at = NOW()
+		WHERE t.id = $1`, targetID, req.SourceIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to merge customer details: " + err.Error()})
+		return
+	}
+
+	bookings, err := tx.Exec(ctx, "UPDATE bookings SET customer_id = $1 WHERE customer_id = ANY($2::uuid[])", targetID, req.SourceIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move bookings: " + err.Error()})
+		return
+	}
+	if _, err := tx.Exec(ctx, "UPDATE customer_incidents SET customer_id = $1 WHERE customer_id = ANY($2::uuid[])", targetID, req.SourceIDs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move incidents: " + err.Error()})
+		return
+	}
+	if _, err := tx.Exec(ctx, "UPDATE customer_notes SET customer_id = $1 WHERE customer_id = ANY($2::uuid[])", targetID, req.SourceIDs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move notes: " + err.Error()})
+		return
+	}
+	if _, err := tx.Exec(ctx, "UPDATE loyalty_transactions SET customer_id = $1 WHERE customer_id = ANY($2::uuid[])", targetID, req.SourceIDs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move loyalty points: " + err.Error()})
+		return
+	}
+	if _, err := tx.Exec(ctx, "UPDATE promo_redemptions SET customer_id = $1 WHERE customer_id = ANY($2::uuid[])", targetID, req.SourceIDs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move promo redemptions: " + err.Error()})
+		return
+	}
+	// Documents and risk flags follow the customer, so expiry warnings and the risk check still see them
+	if _, err := tx.Exec(ctx, "UPDATE customer_documents SET customer_id = $1 WHERE customer_id = ANY($2::uuid[])", targetID, req.SourceIDs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move documents: " + err.Error()})
+		return
+	}
+	if _, err := tx.Exec(ctx, "UPDATE customer_flags SET customer_id = $1 WHERE customer_id = ANY($2::uuid[])", targetID, req.SourceIDs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move flags: " + err.Error()})
+		return
+	}
+	// Sent emails and text messages appear on the customer timeline
+	if _, err := tx.Exec(ctx, "UPDATE email_outbox SET customer_id = $1 WHERE customer_id = ANY($2::uuid[])", targetID, req.SourceIDs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move emails: " + err.Error()})
+		return
+	}
+	if _, err := tx.Exec(ctx, "UPDATE message_outbox SET customer_id = $1 WHERE customer_id = ANY($2::uuid[])", targetID, req.SourceIDs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move text messages: " + err.Error()})
+		return
+	}
+
+	_, err = tx.Exec(ctx,
+		"UPDATE customers SET deleted_at = NOW(), merged_into = $1, updated_at = NOW() WHERE id = ANY($2::uuid[])",
+		targetID, req.SourceIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete merged customers: " + err.Error()})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit merge: " + err.Error()})
+		return
+	}
+
+	audit.LogAudit(c, "MERGE_CUSTOMERS", gin.H{"customer_id": targetID, "merged": req.SourceIDs})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Customers merged successfully",
+		"id":             targetID,
+		"moved_bookings": bookings.RowsAffected(),
+	})
+}
+
+// findDuplicateCustomers returns the customers with the same email (case-insensitive) or phone number
+// (last phoneMatchDigits digits), excluding excludeID
+func findDuplicateCustomers(db *pgxpool.Pool, email, phone, excludeID string) ([]Customer, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	phoneDigits := normalizePhone(phone)
+	if len(phoneDigits) > phoneMatchDigits {
+		phoneDigits = phoneDigits[len(phoneDigits)-phoneMatchDigits:]
+	}
+	if email == "" && len(phoneDigits) < phoneMatchDigits {
+		return []Customer{}, nil
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT `+customerColumns+`
+		FROM customers
+		WHERE deleted_at IS NULL
+		  AND id::text != $3
+		  AND (($1 != '' AND LOWER(TRIM(email)) = $1)
+		       OR (length($2) = $4 AND right(regexp_replace(phone, '\D', '', 'g'), $4) = $2))
+		ORDER BY created_at`, email, phoneDigits, excludeID, phoneMatchDigits)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	duplicates := []Customer{}
+	for rows.Next() {
+		var cust Customer
+		if err := scanCustomer(rows, &cust); err != nil {
+			return nil, err
+		}
+		duplicates = append(duplicates, cust)
+	}
+	return duplicates, rows.Err()
+}
+
+// normalizePhone keeps only the digits of a phone number
+func normalizePhone(phone string) string {
+	var b strings.Builder
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func encodeCursor(createdAt time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(createdAt.Format(time.RFC3339Nano) + "|" + id))
+}
+
+func decodeCursor(cursor string) (time.Time, string, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return time.Time{}, "", false
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil || !isUUID(parts[1]) {
+		return time.Time{}, "", false
+	}
+	return createdAt, parts[1], true
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// isUUID tells whether an id from a request can be compared with a uuid column without a database error
+func isUUID(s string) bool {
+	return uuidPattern.MatchString(s)
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DashboardBooking is a pickup or return scheduled for today
+type DashboardBooking struct {
+	BookingID    string `json:"booking_id"`
+	CustomerName string `json:"customer_name"`
+	Car          string `json:"car"`
+	Status       string `json:"status"`
+}
+
+// DashboardCar is a car whose service is due soon
+type DashboardCar struct {
+	CarID           string    `json:"car_id"`
+	Car             string    `json:"car"`
+	NextServiceDate time.Time `json:"next_service_date"`
+}
+
+// Dashboard gathers the KPIs shown on the tenant home screen. Amounts are in the base currency.
+type Dashboard struct {
+	Date         string `json:"date"`
+	BaseCurrency string `json:"base_currency"`
+
+	PickupsToday  []DashboardBooking `json:"pickups_today"`
+	ReturnsToday  []DashboardBooking `json:"returns_today"`
+	ActiveRentals int                `json:"active_rentals"`
+
+	PendingRequests int `json:"pending_requests"`
+
+	OverdueInvoices    int     `json:"overdue_invoices"`
+	OverdueOutstanding float64 `json:"overdue_outstanding"`
+
+	CarsDueForService []DashboardCar `json:"cars_due_for_service"`
+
+	RevenueMTD           float64  `json:"revenue_mtd"`
+	RevenuePreviousMonth float64  `json:"revenue_previous_month"` // Same days of the previous month
+	RevenueChange        *float64 `json:"revenue_change"`         // Percentage, null when there was no revenue last month
+
+	UtilizationThisWeek float64 `json:"utilization_this_week"` // Monday to today
+
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+const (
+	// dashboardCacheTTL keeps the dashboard from hitting the database on every home screen refresh
+	dashboardCacheTTL = time.Minute
+	// serviceDueWithinDays is how far ahead a scheduled service is reported
+	serviceDueWithinDays = 7
+)
+
+type dashboardCacheEntry struct {
+	dashboard Dashboard
+	expires   time.Time
+}
+
+// dashboardCache holds the last dashboard computed for each tenant
+var dashboardCache = struct {
+	mu      sync.Mutex
+	entries map[string]dashboardCacheEntry
+}{entries: map[string]dashboardCacheEntry{}}
+
+// GetDashboard returns the home screen KPIs. Results are cached for a minute per tenant; ?refresh=true bypasses the cache.
+func GetDashboard(c *gin.Context) {
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	now := time.Now()
+	if c.Query("refresh") != "true" {
+		dashboardCache.mu.Lock()
+		entry, ok := dashboardCache.entries[tenant.ID]
+		dashboardCache.mu.Unlock()
+		if ok && now.Before(entry.expires) {
+			c.JSON(http.StatusOK, entry.dashboard)
+			return
+		}
+	}
+
+	dashboard, err := buildDashboard(db, GetBaseCurrency(db, tenant.ID), now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build dashboard: " + err.Error()})
+		return
+	}
+
+	dashboardCache.mu.Lock()
+	dashboardCache.entries[tenant.ID] = dashboardCacheEntry{dashboard: dashboard, expires: now.Add(dashboardCacheTTL)}
+	dashboardCache.mu.Unlock()
+
+	c.JSON(http.StatusOK, dashboard)
+}
+
+func buildDashboard(db *pgxpool.Pool, baseCurrency string, now time.Time) (Dashboard, error) {
+	ctx := context.Background()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	d := Dashboard{Date: today.Format("2006-01-02"), BaseCurrency: baseCurrency, GeneratedAt: now}
+
+	var err error
+	if d.PickupsToday, err = dashboardBookings(db, "b.start_date = $1 AND b.status IN ('pending', 'confirmed')", today); err != nil {
+		return d, err
+	}
+	if d.ReturnsToday, err = dashboardBookings(db, "b.end_date = $1 AND b.status IN ('confirmed', 'active')", today); err != nil {
+		return d, err
+	}
+
+	err = db.QueryRow(ctx,
+		"SELECT COUNT(*) FROM bookings WHERE status IN ('confirmed', 'active') AND $1::date BETWEEN start_date AND end_date",
+		today).Scan(&d.ActiveRentals)
+	if err != nil {
+		return d, err
+	}
+
+	err = db.QueryRow(ctx, "SELECT COUNT(*) FROM booking_requests WHERE status = 'pending'").Scan(&d.PendingRequests)
+	if err != nil {
+		return d, err
+	}
+
+	err = db.QueryRow(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(o.outstanding * o.rate), 0)
+		FROM (
+			SELECT i.amount - COALESCE((SELECT SUM(p.amount) FROM payments p WHERE p.invoice_id = i.id), 0) as outstanding,
+			       COALESCE(stored_rate(i.exchange_rate, i.exchange_rate_base, $2), fx_rate(COALESCE(i.currency, 'MAD'), $2, i.created_at::date)) as rate
+			FROM invoices i
+			WHERE i.due_date < $1 AND i.status NOT IN ('Paid', 'Cancelled')
+		) o
+		WHERE o.outstanding > 0`, today, baseCurrency).Scan(&d.OverdueInvoices, &d.OverdueOutstanding)
+	if err != nil {
+		return d, err
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT id, brand || ' ' || model || ' (' || license_plate || ')', next_service_date
+		FROM cars
+		WHERE next_service_date <= $1::date + $2::int
+		ORDER BY next_service_date`, today, serviceDueWithinDays)
+	if err != nil {
+		return d, err
+	}
+	d.CarsDueForService = []DashboardCar{}
+	for rows.Next() {
+		var car DashboardCar
+		if err := rows.Scan(&car.CarID, &car.Car, &car.NextServiceDate); err != nil {
+			rows.Close()
+			return d, err
+		}
+		d.CarsDueForService = append(d.CarsDueForService, car)
+	}
+	rows.Close()
+
+	// Month to date against the same number of days of the previous month
+	monthStart := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+	prevStart := monthStart.AddDate(0, -1, 0)
+	prevEnd := prevStart.AddDate(0, 0, today.Day()-1)
+	if prevEnd.Month() != prevStart.Month() {
+		prevEnd = monthStart.AddDate(0, 0, -1)
+	}
+	revenueQuery := `
+		SELECT COALESCE(SUM(i.amount * COALESCE(stored_rate(i.exchange_rate, i.exchange_rate_base, $3), fx_rate(COALESCE(i.currency, 'MAD'), $3, i.created_at::date))), 0)
+		FROM invoices i
+		WHERE i.created_at::date BETWEEN $1 AND $2 AND i.status != 'Cancelled'`
+	if err := db.QueryRow(ctx, revenueQuery, monthStart, today, baseCurrency).Scan(&d.RevenueMTD); err != nil {
+		return d, err
+	}
+	if err := db.QueryRow(ctx, revenueQuery, prevStart, prevEnd, baseCurrency).Scan(&d.RevenuePreviousMonth); err != nil {
+		return d, err
+	}
+	if d.RevenuePreviousMonth > 0 {
+		change := (d.RevenueMTD - d.RevenuePreviousMonth) / d.RevenuePreviousMonth * 100
+		d.RevenueChange = &change
+	}
+
+	weekStart := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+	var rented, available int
+	err = db.QueryRow(ctx, `
+		SELECT COUNT(*) FILTER (WHERE rented), COUNT(*) FILTER (WHERE rented OR NOT blocked)
+		FROM (`+utilizationDaysQuery+`) s`, weekStart, today).Scan(&rented, &available)
+	if err != nil {
+		return d, err
+	}
+	d.UtilizationThisWeek = utilizationPercentage(rented, available)
+
+	return d, nil
+}
+
+// dashboardBookings lists the bookings matching a condition on $1 (today)
+func dashboardBookings(db *pgxpool.Pool, condition string, today time.Time) ([]DashboardBooking, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT b.id, COALESCE(cust.first_name || ' ' || cust.last_name, 'Unknown'),
+		       c.brand || ' ' || c.model || ' (' || c.license_plate || ')', b.status
+		FROM bookings b
+		JOIN cars c ON b.car_id = c.id
+		LEFT JOIN customers cust ON b.customer_id = cust.id
+		WHERE `+condition+`
+		ORDER BY b.created_at`, today)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bookings := []DashboardBooking{}
+	for rows.Next() {
+		var b DashboardBooking
+		if err := rows.Scan(&b.BookingID, &b.CustomerName, &b.Car, &b.Status); err != nil {
+			return nil, err
+		}
+		bookings = append(bookings, b)
+	}
+	return bookings, rows.Err()
+}
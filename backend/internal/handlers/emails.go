@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"car-rental-backend/internal/audit"
+	"car-rental-backend/internal/mail"
+	"car-rental-backend/internal/models"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OutboxEntry is an email of the delivery log
+type OutboxEntry struct {
+	ID         string     `json:"id"`
+	Template   string     `json:"template"`
+	CustomerID *string    `json:"customer_id"`
+	Recipients []string   `json:"recipients"`
+	Subject    string     `json:"subject"`
+	Status     string     `json:"status"` // pending, sent, failed
+	Attempts   int        `json:"attempts"`
+	LastError  string     `json:"last_error"`
+	SentAt     *time.Time `json:"sent_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// mailer sends the emails that can't wait for the outbox (portal login codes); set from main with SetMailer
+var mailer mail.Mailer = mail.LogMailer{}
+
+// SetMailer sets the mailer used by handlers
+func SetMailer(m mail.Mailer) {
+	mailer = m
+}
+
+// publicURL is the address the API is reachable at from outside (PUBLIC_URL, default http://localhost:8080),
+// used for links and images in emails
+func publicURL() string {
+	if u := os.Getenv("PUBLIC_URL"); u != "" {
+		return strings.TrimRight(u, "/")
+	}
+	return "http://localhost:8080"
+}
+
+// loadEmailBranding returns the tenant's branding for emails
+func loadEmailBranding(db dbQuerier, tenant *models.Tenant) mail.Branding {
+	brand := mail.Branding{TenantName: tenant.Name}
+	db.QueryRow(context.Background(),
+		"SELECT COALESCE(logo_url, ''), COALESCE(primary_color, ''), COALESCE(secondary_color, '') FROM branding WHERE tenant_id = $1",
+		tenant.ID).Scan(&brand.LogoURL, &brand.PrimaryColor, &brand.SecondaryColor)
+	if strings.HasPrefix(brand.LogoURL, "/") {
+		brand.LogoURL = publicURL() + brand.LogoURL
+	}
+	return brand
+}
+
+// queueEmail renders a branded email and saves it to the outbox. Nothing is queued without a recipient.
+func queueEmail(db dbQuerier, tenant *models.Tenant, template, to, customerID string, data map[string]interface{}) error {
+	to = strings.TrimSpace(to)
+	if to == "" {
+		return nil
+	}
+	msg, err := mail.Render(template, loadEmailBranding(db, tenant), data)
+	if err != nil {
+		return err
+	}
+	msg.To = []string{to}
+	_, err = mail.Enqueue(db, mail.OutboxEmail{TenantID: tenant.ID, Template: template, CustomerID: customerID, Message: msg})
+	return err
+}
+
+// queueBookingConfirmation emails the customer of a confirmed booking
+func queueBookingConfirmation(db dbQuerier, tenant *models.Tenant, bookingID string) error {
+	var customerID, name, email, car, currency string
+	var start, end time.Time
+	var total float64
+	err := db.QueryRow(context.Background(), `
+		SELECT cust.id, TRIM(cust.first_name || ' ' || COALESCE(cust.last_name, '')), COALESCE(cust.email, ''),
+		       c.brand || ' ' || c.model, b.start_date, b.end_date,
+		       (b.price_per_day * (b.end_date - b.start_date + 1))::float8, COALESCE(b.currency, 'MAD')
+		FROM bookings b
+		JOIN cars c ON b.car_id = c.id
+		JOIN customers cust ON b.customer_id = cust.id
+		WHERE b.id = $1`, bookingID).Scan(&customerID, &name, &email, &car, &start, &end, &total, &currency)
+	if err != nil {
+		return err
+	}
+	return queueEmail(db, tenant, mail.TemplateBookingConfirmation, email, customerID, map[string]interface{}{
+		"CustomerName": name,
+		"Car":          car,
+		"StartDate":    start.Format("2006-01-02"),
+		"EndDate":      end.Format("2006-01-02"),
+		"Total":        formatMoney(total, currency),
+	})
+}
+
+// queueRequestEmail emails the sender of a booking request (request received, or confirmed)
+func queueRequestEmail(db dbQuerier, tenant *models.Tenant, requestID, template string) error {
+	var name, email, car string
+	var start, end time.Time
+	err := db.QueryRow(context.Background(), `
+		SELECT r.customer_name, COALESCE(r.customer_email, ''), COALESCE(c.brand || ' ' || c.model, ''), r.pickup_date, r.return_date
+		FROM booking_requests r
+		LEFT JOIN cars c ON r.car_id = c.id
+		WHERE r.id = $1`, requestID).Scan(&name, &email, &car, &start, &end)
+	if err != nil {
+		return err
+	}
+	return queueEmail(db, tenant, template, email, "", map[string]interface{}{
+		"CustomerName": name,
+		"Car":          car,
+		"StartDate":    start.Format("2006-01-02"),
+		"EndDate":      end.Format("2006-01-02"),
+		"Total":        "",
+	})
+}
+
+// queueInvoiceEmail emails an issued invoice, with its lines, to the booking's customer
+func queueInvoiceEmail(db dbQuerier, tenant *models.Tenant, invoiceID string) error {
+	var customerID, name, email, currency string
+	var amount float64
+	var dueDate time.Time
+	err := db.QueryRow(context.Background(), `
+		SELECT cust.id, TRIM(cust.first_name || ' ' || COALESCE(cust.last_name, '')), COALESCE(cust.email, ''),
+		       i.amount::float8, COALESCE(i.currency, 'MAD'), i.due_date
+		FROM invoices i
+		JOIN bookings b ON i.booking_id = b.id
+		JOIN customers cust ON b.customer_id = cust.id
+		WHERE i.id = $1`, invoiceID).Scan(&customerID, &name, &email, &amount, &currency, &dueDate)
+	if err != nil {
+		return err
+	}
+
+	invoiceLines, err := loadInvoiceLines(db, invoiceID)
+	if err != nil {
+		return err
+	}
+	lines := []map[string]string{}
+	for _, l := range invoiceLines {
+		lines = append(lines, map[string]string{"Description": l.Description, "Amount": formatMoney(l.Amount, currency)})
+	}
+
+	return queueEmail(db, tenant, mail.TemplateInvoiceIssued, email, customerID, map[string]interface{}{
+		"CustomerName": name,
+		"Number":       shortRef(invoiceID),
+		"Lines":        lines,
+		"Total":        formatMoney(amount, currency),
+		"DueDate":      dueDate.Format("2006-01-02"),
+	})
+}
+
+func formatMoney(amount float64, currency string) string {
+	return fmt.Sprintf("%.2f %s", amount, currency)
+}
+
+// GetEmailOutbox lists the tenant's outgoing emails, newest first. Filter with ?status=pending|sent|failed.
+func GetEmailOutbox(c *gin.Context) {
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT id, COALESCE(template, ''), customer_id, recipients, subject, status, attempts, COALESCE(last_error, ''),
+		       sent_at, created_at
+		FROM email_outbox
+		WHERE tenant_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC
+		LIMIT 200`, tenant.ID, c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch emails: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	emails := []OutboxEntry{}
+	for rows.Next() {
+		var e OutboxEntry
+		if err := rows.Scan(&e.ID, &e.Template, &e.CustomerID, &e.Recipients, &e.Subject, &e.Status, &e.Attempts,
+			&e.LastError, &e.SentAt, &e.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan email: " + err.Error()})
+			return
+		}
+		emails = append(emails, e)
+	}
+
+	c.JSON(http.StatusOK, emails)
+}
+
+// RetryEmail queues a failed email again
+func RetryEmail(c *gin.Context) {
+	db, _, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	ok, err := mail.Retry(db, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry email: " + err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed email not found"})
+		return
+	}
+
+	audit.LogAudit(c, "RETRY_EMAIL", gin.H{"email_id": c.Param("id")})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email queued again"})
+}
+
+// logEmailError reports an email that could not be queued; the action that triggered it still succeeds
+func logEmailError(template, id string, err error) {
+	if err != nil {
+		log.Printf("[MAIL] Failed to queue %s email for %s: %v", template, id, err)
+	}
+}
@@ -0,0 +1,965 @@
+package handlers
+
+import (
+	"car-rental-backend/internal/models"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Expense struct {
+	ID                 string    `json:"id"`
+	Amount             float64   `json:"amount"`
+	Currency           string    `json:"currency"`
+	CategoryID         string    `json:"category_id"`
+	Category           string    `json:"category"`
+	CarID              string    `json:"car_id"`
+	CarInfo            string    `json:"car_info,omitempty"` // Joined
+	Date               time.Time `json:"date"`
+	Description        string    `json:"description"`
+	ReceiptURL         string    `json:"receipt_url"`
+	RecurringExpenseID string    `json:"recurring_expense_id,omitempty"`
+}
+
+// CreateExpenseRequest takes either a category_id or a category name.
+// An unknown category name is added to the tenant's categories.
+type CreateExpenseRequest struct {
+	Amount      float64   `json:"amount" binding:"required"`
+	Currency    string    `json:"currency"` // Defaults to the base currency
+	CategoryID  string    `json:"category_id"`
+	Category    string    `json:"category"`
+	CarID       string    `json:"car_id"`
+	Date        time.Time `json:"date" binding:"required"`
+	Description string    `json:"description"`
+}
+
+type UpdateExpenseRequest struct {
+	Amount      *float64   `json:"amount"`
+	Currency    *string    `json:"currency"`
+	CategoryID  *string    `json:"category_id"`
+	Category    *string    `json:"category"`
+	CarID       *string    `json:"car_id"` // Empty string detaches the expense from its car
+	Date        *time.Time `json:"date"`
+	Description *string    `json:"description"`
+}
+
+type ExpenseCategory struct {
+	ID           string  `json:"id"`
+	Name         string  `json:"name"`
+	Description  string  `json:"description"`
+	ExpenseCount int     `json:"expense_count"`
+	TotalAmount  float64 `json:"total_amount"`
+}
+
+type ExpenseCategoryRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+}
+
+// RecurringExpense generates an expense every period (weekly, monthly, quarterly, yearly)
+type RecurringExpense struct {
+	ID          string     `json:"id"`
+	CategoryID  string     `json:"category_id"`
+	Category    string     `json:"category"`
+	CarID       string     `json:"car_id"`
+	Amount      float64    `json:"amount"`
+	Currency    string     `json:"currency"`
+	Description string     `json:"description"`
+	Frequency   string     `json:"frequency"`
+	StartDate   time.Time  `json:"start_date"`
+	EndDate     *time.Time `json:"end_date"`
+	NextRunDate time.Time  `json:"next_run_date"`
+	Active      bool       `json:"active"`
+}
+
+type CreateRecurringExpenseRequest struct {
+	CategoryID  string     `json:"category_id"`
+	Category    string     `json:"category"`
+	CarID       string     `json:"car_id"`
+	Amount      float64    `json:"amount" binding:"required,gt=0"`
+	Currency    string     `json:"currency"`
+	Description string     `json:"description"`
+	Frequency   string     `json:"frequency" binding:"required,oneof=weekly monthly quarterly yearly"`
+	StartDate   time.Time  `json:"start_date" binding:"required"`
+	EndDate     *time.Time `json:"end_date"`
+}
+
+type UpdateRecurringExpenseRequest struct {
+	Amount      *float64   `json:"amount"`
+	Description *string    `json:"description"`
+	EndDate     *time.Time `json:"end_date"`
+	Active      *bool      `json:"active"`
+}
+
+// nullIfEmpty turns an empty string into SQL NULL
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// resolveExpenseCategory returns the id and name of a category given by id or by name.
+// Categories given by name are created on first use.
+func resolveExpenseCategory(db *pgxpool.Pool, tenantID, categoryID, name string) (string, string, error) {
+	if categoryID != "" {
+		err := db.QueryRow(context.Background(),
+			"SELECT id, name FROM expense_categories WHERE id = $1", categoryID).Scan(&categoryID, &name)
+		if err != nil {
+			return "", "", fmt.Errorf("expense category not found")
+		}
+		return categoryID, name, nil
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", "", fmt.Errorf("category_id or category is required")
+	}
+	err := db.QueryRow(context.Background(),
+		`INSERT INTO expense_categories (tenant_id, name) VALUES ($1, $2)
+		 ON CONFLICT (name) DO UPDATE SET name = expense_categories.name
+		 RETURNING id, name`, tenantID, name).Scan(&categoryID, &name)
+	if err != nil {
+		return "", "", err
+	}
+	return categoryID, name, nil
+}
+
+// GetExpenses lists expenses, optionally filtered by ?from=&to=, ?car_id= and ?category_id=.
+// ?format=csv|xlsx downloads them instead.
+func GetExpenses(c *gin.Context) {
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
+
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	var from, to interface{}
+	if c.Query("from") != "" || c.Query("to") != "" {
+		fromDate, toDate, err := parseDateRange(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		from, to = fromDate, toDate
+	}
+
+	query := `
+		SELECT e.id, e.amount, COALESCE(e.currency, $1), COALESCE(e.category_id::text, ''), e.category,
+		       COALESCE(e.car_id::text, ''), COALESCE(c.brand || ' ' || c.model || ' (' || c.license_plate || ')', ''),
+		       e.date, COALESCE(e.description, ''), COALESCE(e.receipt_url, ''), COALESCE(e.recurring_expense_id::text, '')
+		FROM expenses e
+		LEFT JOIN cars c ON e.car_id = c.id
+		WHERE ($2::date IS NULL OR e.date BETWEEN $2 AND $3)
+		  AND ($4 = '' OR e.car_id::text = $4)
+		  AND ($5 = '' OR e.category_id::text = $5)
+		ORDER BY e.date DESC
+	`
+	rows, err := db.Query(context.Background(), query,
+		GetBaseCurrency(db, tenant.ID), from, to, c.Query("car_id"), c.Query("category_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch expenses: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	exp, err := startExport(c, format, tenant, "expenses", "Expenses",
+		"Expense ID", "Date", "Category", "Car", "Description", "Amount", "Currency", "Receipt")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start export: " + err.Error()})
+		return
+	}
+
+	var expenses []Expense
+	for rows.Next() {
+		var e Expense
+		if err := rows.Scan(&e.ID, &e.Amount, &e.Currency, &e.CategoryID, &e.Category, &e.CarID, &e.CarInfo,
+			&e.Date, &e.Description, &e.ReceiptURL, &e.RecurringExpenseID); err != nil {
+			rowsFailed(c, exp, "Failed to scan expense", err)
+			return
+		}
+		if exp != nil {
+			if err := exp.Row(e.ID, e.Date, e.Category, e.CarInfo, e.Description, e.Amount, e.Currency, e.ReceiptURL); err != nil {
+				return
+			}
+			continue
+		}
+		expenses = append(expenses, e)
+	}
+
+	if exp != nil {
+		finishExport(exp)
+		return
+	}
+
+	if expenses == nil {
+		expenses = []Expense{}
+	}
+
+	c.JSON(http.StatusOK, expenses)
+}
+
+// GetExpense returns a single expense
+func GetExpense(c *gin.Context) {
+	id := c.Param("id")
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	var e Expense
+	err = db.QueryRow(context.Background(), `
+		SELECT e.id, e.amount, COALESCE(e.currency, $2), COALESCE(e.category_id::text, ''), e.category,
+		       COALESCE(e.car_id::text, ''), COALESCE(c.brand || ' ' || c.model || ' (' || c.license_plate || ')', ''),
+		       e.date, COALESCE(e.description, ''), COALESCE(e.receipt_url, ''), COALESCE(e.recurring_expense_id::text, '')
+		FROM expenses e
+		LEFT JOIN cars c ON e.car_id = c.id
+		WHERE e.id = $1`, id, GetBaseCurrency(db, tenant.ID)).Scan(
+		&e.ID, &e.Amount, &e.Currency, &e.CategoryID, &e.Category, &e.CarID, &e.CarInfo,
+		&e.Date, &e.Description, &e.ReceiptURL, &e.RecurringExpenseID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Expense not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, e)
+}
+
+func CreateExpense(c *gin.Context) {
+	var req CreateExpenseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	if !ensurePeriodOpen(c, db, tenant.ID, req.Date) {
+		return
+	}
+
+	categoryID, categoryName, err := resolveExpenseCategory(db, tenant.ID, req.CategoryID, req.Category)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	currency := strings.ToUpper(req.Currency)
+	if currency == "" {
+		currency = GetBaseCurrency(db, tenant.ID)
+	}
+
+	var expenseID string
+	err = db.QueryRow(context.Background(),
+		`INSERT INTO expenses (tenant_id, amount, currency, category_id, category, car_id, date, description)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		tenant.ID, req.Amount, currency, categoryID, categoryName, nullIfEmpty(req.CarID), req.Date, req.Description).Scan(&expenseID)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create expense: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Expense created successfully", "id": expenseID})
+}
+
+// UpdateExpense updates the provided fields of an expense
+func UpdateExpense(c *gin.Context) {
+	expenseID := c.Param("id")
+	var req UpdateExpenseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	// Both the current and the new date must be in an open period
+	var currentDate time.Time
+	err = db.QueryRow(context.Background(), "SELECT date FROM expenses WHERE id = $1", expenseID).Scan(&currentDate)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Expense not found"})
+		return
+	}
+	dates := []time.Time{currentDate}
+	if req.Date != nil {
+		dates = append(dates, *req.Date)
+	}
+	if !ensurePeriodOpen(c, db, tenant.ID, dates...) {
+		return
+	}
+
+	// Build dynamic UPDATE query based on provided fields
+	setClauses := []string{}
+	args := []interface{}{}
+	argIndex := 1
+
+	if req.Amount != nil {
+		setClauses = append(setClauses, fmt.Sprintf("amount = $%d", argIndex))
+		args = append(args, *req.Amount)
+		argIndex++
+	}
+	if req.Currency != nil {
+		currency := strings.ToUpper(*req.Currency)
+		if currency == "" {
+			currency = GetBaseCurrency(db, tenant.ID)
+		}
+		setClauses = append(setClauses, fmt.Sprintf("currency = $%d", argIndex))
+		args = append(args, currency)
+		argIndex++
+	}
+	if req.CategoryID != nil || req.Category != nil {
+		var categoryID, categoryName string
+		if req.CategoryID != nil {
+			categoryID = *req.CategoryID
+		}
+		if req.Category != nil {
+			categoryName = *req.Category
+		}
+		categoryID, categoryName, err = resolveExpenseCategory(db, tenant.ID, categoryID, categoryName)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		setClauses = append(setClauses, fmt.Sprintf("category_id = $%d, category = $%d", argIndex, argIndex+1))
+		args = append(args, categoryID, categoryName)
+		argIndex += 2
+	}
+	if req.CarID != nil {
+		setClauses = append(setClauses, fmt.Sprintf("car_id = $%d", argIndex))
+		args = append(args, nullIfEmpty(*req.CarID))
+		argIndex++
+	}
+	if req.Date != nil {
+		setClauses = append(setClauses, fmt.Sprintf("date = $%d", argIndex))
+		args = append(args, *req.Date)
+		argIndex++
+	}
+	if req.Description != nil {
+		setClauses = append(setClauses, fmt.Sprintf("description = $%d", argIndex))
+		args = append(args, *req.Description)
+		argIndex++
+	}
+
+	if len(setClauses) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields to update"})
+		return
+	}
+
+	query := fmt.Sprintf("UPDATE expenses SET %s, updated_at = NOW() WHERE id = $%d",
+		strings.Join(setClauses, ", "), argIndex)
+	args = append(args, expenseID)
+
+	result, err := db.Exec(context.Background(), query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update expense: " + err.Error()})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Expense not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Expense updated successfully"})
+}
+
+// DeleteExpense deletes an expense and its receipt file
+func DeleteExpense(c *gin.Context) {
+	expenseID := c.Param("id")
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	var date time.Time
+	err = db.QueryRow(context.Background(), "SELECT date FROM expenses WHERE id = $1", expenseID).Scan(&date)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Expense not found"})
+		return
+	}
+	if !ensurePeriodOpen(c, db, tenant.ID, date) {
+		return
+	}
+
+	var receiptPath string
+	err = db.QueryRow(context.Background(),
+		"DELETE FROM expenses WHERE id = $1 RETURNING COALESCE(receipt_path, '')", expenseID).Scan(&receiptPath)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Expense not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete expense: " + err.Error()})
+		return
+	}
+
+	removeReceiptFile(tenant.ID, receiptPath)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Expense deleted successfully"})
+}
+
+// UploadExpenseReceipt attaches a receipt file ("receipt") to an expense, replacing any previous one.
+// Receipts are stored with the customer documents, outside the public uploads, and downloaded with GetExpenseReceipt.
+func UploadExpenseReceipt(c *gin.Context) {
+	expenseID := c.Param("id")
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	file, err := c.FormFile("receipt")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No receipt file provided"})
+		return
+	}
+
+	ext := strings.ToLower(filepath.Ext(file.Filename))
+	if ext != ".pdf" && ext != ".png" && ext != ".jpg" && ext != ".jpeg" && ext != ".webp" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file type. Allowed: pdf, png, jpg, jpeg, webp"})
+		return
+	}
+
+	var oldReceiptPath string
+	var date time.Time
+	err = db.QueryRow(context.Background(),
+		"SELECT COALESCE(receipt_path, ''), date FROM expenses WHERE id = $1", expenseID).Scan(&oldReceiptPath, &date)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Expense not found"})
+		return
+	}
+	if !ensurePeriodOpen(c, db, tenant.ID, date) {
+		return
+	}
+
+	relPath := filepath.Join(tenant.ID, "receipts", fmt.Sprintf("%s_%d%s", generateRandomString(16), time.Now().UnixNano(), ext))
+	fullPath := filepath.Join(documentsDir(), relPath)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0700); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create receipt directory"})
+		return
+	}
+	if err := c.SaveUploadedFile(file, fullPath); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save receipt"})
+		return
+	}
+
+	receiptURL := expenseReceiptURL(expenseID)
+	_, err = db.Exec(context.Background(),
+		"UPDATE expenses SET receipt_url = $1, receipt_path = $2, updated_at = NOW() WHERE id = $3", receiptURL, relPath, expenseID)
+	if err != nil {
+		os.Remove(fullPath)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save receipt: " + err.Error()})
+		return
+	}
+
+	removeReceiptFile(tenant.ID, oldReceiptPath)
+
+	c.JSON(http.StatusOK, gin.H{"receipt_url": receiptURL, "message": "Receipt uploaded successfully"})
+}
+
+// GetExpenseReceipt streams the receipt of an expense to authenticated staff of the tenant
+func GetExpenseReceipt(c *gin.Context) {
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	var relPath string
+	err = db.QueryRow(context.Background(),
+		"SELECT COALESCE(receipt_path, '') FROM expenses WHERE id = $1", c.Param("id")).Scan(&relPath)
+	if err != nil || relPath == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Receipt not found"})
+		return
+	}
+
+	fullPath, ok := documentPath(tenant.ID, relPath)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Receipt not found"})
+		return
+	}
+	if _, err := os.Stat(fullPath); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Receipt file missing"})
+		return
+	}
+
+	c.Header("Cache-Control", "private, no-store")
+	if c.Query("download") == "true" {
+		c.FileAttachment(fullPath, "receipt"+filepath.Ext(fullPath))
+		return
+	}
+	c.File(fullPath)
+}
+
+// expenseReceiptURL is the authenticated route a receipt is downloaded from
+func expenseReceiptURL(expenseID string) string {
+	return "/api/v1/financials/expenses/" + expenseID + "/receipt"
+}
+
+// removeReceiptFile deletes a stored receipt given its path relative to the documents directory
+func removeReceiptFile(tenantID, relPath string) {
+	if relPath == "" {
+		return
+	}
+	if fullPath, ok := documentPath(tenantID, relPath); ok {
+		os.Remove(fullPath)
+	}
+}
+
+// legacyReceiptsDir is where receipts used to be stored, publicly served under /uploads/receipts/
+const legacyReceiptsDir = "uploads/receipts"
+
+// MigrateExpenseReceipts moves the tenant's receipts out of the public uploads directory into private storage.
+// It returns how many receipts were moved.
+func MigrateExpenseReceipts(db *pgxpool.Pool, tenant *models.Tenant) (int, error) {
+	ctx := context.Background()
+	rows, err := db.Query(ctx,
+		"SELECT id, receipt_url FROM expenses WHERE receipt_path IS NULL AND receipt_url LIKE '/uploads/receipts/%'")
+	if err != nil {
+		return 0, err
+	}
+	type legacyReceipt struct{ expenseID, url string }
+	var receipts []legacyReceipt
+	for rows.Next() {
+		var r legacyReceipt
+		if err := rows.Scan(&r.expenseID, &r.url); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		receipts = append(receipts, r)
+	}
+	rows.Close()
+
+	moved := 0
+	for _, r := range receipts {
+		name := filepath.Base(r.url)
+		relPath := filepath.Join(tenant.ID, "receipts", name)
+		fullPath := filepath.Join(documentsDir(), relPath)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0700); err != nil {
+			return moved, err
+		}
+		if err := moveFile(filepath.Join(legacyReceiptsDir, name), fullPath); err != nil {
+			log.Printf("[RECEIPTS] Failed to move receipt of expense %s: %v", r.expenseID, err)
+			continue
+		}
+		_, err := db.Exec(ctx, "UPDATE expenses SET receipt_url = $1, receipt_path = $2 WHERE id = $3",
+			expenseReceiptURL(r.expenseID), relPath, r.expenseID)
+		if err != nil {
+			return moved, err
+		}
+		moved++
+	}
+	return moved, nil
+}
+
+// moveFile renames a file, copying it when the directories are on different filesystems
+func moveFile(from, to string) error {
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(to)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(from)
+}
+
+// GetExpenseCategories lists expense categories with their usage
+func GetExpenseCategories(c *gin.Context) {
+	db, _, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT ec.id, ec.name, COALESCE(ec.description, ''), COUNT(e.id), COALESCE(SUM(e.amount), 0)
+		FROM expense_categories ec
+		LEFT JOIN expenses e ON e.category_id = ec.id
+		GROUP BY ec.id, ec.name, ec.description
+		ORDER BY ec.name`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch expense categories: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var categories []ExpenseCategory
+	for rows.Next() {
+		var ec ExpenseCategory
+		if err := rows.Scan(&ec.ID, &ec.Name, &ec.Description, &ec.ExpenseCount, &ec.TotalAmount); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan expense category: " + err.Error()})
+			return
+		}
+		categories = append(categories, ec)
+	}
+
+	if categories == nil {
+		categories = []ExpenseCategory{}
+	}
+
+	c.JSON(http.StatusOK, categories)
+}
+
+func CreateExpenseCategory(c *gin.Context) {
+	var req ExpenseCategoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	var id string
+	err = db.QueryRow(context.Background(),
+		"INSERT INTO expense_categories (tenant_id, name, description) VALUES ($1, $2, $3) ON CONFLICT (name) DO NOTHING RETURNING id",
+		tenant.ID, strings.TrimSpace(req.Name), req.Description).Scan(&id)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Category already exists"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create expense category: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Expense category created successfully", "id": id})
+}
+
+// UpdateExpenseCategory renames a category; existing expenses follow the new name
+func UpdateExpenseCategory(c *gin.Context) {
+	id := c.Param("id")
+	var req ExpenseCategoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, _, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	result, err := db.Exec(context.Background(),
+		"UPDATE expense_categories SET name = $1, description = $2 WHERE id = $3", name, req.Description, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update expense category: " + err.Error()})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Expense category not found"})
+		return
+	}
+
+	_, err = db.Exec(context.Background(), "UPDATE expenses SET category = $1 WHERE category_id = $2", name, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update expenses: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Expense category updated successfully"})
+}
+
+// DeleteExpenseCategory deletes a category that is no longer used by any expense
+func DeleteExpenseCategory(c *gin.Context) {
+	id := c.Param("id")
+	db, _, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	var inUse bool
+	err = db.QueryRow(context.Background(),
+		`SELECT EXISTS(SELECT 1 FROM expenses WHERE category_id = $1)
+		     OR EXISTS(SELECT 1 FROM recurring_expenses WHERE category_id = $1)`, id).Scan(&inUse)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check category usage: " + err.Error()})
+		return
+	}
+	if inUse {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Category is used by existing expenses"})
+		return
+	}
+
+	result, err := db.Exec(context.Background(), "DELETE FROM expense_categories WHERE id = $1", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete expense category: " + err.Error()})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Expense category not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Expense category deleted successfully"})
+}
+
+// GetRecurringExpenses lists recurring expenses
+func GetRecurringExpenses(c *gin.Context) {
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT r.id, COALESCE(r.category_id::text, ''), COALESCE(ec.name, ''), COALESCE(r.car_id::text, ''),
+		       r.amount, COALESCE(r.currency, $1), COALESCE(r.description, ''), r.frequency,
+		       r.start_date, r.end_date, r.next_run_date, r.active
+		FROM recurring_expenses r
+		LEFT JOIN expense_categories ec ON r.category_id = ec.id
+		ORDER BY r.next_run_date`, GetBaseCurrency(db, tenant.ID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch recurring expenses: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var recurring []RecurringExpense
+	for rows.Next() {
+		var r RecurringExpense
+		if err := rows.Scan(&r.ID, &r.CategoryID, &r.Category, &r.CarID, &r.Amount, &r.Currency, &r.Description,
+			&r.Frequency, &r.StartDate, &r.EndDate, &r.NextRunDate, &r.Active); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan recurring expense: " + err.Error()})
+			return
+		}
+		recurring = append(recurring, r)
+	}
+
+	if recurring == nil {
+		recurring = []RecurringExpense{}
+	}
+
+	c.JSON(http.StatusOK, recurring)
+}
+
+// CreateRecurringExpense schedules an expense that is generated automatically on each due date
+func CreateRecurringExpense(c *gin.Context) {
+	var req CreateRecurringExpenseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.EndDate != nil && req.EndDate.Before(req.StartDate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "end_date must not be before start_date"})
+		return
+	}
+
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	categoryID, _, err := resolveExpenseCategory(db, tenant.ID, req.CategoryID, req.Category)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	currency := strings.ToUpper(req.Currency)
+	if currency == "" {
+		currency = GetBaseCurrency(db, tenant.ID)
+	}
+
+	var id string
+	err = db.QueryRow(context.Background(),
+		`INSERT INTO recurring_expenses (tenant_id, category_id, car_id, amount, currency, description, frequency, start_date, end_date, next_run_date)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $8) RETURNING id`,
+		tenant.ID, categoryID, nullIfEmpty(req.CarID), req.Amount, currency,
+		req.Description, req.Frequency, req.StartDate, req.EndDate).Scan(&id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create recurring expense: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Recurring expense created successfully", "id": id})
+}
+
+// UpdateRecurringExpense changes the amount, description, end date or pauses a recurring expense.
+// Already generated expenses are not modified.
+func UpdateRecurringExpense(c *gin.Context) {
+	id := c.Param("id")
+	var req UpdateRecurringExpenseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, _, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	setClauses := []string{}
+	args := []interface{}{}
+	argIndex := 1
+
+	if req.Amount != nil {
+		setClauses = append(setClauses, fmt.Sprintf("amount = $%d", argIndex))
+		args = append(args, *req.Amount)
+		argIndex++
+	}
+	if req.Description != nil {
+		setClauses = append(setClauses, fmt.Sprintf("description = $%d", argIndex))
+		args = append(args, *req.Description)
+		argIndex++
+	}
+	if req.EndDate != nil {
+		setClauses = append(setClauses, fmt.Sprintf("end_date = $%d", argIndex))
+		args = append(args, *req.EndDate)
+		argIndex++
+	}
+	if req.Active != nil {
+		setClauses = append(setClauses, fmt.Sprintf("active = $%d", argIndex))
+		args = append(args, *req.Active)
+		argIndex++
+	}
+
+	// A paused series resumes from today: the occurrences of the pause are not booked afterwards
+	if req.Active != nil && *req.Active {
+		var frequency string
+		var startDate, nextRunDate time.Time
+		var active bool
+		err := db.QueryRow(context.Background(),
+			"SELECT frequency, start_date, next_run_date, active FROM recurring_expenses WHERE id = $1", id).
+			Scan(&frequency, &startDate, &nextRunDate, &active)
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Recurring expense not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch recurring expense: " + err.Error()})
+			return
+		}
+		if !active {
+			now := time.Now()
+			today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+			for nextRunDate.Before(today) {
+				nextRunDate = NextOccurrence(frequency, startDate, nextRunDate)
+			}
+			setClauses = append(setClauses, fmt.Sprintf("next_run_date = $%d", argIndex))
+			args = append(args, nextRunDate)
+			argIndex++
+		}
+	}
+
+	if len(setClauses) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields to update"})
+		return
+	}
+
+	query := fmt.Sprintf("UPDATE recurring_expenses SET %s, updated_at = NOW() WHERE id = $%d",
+		strings.Join(setClauses, ", "), argIndex)
+	args = append(args, id)
+
+	result, err := db.Exec(context.Background(), query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update recurring expense: " + err.Error()})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Recurring expense not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Recurring expense updated successfully"})
+}
+
+// DeleteRecurringExpense stops a recurring expense. Generated expenses are kept.
+func DeleteRecurringExpense(c *gin.Context) {
+	id := c.Param("id")
+	db, _, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	result, err := db.Exec(context.Background(), "DELETE FROM recurring_expenses WHERE id = $1", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete recurring expense: " + err.Error()})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Recurring expense not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Recurring expense deleted successfully"})
+}
+
+// NextOccurrence returns the occurrence after current. Month-based frequencies are counted from
+// the start date so that e.g. a series starting on the 31st falls on the last day of shorter months.
+func NextOccurrence(frequency string, start, current time.Time) time.Time {
+	months := 0
+	switch frequency {
+	case "weekly":
+		return current.AddDate(0, 0, 7)
+	case "quarterly":
+		months = 3
+	case "yearly":
+		months = 12
+	default:
+		months = 1
+	}
+
+	elapsed := (current.Year()-start.Year())*12 + int(current.Month()-start.Month())
+	return addMonthsClamped(start, elapsed+months)
+}
+
+// addMonthsClamped adds months to t, keeping the day of month within the target month
+func addMonthsClamped(t time.Time, months int) time.Time {
+	firstOfMonth := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location()).AddDate(0, months, 0)
+	lastDay := firstOfMonth.AddDate(0, 1, -1).Day()
+	day := t.Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return time.Date(firstOfMonth.Year(), firstOfMonth.Month(), day, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"car-rental-backend/internal/export"
+	"car-rental-backend/internal/models"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportFormat reads ?format=. It returns "" for the default JSON response,
+// and false after answering 400 when the format is not supported.
+func exportFormat(c *gin.Context) (string, bool) {
+	switch format := c.Query("format"); format {
+	case "", "json":
+		return "", true
+	case export.FormatCSV, export.FormatXLSX:
+		return format, true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format. Allowed: json, csv, xlsx"})
+		return "", false
+	}
+}
+
+// startExport sends the download headers and the file header (tenant name, report title,
+// generation date and column names). It returns a nil writer when format is "".
+// Numbers and dates follow ?locale= or, when absent, the Accept-Language header.
+func startExport(c *gin.Context, format string, tenant *models.Tenant, name, title string, columns ...string) (export.Writer, error) {
+	if format == "" {
+		return nil, nil
+	}
+
+	locale := export.LocaleFor(c.DefaultQuery("locale", c.GetHeader("Accept-Language")))
+	now := time.Now()
+
+	c.Header("Content-Type", export.ContentType(format))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s-%s.%s"`,
+		tenant.Subdomain, name, now.Format("20060102"), format))
+	c.Status(http.StatusOK)
+
+	w, err := export.New(format, c.Writer, title, locale)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]interface{}, len(columns))
+	for i, col := range columns {
+		header[i] = col
+	}
+	for _, row := range [][]interface{}{
+		{tenant.Name},
+		{title, "Generated " + now.Format(locale.DateLayout+" 15:04")},
+		{},
+		header,
+	} {
+		if err := w.Row(row...); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// finishExport completes the file. Headers are already sent, so failures can only be logged.
+func finishExport(w export.Writer) {
+	if err := w.Close(); err != nil {
+		log.Printf("[EXPORT] Failed to complete export: %v", err)
+	}
+}
+
+// rowsFailed reports an error while reading the rows of a list. Once an export has started the response is
+// already a file with a 200 status, so the error can only be logged and the download is left incomplete.
+func rowsFailed(c *gin.Context, exp export.Writer, message string, err error) {
+	if exp != nil {
+		log.Printf("[EXPORT] %s: %v", message, err)
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message + ": " + err.Error()})
+}
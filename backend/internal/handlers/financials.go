@@ -2,40 +2,39 @@ package handlers
 
 import (
 	"car-rental-backend/internal/database"
+	"car-rental-backend/internal/mail"
 	"car-rental-backend/internal/models"
 	"context"
+	"fmt"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-type Expense struct {
-	ID          string    `json:"id"`
-	Amount      float64   `json:"amount"`
-	Category    string    `json:"category"`
-	Date        time.Time `json:"date"`
-	Description string    `json:"description"`
-}
-
-type CreateExpenseRequest struct {
-	Amount      float64   `json:"amount" binding:"required"`
-	Category    string    `json:"category" binding:"required"`
-	Date        time.Time `json:"date" binding:"required"`
-	Description string    `json:"description"`
-}
-
 type Invoice struct {
 	ID           string     `json:"id"`
 	BookingID    string     `json:"booking_id"`
 	Amount       float64    `json:"amount"`
+	Currency     string     `json:"currency"`
+	ExchangeRate *float64   `json:"exchange_rate"` // To ExchangeRateBase, fixed at invoice date
+	RateBase     *string    `json:"exchange_rate_base"`
 	Status       string     `json:"status"`
 	IssuedDate   time.Time  `json:"issued_date"`
 	DueDate      *time.Time `json:"due_date"`
 	CustomerName string     `json:"customer_name,omitempty"` // Joined
 }
 
+// InvoiceLine is a line of an invoice: the rental, or a discount with a negative amount
+type InvoiceLine struct {
+	Kind        string  `json:"kind"` // rental, promo, loyalty, other
+	Description string  `json:"description"`
+	Amount      float64 `json:"amount"`
+}
+
 type GenerateInvoiceRequest struct {
 	BookingID string    `json:"booking_id" binding:"required"`
 	Amount    float64   `json:"amount" binding:"required"`
@@ -43,9 +42,13 @@ type GenerateInvoiceRequest struct {
 }
 
 type RevenueStats struct {
-	TotalRevenue  float64 `json:"total_revenue"`
-	TotalExpenses float64 `json:"total_expenses"`
-	NetProfit     float64 `json:"net_profit"`
+	BaseCurrency       string             `json:"base_currency"`
+	TotalRevenue       float64            `json:"total_revenue"`  // In base currency
+	TotalExpenses      float64            `json:"total_expenses"` // In base currency
+	NetProfit          float64            `json:"net_profit"`
+	RevenueByCurrency  map[string]float64 `json:"revenue_by_currency"` // Original amounts
+	ExpensesByCurrency map[string]float64 `json:"expenses_by_currency"`
+	MissingRates       []string           `json:"missing_rates"` // Currencies without an exchange rate, excluded from totals
 }
 
 // Helper to get tenant DB connection (reused logic)
@@ -59,41 +62,10 @@ func getTenantDBForFinancials(c *gin.Context) (*pgxpool.Pool, *models.Tenant, er
 	return db, tenant, err
 }
 
-func GetExpenses(c *gin.Context) {
-	db, _, err := getTenantDBForFinancials(c)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
-		return
-	}
-
-	rows, err := db.Query(context.Background(), "SELECT id, amount, category, date, description FROM expenses ORDER BY date DESC")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch expenses: " + err.Error()})
-		return
-	}
-	defer rows.Close()
-
-	var expenses []Expense
-	for rows.Next() {
-		var e Expense
-		if err := rows.Scan(&e.ID, &e.Amount, &e.Category, &e.Date, &e.Description); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan expense: " + err.Error()})
-			return
-		}
-		expenses = append(expenses, e)
-	}
-
-	if expenses == nil {
-		expenses = []Expense{}
-	}
-
-	c.JSON(http.StatusOK, expenses)
-}
-
-func CreateExpense(c *gin.Context) {
-	var req CreateExpenseRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// GetInvoices lists invoices, or downloads them with ?format=csv|xlsx
+func GetInvoices(c *gin.Context) {
+	format, ok := exportFormat(c)
+	if !ok {
 		return
 	}
 
@@ -103,29 +75,9 @@ func CreateExpense(c *gin.Context) {
 		return
 	}
 
-	var expenseID string
-	err = db.QueryRow(context.Background(),
-		"INSERT INTO expenses (tenant_id, amount, category, date, description) VALUES ($1, $2, $3, $4, $5) RETURNING id",
-		tenant.ID, req.Amount, req.Category, req.Date, req.Description).Scan(&expenseID)
-
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create expense: " + err.Error()})
-		return
-	}
-
-	c.JSON(http.StatusCreated, gin.H{"message": "Expense created successfully", "id": expenseID})
-}
-
-func GetInvoices(c *gin.Context) {
-	db, _, err := getTenantDBForFinancials(c)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
-		return
-	}
-
 	// Join with bookings and customers to get customer name
 	query := `
-		SELECT i.id, i.booking_id, i.amount, i.status, i.created_at, i.due_date, 
+		SELECT i.id, i.booking_id, i.amount, COALESCE(i.currency, 'MAD'), i.exchange_rate, i.exchange_rate_base, i.status, i.created_at, i.due_date, 
 		       COALESCE(cust.first_name || ' ' || cust.last_name, 'Unknown') as customer_name
 		FROM invoices i
 		JOIN bookings b ON i.booking_id = b.id
@@ -139,16 +91,34 @@ func GetInvoices(c *gin.Context) {
 	}
 	defer rows.Close()
 
+	exp, err := startExport(c, format, tenant, "invoices", "Invoices",
+		"Invoice ID", "Booking ID", "Customer", "Issued", "Due", "Status", "Amount", "Currency", "Exchange rate")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start export: " + err.Error()})
+		return
+	}
+
 	var invoices []Invoice
 	for rows.Next() {
 		var i Invoice
-		if err := rows.Scan(&i.ID, &i.BookingID, &i.Amount, &i.Status, &i.IssuedDate, &i.DueDate, &i.CustomerName); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan invoice: " + err.Error()})
+		if err := rows.Scan(&i.ID, &i.BookingID, &i.Amount, &i.Currency, &i.ExchangeRate, &i.RateBase, &i.Status, &i.IssuedDate, &i.DueDate, &i.CustomerName); err != nil {
+			rowsFailed(c, exp, "Failed to scan invoice", err)
 			return
 		}
+		if exp != nil {
+			if err := exp.Row(i.ID, i.BookingID, i.CustomerName, i.IssuedDate, i.DueDate, i.Status, i.Amount, i.Currency, i.ExchangeRate); err != nil {
+				return
+			}
+			continue
+		}
 		invoices = append(invoices, i)
 	}
 
+	if exp != nil {
+		finishExport(exp)
+		return
+	}
+
 	if invoices == nil {
 		invoices = []Invoice{}
 	}
@@ -169,41 +139,254 @@ func GenerateInvoice(c *gin.Context) {
 		return
 	}
 
-	var invoiceID string
+	if !ensurePeriodOpen(c, db, tenant.ID, time.Now()) {
+		return
+	}
+
+	// Invoices are issued in the booking's currency; the rate to the base currency is fixed at invoice date
+	var currency string
 	err = db.QueryRow(context.Background(),
-		"INSERT INTO invoices (tenant_id, booking_id, amount, due_date) VALUES ($1, $2, $3, $4) RETURNING id",
-		tenant.ID, req.BookingID, req.Amount, req.DueDate).Scan(&invoiceID)
+		"SELECT COALESCE(currency, 'MAD') FROM bookings WHERE id = $1", req.BookingID).Scan(&currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Booking not found"})
+		return
+	}
+	var exchangeRate interface{}
+	baseCurrency := GetBaseCurrency(db, tenant.ID)
+	if rate, ok := lookupExchangeRate(db, currency, baseCurrency, time.Now()); ok {
+		exchangeRate = rate
+	}
+
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var invoiceID string
+	err = tx.QueryRow(ctx,
+		"INSERT INTO invoices (tenant_id, booking_id, amount, currency, exchange_rate, exchange_rate_base, due_date) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id",
+		tenant.ID, req.BookingID, req.Amount, currency, exchangeRate, baseCurrency, req.DueDate).Scan(&invoiceID)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate invoice: " + err.Error()})
 		return
 	}
 
+	if err := createInvoiceLines(tx, tenant.ID, invoiceID, req.BookingID, req.Amount); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate invoice lines: " + err.Error()})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate invoice: " + err.Error()})
+		return
+	}
+	logEmailError(mail.TemplateInvoiceIssued, invoiceID, queueInvoiceEmail(db, tenant, invoiceID))
+
 	c.JSON(http.StatusCreated, gin.H{"message": "Invoice generated successfully", "id": invoiceID})
 }
 
-func GetRevenueStats(c *gin.Context) {
+// GetInvoiceLines returns the lines of an invoice
+func GetInvoiceLines(c *gin.Context) {
 	db, _, err := getTenantDBForFinancials(c)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
 		return
 	}
 
-	var stats RevenueStats
+	lines, err := loadInvoiceLines(db, c.Param("id"))
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoice lines: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, lines)
+}
 
-	// Calculate Total Revenue (Sum of Paid Invoices or just all Invoices for now? Let's say all for simplicity or just Paid)
-	// For MVP let's sum all invoices as "Revenue" and all expenses as "Expenses"
-	err = db.QueryRow(context.Background(), "SELECT COALESCE(SUM(amount), 0) FROM invoices").Scan(&stats.TotalRevenue)
+// createInvoiceLines itemizes an invoice of amount (what is due after discounts) as the rental and the
+// booking's promo code and loyalty discounts. Discounts go on the first invoice of the booking only.
+func createInvoiceLines(tx pgx.Tx, tenantID, invoiceID, bookingID string, amount float64) error {
+	ctx := context.Background()
+	var car, promoCode string
+	var start, end time.Time
+	var promoDiscount, loyaltyDiscount float64
+	var loyaltyPoints int
+	var discounted bool
+	err := tx.QueryRow(ctx, `
+		SELECT c.brand || ' ' || c.model, b.start_date, b.end_date, COALESCE(p.code, ''),
+		       b.promo_discount::float8, b.loyalty_discount::float8, b.loyalty_points_redeemed,
+		       EXISTS (SELECT 1 FROM invoice_lines l JOIN invoices i ON l.invoice_id = i.id
+		               WHERE i.booking_id = b.id AND i.id != $2 AND i.status != 'Cancelled' AND l.kind IN ('promo', 'loyalty'))
+		FROM bookings b
+		JOIN cars c ON b.car_id = c.id
+		LEFT JOIN promo_codes p ON b.promo_code_id = p.id
+		WHERE b.id = $1`, bookingID, invoiceID).Scan(&car, &start, &end, &promoCode,
+		&promoDiscount, &loyaltyDiscount, &loyaltyPoints, &discounted)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate revenue: " + err.Error()})
+		return err
+	}
+
+	lines := []InvoiceLine{}
+	rental := amount
+	if !discounted {
+		if promoDiscount > 0 {
+			lines = append(lines, InvoiceLine{Kind: "promo", Description: "Promo code " + promoCode, Amount: -promoDiscount})
+			rental += promoDiscount
+		}
+		if loyaltyDiscount > 0 {
+			lines = append(lines, InvoiceLine{Kind: "loyalty", Description: fmt.Sprintf("Loyalty points (%d)", loyaltyPoints), Amount: -loyaltyDiscount})
+			rental += loyaltyDiscount
+		}
+	}
+	lines = append([]InvoiceLine{{
+		Kind:        "rental",
+		Description: fmt.Sprintf("Rental %s, %s to %s", car, start.Format("2006-01-02"), end.Format("2006-01-02")),
+		Amount:      roundCents(rental),
+	}}, lines...)
+
+	for i, line := range lines {
+		_, err := tx.Exec(ctx,
+			"INSERT INTO invoice_lines (tenant_id, invoice_id, kind, description, amount, position) VALUES ($1, $2, $3, $4, $5, $6)",
+			tenantID, invoiceID, line.Kind, line.Description, line.Amount, i)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadInvoiceLines returns the lines of an invoice. Invoices issued before lines existed get a single rental line.
+func loadInvoiceLines(db dbQuerier, invoiceID string) ([]InvoiceLine, error) {
+	var amount float64
+	if err := db.QueryRow(context.Background(),
+		"SELECT amount::float8 FROM invoices WHERE id = $1", invoiceID).Scan(&amount); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(context.Background(),
+		"SELECT kind, description, amount::float8 FROM invoice_lines WHERE invoice_id = $1 ORDER BY position", invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := []InvoiceLine{}
+	for rows.Next() {
+		var l InvoiceLine
+		if err := rows.Scan(&l.Kind, &l.Description, &l.Amount); err != nil {
+			return nil, err
+		}
+		lines = append(lines, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		lines = append(lines, InvoiceLine{Kind: "rental", Description: "Rental", Amount: amount})
+	}
+	return lines, nil
+}
+
+func GetRevenueStats(c *gin.Context) {
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
 		return
 	}
 
-	err = db.QueryRow(context.Background(), "SELECT COALESCE(SUM(amount), 0) FROM expenses").Scan(&stats.TotalExpenses)
+	stats := RevenueStats{
+		BaseCurrency:       GetBaseCurrency(db, tenant.ID),
+		RevenueByCurrency:  map[string]float64{},
+		ExpensesByCurrency: map[string]float64{},
+		MissingRates:       []string{},
+	}
+
+	// Optional period filter (?from=&to=); without it all invoices and expenses are summed
+	var from, to interface{}
+	if c.Query("from") != "" || c.Query("to") != "" {
+		fromDate, toDate, err := parseDateRange(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		from, to = fromDate, toDate
+	}
+
+	// Sum all invoices as "Revenue" and all expenses as "Expenses", per original currency.
+	// Base amounts use the rate fixed on the invoice, or the rate at invoice/expense date.
+	missing := map[string]bool{}
+	rows, err := db.Query(context.Background(),
+		`SELECT COALESCE(currency, 'MAD'), SUM(amount), SUM(amount * COALESCE(stored_rate(exchange_rate, exchange_rate_base, $3), fx_rate(COALESCE(currency, 'MAD'), $3, created_at::date))),
+		        BOOL_OR(COALESCE(stored_rate(exchange_rate, exchange_rate_base, $3), fx_rate(COALESCE(currency, 'MAD'), $3, created_at::date)) IS NULL)
+		 FROM invoices
+		 WHERE status != 'Cancelled' AND ($1::date IS NULL OR created_at::date BETWEEN $1 AND $2)
+		 GROUP BY 1`,
+		from, to, stats.BaseCurrency)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate revenue: " + err.Error()})
+		return
+	}
+	for rows.Next() {
+		var currency string
+		var original float64
+		var converted *float64
+		var hasMissing bool
+		if err := rows.Scan(&currency, &original, &converted, &hasMissing); err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate revenue: " + err.Error()})
+			return
+		}
+		stats.RevenueByCurrency[currency] = original
+		if converted != nil {
+			stats.TotalRevenue += *converted
+		}
+		if hasMissing {
+			missing[currency] = true
+		}
+	}
+	rows.Close()
+
+	rows, err = db.Query(context.Background(),
+		`SELECT COALESCE(currency, $3), SUM(amount), SUM(amount * fx_rate(COALESCE(currency, $3), $3, date)),
+		        BOOL_OR(fx_rate(COALESCE(currency, $3), $3, date) IS NULL)
+		 FROM expenses
+		 WHERE $1::date IS NULL OR date BETWEEN $1 AND $2
+		 GROUP BY 1`,
+		from, to, stats.BaseCurrency)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate expenses: " + err.Error()})
 		return
 	}
+	for rows.Next() {
+		var currency string
+		var original float64
+		var converted *float64
+		var hasMissing bool
+		if err := rows.Scan(&currency, &original, &converted, &hasMissing); err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate expenses: " + err.Error()})
+			return
+		}
+		stats.ExpensesByCurrency[currency] = original
+		if converted != nil {
+			stats.TotalExpenses += *converted
+		}
+		if hasMissing {
+			missing[currency] = true
+		}
+	}
+	rows.Close()
+
+	for currency := range missing {
+		stats.MissingRates = append(stats.MissingRates, currency)
+	}
+	sort.Strings(stats.MissingRates)
 
 	stats.NetProfit = stats.TotalRevenue - stats.TotalExpenses
 
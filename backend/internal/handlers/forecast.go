@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ForecastPeriod projects revenue (in the base currency) and utilization over a window of future days.
+// Pending booking requests are weighted by the historical conversion rate.
+type ForecastPeriod struct {
+	Days                 int     `json:"days"`
+	From                 string  `json:"from"`
+	To                   string  `json:"to"`
+	ConfirmedRevenue     float64 `json:"confirmed_revenue"` // Confirmed and active bookings
+	PendingRevenue       float64 `json:"pending_revenue"`   // Pending bookings
+	RequestRevenue       float64 `json:"request_revenue"`   // Pending booking requests x conversion rate
+	ExpectedRevenue      float64 `json:"expected_revenue"`
+	ConfirmedUtilization float64 `json:"confirmed_utilization"`
+	ExpectedUtilization  float64 `json:"expected_utilization"`
+	LowDemand            bool    `json:"low_demand,omitempty"` // Weeks only: expected utilization under the threshold
+}
+
+type RevenueForecast struct {
+	BaseCurrency       string           `json:"base_currency"`
+	ConversionRate     float64          `json:"conversion_rate"`
+	ConversionSample   int              `json:"conversion_sample"` // Decided requests the rate is based on (0 = default rate)
+	LowDemandThreshold float64          `json:"low_demand_threshold"`
+	Horizons           []ForecastPeriod `json:"horizons"` // Next 30, 60 and 90 days
+	Weeks              []ForecastPeriod `json:"weeks"`    // Week by week, to spot empty weeks
+}
+
+const (
+	// defaultConversionRate is used until enough booking requests have been confirmed or rejected
+	defaultConversionRate = 0.5
+	// minConversionSample is the number of decided requests needed to trust the historical rate
+	minConversionSample = 10
+	// conversionHistoryDays is how far back requests are used to compute the conversion rate
+	conversionHistoryDays = 180
+)
+
+var forecastHorizons = []int{30, 60, 90}
+
+// forecastDay holds the projected figures of one future day
+type forecastDay struct {
+	day                                                    time.Time
+	confirmedRevenue, pendingRevenue, requestRevenue       float64
+	confirmedCars, pendingCars, requestCars, availableCars int
+}
+
+// GetRevenueForecast projects revenue and utilization for the next 30/60/90 days from confirmed and
+// pending bookings and pending booking requests. ?threshold= sets the utilization percentage under
+// which a week is flagged as low demand (default 50).
+func GetRevenueForecast(c *gin.Context) {
+	threshold := 50.0
+	if v := c.Query("threshold"); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil || t < 0 || t > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "threshold must be a percentage between 0 and 100"})
+			return
+		}
+		threshold = t
+	}
+
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+	baseCurrency := GetBaseCurrency(db, tenant.ID)
+
+	forecast := RevenueForecast{BaseCurrency: baseCurrency, ConversionRate: defaultConversionRate, LowDemandThreshold: threshold}
+
+	var confirmed, decided int
+	err = db.QueryRow(context.Background(), `
+		SELECT COUNT(*) FILTER (WHERE status = 'confirmed'), COUNT(*) FILTER (WHERE status IN ('confirmed', 'rejected'))
+		FROM booking_requests
+		WHERE created_at >= NOW() - make_interval(days => $1)`, conversionHistoryDays).Scan(&confirmed, &decided)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute conversion rate: " + err.Error()})
+		return
+	}
+	if decided >= minConversionSample {
+		forecast.ConversionRate = float64(confirmed) / float64(decided)
+		forecast.ConversionSample = decided
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	end := today.AddDate(0, 0, forecastHorizons[len(forecastHorizons)-1]-1)
+
+	// Future amounts are converted at today's rate when the booking has no fixed rate
+	rows, err := db.Query(context.Background(), `
+		SELECT d.day::date,
+		       COALESCE((SELECT SUM(b.price_per_day * COALESCE(stored_rate(b.exchange_rate, b.exchange_rate_base, $3), fx_rate(COALESCE(b.currency, 'MAD'), $3, CURRENT_DATE)))
+		                 FROM bookings b WHERE b.status IN ('confirmed', 'active') AND d.day::date BETWEEN b.start_date AND b.end_date), 0),
+		       (SELECT COUNT(DISTINCT b.car_id)
+		        FROM bookings b WHERE b.status IN ('confirmed', 'active') AND d.day::date BETWEEN b.start_date AND b.end_date),
+		       COALESCE((SELECT SUM(b.price_per_day * COALESCE(stored_rate(b.exchange_rate, b.exchange_rate_base, $3), fx_rate(COALESCE(b.currency, 'MAD'), $3, CURRENT_DATE)))
+		                 FROM bookings b WHERE b.status = 'pending' AND d.day::date BETWEEN b.start_date AND b.end_date), 0),
+		       (SELECT COUNT(DISTINCT b.car_id)
+		        FROM bookings b WHERE b.status = 'pending' AND d.day::date BETWEEN b.start_date AND b.end_date),
+		       COALESCE((SELECT SUM(c.price_per_day * fx_rate(COALESCE(c.currency, 'MAD'), $3, CURRENT_DATE))
+		                 FROM booking_requests r JOIN cars c ON r.car_id = c.id
+		                 WHERE r.status = 'pending' AND d.day::date BETWEEN r.pickup_date AND r.return_date), 0),
+		       (SELECT COUNT(*) FROM booking_requests r
+		        WHERE r.status = 'pending' AND d.day::date BETWEEN r.pickup_date AND r.return_date),
+		       (SELECT COUNT(*) FROM cars c
+		        WHERE COALESCE(c.purchase_date, c.created_at::date) <= d.day::date
+		          AND NOT EXISTS (SELECT 1 FROM car_blocks k
+		                          WHERE k.car_id = c.id AND d.day::date BETWEEN k.start_date AND k.end_date))
+		FROM generate_series($1::date, $2::date, interval '1 day') d(day)
+		ORDER BY d.day`, today, end, baseCurrency)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute forecast: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var days []forecastDay
+	for rows.Next() {
+		var d forecastDay
+		if err := rows.Scan(&d.day, &d.confirmedRevenue, &d.confirmedCars, &d.pendingRevenue, &d.pendingCars,
+			&d.requestRevenue, &d.requestCars, &d.availableCars); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan forecast: " + err.Error()})
+			return
+		}
+		days = append(days, d)
+	}
+
+	forecast.Horizons = []ForecastPeriod{}
+	for _, h := range forecastHorizons {
+		if h <= len(days) {
+			forecast.Horizons = append(forecast.Horizons, forecastPeriod(days[:h], forecast.ConversionRate))
+		}
+	}
+
+	forecast.Weeks = []ForecastPeriod{}
+	for start := 0; start < len(days); start += 7 {
+		stop := start + 7
+		if stop > len(days) {
+			stop = len(days)
+		}
+		week := forecastPeriod(days[start:stop], forecast.ConversionRate)
+		week.LowDemand = week.ExpectedUtilization < threshold
+		forecast.Weeks = append(forecast.Weeks, week)
+	}
+
+	c.JSON(http.StatusOK, forecast)
+}
+
+// forecastPeriod sums a run of forecast days
+func forecastPeriod(days []forecastDay, conversionRate float64) ForecastPeriod {
+	p := ForecastPeriod{
+		Days: len(days),
+		From: days[0].day.Format("2006-01-02"),
+		To:   days[len(days)-1].day.Format("2006-01-02"),
+	}
+	var confirmedCarDays, expectedCarDays float64
+	var availableCarDays int
+	for _, d := range days {
+		p.ConfirmedRevenue += d.confirmedRevenue
+		p.PendingRevenue += d.pendingRevenue
+		p.RequestRevenue += d.requestRevenue * conversionRate
+		confirmedCarDays += float64(d.confirmedCars)
+		expectedCarDays += float64(d.confirmedCars+d.pendingCars) + float64(d.requestCars)*conversionRate
+		availableCarDays += d.availableCars
+	}
+	p.ExpectedRevenue = p.ConfirmedRevenue + p.PendingRevenue + p.RequestRevenue
+	if availableCarDays > 0 {
+		p.ConfirmedUtilization = roundPercent(confirmedCarDays / float64(availableCarDays) * 100)
+		p.ExpectedUtilization = roundPercent(expectedCarDays / float64(availableCarDays) * 100)
+		if p.ExpectedUtilization > 100 {
+			p.ExpectedUtilization = 100
+		}
+	}
+	return p
+}
+
+// roundPercent rounds a percentage to one decimal
+func roundPercent(f float64) float64 {
+	return float64(int64(f*10+0.5)) / 10
+}
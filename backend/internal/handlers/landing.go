@@ -2,6 +2,9 @@ package handlers
 
 import (
 	"car-rental-backend/internal/database"
+	"car-rental-backend/internal/events"
+	"car-rental-backend/internal/mail"
+	"car-rental-backend/internal/messaging"
 	"car-rental-backend/internal/models"
 	"context"
 	"encoding/json"
@@ -37,20 +40,22 @@ type LandingPage struct {
 
 // BookingRequest represents a public booking request
 type BookingRequest struct {
-	ID                string `json:"id"`
-	TenantID          string `json:"tenant_id"`
-	CarID             string `json:"car_id"`
-	CarInfo           string `json:"car_info,omitempty"` // Brand + Model for display
-	CustomerName      string `json:"customer_name"`
-	CustomerPhone     string `json:"customer_phone"`
-	CustomerEmail     string `json:"customer_email"`
-	PickupDate        string `json:"pickup_date"`
-	ReturnDate        string `json:"return_date"`
-	PickupLocation    string `json:"pickup_location"`
-	DeliveryRequested bool   `json:"delivery_requested"`
-	Message           string `json:"message"`
-	Status            string `json:"status"` // pending, confirmed, rejected
-	CreatedAt         string `json:"created_at"`
+	ID                string  `json:"id"`
+	TenantID          string  `json:"tenant_id"`
+	CarID             string  `json:"car_id"`
+	CarInfo           string  `json:"car_info,omitempty"` // Brand + Model for display
+	CustomerName      string  `json:"customer_name"`
+	CustomerPhone     string  `json:"customer_phone"`
+	CustomerEmail     string  `json:"customer_email"`
+	PickupDate        string  `json:"pickup_date"`
+	ReturnDate        string  `json:"return_date"`
+	PickupLocation    string  `json:"pickup_location"`
+	DeliveryRequested bool    `json:"delivery_requested"`
+	Message           string  `json:"message"`
+	Status            string  `json:"status"` // pending, confirmed, rejected, cancelled
+	PromoCode         string  `json:"promo_code,omitempty"`
+	PromoDiscount     float64 `json:"promo_discount"`
+	CreatedAt         string  `json:"created_at"`
 }
 
 // GetLandingPage returns landing page settings for a tenant
@@ -184,9 +189,11 @@ func GetBookingRequests(c *gin.Context) {
 	rows, err := pool.Query(context.Background(),
 		`SELECT br.id, br.tenant_id, br.car_id, CONCAT(c.brand, ' ', c.model) as car_info,
 		 br.customer_name, br.customer_phone, br.customer_email, br.pickup_date, br.return_date,
-		 br.pickup_location, br.delivery_requested, COALESCE(br.message, ''), br.status, br.created_at
+		 br.pickup_location, br.delivery_requested, COALESCE(br.message, ''), br.status,
+		 COALESCE(p.code, ''), br.promo_discount, br.created_at
 		 FROM booking_requests br
 		 LEFT JOIN cars c ON br.car_id = c.id
+		 LEFT JOIN promo_codes p ON br.promo_code_id = p.id
 		 ORDER BY br.created_at DESC`)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch booking requests"})
@@ -200,7 +207,7 @@ func GetBookingRequests(c *gin.Context) {
 		var pickupDate, returnDate, createdAt time.Time
 		if err := rows.Scan(&r.ID, &r.TenantID, &r.CarID, &r.CarInfo, &r.CustomerName,
 			&r.CustomerPhone, &r.CustomerEmail, &pickupDate, &returnDate, &r.PickupLocation,
-			&r.DeliveryRequested, &r.Message, &r.Status, &createdAt); err != nil {
+			&r.DeliveryRequested, &r.Message, &r.Status, &r.PromoCode, &r.PromoDiscount, &createdAt); err != nil {
 			continue
 		}
 		r.PickupDate = pickupDate.Format("2006-01-02")
@@ -245,6 +252,29 @@ func UpdateBookingRequestStatus(c *gin.Context) {
 		return
 	}
 
+	// Confirming a request is refused for blacklisted renters
+	warnings := []string{}
+	if req.Status == "confirmed" {
+		var phone, email string
+		err = pool.QueryRow(context.Background(),
+			"SELECT COALESCE(customer_phone, ''), COALESCE(customer_email, '') FROM booking_requests WHERE id = $1",
+			requestID).Scan(&phone, &email)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Booking request not found"})
+			return
+		}
+		risk, err := CheckCustomerRisk(pool, tenantModel.ID, "", phone, email, "")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check customer risk: " + err.Error()})
+			return
+		}
+		if risk.Blocked {
+			c.JSON(http.StatusForbidden, gin.H{"error": risk.Reason, "risk": risk})
+			return
+		}
+		warnings = risk.Warnings
+	}
+
 	_, err = pool.Exec(context.Background(),
 		`UPDATE booking_requests SET status = $1 WHERE id = $2`,
 		req.Status, requestID)
@@ -253,7 +283,28 @@ func UpdateBookingRequestStatus(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Status updated successfully"})
+	if req.Status == "confirmed" {
+		logEmailError(mail.TemplateBookingConfirmation, requestID, queueRequestEmail(pool, tenantModel, requestID, mail.TemplateBookingConfirmation))
+		logMessageError(messaging.TemplateBookingConfirmed, requestID, queueRequestMessage(pool, tenantModel, requestID, messaging.TemplateBookingConfirmed))
+	}
+
+	events.Publish(events.Event{
+		Type:     events.BookingRequestStatusChanged,
+		Tenant:   tenantModel,
+		DB:       pool,
+		ActorID:  c.GetString("user_id"),
+		EntityID: requestID,
+		Data:     map[string]interface{}{"id": requestID, "status": req.Status},
+	})
+
+	// A rejected request gives back its promo code use
+	if req.Status == "rejected" {
+		if err := releasePromoRedemption(pool, "booking_request_id", requestID); err != nil {
+			log.Printf("[PROMO] Failed to release promo code of request %s: %v", requestID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Status updated successfully", "warnings": warnings})
 }
 
 // CreatePublicBookingRequest creates a booking request from the public landing page (no auth required)
@@ -267,9 +318,9 @@ func CreatePublicBookingRequest(c *gin.Context) {
 
 	// Find tenant by subdomain
 	masterPool := database.DB
-	var tenantID, dbName string
+	var tenantModel models.Tenant
 	err := masterPool.QueryRow(context.Background(),
-		`SELECT id, db_name FROM tenants WHERE subdomain = $1`, subdomain).Scan(&tenantID, &dbName)
+		`SELECT id, name, db_name FROM tenants WHERE subdomain = $1`, subdomain).Scan(&tenantModel.ID, &tenantModel.Name, &tenantModel.DBName)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
 		return
@@ -285,32 +336,87 @@ func CreatePublicBookingRequest(c *gin.Context) {
 		PickupLocation    string `json:"pickup_location"`
 		DeliveryRequested bool   `json:"delivery_requested"`
 		Message           string `json:"message"`
+		PromoCode         string `json:"promo_code"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	pool, err := database.GetTenantDB(dbName)
+	pool, err := database.GetTenantDB(tenantModel.DBName)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection failed"})
 		return
 	}
 
+	promo := PromoCheck{}
+	if req.PromoCode != "" {
+		var ok bool
+		if promo, ok = applyPublicPromoCode(c, pool, tenantModel.ID, req.PromoCode, req.CarID, req.CustomerPhone, req.PickupDate, req.ReturnDate); !ok {
+			return
+		}
+	}
+
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create booking request"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
 	var id string
-	err = pool.QueryRow(context.Background(),
+	err = tx.QueryRow(ctx,
 		`INSERT INTO booking_requests (tenant_id, car_id, customer_name, customer_phone, 
-		 customer_email, pickup_date, return_date, pickup_location, delivery_requested, message, status)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, 'pending') RETURNING id`,
-		tenantID, req.CarID, req.CustomerName, req.CustomerPhone, req.CustomerEmail,
-		req.PickupDate, req.ReturnDate, req.PickupLocation, req.DeliveryRequested, req.Message).Scan(&id)
+		 customer_email, pickup_date, return_date, pickup_location, delivery_requested, message, status,
+		 promo_code_id, promo_discount)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, 'pending', $11, $12) RETURNING id`,
+		tenantModel.ID, req.CarID, req.CustomerName, req.CustomerPhone, req.CustomerEmail,
+		req.PickupDate, req.ReturnDate, req.PickupLocation, req.DeliveryRequested, req.Message,
+		nullIfEmpty(promo.PromoCodeID), promo.Discount).Scan(&id)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create booking request"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"id": id, "message": "Booking request submitted successfully"})
+	if promo.Valid {
+		redeemed, err := redeemPromoCode(tx, tenantModel.ID, promo, "", id, "", req.CustomerPhone)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create booking request"})
+			return
+		}
+		if !redeemed {
+			c.JSON(http.StatusConflict, gin.H{"error": "This promo code has reached its usage limit"})
+			return
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create booking request"})
+		return
+	}
+
+	logEmailError(mail.TemplateRequestReceived, id, queueRequestEmail(pool, &tenantModel, id, mail.TemplateRequestReceived))
+	logMessageError(messaging.TemplateRequestReceived, id, queueRequestMessage(pool, &tenantModel, id, messaging.TemplateRequestReceived))
+
+	requestData := map[string]interface{}{
+		"id":             id,
+		"car_id":         req.CarID,
+		"customer_name":  req.CustomerName,
+		"customer_phone": req.CustomerPhone,
+		"pickup_date":    req.PickupDate,
+		"return_date":    req.ReturnDate,
+	}
+	events.Publish(events.Event{
+		Type:     events.BookingRequestCreated,
+		Tenant:   &tenantModel,
+		DB:       pool,
+		EntityID: id,
+		Data:     requestData,
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"id": id, "message": "Booking request submitted successfully", "promo": promo})
 }
 
 // PublicLandingResponse combines landing page settings with branding and cars
@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"car-rental-backend/internal/audit"
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// LoyaltySettings configures how customers earn and redeem points. Amounts are in the base currency.
+type LoyaltySettings struct {
+	Enabled         bool    `json:"enabled"`
+	PointsPerUnit   float64 `json:"points_per_unit" binding:"gte=0"`
+	PointValue      float64 `json:"point_value" binding:"gte=0"`
+	MinRedeemPoints int     `json:"min_redeem_points" binding:"gte=0"`
+}
+
+var defaultLoyaltySettings = LoyaltySettings{
+	Enabled:         false,
+	PointsPerUnit:   1,
+	PointValue:      0.1,
+	MinRedeemPoints: 100,
+}
+
+type LoyaltyTransaction struct {
+	ID        string    `json:"id"`
+	BookingID *string   `json:"booking_id"`
+	Points    int       `json:"points"`
+	Kind      string    `json:"kind"` // earned, redeemed, refunded, reversed, adjusted
+	Note      string    `json:"note"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type AdjustLoyaltyRequest struct {
+	Points int    `json:"points" binding:"required"`
+	Note   string `json:"note" binding:"required"`
+}
+
+// GetLoyaltySettings returns the tenant's loyalty program settings
+func GetLoyaltySettings(c *gin.Context) {
+	db, tenant, err := getTenantDBForCustomers(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	settings, err := LoadLoyaltySettings(db, tenant.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch loyalty settings: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// UpdateLoyaltySettings updates the tenant's loyalty program settings
+func UpdateLoyaltySettings(c *gin.Context) {
+	var req LoyaltySettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, tenant, err := getTenantDBForCustomers(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	_, err = db.Exec(context.Background(),
+		`INSERT INTO loyalty_settings (tenant_id, enabled, points_per_unit, point_value, min_redeem_points, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, NOW())
+		 ON CONFLICT (tenant_id) DO UPDATE SET enabled = $2, points_per_unit = $3, point_value = $4,
+		     min_redeem_points = $5, updated_at = NOW()`,
+		tenant.ID, req.Enabled, req.PointsPerUnit, req.PointValue, req.MinRedeemPoints)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update loyalty settings: " + err.Error()})
+		return
+	}
+
+	audit.LogAudit(c, "UPDATE_LOYALTY_SETTINGS", gin.H{"enabled": req.Enabled, "points_per_unit": req.PointsPerUnit, "point_value": req.PointValue})
+
+	c.JSON(http.StatusOK, req)
+}
+
+// LoadLoyaltySettings returns the tenant's loyalty settings, or the defaults if none are saved
+func LoadLoyaltySettings(db dbQuerier, tenantID string) (LoyaltySettings, error) {
+	settings := defaultLoyaltySettings
+	err := db.QueryRow(context.Background(),
+		"SELECT enabled, points_per_unit::float8, point_value::float8, min_redeem_points FROM loyalty_settings WHERE tenant_id = $1",
+		tenantID).Scan(&settings.Enabled, &settings.PointsPerUnit, &settings.PointValue, &settings.MinRedeemPoints)
+	if err == pgx.ErrNoRows {
+		return defaultLoyaltySettings, nil
+	}
+	return settings, err
+}
+
+// GetCustomerLoyalty returns a customer's points balance and history
+func GetCustomerLoyalty(c *gin.Context) {
+	customerID := c.Param("id")
+	db, tenant, err := getTenantDBForCustomers(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT id, booking_id, points, kind, COALESCE(note, ''), created_at
+		FROM loyalty_transactions
+		WHERE customer_id = $1
+		ORDER BY created_at DESC`, customerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch loyalty points: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	balance := 0
+	transactions := []LoyaltyTransaction{}
+	for rows.Next() {
+		var t LoyaltyTransaction
+		if err := rows.Scan(&t.ID, &t.BookingID, &t.Points, &t.Kind, &t.Note, &t.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan loyalty transaction: " + err.Error()})
+			return
+		}
+		balance += t.Points
+		transactions = append(transactions, t)
+	}
+
+	settings, _ := LoadLoyaltySettings(db, tenant.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"balance":      balance,
+		"value":        roundCents(float64(balance) * settings.PointValue), // In base currency
+		"currency":     GetBaseCurrency(db, tenant.ID),
+		"transactions": transactions,
+	})
+}
+
+// AdjustCustomerLoyalty adds or removes points by hand (e.g. goodwill gesture or correction)
+func AdjustCustomerLoyalty(c *gin.Context) {
+	customerID := c.Param("id")
+	var req AdjustLoyaltyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, tenant, err := getTenantDBForCustomers(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	balance, err := lockLoyaltyBalance(tx, customerID)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch loyalty points: " + err.Error()})
+		return
+	}
+	if balance+req.Points < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The balance cannot go below zero", "balance": balance})
+		return
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO loyalty_transactions (tenant_id, customer_id, points, kind, note, created_by)
+		 VALUES ($1, $2, $3, 'adjusted', $4, $5)`,
+		tenant.ID, customerID, req.Points, req.Note, nullIfEmpty(c.GetString("user_id")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to adjust loyalty points: " + err.Error()})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to adjust loyalty points: " + err.Error()})
+		return
+	}
+
+	audit.LogAudit(c, "ADJUST_LOYALTY_POINTS", gin.H{"customer_id": customerID, "points": req.Points, "note": req.Note})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Loyalty points adjusted", "balance": balance + req.Points})
+}
+
+// lockLoyaltyBalance locks the customer row, so concurrent redemptions cannot overspend, and returns their balance
+func lockLoyaltyBalance(tx pgx.Tx, customerID string) (int, error) {
+	var balance int
+	err := tx.QueryRow(context.Background(), `
+		SELECT COALESCE((SELECT SUM(points) FROM loyalty_transactions WHERE customer_id = c.id), 0)
+		FROM customers c WHERE c.id = $1 AND c.deleted_at IS NULL
+		FOR UPDATE`, customerID).Scan(&balance)
+	return balance, err
+}
+
+// loyaltyDiscount converts points to a discount in a booking's currency. rate is the value of one unit of the
+// booking currency in the base currency.
+func loyaltyDiscount(settings LoyaltySettings, points int, rate float64) float64 {
+	if rate <= 0 {
+		return 0
+	}
+	return roundCents(float64(points) * settings.PointValue / rate)
+}
+
+// redeemLoyaltyPoints spends points of a customer on a booking. It returns a reason when the redemption is refused.
+func redeemLoyaltyPoints(tx pgx.Tx, tenantID, customerID, bookingID, userID string, points int, settings LoyaltySettings) (string, error) {
+	if !settings.Enabled {
+		return "The loyalty program is not enabled", nil
+	}
+	if points < settings.MinRedeemPoints {
+		return "Not enough points to redeem, the minimum is " + strconv.Itoa(settings.MinRedeemPoints), nil
+	}
+	balance, err := lockLoyaltyBalance(tx, customerID)
+	if err != nil {
+		return "", err
+	}
+	if points > balance {
+		return "The customer only has " + strconv.Itoa(balance) + " points", nil
+	}
+
+	_, err = tx.Exec(context.Background(),
+		`INSERT INTO loyalty_transactions (tenant_id, customer_id, booking_id, points, kind, created_by)
+		 VALUES ($1, $2, $3, $4, 'redeemed', $5)`,
+		tenantID, customerID, bookingID, -points, nullIfEmpty(userID))
+	return "", err
+}
+
+// AwardLoyaltyPoints credits the customer of a completed booking with points for its amount in the base
+// currency. A booking earns points only once.
+func AwardLoyaltyPoints(db *pgxpool.Pool, tenantID, bookingID string) error {
+	settings, err := LoadLoyaltySettings(db, tenantID)
+	if err != nil || !settings.Enabled || settings.PointsPerUnit == 0 {
+		return err
+	}
+
+	ctx := context.Background()
+	var customerID *string
+	var total float64
+	var currency string
+	var exchangeRate *float64
+	err = db.QueryRow(ctx, `
+		SELECT customer_id, (price_per_day * (end_date - start_date + 1))::float8, COALESCE(currency, 'MAD'),
+		       stored_rate(exchange_rate, exchange_rate_base, $2)::float8
+		FROM bookings WHERE id = $1`, bookingID, GetBaseCurrency(db, tenantID)).Scan(&customerID, &total, &currency, &exchangeRate)
+	if err != nil || customerID == nil {
+		return err
+	}
+
+	rate := 1.0
+	if exchangeRate != nil {
+		rate = *exchangeRate
+	} else if r, ok := lookupExchangeRate(db, currency, GetBaseCurrency(db, tenantID), time.Now()); ok {
+		rate = r
+	}
+	points := int(math.Floor(total * rate * settings.PointsPerUnit))
+	if points <= 0 {
+		return nil
+	}
+
+	_, err = db.Exec(ctx,
+		`INSERT INTO loyalty_transactions (tenant_id, customer_id, booking_id, points, kind)
+		 VALUES ($1, $2, $3, $4, 'earned')
+		 ON CONFLICT (booking_id) WHERE kind = 'earned' DO NOTHING`,
+		tenantID, *customerID, bookingID, points)
+	return err
+}
+
+// ReleaseBookingRewards undoes the rewards of a cancelled booking: redeemed points are refunded, earned points
+// are taken back and its promo code use is released. Running it again has no effect.
+func ReleaseBookingRewards(db *pgxpool.Pool, tenantID, bookingID string) error {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, undo := range []struct{ from, to string }{{"redeemed", "refunded"}, {"earned", "reversed"}} {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO loyalty_transactions (tenant_id, customer_id, booking_id, points, kind, note)
+			SELECT $1, t.customer_id, t.booking_id, -t.points, $4, 'Booking cancelled'
+			FROM loyalty_transactions t
+			WHERE t.booking_id = $2 AND t.kind = $3
+			  AND NOT EXISTS (SELECT 1 FROM loyalty_transactions u WHERE u.booking_id = $2 AND u.kind = $4)`,
+			tenantID, bookingID, undo.from, undo.to)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := releasePromoRedemption(tx, "booking_id", bookingID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,453 @@
+package handlers
+
+import (
+	"car-rental-backend/internal/audit"
+	"car-rental-backend/internal/database"
+	"car-rental-backend/internal/messaging"
+	"car-rental-backend/internal/models"
+	"context"
+	"crypto/subtle"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// MessagingSettings controls the SMS/WhatsApp messages sent to customers
+type MessagingSettings struct {
+	Enabled              bool   `json:"enabled"`
+	Channel              string `json:"channel" binding:"omitempty,oneof=sms whatsapp"`
+	Sender               string `json:"sender"`
+	CountryCode          string `json:"country_code"`
+	SendRequestReceived  bool   `json:"send_request_received"`
+	SendBookingConfirmed bool   `json:"send_booking_confirmed"`
+	SendPickupReminder   bool   `json:"send_pickup_reminder"`
+	SendReturnReminder   bool   `json:"send_return_reminder"`
+}
+
+// OutboxMessage is a text message of the delivery log
+type OutboxMessage struct {
+	ID                string     `json:"id"`
+	Channel           string     `json:"channel"`
+	Template          string     `json:"template"`
+	CustomerID        *string    `json:"customer_id"`
+	BookingID         *string    `json:"booking_id"`
+	BookingRequestID  *string    `json:"booking_request_id"`
+	Recipient         string     `json:"recipient"`
+	Body              string     `json:"body"`
+	Status            string     `json:"status"` // pending, sent, delivered, failed
+	ProviderMessageID string     `json:"provider_message_id"`
+	Attempts          int        `json:"attempts"`
+	LastError         string     `json:"last_error"`
+	SentAt            *time.Time `json:"sent_at"`
+	DeliveredAt       *time.Time `json:"delivered_at"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+// defaultMessagingSettings is used when a tenant has not configured messaging yet. Messages cost money, so
+// they are off until the tenant turns them on.
+var defaultMessagingSettings = MessagingSettings{
+	Enabled:              false,
+	Channel:              messaging.ChannelSMS,
+	CountryCode:          "212",
+	SendRequestReceived:  true,
+	SendBookingConfirmed: true,
+	SendPickupReminder:   true,
+	SendReturnReminder:   true,
+}
+
+// messenger sends the messages of the outbox; set from main with SetMessenger
+var messenger = &messaging.Router{Providers: map[string]messaging.Provider{
+	messaging.ChannelSMS:      &messaging.FakeProvider{},
+	messaging.ChannelWhatsApp: &messaging.FakeProvider{},
+}}
+
+// SetMessenger sets the providers used for SMS and WhatsApp
+func SetMessenger(r *messaging.Router) {
+	messenger = r
+}
+
+// GetMessagingSettings returns the tenant's SMS/WhatsApp settings
+func GetMessagingSettings(c *gin.Context) {
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	settings, err := LoadMessagingSettings(db, tenant.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messaging settings: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// LoadMessagingSettings returns the tenant's messaging settings, or the defaults if none are saved
+func LoadMessagingSettings(db dbQuerier, tenantID string) (MessagingSettings, error) {
+	settings := defaultMessagingSettings
+	err := db.QueryRow(context.Background(),
+		`SELECT enabled, channel, COALESCE(sender, ''), country_code, send_request_received, send_booking_confirmed,
+		        send_pickup_reminder, send_return_reminder
+		 FROM messaging_settings WHERE tenant_id = $1`,
+		tenantID).Scan(&settings.Enabled, &settings.Channel, &settings.Sender, &settings.CountryCode,
+		&settings.SendRequestReceived, &settings.SendBookingConfirmed, &settings.SendPickupReminder, &settings.SendReturnReminder)
+	if err == pgx.ErrNoRows {
+		return settings, nil
+	}
+	return settings, err
+}
+
+// UpdateMessagingSettings updates the tenant's SMS/WhatsApp settings
+func UpdateMessagingSettings(c *gin.Context) {
+	var req MessagingSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Channel == "" {
+		req.Channel = defaultMessagingSettings.Channel
+	}
+	req.CountryCode = normalizePhone(req.CountryCode)
+	if req.CountryCode == "" {
+		req.CountryCode = defaultMessagingSettings.CountryCode
+	}
+	if len(req.CountryCode) > 4 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid country code"})
+		return
+	}
+
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	_, err = db.Exec(context.Background(),
+		`INSERT INTO messaging_settings (tenant_id, enabled, channel, sender, country_code, send_request_received,
+		 send_booking_confirmed, send_pickup_reminder, send_return_reminder, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())
+		 ON CONFLICT (tenant_id) DO UPDATE SET
+		 enabled = $2, channel = $3, sender = $4, country_code = $5, send_request_received = $6,
+		 send_booking_confirmed = $7, send_pickup_reminder = $8, send_return_reminder = $9, updated_at = NOW()`,
+		tenant.ID, req.Enabled, req.Channel, nullIfEmpty(strings.TrimSpace(req.Sender)), req.CountryCode,
+		req.SendRequestReceived, req.SendBookingConfirmed, req.SendPickupReminder, req.SendReturnReminder)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update messaging settings: " + err.Error()})
+		return
+	}
+
+	audit.LogAudit(c, "UPDATE_MESSAGING_SETTINGS", gin.H{"enabled": req.Enabled, "channel": req.Channel})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Messaging settings updated successfully"})
+}
+
+// messageEnabled tells whether the tenant sends a message template
+func (s MessagingSettings) messageEnabled(template string) bool {
+	if !s.Enabled {
+		return false
+	}
+	switch template {
+	case messaging.TemplateRequestReceived:
+		return s.SendRequestReceived
+	case messaging.TemplateBookingConfirmed:
+		return s.SendBookingConfirmed
+	case messaging.TemplatePickupReminder:
+		return s.SendPickupReminder
+	case messaging.TemplateReturnReminder:
+		return s.SendReturnReminder
+	}
+	return false
+}
+
+// internationalPhone returns a phone number as international digits. Local numbers (leading 0) get the
+// tenant's country code; numbers written with + or 00 keep theirs.
+func internationalPhone(phone, countryCode string) string {
+	phone = strings.TrimSpace(phone)
+	digits := normalizePhone(phone)
+	switch {
+	case strings.HasPrefix(phone, "+"):
+		return digits
+	case strings.HasPrefix(digits, "00"):
+		return digits[2:]
+	case strings.HasPrefix(digits, "0"):
+		return countryCode + digits[1:]
+	}
+	return digits
+}
+
+// queueMessage renders a message and saves it to the outbox, if the tenant sends this template. Nothing is queued
+// without a usable phone number.
+func queueMessage(db dbQuerier, tenant *models.Tenant, template, phone string, link messaging.OutboxMessage, data map[string]interface{}) error {
+	settings, err := LoadMessagingSettings(db, tenant.ID)
+	if err != nil || !settings.messageEnabled(template) {
+		return err
+	}
+	to := internationalPhone(phone, settings.CountryCode)
+	if len(to) < 8 {
+		return nil
+	}
+	body, err := messaging.Render(template, tenant.Name, data)
+	if err != nil {
+		return err
+	}
+
+	link.TenantID = tenant.ID
+	link.Template = template
+	link.Message = messaging.Message{Channel: settings.Channel, To: to, From: settings.Sender, Body: body}
+	_, err = messaging.Enqueue(db, link)
+	return err
+}
+
+// queueBookingMessage texts the customer of a booking (confirmation or reminders)
+func queueBookingMessage(db dbQuerier, tenant *models.Tenant, bookingID, template string) error {
+	var customerID, name, phone, car, currency string
+	var start, end time.Time
+	var total float64
+	err := db.QueryRow(context.Background(), `
+		SELECT cust.id, cust.first_name, COALESCE(cust.phone, ''), c.brand || ' ' || c.model, b.start_date, b.end_date,
+		       (b.price_per_day * (b.end_date - b.start_date + 1))::float8, COALESCE(b.currency, 'MAD')
+		FROM bookings b
+		JOIN cars c ON b.car_id = c.id
+		JOIN customers cust ON b.customer_id = cust.id
+		WHERE b.id = $1`, bookingID).Scan(&customerID, &name, &phone, &car, &start, &end, &total, &currency)
+	if err != nil {
+		return err
+	}
+	return queueMessage(db, tenant, template, phone, messaging.OutboxMessage{CustomerID: customerID, BookingID: bookingID},
+		map[string]interface{}{
+			"CustomerName": name,
+			"Car":          car,
+			"StartDate":    start.Format("2006-01-02"),
+			"EndDate":      end.Format("2006-01-02"),
+			"Total":        formatMoney(total, currency),
+		})
+}
+
+// queueRequestMessage texts the sender of a booking request (request received, or confirmed)
+func queueRequestMessage(db dbQuerier, tenant *models.Tenant, requestID, template string) error {
+	var name, phone, car string
+	var start, end time.Time
+	err := db.QueryRow(context.Background(), `
+		SELECT r.customer_name, r.customer_phone, COALESCE(c.brand || ' ' || c.model, ''), r.pickup_date, r.return_date
+		FROM booking_requests r
+		LEFT JOIN cars c ON r.car_id = c.id
+		WHERE r.id = $1`, requestID).Scan(&name, &phone, &car, &start, &end)
+	if err != nil {
+		return err
+	}
+	return queueMessage(db, tenant, template, phone, messaging.OutboxMessage{BookingRequestID: requestID},
+		map[string]interface{}{
+			"CustomerName": name,
+			"Car":          car,
+			"StartDate":    start.Format("2006-01-02"),
+			"EndDate":      end.Format("2006-01-02"),
+			"Total":        "",
+		})
+}
+
+// QueueBookingReminders queues the pickup reminders of the confirmed bookings starting tomorrow and the return
+// reminders of the bookings ending tomorrow. Each booking is reminded once; it returns how many were queued.
+func QueueBookingReminders(db dbQuerier, tenant *models.Tenant, now time.Time) (int, error) {
+	settings, err := LoadMessagingSettings(db, tenant.ID)
+	if err != nil || !settings.Enabled {
+		return 0, err
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT b.id, 'pickup_reminder' FROM bookings b
+		WHERE b.status = 'confirmed' AND b.start_date = $1::date + 1
+		UNION ALL
+		SELECT b.id, 'return_reminder' FROM bookings b
+		WHERE b.status IN ('confirmed', 'active') AND b.end_date = $1::date + 1 AND b.end_date > b.start_date`,
+		now.Format("2006-01-02"))
+	if err != nil {
+		return 0, err
+	}
+	type reminder struct{ bookingID, template string }
+	var reminders []reminder
+	for rows.Next() {
+		var r reminder
+		if err := rows.Scan(&r.bookingID, &r.template); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		reminders = append(reminders, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	for _, r := range reminders {
+		if !settings.messageEnabled(r.template) {
+			continue
+		}
+		var exists bool
+		db.QueryRow(context.Background(),
+			"SELECT EXISTS (SELECT 1 FROM message_outbox WHERE booking_id = $1 AND template = $2)",
+			r.bookingID, r.template).Scan(&exists)
+		if exists {
+			continue
+		}
+		if err := queueBookingMessage(db, tenant, r.bookingID, r.template); err != nil {
+			logMessageError(r.template, r.bookingID, err)
+			continue
+		}
+		queued++
+	}
+	return queued, nil
+}
+
+// GetMessageOutbox lists the tenant's text messages, newest first. Filter with ?status= and ?channel=.
+func GetMessageOutbox(c *gin.Context) {
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT id, channel, COALESCE(template, ''), customer_id, booking_id, booking_request_id, recipient, body, status,
+		       COALESCE(provider_message_id, ''), attempts, COALESCE(last_error, ''), sent_at, delivered_at, created_at
+		FROM message_outbox
+		WHERE tenant_id = $1 AND ($2 = '' OR status = $2) AND ($3 = '' OR channel = $3)
+		ORDER BY created_at DESC
+		LIMIT 200`, tenant.ID, c.Query("status"), c.Query("channel"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	messages := []OutboxMessage{}
+	for rows.Next() {
+		var m OutboxMessage
+		if err := rows.Scan(&m.ID, &m.Channel, &m.Template, &m.CustomerID, &m.BookingID, &m.BookingRequestID, &m.Recipient,
+			&m.Body, &m.Status, &m.ProviderMessageID, &m.Attempts, &m.LastError, &m.SentAt, &m.DeliveredAt, &m.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan message: " + err.Error()})
+			return
+		}
+		messages = append(messages, m)
+	}
+
+	c.JSON(http.StatusOK, messages)
+}
+
+// RetryMessage queues a failed text message again
+func RetryMessage(c *gin.Context) {
+	db, _, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	ok, err := messaging.Retry(db, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry message: " + err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed message not found"})
+		return
+	}
+
+	audit.LogAudit(c, "RETRY_MESSAGE", gin.H{"message_id": c.Param("id")})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Message queued again"})
+}
+
+// webhookTokenValid checks the MESSAGING_WEBHOOK_TOKEN shared with the providers. Without it, delivery reports
+// are refused.
+func webhookTokenValid(token string) bool {
+	expected := os.Getenv("MESSAGING_WEBHOOK_TOKEN")
+	return expected != "" && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// VerifyMessagingWebhook answers the subscription check WhatsApp makes when the webhook is registered
+func VerifyMessagingWebhook(c *gin.Context) {
+	if c.Query("hub.mode") != "subscribe" || !webhookTokenValid(c.Query("hub.verify_token")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid verify token"})
+		return
+	}
+	c.String(http.StatusOK, c.Query("hub.challenge"))
+}
+
+// MessagingStatusWebhook receives delivery reports from a provider (/messaging/:channel/status?token=...).
+// Each report is applied to the tenant that sent the message, found from the route saved when it was sent.
+func MessagingStatusWebhook(c *gin.Context) {
+	if !webhookTokenValid(c.Query("token")) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return
+	}
+	parser, ok := messenger.Provider(c.Param("channel")).(messaging.StatusParser)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown channel"})
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read body"})
+		return
+	}
+	updates, err := parser.ParseStatus(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery report: " + err.Error()})
+		return
+	}
+
+	pending := []messaging.StatusUpdate{}
+	for _, u := range updates {
+		if u.Status == "delivered" || u.Status == "failed" {
+			pending = append(pending, u)
+		}
+	}
+	if len(pending) == 0 {
+		c.JSON(http.StatusOK, gin.H{"updated": 0})
+		return
+	}
+
+	updated := 0
+	for _, u := range pending {
+		tenantID, err := messaging.LookupRoute(database.DB, u.ProviderMessageID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+		if tenantID == "" {
+			continue
+		}
+		var dbName string
+		err = database.DB.QueryRow(context.Background(), "SELECT db_name FROM tenants WHERE id = $1", tenantID).Scan(&dbName)
+		if err != nil {
+			continue
+		}
+		pool, err := database.GetTenantDB(dbName)
+		if err != nil {
+			continue
+		}
+		found, err := messaging.UpdateStatus(pool, u)
+		if err != nil {
+			log.Printf("[MESSAGING] Failed to apply delivery report for %s: %v", u.ProviderMessageID, err)
+		}
+		if found {
+			updated++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"updated": updated})
+}
+
+// logMessageError reports a message that could not be queued; the action that triggered it still succeeds
+func logMessageError(template, id string, err error) {
+	if err != nil {
+		log.Printf("[MESSAGING] Failed to queue %s message for %s: %v", template, id, err)
+	}
+}
@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"car-rental-backend/internal/events"
+	"car-rental-backend/internal/models"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// notificationRule turns an event into a notification for the users of some roles
+type notificationRule struct {
+	EventType   string
+	Label       string   // Shown in the notification preferences
+	Roles       []string // Role names notified; empty for every staff member
+	NotifyActor bool     // Whether the user who caused the event is notified too
+	Severity    string   // info, warning, success or error
+	Title       string
+	Message     func(e events.Event) string
+}
+
+var notificationRules = []notificationRule{
+	{
+		EventType: events.BookingRequestCreated,
+		Label:     "New booking request from the website",
+		Severity:  "info",
+		Title:     "New booking request",
+		Message: func(e events.Event) string {
+			return fmt.Sprintf("%s asked for a car from %s to %s", eventString(e, "customer_name"),
+				eventString(e, "pickup_date"), eventString(e, "return_date"))
+		},
+	},
+	{
+		EventType: events.BookingCreated,
+		Label:     "Booking created by a colleague",
+		Severity:  "success",
+		Title:     "New booking",
+		Message: func(e events.Event) string {
+			return fmt.Sprintf("Booking for %s from %s to %s", eventString(e, "customer_name"),
+				eventString(e, "start_date"), eventString(e, "end_date"))
+		},
+	},
+	{
+		EventType: events.InvoiceOverdue,
+		Label:     "Invoice overdue",
+		Roles:     []string{"admin", "manager", "accountant"},
+		Severity:  "warning",
+		Title:     "Invoice overdue",
+		Message: func(e events.Event) string {
+			return fmt.Sprintf("Invoice for %s is %s days overdue (%s outstanding)", eventString(e, "customer_name"),
+				eventString(e, "days_overdue"), eventString(e, "outstanding"))
+		},
+	},
+	{
+		EventType: events.CarServiceDue,
+		Label:     "Car service due",
+		Roles:     []string{"admin", "manager", "mechanic"},
+		Severity:  "warning",
+		Title:     "Service due",
+		Message: func(e events.Event) string {
+			return fmt.Sprintf("%s (%s) is due for service on %s", eventString(e, "car"),
+				eventString(e, "license_plate"), eventString(e, "next_service_date"))
+		},
+	},
+	{
+		EventType: events.StaffAdded,
+		Label:     "Staff member added",
+		Roles:     []string{"admin"},
+		Severity:  "info",
+		Title:     "New staff member",
+		Message: func(e events.Event) string {
+			return fmt.Sprintf("%s (%s) joined the team", eventString(e, "name"), eventString(e, "email"))
+		},
+	},
+	{
+		EventType: events.RecurringExpenseMoved,
+		Label:     "Recurring expense moved out of a closed period",
+		Roles:     []string{"admin", "accountant"},
+		Severity:  "warning",
+		Title:     "Recurring expense moved",
+		Message: func(e events.Event) string {
+			return fmt.Sprintf("%s occurrence(s) of %s fell in the closed period and were booked on %s",
+				eventString(e, "occurrences"), eventString(e, "description"), eventString(e, "booked_on"))
+		},
+	},
+}
+
+// NotificationPreference tells whether a user is notified of an event type
+type NotificationPreference struct {
+	EventType string `json:"event_type"`
+	Label     string `json:"label"`
+	Enabled   bool   `json:"enabled"`
+}
+
+type UpdateNotificationPreferencesRequest struct {
+	Preferences []struct {
+		EventType string `json:"event_type" binding:"required"`
+		Enabled   bool   `json:"enabled"`
+	} `json:"preferences" binding:"required"`
+}
+
+// SubscribeNotifications creates notifications from the domain events; called once from main
+func SubscribeNotifications(bus *events.Bus) {
+	for _, rule := range notificationRules {
+		rule := rule
+		bus.Subscribe(rule.EventType, func(e events.Event) {
+			if err := notifyEvent(rule, e); err != nil {
+				log.Printf("[EVENTS] Failed to create notifications for %s: %v", e.Type, err)
+			}
+		})
+	}
+}
+
+// notifyEvent notifies the staff of the rule's roles who haven't turned the event off
+func notifyEvent(rule notificationRule, e events.Event) error {
+	roles := []string{}
+	for _, r := range rule.Roles {
+		roles = append(roles, strings.ToLower(r))
+	}
+	actor := ""
+	if !rule.NotifyActor {
+		actor = e.ActorID
+	}
+
+	rows, err := e.DB.Query(context.Background(), `
+		SELECT u.id FROM users u
+		LEFT JOIN roles r ON u.role_id = r.id
+		WHERE u.tenant_id = $1
+		  AND (cardinality($2::text[]) = 0 OR LOWER(r.name) = ANY($2))
+		  AND u.id::text != $3
+		  AND NOT EXISTS (
+			SELECT 1 FROM notification_preferences p
+			WHERE p.user_id = u.id AND p.event_type = $4 AND NOT p.enabled
+		  )`, e.Tenant.ID, roles, actor, rule.EventType)
+	if err != nil {
+		return err
+	}
+	var userIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		userIDs = append(userIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	eventType, entityID := rule.EventType, e.EntityID
+	n := Notification{Title: rule.Title, Message: rule.Message(e), Type: rule.Severity, EventType: &eventType}
+	if entityID != "" {
+		n.EntityID = &entityID
+	}
+	for _, userID := range userIDs {
+		if err := insertNotification(e.DB, e.Tenant.ID, userID, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eventString formats a field of the event data, or "?" when it is missing
+func eventString(e events.Event, key string) string {
+	v, ok := e.Data[key]
+	if !ok || v == nil {
+		return "?"
+	}
+	return fmt.Sprint(v)
+}
+
+// GetNotificationPreferences lists the event notifications and whether the current user receives them
+func GetNotificationPreferences(c *gin.Context) {
+	db, _, userID, err := getTenantDBForNotifications(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	disabled := map[string]bool{}
+	rows, err := db.Query(context.Background(),
+		"SELECT event_type FROM notification_preferences WHERE user_id = $1 AND NOT enabled", userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch preferences: " + err.Error()})
+		return
+	}
+	for rows.Next() {
+		var eventType string
+		if err := rows.Scan(&eventType); err == nil {
+			disabled[eventType] = true
+		}
+	}
+	rows.Close()
+
+	preferences := []NotificationPreference{}
+	for _, rule := range notificationRules {
+		preferences = append(preferences, NotificationPreference{
+			EventType: rule.EventType,
+			Label:     rule.Label,
+			Enabled:   !disabled[rule.EventType],
+		})
+	}
+
+	c.JSON(http.StatusOK, preferences)
+}
+
+// UpdateNotificationPreferences turns event notifications on or off for the current user
+func UpdateNotificationPreferences(c *gin.Context) {
+	var req UpdateNotificationPreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	known := map[string]bool{}
+	for _, rule := range notificationRules {
+		known[rule.EventType] = true
+	}
+	for _, p := range req.Preferences {
+		if !known[p.EventType] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown event type: " + p.EventType})
+			return
+		}
+	}
+
+	db, tenant, userID, err := getTenantDBForNotifications(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	for _, p := range req.Preferences {
+		_, err := tx.Exec(ctx,
+			`INSERT INTO notification_preferences (tenant_id, user_id, event_type, enabled, updated_at)
+			 VALUES ($1, $2, $3, $4, NOW())
+			 ON CONFLICT (user_id, event_type) DO UPDATE SET enabled = $4, updated_at = NOW()`,
+			tenant.ID, userID, p.EventType, p.Enabled)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update preferences: " + err.Error()})
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update preferences: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notification preferences updated successfully"})
+}
+
+// PublishServiceDueEvents publishes car.service_due for the cars whose next service is within the dashboard's
+// window. Each service date is published once; it returns how many cars were due.
+func PublishServiceDueEvents(db *pgxpool.Pool, tenant *models.Tenant, now time.Time) (int, error) {
+	rows, err := db.Query(context.Background(), `
+		UPDATE cars SET service_due_notified_for = next_service_date
+		WHERE next_service_date <= $1::date + $2::int
+		  AND service_due_notified_for IS DISTINCT FROM next_service_date
+		RETURNING id, brand || ' ' || model, license_plate, next_service_date`,
+		now.Format("2006-01-02"), serviceDueWithinDays)
+	if err != nil {
+		return 0, err
+	}
+	var due []events.Event
+	for rows.Next() {
+		var id, car, plate string
+		var serviceDate time.Time
+		if err := rows.Scan(&id, &car, &plate, &serviceDate); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, events.Event{
+			Type:     events.CarServiceDue,
+			Tenant:   tenant,
+			DB:       db,
+			EntityID: id,
+			Data: map[string]interface{}{
+				"id":                id,
+				"car":               car,
+				"license_plate":     plate,
+				"next_service_date": serviceDate.Format("2006-01-02"),
+			},
+		})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, e := range due {
+		events.Publish(e)
+	}
+	return len(due), nil
+}
@@ -4,7 +4,9 @@ import (
 	"car-rental-backend/internal/database"
 	"car-rental-backend/internal/models"
 	"context"
+	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -12,12 +14,15 @@ import (
 )
 
 type Notification struct {
-	ID        string    `json:"id"`
-	Title     string    `json:"title"`
-	Message   string    `json:"message"`
-	Type      string    `json:"type"`
-	IsRead    bool      `json:"is_read"`
-	CreatedAt time.Time `json:"created_at"`
+	ID         string    `json:"id"`
+	Title      string    `json:"title"`
+	Message    string    `json:"message"`
+	Type       string    `json:"type"`
+	EventType  *string   `json:"event_type"` // Set for notifications created from a domain event
+	EntityID   *string   `json:"entity_id"`
+	IsRead     bool      `json:"is_read"`
+	IsArchived bool      `json:"is_archived"`
+	CreatedAt  time.Time `json:"created_at"`
 }
 
 // Helper to get tenant DB connection (reused logic)
@@ -38,79 +43,266 @@ func getTenantDBForNotifications(c *gin.Context) (*pgxpool.Pool, *models.Tenant,
 	return db, tenant, userID, err
 }
 
+// notificationInbox joins the notifications the user can see ($1): their own and the tenant-wide ones, with the
+// user's receipt for the latter. Tenant-wide notifications the user deleted are left out.
+const notificationInbox = `
+	FROM notifications n
+	LEFT JOIN notification_receipts r ON r.notification_id = n.id AND r.user_id = $1::uuid
+	WHERE (n.user_id = $1::uuid OR n.user_id IS NULL) AND r.deleted_at IS NULL`
+
+const (
+	notificationIsRead     = `(n.is_read OR r.read_at IS NOT NULL)`
+	notificationIsArchived = `(n.archived_at IS NOT NULL OR r.archived_at IS NOT NULL)`
+)
+
+const (
+	defaultNotificationPageSize = 50
+	maxNotificationPageSize     = 200
+)
+
+// GetNotifications lists the user's notifications and the tenant-wide ones, newest first. Filter with ?type=
+// (info, warning, ...), ?event_type=, ?unread=true and ?archived=true (archived ones are hidden by default);
+// paginate with ?limit= and ?cursor=.
 func GetNotifications(c *gin.Context) {
+	limit := defaultNotificationPageSize
+	if v := c.Query("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > maxNotificationPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxNotificationPageSize)})
+			return
+		}
+		limit = l
+	}
+
 	db, _, userID, err := getTenantDBForNotifications(c)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
 		return
 	}
 
-	// Fetch notifications for the specific user OR system-wide (user_id IS NULL)
-	// In a real multi-tenant app, we might filter by tenant_id in the query if the DB is shared,
-	// but here we are connected to the tenant's specific DB, so we just filter by user.
-	// However, our schema has tenant_id, so let's respect it if we were on a shared DB.
-	// Since we are on a dedicated DB, we just check user_id.
-
 	query := `
-		SELECT id, title, message, type, is_read, created_at 
-		FROM notifications 
-		WHERE user_id = $1 OR user_id IS NULL 
-		ORDER BY created_at DESC 
-		LIMIT 50
-	`
-
-	rows, err := db.Query(context.Background(), query, userID)
+		SELECT n.id, n.title, n.message, n.type, n.event_type, n.entity_id, ` + notificationIsRead + `,
+		       ` + notificationIsArchived + `, n.created_at` + notificationInbox
+	args := []interface{}{userID}
+
+	if v := c.Query("type"); v != "" {
+		args = append(args, v)
+		query += fmt.Sprintf(" AND n.type = $%d", len(args))
+	}
+	if v := c.Query("event_type"); v != "" {
+		args = append(args, v)
+		query += fmt.Sprintf(" AND n.event_type = $%d", len(args))
+	}
+	if c.Query("unread") == "true" {
+		query += " AND NOT " + notificationIsRead
+	}
+	if c.Query("archived") == "true" {
+		query += " AND " + notificationIsArchived
+	} else {
+		query += " AND NOT " + notificationIsArchived
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		createdAt, id, ok := decodeCursor(cursor)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		args = append(args, createdAt, id)
+		query += fmt.Sprintf(" AND (n.created_at, n.id) < ($%d, $%d::uuid)", len(args)-1, len(args))
+	}
+	args = append(args, limit+1)
+	query += fmt.Sprintf(" ORDER BY n.created_at DESC, n.id DESC LIMIT $%d", len(args))
+
+	rows, err := db.Query(context.Background(), query, args...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notifications: " + err.Error()})
 		return
 	}
 	defer rows.Close()
 
-	var notifications []Notification
+	notifications := []Notification{}
 	for rows.Next() {
 		var n Notification
-		if err := rows.Scan(&n.ID, &n.Title, &n.Message, &n.Type, &n.IsRead, &n.CreatedAt); err != nil {
+		if err := rows.Scan(&n.ID, &n.Title, &n.Message, &n.Type, &n.EventType, &n.EntityID, &n.IsRead, &n.IsArchived, &n.CreatedAt); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan notification: " + err.Error()})
 			return
 		}
 		notifications = append(notifications, n)
 	}
 
-	if notifications == nil {
-		notifications = []Notification{}
+	var nextCursor *string
+	if len(notifications) > limit {
+		notifications = notifications[:limit]
+		last := notifications[limit-1]
+		cursor := encodeCursor(last.CreatedAt, last.ID)
+		nextCursor = &cursor
 	}
 
-	c.JSON(http.StatusOK, notifications)
+	c.JSON(http.StatusOK, gin.H{"data": notifications, "next_cursor": nextCursor})
 }
 
-func MarkNotificationRead(c *gin.Context) {
-	id := c.Param("id")
-	db, _, _, err := getTenantDBForNotifications(c)
+// GetUnreadNotificationCount returns how many of the user's notifications are unread (archived ones excluded)
+func GetUnreadNotificationCount(c *gin.Context) {
+	db, _, userID, err := getTenantDBForNotifications(c)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
 		return
 	}
 
-	_, err = db.Exec(context.Background(), "UPDATE notifications SET is_read = TRUE WHERE id = $1", id)
+	var count int
+	err = db.QueryRow(context.Background(),
+		"SELECT COUNT(*)"+notificationInbox+" AND NOT "+notificationIsRead+" AND NOT "+notificationIsArchived,
+		userID).Scan(&count)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count notifications: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"count": count})
+}
+
+// updateNotification applies an action to a notification the user can see: ownSQL to their own notification,
+// receiptSQL to a tenant-wide one. Both get $1 the user ID, $2 the notification ID and then args. It responds 404 when the
+// notification belongs to someone else or doesn't exist, and returns whether it succeeded.
+func updateNotification(c *gin.Context, ownSQL, receiptSQL string, args ...interface{}) bool {
+	if !isUUID(c.Param("id")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		return false
+	}
+	db, _, userID, err := getTenantDBForNotifications(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return false
+	}
+	args = append([]interface{}{userID, c.Param("id")}, args...)
+
+	result, err := db.Exec(context.Background(), ownSQL, args...)
+	if err == nil && result.RowsAffected() == 0 {
+		result, err = db.Exec(context.Background(), receiptSQL, args...)
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark notification as read: " + err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification: " + err.Error()})
+		return false
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		return false
+	}
+	return true
+}
+
+// upsertReceipt sets a column of the user's receipt of a tenant-wide notification to a SQL value
+func upsertReceipt(column, value string) string {
+	return `INSERT INTO notification_receipts (notification_id, user_id, ` + column + `)
+		SELECT n.id, $1::uuid, ` + value + ` FROM notifications n WHERE n.id = $2::uuid AND n.user_id IS NULL
+		ON CONFLICT (notification_id, user_id) DO UPDATE SET ` + column + ` = EXCLUDED.` + column
+}
+
+func MarkNotificationRead(c *gin.Context) {
+	if !updateNotification(c,
+		"UPDATE notifications SET is_read = TRUE WHERE id = $2::uuid AND user_id = $1::uuid",
+		upsertReceipt("read_at", "NOW()")) {
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Notification marked as read"})
 }
 
+// MarkAllNotificationsRead marks every unread notification of the user as read, optionally only those of a ?type=
+func MarkAllNotificationsRead(c *gin.Context) {
+	db, _, userID, err := getTenantDBForNotifications(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+	notifType := c.Query("type")
+
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	own, err := tx.Exec(ctx,
+		"UPDATE notifications SET is_read = TRUE WHERE user_id = $1::uuid AND NOT is_read AND ($2 = '' OR type = $2)",
+		userID, notifType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark notifications as read: " + err.Error()})
+		return
+	}
+	shared, err := tx.Exec(ctx, `
+		INSERT INTO notification_receipts (notification_id, user_id, read_at)
+		SELECT n.id, $1::uuid, NOW()`+notificationInbox+` AND n.user_id IS NULL AND NOT `+notificationIsRead+`
+		  AND ($2 = '' OR n.type = $2)
+		ON CONFLICT (notification_id, user_id) DO UPDATE SET read_at = EXCLUDED.read_at`,
+		userID, notifType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark notifications as read: " + err.Error()})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark notifications as read: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notifications marked as read", "updated": own.RowsAffected() + shared.RowsAffected()})
+}
+
+// ArchiveNotification moves a notification out of the inbox; archived notifications are listed with ?archived=true
+func ArchiveNotification(c *gin.Context) {
+	setNotificationArchived(c, true)
+}
+
+// UnarchiveNotification moves an archived notification back to the inbox
+func UnarchiveNotification(c *gin.Context) {
+	setNotificationArchived(c, false)
+}
+
+func setNotificationArchived(c *gin.Context, archived bool) {
+	if !updateNotification(c,
+		"UPDATE notifications SET archived_at = CASE WHEN $3::bool THEN NOW() END WHERE id = $2::uuid AND user_id = $1::uuid",
+		upsertReceipt("archived_at", "CASE WHEN $3::bool THEN NOW() END"), archived) {
+		return
+	}
+
+	if archived {
+		c.JSON(http.StatusOK, gin.H{"message": "Notification archived"})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "Notification moved back to the inbox"})
+	}
+}
+
+// DeleteNotification deletes one of the user's notifications. Tenant-wide notifications are only removed from
+// the user's inbox.
+func DeleteNotification(c *gin.Context) {
+	if !updateNotification(c,
+		"DELETE FROM notifications WHERE id = $2::uuid AND user_id = $1::uuid",
+		upsertReceipt("deleted_at", "NOW()")) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notification deleted"})
+}
+
 // Internal helper to create a notification
 // This isn't an API endpoint but a function other handlers can call
 func CreateNotificationInternal(db *pgxpool.Pool, tenantID, userID, title, message, notifType string) error {
-	// If userID is empty, pass nil to SQL
-	var userIDArg interface{} = userID
-	if userID == "" {
-		userIDArg = nil
+	return insertNotification(db, tenantID, userID, Notification{Title: title, Message: message, Type: notifType})
+}
+
+// insertNotification saves a notification for a user (system-wide when userID is empty) and pushes it to them
+func insertNotification(db *pgxpool.Pool, tenantID, userID string, n Notification) error {
+	err := db.QueryRow(context.Background(),
+		`INSERT INTO notifications (tenant_id, user_id, title, message, type, event_type, entity_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at`,
+		tenantID, nullIfEmpty(userID), n.Title, n.Message, n.Type, n.EventType, n.EntityID).Scan(&n.ID, &n.CreatedAt)
+	if err != nil {
+		return err
 	}
 
-	_, err := db.Exec(context.Background(),
-		"INSERT INTO notifications (tenant_id, user_id, title, message, type) VALUES ($1, $2, $3, $4, $5)",
-		tenantID, userIDArg, title, message, notifType)
-	return err
+	// Pushed to the user, or to everyone in the tenant for system-wide notifications
+	publishEvent(tenantID, userID, EventNotification, n)
+	return nil
 }
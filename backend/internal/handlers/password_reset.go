@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"car-rental-backend/internal/audit"
+	"car-rental-backend/internal/database"
+	"car-rental-backend/internal/mail"
+	"car-rental-backend/internal/models"
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=6"`
+}
+
+// passwordResetTTL is how long a reset link stays valid
+const passwordResetTTL = time.Hour
+
+// ForgotPassword emails a password reset link. Like login, the user is looked up in every tenant; the response
+// doesn't say whether the email is known.
+func ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	response := gin.H{"message": "If this email has an account, a reset link has been sent"}
+
+	rows, err := database.DB.Query(context.Background(), "SELECT id, name, subdomain, db_name, subscription_tier FROM tenants")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	var tenants []models.Tenant
+	for rows.Next() {
+		var t models.Tenant
+		if err := rows.Scan(&t.ID, &t.Name, &t.Subdomain, &t.DBName, &t.SubscriptionTier); err == nil {
+			tenants = append(tenants, t)
+		}
+	}
+	rows.Close()
+
+	for i := range tenants {
+		tenant := &tenants[i]
+		pool, err := database.GetTenantDB(tenant.DBName)
+		if err != nil {
+			continue
+		}
+
+		var userID, name string
+		err = pool.QueryRow(context.Background(),
+			"SELECT id, COALESCE(first_name, '') FROM users WHERE email = $1 AND tenant_id = $2",
+			req.Email, tenant.ID).Scan(&userID, &name)
+		if err != nil {
+			continue
+		}
+
+		token, err := randomToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+		_, err = pool.Exec(context.Background(),
+			"INSERT INTO password_resets (tenant_id, user_id, token_hash, expires_at) VALUES ($1, $2, $3, $4)",
+			tenant.ID, userID, hashSecret(token), time.Now().Add(passwordResetTTL))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save reset token: " + err.Error()})
+			return
+		}
+
+		// The tenant is part of the token, since users live in the tenant databases
+		err = queueEmail(pool, tenant, mail.TemplatePasswordReset, req.Email, "", map[string]interface{}{
+			"Name":     name,
+			"Link":     passwordResetLink(tenant.ID + "." + token),
+			"ValidFor": "1 hour",
+		})
+		logEmailError(mail.TemplatePasswordReset, userID, err)
+		break
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ResetPassword sets a new password with a token from a reset link
+func ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	invalid := gin.H{"error": "Invalid or expired reset link"}
+
+	tenantID, token, ok := strings.Cut(req.Token, ".")
+	if !ok {
+		c.JSON(http.StatusBadRequest, invalid)
+		return
+	}
+	var tenant models.Tenant
+	err := database.DB.QueryRow(context.Background(),
+		"SELECT id, name, subdomain, db_name, subscription_tier FROM tenants WHERE id::text = $1",
+		tenantID).Scan(&tenant.ID, &tenant.Name, &tenant.Subdomain, &tenant.DBName, &tenant.SubscriptionTier)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, invalid)
+		return
+	}
+	pool, err := database.GetTenantDB(tenant.DBName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection failed"})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var userID string
+	err = tx.QueryRow(ctx, `
+		UPDATE password_resets SET used_at = NOW()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id`, hashSecret(token)).Scan(&userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, invalid)
+		return
+	}
+	if _, err := tx.Exec(ctx, "UPDATE users SET password_hash = $1, updated_at = NOW() WHERE id = $2", string(hashedPassword), userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password: " + err.Error()})
+		return
+	}
+	// Other links sent to the user stop working too
+	if _, err := tx.Exec(ctx, "UPDATE password_resets SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL", userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password: " + err.Error()})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password: " + err.Error()})
+		return
+	}
+
+	c.Set("tenant", &tenant)
+	c.Set("user_id", userID)
+	audit.LogAudit(c, "RESET_PASSWORD", gin.H{})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password updated, you can now log in"})
+}
+
+// passwordResetLink builds the link of a reset email (APP_URL, default http://localhost:5173)
+func passwordResetLink(token string) string {
+	base := os.Getenv("APP_URL")
+	if base == "" {
+		base = "http://localhost:5173"
+	}
+	return strings.TrimRight(base, "/") + "/reset-password?token=" + url.QueryEscape(token)
+}
@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"car-rental-backend/internal/database"
+	"car-rental-backend/internal/models"
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TenantStats is the snapshot of one tenant's activity, computed from its own database.
+// GMV is in the tenant's base currency.
+type TenantStats struct {
+	TenantID           string     `json:"tenant_id"`
+	Name               string     `json:"name"`
+	Subdomain          string     `json:"subdomain"`
+	SubscriptionTier   string     `json:"subscription_tier"`
+	Cars               int        `json:"cars"`
+	Bookings           int        `json:"bookings"`
+	Bookings30d        int        `json:"bookings_30d"`
+	BookingRequests    int        `json:"booking_requests"`
+	BookingRequests30d int        `json:"booking_requests_30d"`
+	GMV                float64    `json:"gmv"`
+	GMV30d             float64    `json:"gmv_30d"`
+	Currency           string     `json:"currency"`
+	ActiveUsers30d     int        `json:"active_users_30d"`
+	LastActivityAt     *time.Time `json:"last_activity_at"`
+	Active             bool       `json:"active"`
+	Error              string     `json:"error,omitempty"` // Set when the tenant database could not be read
+	RefreshedAt        time.Time  `json:"refreshed_at"`
+}
+
+// activeTenantDays is how recent a booking, booking request or user action must be for a tenant to count as active
+const activeTenantDays = 30
+
+// GetAdminStats returns platform-wide aggregates from the latest tenant snapshots
+func GetAdminStats(c *gin.Context) {
+	var totalTenants int
+	// Exclude the admin tenant from counts
+	err := database.DB.QueryRow(context.Background(), "SELECT COUNT(*) FROM tenants WHERE subdomain != 'admin'").Scan(&totalTenants)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stats"})
+		return
+	}
+
+	// Count tenants created this month (excluding admin)
+	var newThisMonth int
+	err = database.DB.QueryRow(context.Background(),
+		"SELECT COUNT(*) FROM tenants WHERE created_at >= date_trunc('month', CURRENT_DATE) AND subdomain != 'admin'").Scan(&newThisMonth)
+	if err != nil {
+		newThisMonth = 0
+	}
+
+	stats, err := loadTenantStats()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stats: " + err.Error()})
+		return
+	}
+
+	var activeTenants, cars, bookings, bookings30d, requests, requests30d, activeUsers int
+	gmv := map[string]float64{}
+	gmv30d := map[string]float64{}
+	var refreshedAt *time.Time
+	for i, s := range stats {
+		if s.Active {
+			activeTenants++
+		}
+		cars += s.Cars
+		bookings += s.Bookings
+		bookings30d += s.Bookings30d
+		requests += s.BookingRequests
+		requests30d += s.BookingRequests30d
+		activeUsers += s.ActiveUsers30d
+		gmv[s.Currency] += s.GMV
+		gmv30d[s.Currency] += s.GMV30d
+		if refreshedAt == nil || s.RefreshedAt.Before(*refreshedAt) {
+			refreshedAt = &stats[i].RefreshedAt
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"total_tenants":        totalTenants,
+		"active_tenants":       activeTenants,
+		"new_this_month":       newThisMonth,
+		"cars":                 cars,
+		"bookings":             bookings,
+		"bookings_30d":         bookings30d,
+		"booking_requests":     requests,
+		"booking_requests_30d": requests30d,
+		"active_users_30d":     activeUsers,
+		"gmv":                  gmv, // Per currency, tenants keep their own base currency
+		"gmv_30d":              gmv30d,
+		"refreshed_at":         refreshedAt, // Oldest snapshot included
+	})
+}
+
+// GetTenantStats returns the latest snapshot of every tenant
+func GetTenantStats(c *gin.Context) {
+	stats, err := loadTenantStats()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tenant stats: " + err.Error()})
+		return
+	}
+	if stats == nil {
+		stats = []TenantStats{}
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// RefreshPlatformStatsHandler recomputes the tenant snapshots right away
+func RefreshPlatformStatsHandler(c *gin.Context) {
+	if err := RefreshPlatformStats(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh stats: " + err.Error()})
+		return
+	}
+	GetTenantStats(c)
+}
+
+// RefreshPlatformStats computes the stats of every shop tenant and stores them in the master database.
+// A tenant whose database cannot be read keeps its counters from the last successful refresh.
+func RefreshPlatformStats() error {
+	rows, err := database.DB.Query(context.Background(),
+		"SELECT id, name, subdomain, db_name, subscription_tier FROM tenants WHERE subdomain != 'admin'")
+	if err != nil {
+		return err
+	}
+	var tenants []models.Tenant
+	for rows.Next() {
+		var t models.Tenant
+		if err := rows.Scan(&t.ID, &t.Name, &t.Subdomain, &t.DBName, &t.SubscriptionTier); err != nil {
+			rows.Close()
+			return err
+		}
+		tenants = append(tenants, t)
+	}
+	rows.Close()
+
+	for i := range tenants {
+		tenant := &tenants[i]
+		db, err := database.GetTenantDB(tenant.DBName)
+		if err == nil {
+			var s TenantStats
+			if s, err = computeTenantStats(db, tenant); err == nil {
+				err = saveTenantStats(s)
+			}
+		}
+		if err != nil {
+			log.Printf("[STATS] Failed to refresh stats of tenant %s: %v", tenant.Subdomain, err)
+			database.DB.Exec(context.Background(),
+				`INSERT INTO tenant_stats_snapshots (tenant_id, error, refreshed_at) VALUES ($1, $2, NOW())
+				 ON CONFLICT (tenant_id) DO UPDATE SET error = $2, refreshed_at = NOW()`, tenant.ID, err.Error())
+		}
+	}
+	return nil
+}
+
+func computeTenantStats(db *pgxpool.Pool, tenant *models.Tenant) (TenantStats, error) {
+	ctx := context.Background()
+	s := TenantStats{TenantID: tenant.ID, Currency: GetBaseCurrency(db, tenant.ID)}
+
+	if err := db.QueryRow(ctx, "SELECT COUNT(*) FROM cars").Scan(&s.Cars); err != nil {
+		return s, err
+	}
+
+	var lastBooking, lastRequest, lastAction *time.Time
+	err := db.QueryRow(ctx, `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE created_at >= NOW() - make_interval(days => $1)), MAX(created_at)
+		FROM bookings`, activeTenantDays).Scan(&s.Bookings, &s.Bookings30d, &lastBooking)
+	if err != nil {
+		return s, err
+	}
+
+	err = db.QueryRow(ctx, `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE created_at >= NOW() - make_interval(days => $1)), MAX(created_at)
+		FROM booking_requests`, activeTenantDays).Scan(&s.BookingRequests, &s.BookingRequests30d, &lastRequest)
+	if err != nil {
+		return s, err
+	}
+
+	// Gross merchandise value: everything invoiced to customers, in the base currency
+	err = db.QueryRow(ctx, `
+		SELECT COALESCE(SUM(v.amount), 0), COALESCE(SUM(v.amount) FILTER (WHERE v.created_at >= NOW() - make_interval(days => $2)), 0)
+		FROM (
+			SELECT i.amount * COALESCE(stored_rate(i.exchange_rate, i.exchange_rate_base, $1), fx_rate(COALESCE(i.currency, 'MAD'), $1, i.created_at::date)) as amount, i.created_at
+			FROM invoices i
+			WHERE i.status != 'Cancelled'
+		) v`, s.Currency, activeTenantDays).Scan(&s.GMV, &s.GMV30d)
+	if err != nil {
+		return s, err
+	}
+
+	// Audit logs record every login and change, so they tell who actually used the app
+	err = db.QueryRow(ctx, `
+		SELECT COUNT(DISTINCT user_id) FILTER (WHERE created_at >= NOW() - make_interval(days => $1)), MAX(created_at)
+		FROM audit_logs
+		WHERE user_id IS NOT NULL`, activeTenantDays).Scan(&s.ActiveUsers30d, &lastAction)
+	if err != nil {
+		return s, err
+	}
+
+	for _, t := range []*time.Time{lastBooking, lastRequest, lastAction} {
+		if t != nil && (s.LastActivityAt == nil || t.After(*s.LastActivityAt)) {
+			s.LastActivityAt = t
+		}
+	}
+	s.Active = s.LastActivityAt != nil && time.Since(*s.LastActivityAt) < activeTenantDays*24*time.Hour
+
+	return s, nil
+}
+
+func saveTenantStats(s TenantStats) error {
+	_, err := database.DB.Exec(context.Background(),
+		`INSERT INTO tenant_stats_snapshots (tenant_id, cars, bookings, bookings_30d, booking_requests, booking_requests_30d,
+		     gmv, gmv_30d, currency, active_users_30d, last_activity_at, active, error, refreshed_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NULL, NOW())
+		 ON CONFLICT (tenant_id) DO UPDATE SET
+		 cars = $2, bookings = $3, bookings_30d = $4, booking_requests = $5, booking_requests_30d = $6,
+		 gmv = $7, gmv_30d = $8, currency = $9, active_users_30d = $10, last_activity_at = $11, active = $12,
+		 error = NULL, refreshed_at = NOW()`,
+		s.TenantID, s.Cars, s.Bookings, s.Bookings30d, s.BookingRequests, s.BookingRequests30d,
+		s.GMV, s.GMV30d, s.Currency, s.ActiveUsers30d, s.LastActivityAt, s.Active)
+	return err
+}
+
+func loadTenantStats() ([]TenantStats, error) {
+	rows, err := database.DB.Query(context.Background(), `
+		SELECT t.id, t.name, t.subdomain, COALESCE(t.subscription_tier, 'normal'),
+		       s.cars, s.bookings, s.bookings_30d, s.booking_requests, s.booking_requests_30d,
+		       s.gmv, s.gmv_30d, s.currency, s.active_users_30d, s.last_activity_at, s.active,
+		       COALESCE(s.error, ''), s.refreshed_at
+		FROM tenant_stats_snapshots s
+		JOIN tenants t ON s.tenant_id = t.id
+		WHERE t.subdomain != 'admin'
+		ORDER BY s.gmv DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []TenantStats
+	for rows.Next() {
+		var s TenantStats
+		if err := rows.Scan(&s.TenantID, &s.Name, &s.Subdomain, &s.SubscriptionTier,
+			&s.Cars, &s.Bookings, &s.Bookings30d, &s.BookingRequests, &s.BookingRequests30d,
+			&s.GMV, &s.GMV30d, &s.Currency, &s.ActiveUsers30d, &s.LastActivityAt, &s.Active,
+			&s.Error, &s.RefreshedAt); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
@@ -0,0 +1,375 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PnLLine is one row of the profit & loss report (a period, a car or a car category)
+type PnLLine struct {
+	Key           string             `json:"key"`
+	Label         string             `json:"label"`
+	Revenue       float64            `json:"revenue"`
+	Expenses      map[string]float64 `json:"expenses"` // by expense category
+	TotalExpenses float64            `json:"total_expenses"`
+	NetProfit     float64            `json:"net_profit"`
+}
+
+type PnLReport struct {
+	From          string    `json:"from"`
+	To            string    `json:"to"`
+	GroupBy       string    `json:"group_by"`
+	BaseCurrency  string    `json:"base_currency"` // All amounts are converted to this currency
+	MissingRates  []string  `json:"missing_rates"` // Currencies without an exchange rate, excluded from totals
+	Lines         []PnLLine `json:"lines"`
+	TotalRevenue  float64   `json:"total_revenue"`
+	TotalExpenses float64   `json:"total_expenses"`
+	NetProfit     float64   `json:"net_profit"`
+}
+
+// CashFlowLine shows money actually received (payments) and spent (expenses) in a period
+type CashFlowLine struct {
+	Period         string  `json:"period"`
+	Inflow         float64 `json:"inflow"`
+	Outflow        float64 `json:"outflow"`
+	Net            float64 `json:"net"`
+	RunningBalance float64 `json:"running_balance"`
+}
+
+type CashFlowReport struct {
+	From         string         `json:"from"`
+	To           string         `json:"to"`
+	GroupBy      string         `json:"group_by"`
+	BaseCurrency string         `json:"base_currency"`
+	MissingRates []string       `json:"missing_rates"` // Currencies without an exchange rate, excluded from totals
+	Lines        []CashFlowLine `json:"lines"`
+	TotalInflow  float64        `json:"total_inflow"`
+	TotalOutflow float64        `json:"total_outflow"`
+	Net          float64        `json:"net"`
+}
+
+// unallocatedKey groups expenses that are not attached to a car
+const unallocatedKey = "unallocated"
+
+// periodFormats maps a time grouping to its date_trunc unit and to_char label format
+var periodFormats = map[string][2]string{
+	"day":   {"day", "YYYY-MM-DD"},
+	"week":  {"week", `IYYY-"W"IW`},
+	"month": {"month", "YYYY-MM"},
+}
+
+// pnlMissingRatesQuery lists the currencies of invoices and expenses in $1..$2 that have no rate into $3
+const pnlMissingRatesQuery = `
+	SELECT COALESCE(currency, 'MAD') FROM invoices
+	WHERE created_at::date BETWEEN $1 AND $2 AND status != 'Cancelled'
+	  AND COALESCE(stored_rate(exchange_rate, exchange_rate_base, $3), fx_rate(COALESCE(currency, 'MAD'), $3, created_at::date)) IS NULL
+	UNION
+	SELECT COALESCE(currency, $3) FROM expenses
+	WHERE date BETWEEN $1 AND $2 AND fx_rate(COALESCE(currency, $3), $3, date) IS NULL
+	ORDER BY 1`
+
+// cashFlowMissingRatesQuery lists the currencies of payments and expenses in $1..$2 that have no rate into $3
+const cashFlowMissingRatesQuery = `
+	SELECT COALESCE(i.currency, 'MAD') FROM payments p
+	JOIN invoices i ON p.invoice_id = i.id
+	WHERE p.paid_at BETWEEN $1 AND $2 AND fx_rate(COALESCE(i.currency, 'MAD'), $3, p.paid_at) IS NULL
+	UNION
+	SELECT COALESCE(currency, $3) FROM expenses
+	WHERE date BETWEEN $1 AND $2 AND fx_rate(COALESCE(currency, $3), $3, date) IS NULL
+	ORDER BY 1`
+
+// findMissingRates runs one of the *MissingRatesQuery queries
+func findMissingRates(db *pgxpool.Pool, query string, from, to time.Time, baseCurrency string) ([]string, error) {
+	rows, err := db.Query(context.Background(), query, from, to, baseCurrency)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	missing := []string{}
+	for rows.Next() {
+		var currency string
+		if err := rows.Scan(&currency); err != nil {
+			return nil, err
+		}
+		missing = append(missing, currency)
+	}
+	return missing, rows.Err()
+}
+
+// parseDateRange reads ?from=&to= (YYYY-MM-DD). Defaults to the last 12 months, up to today.
+func parseDateRange(c *gin.Context) (time.Time, time.Time, error) {
+	now := time.Now()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	from := time.Date(now.Year(), now.Month()-11, 1, 0, 0, 0, 0, time.UTC)
+
+	var err error
+	if v := c.Query("from"); v != "" {
+		if from, err = time.Parse("2006-01-02", v); err != nil {
+			return from, to, fmt.Errorf("invalid from date, expected YYYY-MM-DD")
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if to, err = time.Parse("2006-01-02", v); err != nil {
+			return from, to, fmt.Errorf("invalid to date, expected YYYY-MM-DD")
+		}
+	}
+	if to.Before(from) {
+		return from, to, fmt.Errorf("to date must not be before from date")
+	}
+	return from, to, nil
+}
+
+// GetProfitAndLoss returns revenue (by invoice date), expenses by category and net profit,
+// grouped by month, week, car or car category
+func GetProfitAndLoss(c *gin.Context) {
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	from, to, err := parseDateRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	groupBy := c.DefaultQuery("group_by", "month")
+	baseCurrency := GetBaseCurrency(db, tenant.ID)
+
+	var revenueQuery, expenseQuery string
+	var args []interface{}
+	switch groupBy {
+	case "month", "week":
+		f := periodFormats[groupBy]
+		revenueQuery = `
+			SELECT to_char(date_trunc($3, i.created_at), $4) as key, to_char(date_trunc($3, i.created_at), $4) as label,
+			       COALESCE(SUM(i.amount * COALESCE(stored_rate(i.exchange_rate, i.exchange_rate_base, $5), fx_rate(COALESCE(i.currency, 'MAD'), $5, i.created_at::date))), 0)
+			FROM invoices i
+			WHERE i.created_at::date BETWEEN $1 AND $2 AND i.status != 'Cancelled'
+			GROUP BY 1, 2`
+		expenseQuery = `
+			SELECT to_char(date_trunc($3, e.date::timestamp), $4) as key, to_char(date_trunc($3, e.date::timestamp), $4) as label, e.category,
+			       COALESCE(SUM(e.amount * fx_rate(COALESCE(e.currency, $5), $5, e.date)), 0)
+			FROM expenses e
+			WHERE e.date BETWEEN $1 AND $2
+			GROUP BY 1, 2, 3`
+		args = []interface{}{from, to, f[0], f[1], baseCurrency}
+	case "car":
+		revenueQuery = `
+			SELECT c.id::text as key, c.brand || ' ' || c.model || ' (' || c.license_plate || ')' as label,
+			       COALESCE(SUM(i.amount * COALESCE(stored_rate(i.exchange_rate, i.exchange_rate_base, $3), fx_rate(COALESCE(i.currency, 'MAD'), $3, i.created_at::date))), 0)
+			FROM invoices i
+			JOIN bookings b ON i.booking_id = b.id
+			JOIN cars c ON b.car_id = c.id
+			WHERE i.created_at::date BETWEEN $1 AND $2 AND i.status != 'Cancelled'
+			GROUP BY 1, 2`
+		expenseQuery = `
+			SELECT COALESCE(c.id::text, '` + unallocatedKey + `') as key,
+			       COALESCE(c.brand || ' ' || c.model || ' (' || c.license_plate || ')', '') as label, e.category,
+			       COALESCE(SUM(e.amount * fx_rate(COALESCE(e.currency, $3), $3, e.date)), 0)
+			FROM expenses e
+			LEFT JOIN cars c ON e.car_id = c.id
+			WHERE e.date BETWEEN $1 AND $2
+			GROUP BY 1, 2, 3`
+		args = []interface{}{from, to, baseCurrency}
+	case "category":
+		revenueQuery = `
+			SELECT COALESCE(NULLIF(c.category, ''), 'Uncategorized') as key, COALESCE(NULLIF(c.category, ''), 'Uncategorized') as label,
+			       COALESCE(SUM(i.amount * COALESCE(stored_rate(i.exchange_rate, i.exchange_rate_base, $3), fx_rate(COALESCE(i.currency, 'MAD'), $3, i.created_at::date))), 0)
+			FROM invoices i
+			JOIN bookings b ON i.booking_id = b.id
+			JOIN cars c ON b.car_id = c.id
+			WHERE i.created_at::date BETWEEN $1 AND $2 AND i.status != 'Cancelled'
+			GROUP BY 1, 2`
+		expenseQuery = `
+			SELECT CASE WHEN c.id IS NULL THEN '` + unallocatedKey + `' ELSE COALESCE(NULLIF(c.category, ''), 'Uncategorized') END as key,
+			       COALESCE(NULLIF(c.category, ''), 'Uncategorized') as label, e.category,
+			       COALESCE(SUM(e.amount * fx_rate(COALESCE(e.currency, $3), $3, e.date)), 0)
+			FROM expenses e
+			LEFT JOIN cars c ON e.car_id = c.id
+			WHERE e.date BETWEEN $1 AND $2
+			GROUP BY 1, 2, 3`
+		args = []interface{}{from, to, baseCurrency}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group_by. Allowed: month, week, car, category"})
+		return
+	}
+
+	lines, err := buildPnLLines(db, revenueQuery, expenseQuery, args, groupBy == "month" || groupBy == "week")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build profit & loss: " + err.Error()})
+		return
+	}
+
+	missing, err := findMissingRates(db, pnlMissingRatesQuery, from, to, baseCurrency)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check exchange rates: " + err.Error()})
+		return
+	}
+
+	report := PnLReport{
+		From:         from.Format("2006-01-02"),
+		To:           to.Format("2006-01-02"),
+		GroupBy:      groupBy,
+		BaseCurrency: baseCurrency,
+		MissingRates: missing,
+		Lines:        lines,
+	}
+	for _, l := range lines {
+		report.TotalRevenue += l.Revenue
+		report.TotalExpenses += l.TotalExpenses
+	}
+	report.NetProfit = report.TotalRevenue - report.TotalExpenses
+
+	c.JSON(http.StatusOK, report)
+}
+
+// buildPnLLines merges revenue rows (key, label, amount) and expense rows (key, label, category, amount) into lines.
+// Time-based lines are sorted by period; the others by revenue, with unallocated expenses last.
+func buildPnLLines(db *pgxpool.Pool, revenueQuery, expenseQuery string, args []interface{}, byPeriod bool) ([]PnLLine, error) {
+	byKey := make(map[string]*PnLLine)
+	line := func(key, label string) *PnLLine {
+		l, ok := byKey[key]
+		if !ok {
+			l = &PnLLine{Key: key, Label: label, Expenses: map[string]float64{}}
+			byKey[key] = l
+		}
+		return l
+	}
+
+	rows, err := db.Query(context.Background(), revenueQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var key, label string
+		var amount float64
+		if err := rows.Scan(&key, &label, &amount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		line(key, label).Revenue += amount
+	}
+	rows.Close()
+
+	rows, err = db.Query(context.Background(), expenseQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var key, label, category string
+		var amount float64
+		if err := rows.Scan(&key, &label, &category, &amount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if key == unallocatedKey {
+			label = "Unallocated expenses"
+		}
+		l := line(key, label)
+		l.Expenses[category] += amount
+		l.TotalExpenses += amount
+	}
+	rows.Close()
+
+	lines := make([]PnLLine, 0, len(byKey))
+	for _, l := range byKey {
+		l.NetProfit = l.Revenue - l.TotalExpenses
+		lines = append(lines, *l)
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		a, b := lines[i], lines[j]
+		if (a.Key == unallocatedKey) != (b.Key == unallocatedKey) {
+			return b.Key == unallocatedKey
+		}
+		if byPeriod {
+			return a.Key < b.Key
+		}
+		return a.Revenue > b.Revenue
+	})
+	return lines, nil
+}
+
+// GetCashFlow returns money received (by payment date) and spent (by expense date) per period
+func GetCashFlow(c *gin.Context) {
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	from, to, err := parseDateRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	groupBy := c.DefaultQuery("group_by", "month")
+	f, ok := periodFormats[groupBy]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group_by. Allowed: day, week, month"})
+		return
+	}
+
+	query := `
+		SELECT period, COALESCE(SUM(inflow), 0), COALESCE(SUM(outflow), 0) FROM (
+			SELECT to_char(date_trunc($3, p.paid_at::timestamp), $4) as period,
+			       p.amount * fx_rate(COALESCE(i.currency, 'MAD'), $5, p.paid_at) as inflow, 0 as outflow
+			FROM payments p
+			JOIN invoices i ON p.invoice_id = i.id
+			WHERE p.paid_at BETWEEN $1 AND $2
+			UNION ALL
+			SELECT to_char(date_trunc($3, e.date::timestamp), $4) as period,
+			       0 as inflow, e.amount * fx_rate(COALESCE(e.currency, $5), $5, e.date) as outflow
+			FROM expenses e
+			WHERE e.date BETWEEN $1 AND $2
+		) flows
+		GROUP BY period
+		ORDER BY period
+	`
+	baseCurrency := GetBaseCurrency(db, tenant.ID)
+	missing, err := findMissingRates(db, cashFlowMissingRatesQuery, from, to, baseCurrency)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check exchange rates: " + err.Error()})
+		return
+	}
+
+	rows, err := db.Query(context.Background(), query, from, to, f[0], f[1], baseCurrency)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cash flow: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	report := CashFlowReport{
+		From:         from.Format("2006-01-02"),
+		To:           to.Format("2006-01-02"),
+		GroupBy:      groupBy,
+		BaseCurrency: baseCurrency,
+		MissingRates: missing,
+		Lines:        []CashFlowLine{},
+	}
+	for rows.Next() {
+		var l CashFlowLine
+		if err := rows.Scan(&l.Period, &l.Inflow, &l.Outflow); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan cash flow: " + err.Error()})
+			return
+		}
+		l.Net = l.Inflow - l.Outflow
+		report.TotalInflow += l.Inflow
+		report.TotalOutflow += l.Outflow
+		report.Net += l.Net
+		l.RunningBalance = report.Net
+		report.Lines = append(report.Lines, l)
+	}
+
+	c.JSON(http.StatusOK, report)
+}
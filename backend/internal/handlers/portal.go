@@ -0,0 +1,690 @@
+package handlers
+
+import (
+	"car-rental-backend/internal/database"
+	"car-rental-backend/internal/events"
+	"car-rental-backend/internal/mail"
+	"car-rental-backend/internal/messaging"
+	"car-rental-backend/internal/middleware"
+	"car-rental-backend/internal/models"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PortalLoginRequest struct {
+	Email string `json:"email"`
+	Phone string `json:"phone"`
+}
+
+// PortalVerifyRequest exchanges either the magic link token or the contact and its code for a session
+type PortalVerifyRequest struct {
+	Token string `json:"token"`
+	Email string `json:"email"`
+	Phone string `json:"phone"`
+	Code  string `json:"code"`
+}
+
+type PortalBookingRequest struct {
+	ID         string    `json:"id"`
+	Car        string    `json:"car"`
+	PickupDate time.Time `json:"pickup_date"`
+	ReturnDate time.Time `json:"return_date"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type PortalBooking struct {
+	ID                      string     `json:"id"`
+	Car                     string     `json:"car"`
+	StartDate               time.Time  `json:"start_date"`
+	EndDate                 time.Time  `json:"end_date"`
+	Status                  string     `json:"status"`
+	TotalPrice              float64    `json:"total_price"`
+	Currency                string     `json:"currency"`
+	CancellationRequestedAt *time.Time `json:"cancellation_requested_at"`
+}
+
+type PortalInvoice struct {
+	ID        string     `json:"id"`
+	BookingID string     `json:"booking_id"`
+	Amount    float64    `json:"amount"`
+	Paid      float64    `json:"paid"`
+	Currency  string     `json:"currency"`
+	Status    string     `json:"status"`
+	IssuedAt  time.Time  `json:"issued_at"`
+	DueDate   *time.Time `json:"due_date"`
+}
+
+type PortalCancelRequest struct {
+	Reason string `json:"reason"`
+}
+
+const (
+	portalCodeTTL      = 15 * time.Minute
+	portalCodeAttempts = 5
+	// portalCodesPerHour limits how many codes a contact can ask for
+	portalCodesPerHour = 5
+)
+
+// portalTenant resolves the tenant of a public portal request from the X-Subdomain header
+func portalTenant(c *gin.Context) (*models.Tenant, *pgxpool.Pool, bool) {
+	subdomain := c.GetHeader("X-Subdomain")
+	if subdomain == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Subdomain required"})
+		return nil, nil, false
+	}
+	var tenant models.Tenant
+	err := database.DB.QueryRow(context.Background(),
+		"SELECT id, name, subdomain, db_name, subscription_tier FROM tenants WHERE subdomain = $1 AND subdomain != 'admin'",
+		subdomain).Scan(&tenant.ID, &tenant.Name, &tenant.Subdomain, &tenant.DBName, &tenant.SubscriptionTier)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+		return nil, nil, false
+	}
+	db, err := database.GetTenantDB(tenant.DBName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection failed"})
+		return nil, nil, false
+	}
+	return &tenant, db, true
+}
+
+// portalContact normalizes the email or phone of a login request. Phone numbers are turned into full
+// international numbers with the tenant's country code, the same way international_phone() does in SQL.
+func portalContact(db *pgxpool.Pool, tenantID, email, phone string) (contactType, contact string, ok bool) {
+	if e := strings.ToLower(strings.TrimSpace(email)); e != "" {
+		return "email", e, true
+	}
+	if normalizePhone(phone) == "" {
+		return "", "", false
+	}
+	settings, err := LoadMessagingSettings(db, tenantID)
+	if err != nil {
+		return "", "", false
+	}
+	if p := internationalPhone(phone, settings.CountryCode); len(p) >= 8 {
+		return "phone", p, true
+	}
+	return "", "", false
+}
+
+// portalContactMatch returns the SQL condition matching an email or phone column against $1. Phones must match
+// the whole international number: the fuzzy suffix used to find duplicates is not enough to grant access.
+func portalContactMatch(contactType, emailColumn, phoneColumn string) string {
+	if contactType == "email" {
+		return "LOWER(TRIM(" + emailColumn + ")) = $1"
+	}
+	return "international_phone(" + phoneColumn + ") = $1"
+}
+
+// PortalLogin sends a one-time code and a magic link to a customer's email or phone. The response is the
+// same whether or not the contact is known, so the endpoint cannot be used to discover customers.
+func PortalLogin(c *gin.Context) {
+	var req PortalLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tenant, db, ok := portalTenant(c)
+	if !ok {
+		return
+	}
+
+	contactType, contact, ok := portalContact(db, tenant.ID, req.Email, req.Phone)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A valid email or phone number is required"})
+		return
+	}
+	// Without an SMS gateway the code could only be logged, which would leak it
+	if contactType == "phone" && !messenger.Configured(messaging.ChannelSMS) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Login by phone is not available, please use your email"})
+		return
+	}
+
+	response := gin.H{"message": "If we have your details on file, a login code has been sent"}
+	ctx := context.Background()
+
+	var known bool
+	err := db.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM customers WHERE deleted_at IS NULL AND `+portalContactMatch(contactType, "email", "phone")+`)
+		    OR EXISTS (SELECT 1 FROM booking_requests WHERE `+portalContactMatch(contactType, "customer_email", "customer_phone")+`)`,
+		contact).Scan(&known)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up customer: " + err.Error()})
+		return
+	}
+	if !known {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	var recent int
+	db.QueryRow(ctx,
+		"SELECT COUNT(*) FROM portal_login_codes WHERE contact_type = $1 AND contact = $2 AND created_at > NOW() - INTERVAL '1 hour'",
+		contactType, contact).Scan(&recent)
+	if recent >= portalCodesPerHour {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many login codes requested, please try again later"})
+		return
+	}
+
+	code, err := randomDigits(6)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate code"})
+		return
+	}
+	token, err := randomToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	_, err = db.Exec(ctx,
+		`INSERT INTO portal_login_codes (tenant_id, contact_type, contact, code_hash, token_hash, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		tenant.ID, contactType, contact, hashSecret(contact+":"+code), hashSecret(token), time.Now().Add(portalCodeTTL))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save login code: " + err.Error()})
+		return
+	}
+
+	link := portalLink(tenant.Subdomain, token)
+	if contactType == "email" {
+		err = mailer.Send(mail.Message{
+			To:      []string{contact},
+			Subject: tenant.Name + ": your login code",
+			Text: fmt.Sprintf("Your login code is %s. It expires in %d minutes.\n\nOr sign in directly: %s\n",
+				code, int(portalCodeTTL.Minutes()), link),
+			HTML: fmt.Sprintf(`<p>Your login code is <b>%s</b>. It expires in %d minutes.</p><p><a href="%s">Sign in to %s</a></p>`,
+				code, int(portalCodeTTL.Minutes()), template.HTMLEscapeString(link), template.HTMLEscapeString(tenant.Name)),
+		})
+		if err != nil {
+			log.Printf("[PORTAL] Failed to send login code to %s: %v", contact, err)
+		}
+	} else {
+		// Sent directly rather than through the outbox, which would keep the code in the database
+		_, err = messenger.Send(messaging.Message{
+			Channel: messaging.ChannelSMS,
+			To:      contact,
+			Body: fmt.Sprintf("%s: your login code is %s. It expires in %d minutes.",
+				tenant.Name, code, int(portalCodeTTL.Minutes())),
+		})
+		if err != nil {
+			log.Printf("[PORTAL] Failed to send login code by SMS: %v", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// PortalVerify checks a magic link token or a login code and returns a customer portal token
+func PortalVerify(c *gin.Context) {
+	var req PortalVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tenant, db, ok := portalTenant(c)
+	if !ok {
+		return
+	}
+	ctx := context.Background()
+
+	var codeID, contactType, contact string
+	if req.Token != "" {
+		err := db.QueryRow(ctx, `
+			UPDATE portal_login_codes SET used_at = NOW()
+			WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+			RETURNING id, contact_type, contact`, hashSecret(req.Token)).Scan(&codeID, &contactType, &contact)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired link"})
+			return
+		}
+	} else {
+		var ok bool
+		contactType, contact, ok = portalContact(db, tenant.ID, req.Email, req.Phone)
+		if !ok || req.Code == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "token, or email/phone and code, are required"})
+			return
+		}
+
+		// Only the latest code counts. Every guess takes an attempt before the code is compared, in one
+		// statement, so parallel guesses cannot go past portalCodeAttempts.
+		var codeHash string
+		err := db.QueryRow(ctx, `
+			UPDATE portal_login_codes SET attempts = attempts + 1
+			WHERE id = (SELECT id FROM portal_login_codes
+			            WHERE contact_type = $1 AND contact = $2 AND used_at IS NULL AND expires_at > NOW()
+			            ORDER BY created_at DESC LIMIT 1)
+			  AND attempts < $3
+			RETURNING id, code_hash`, contactType, contact, portalCodeAttempts).Scan(&codeID, &codeHash)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired code"})
+			return
+		}
+		if codeHash != hashSecret(contact+":"+strings.TrimSpace(req.Code)) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired code"})
+			return
+		}
+
+		// Marking the code used fails if a parallel request already did, so a code opens one session only
+		tag, err := db.Exec(ctx, "UPDATE portal_login_codes SET used_at = NOW() WHERE id = $1 AND used_at IS NULL", codeID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code: " + err.Error()})
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired code"})
+			return
+		}
+	}
+
+	token, err := middleware.IssueCustomerToken(tenant.ID, contactType, contact)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token})
+}
+
+// portalSession returns the tenant DB and verified contact of a portal request
+func portalSession(c *gin.Context) (*pgxpool.Pool, *models.Tenant, string, string, bool) {
+	db, tenant, err := getTenantDBForCustomers(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return nil, nil, "", "", false
+	}
+	return db, tenant, c.GetString("portal_contact_type"), c.GetString("portal_contact"), true
+}
+
+// portalCustomerIDs returns the customer records matching the verified contact
+func portalCustomerIDs(db *pgxpool.Pool, contactType, contact string) ([]string, error) {
+	rows, err := db.Query(context.Background(),
+		"SELECT id FROM customers WHERE deleted_at IS NULL AND "+portalContactMatch(contactType, "email", "phone")+" ORDER BY created_at DESC",
+		contact)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetPortalMe returns the customer profile(s) behind the portal session
+func GetPortalMe(c *gin.Context) {
+	db, tenant, contactType, contact, ok := portalSession(c)
+	if !ok {
+		return
+	}
+
+	rows, err := db.Query(context.Background(),
+		"SELECT "+customerColumns+" FROM customers WHERE deleted_at IS NULL AND "+portalContactMatch(contactType, "email", "phone")+" ORDER BY created_at DESC",
+		contact)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch profile: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	customers := []Customer{}
+	for rows.Next() {
+		var cust Customer
+		if err := scanCustomer(rows, &cust); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan profile: " + err.Error()})
+			return
+		}
+		customers = append(customers, cust)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tenant":       tenant.Name,
+		"contact_type": contactType,
+		"contact":      contact,
+		"customers":    customers,
+	})
+}
+
+// GetPortalRequests lists the booking requests sent with the session's email or phone
+func GetPortalRequests(c *gin.Context) {
+	db, _, contactType, contact, ok := portalSession(c)
+	if !ok {
+		return
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT r.id, COALESCE(c.brand || ' ' || c.model, ''), r.pickup_date, r.return_date, r.status, r.created_at
+		FROM booking_requests r
+		LEFT JOIN cars c ON r.car_id = c.id
+		WHERE `+portalContactMatch(contactType, "r.customer_email", "r.customer_phone")+`
+		ORDER BY r.created_at DESC`, contact)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch requests: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	requests := []PortalBookingRequest{}
+	for rows.Next() {
+		var r PortalBookingRequest
+		if err := rows.Scan(&r.ID, &r.Car, &r.PickupDate, &r.ReturnDate, &r.Status, &r.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan request: " + err.Error()})
+			return
+		}
+		requests = append(requests, r)
+	}
+
+	c.JSON(http.StatusOK, requests)
+}
+
+// CancelPortalRequest withdraws a pending booking request
+func CancelPortalRequest(c *gin.Context) {
+	db, tenant, contactType, contact, ok := portalSession(c)
+	if !ok {
+		return
+	}
+
+	result, err := db.Exec(context.Background(), `
+		UPDATE booking_requests r SET status = 'cancelled'
+		WHERE r.id = $2 AND r.status = 'pending' AND `+portalContactMatch(contactType, "r.customer_email", "r.customer_phone"),
+		contact, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel request: " + err.Error()})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pending request not found"})
+		return
+	}
+	if err := releasePromoRedemption(db, "booking_request_id", c.Param("id")); err != nil {
+		log.Printf("[PROMO] Failed to release promo code of request %s: %v", c.Param("id"), err)
+	}
+
+	events.Publish(events.Event{
+		Type:     events.BookingRequestStatusChanged,
+		Tenant:   tenant,
+		DB:       db,
+		EntityID: c.Param("id"),
+		Data:     map[string]interface{}{"id": c.Param("id"), "status": "cancelled"},
+	})
+	CreateNotificationInternal(db, tenant.ID, "", "Booking request withdrawn",
+		"A customer withdrew their booking request from the portal", "info")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Request cancelled successfully"})
+}
+
+// GetPortalBookings lists the bookings of the session's customer records
+func GetPortalBookings(c *gin.Context) {
+	db, _, contactType, contact, ok := portalSession(c)
+	if !ok {
+		return
+	}
+	customerIDs, err := portalCustomerIDs(db, contactType, contact)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch profile: " + err.Error()})
+		return
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT b.id, c.brand || ' ' || c.model, b.start_date, b.end_date, b.status,
+		       b.price_per_day * (b.end_date - b.start_date + 1), COALESCE(b.currency, 'MAD'), b.cancellation_requested_at
+		FROM bookings b
+		JOIN cars c ON b.car_id = c.id
+		WHERE b.customer_id = ANY($1::uuid[])
+		ORDER BY b.start_date DESC`, customerIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bookings: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	bookings := []PortalBooking{}
+	for rows.Next() {
+		var b PortalBooking
+		if err := rows.Scan(&b.ID, &b.Car, &b.StartDate, &b.EndDate, &b.Status, &b.TotalPrice, &b.Currency,
+			&b.CancellationRequestedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan booking: " + err.Error()})
+			return
+		}
+		bookings = append(bookings, b)
+	}
+
+	c.JSON(http.StatusOK, bookings)
+}
+
+// RequestPortalCancellation asks staff to cancel an upcoming booking
+func RequestPortalCancellation(c *gin.Context) {
+	var req PortalCancelRequest
+	c.ShouldBindJSON(&req)
+
+	db, tenant, contactType, contact, ok := portalSession(c)
+	if !ok {
+		return
+	}
+	customerIDs, err := portalCustomerIDs(db, contactType, contact)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch profile: " + err.Error()})
+		return
+	}
+
+	var startDate time.Time
+	err = db.QueryRow(context.Background(), `
+		UPDATE bookings SET cancellation_requested_at = NOW(), cancellation_reason = $3
+		WHERE id = $1 AND customer_id = ANY($2::uuid[]) AND status IN ('pending', 'confirmed')
+		  AND cancellation_requested_at IS NULL
+		RETURNING start_date`, c.Param("id"), customerIDs, nullIfEmpty(req.Reason)).Scan(&startDate)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No cancellable booking found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request cancellation: " + err.Error()})
+		return
+	}
+
+	CreateNotificationInternal(db, tenant.ID, "", "Cancellation requested",
+		fmt.Sprintf("A customer asked to cancel their booking starting %s", startDate.Format("2006-01-02")), "warning")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Cancellation requested, the agency will confirm it"})
+}
+
+// GetPortalInvoices lists the invoices of the session's bookings
+func GetPortalInvoices(c *gin.Context) {
+	db, _, contactType, contact, ok := portalSession(c)
+	if !ok {
+		return
+	}
+	customerIDs, err := portalCustomerIDs(db, contactType, contact)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch profile: " + err.Error()})
+		return
+	}
+
+	invoices, err := loadPortalInvoices(db, customerIDs, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoices: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, invoices)
+}
+
+var portalInvoiceHTML = template.Must(template.New("invoice").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Invoice {{.Number}}</title></head>
+<body style="font-family: sans-serif">
+<h1>{{.Tenant}}</h1>
+<h2>Invoice {{.Number}}</h2>
+<p>Customer: {{.Customer}}<br>Issued: {{.Invoice.IssuedAt.Format "2006-01-02"}}{{with .Invoice.DueDate}}<br>Due: {{.Format "2006-01-02"}}{{end}}</p>
+<p>Rental: {{.Car}}, {{.Start.Format "2006-01-02"}} to {{.End.Format "2006-01-02"}}</p>
+<table cellpadding="6" border="1" style="border-collapse: collapse">
+{{range .Lines}}<tr><td>{{.Description}}</td><td>{{printf "%.2f" .Amount}} {{$.Invoice.Currency}}</td></tr>
+{{end}}<tr><td><b>Total</b></td><td>{{printf "%.2f" .Invoice.Amount}} {{.Invoice.Currency}}</td></tr>
+<tr><td>Paid</td><td>{{printf "%.2f" .Invoice.Paid}} {{.Invoice.Currency}}</td></tr>
+<tr><td>Status</td><td>{{.Invoice.Status}}</td></tr>
+</table>
+</body></html>`))
+
+// DownloadPortalInvoice returns a printable invoice of one of the session's bookings
+func DownloadPortalInvoice(c *gin.Context) {
+	db, tenant, contactType, contact, ok := portalSession(c)
+	if !ok {
+		return
+	}
+	customerIDs, err := portalCustomerIDs(db, contactType, contact)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch profile: " + err.Error()})
+		return
+	}
+
+	invoices, err := loadPortalInvoices(db, customerIDs, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoice: " + err.Error()})
+		return
+	}
+	if len(invoices) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
+		return
+	}
+	inv := invoices[0]
+
+	var customer, car string
+	var start, end time.Time
+	err = db.QueryRow(context.Background(), `
+		SELECT COALESCE(cust.first_name || ' ' || cust.last_name, ''), c.brand || ' ' || c.model || ' (' || c.license_plate || ')',
+		       b.start_date, b.end_date
+		FROM bookings b
+		JOIN cars c ON b.car_id = c.id
+		LEFT JOIN customers cust ON b.customer_id = cust.id
+		WHERE b.id = $1`, inv.BookingID).Scan(&customer, &car, &start, &end)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch booking: " + err.Error()})
+		return
+	}
+
+	lines, err := loadInvoiceLines(db, inv.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoice lines: " + err.Error()})
+		return
+	}
+
+	number := shortRef(inv.ID)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="invoice-%s.html"`, number))
+	c.Status(http.StatusOK)
+	portalInvoiceHTML.Execute(c.Writer, gin.H{
+		"Tenant": tenant.Name, "Number": number, "Customer": customer, "Car": car,
+		"Start": start, "End": end, "Invoice": inv, "Lines": lines,
+	})
+}
+
+// UploadPortalDocument lets a customer upload an identity document to their most recent customer record
+func UploadPortalDocument(c *gin.Context) {
+	db, tenant, contactType, contact, ok := portalSession(c)
+	if !ok {
+		return
+	}
+	customerIDs, err := portalCustomerIDs(db, contactType, contact)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch profile: " + err.Error()})
+		return
+	}
+	if len(customerIDs) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No customer record yet, documents can be uploaded once a booking is confirmed"})
+		return
+	}
+
+	documentID, ok := storeCustomerDocument(c, db, tenant, customerIDs[0])
+	if !ok {
+		return
+	}
+
+	CreateNotificationInternal(db, tenant.ID, "", "Document uploaded",
+		"A customer uploaded an identity document from the portal", "info")
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Document uploaded successfully", "id": documentID})
+}
+
+// loadPortalInvoices returns the non-cancelled invoices of the given customers' bookings, optionally a single one
+func loadPortalInvoices(db *pgxpool.Pool, customerIDs []string, invoiceID string) ([]PortalInvoice, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT i.id, i.booking_id, i.amount,
+		       COALESCE((SELECT SUM(p.amount) FROM payments p WHERE p.invoice_id = i.id), 0),
+		       COALESCE(i.currency, 'MAD'), i.status, i.created_at, i.due_date
+		FROM invoices i
+		JOIN bookings b ON i.booking_id = b.id
+		WHERE b.customer_id = ANY($1::uuid[]) AND i.status != 'Cancelled' AND ($2 = '' OR i.id::text = $2)
+		ORDER BY i.created_at DESC`, customerIDs, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invoices := []PortalInvoice{}
+	for rows.Next() {
+		var inv PortalInvoice
+		if err := rows.Scan(&inv.ID, &inv.BookingID, &inv.Amount, &inv.Paid, &inv.Currency, &inv.Status,
+			&inv.IssuedAt, &inv.DueDate); err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, inv)
+	}
+	return invoices, rows.Err()
+}
+
+// portalLink builds the magic link (PORTAL_URL, default http://localhost:5173/portal/verify)
+func portalLink(subdomain, token string) string {
+	base := os.Getenv("PORTAL_URL")
+	if base == "" {
+		base = "http://localhost:5173/portal/verify"
+	}
+	return base + "?subdomain=" + url.QueryEscape(subdomain) + "&token=" + url.QueryEscape(token)
+}
+
+// randomDigits returns a cryptographically random numeric code
+func randomDigits(n int) (string, error) {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		d, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(byte('0' + d.Int64()))
+	}
+	return b.String(), nil
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashSecret hashes codes and tokens before they are stored
+func hashSecret(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
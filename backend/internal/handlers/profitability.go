@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VehicleProfitability is one car's line of the profitability report. Amounts are in the base currency.
+type VehicleProfitability struct {
+	CarID        string `json:"car_id"`
+	Make         string `json:"make"`
+	Model        string `json:"model"`
+	LicensePlate string `json:"license_plate"`
+	Category     string `json:"category"`
+
+	Revenue      float64 `json:"revenue"`      // Booking revenue for the days inside the range
+	Expenses     float64 `json:"expenses"`     // Expenses allocated to the car
+	Depreciation float64 `json:"depreciation"` // Straight-line, purchase price down to expected resale value
+	NetProfit    float64 `json:"net_profit"`
+
+	PurchasePrice *float64 `json:"purchase_price"` // Converted to the base currency
+	ROI           *float64 `json:"roi"`            // Net profit / purchase price, in percent
+
+	RentedDays             int     `json:"rented_days"`
+	AvailableDays          int     `json:"available_days"`
+	Utilization            float64 `json:"utilization"` // Percentage of available days rented
+	RevenuePerAvailableDay float64 `json:"revenue_per_available_day"`
+
+	// Warnings explain figures that could not be computed (missing purchase data or exchange rate)
+	Warnings []string `json:"warnings"`
+}
+
+type ProfitabilityReport struct {
+	From              string                 `json:"from"`
+	To                string                 `json:"to"`
+	BaseCurrency      string                 `json:"base_currency"`
+	Cars              []VehicleProfitability `json:"cars"`
+	TotalRevenue      float64                `json:"total_revenue"`
+	TotalExpenses     float64                `json:"total_expenses"`
+	TotalDepreciation float64                `json:"total_depreciation"`
+	NetProfit         float64                `json:"net_profit"`
+}
+
+// profitableBookingStatuses are the booking statuses that count as earned revenue and rented days
+const profitableBookingStatuses = `('confirmed', 'active', 'completed')`
+
+// GetVehicleProfitability returns revenue, allocated expenses, depreciation, ROI and utilization per car
+// for ?from=&to= (default last 12 months). ?sort=net_profit|roi|utilization|revenue_per_day (default net_profit).
+func GetVehicleProfitability(c *gin.Context) {
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	from, to, err := parseDateRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	baseCurrency := GetBaseCurrency(db, tenant.ID)
+
+	// Bookings only count for the days that fall inside the range
+	query := `
+		SELECT c.id, c.brand, c.model, c.license_plate, COALESCE(c.category, ''),
+		       c.created_at::date, c.purchase_price, c.purchase_date, c.expected_resale_value, c.expected_resale_date,
+		       fx_rate(COALESCE(c.currency, 'MAD'), $3, COALESCE(c.purchase_date, c.created_at::date)),
+		       COALESCE(bk.rented_days, 0), COALESCE(bk.revenue, 0), COALESCE(bk.missing_rate, false),
+		       COALESCE(ex.expenses, 0), COALESCE(ex.missing_rate, false),
+		       (SELECT COUNT(DISTINCT d.day) FROM car_blocks k
+		        CROSS JOIN generate_series(GREATEST(k.start_date, $1::date), LEAST(k.end_date, $2::date), interval '1 day') d(day)
+		        WHERE k.car_id = c.id) as blocked_days
+		FROM cars c
+		LEFT JOIN (
+			SELECT b.car_id,
+			       SUM(LEAST(b.end_date, $2::date) - GREATEST(b.start_date, $1::date) + 1) as rented_days,
+			       SUM(b.price_per_day * (LEAST(b.end_date, $2::date) - GREATEST(b.start_date, $1::date) + 1)
+			           * COALESCE(stored_rate(b.exchange_rate, b.exchange_rate_base, $3), fx_rate(COALESCE(b.currency, 'MAD'), $3, b.created_at::date))) as revenue,
+			       BOOL_OR(COALESCE(stored_rate(b.exchange_rate, b.exchange_rate_base, $3), fx_rate(COALESCE(b.currency, 'MAD'), $3, b.created_at::date)) IS NULL) as missing_rate
+			FROM bookings b
+			WHERE b.status IN ` + profitableBookingStatuses + ` AND b.start_date <= $2 AND b.end_date >= $1
+			GROUP BY b.car_id
+		) bk ON bk.car_id = c.id
+		LEFT JOIN (
+			SELECT e.car_id,
+			       SUM(e.amount * fx_rate(COALESCE(e.currency, $3), $3, e.date)) as expenses,
+			       BOOL_OR(fx_rate(COALESCE(e.currency, $3), $3, e.date) IS NULL) as missing_rate
+			FROM expenses e
+			WHERE e.car_id IS NOT NULL AND e.date BETWEEN $1 AND $2
+			GROUP BY e.car_id
+		) ex ON ex.car_id = c.id
+	`
+	rows, err := db.Query(context.Background(), query, from, to, baseCurrency)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch vehicle profitability: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	report := ProfitabilityReport{
+		From:         from.Format("2006-01-02"),
+		To:           to.Format("2006-01-02"),
+		BaseCurrency: baseCurrency,
+		Cars:         []VehicleProfitability{},
+	}
+
+	for rows.Next() {
+		var v VehicleProfitability
+		var createdAt time.Time
+		var purchasePrice, resaleValue, purchaseRate *float64
+		var purchaseDate, resaleDate *time.Time
+		var bookingRateMissing, expenseRateMissing bool
+		var blockedDays int
+		if err := rows.Scan(&v.CarID, &v.Make, &v.Model, &v.LicensePlate, &v.Category,
+			&createdAt, &purchasePrice, &purchaseDate, &resaleValue, &resaleDate, &purchaseRate,
+			&v.RentedDays, &v.Revenue, &bookingRateMissing, &v.Expenses, &expenseRateMissing, &blockedDays); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan vehicle profitability: " + err.Error()})
+			return
+		}
+		v.Warnings = []string{}
+		if bookingRateMissing || expenseRateMissing {
+			v.Warnings = append(v.Warnings, "Some amounts were excluded because an exchange rate is missing")
+		}
+
+		// The car is available from its purchase (or creation) date until the end of the range, except blocked days
+		inService := createdAt
+		if purchaseDate != nil {
+			inService = *purchaseDate
+		}
+		v.AvailableDays = overlapDays(inService, to, from, to) - blockedDays
+		if v.AvailableDays < v.RentedDays {
+			v.AvailableDays = v.RentedDays
+		}
+		if v.AvailableDays > 0 {
+			v.Utilization = math.Min(100, float64(v.RentedDays)/float64(v.AvailableDays)*100)
+			v.RevenuePerAvailableDay = v.Revenue / float64(v.AvailableDays)
+		}
+
+		if purchasePrice != nil && purchaseRate == nil {
+			v.Warnings = append(v.Warnings, "Purchase price excluded: no exchange rate for the car's currency")
+		} else if purchasePrice != nil {
+			price := *purchasePrice * *purchaseRate
+			v.PurchasePrice = &price
+
+			switch {
+			case purchaseDate == nil || resaleValue == nil || resaleDate == nil:
+				v.Warnings = append(v.Warnings, "Depreciation needs purchase date, expected resale value and expected resale date")
+			case !resaleDate.After(*purchaseDate):
+				v.Warnings = append(v.Warnings, "Expected resale date must be after purchase date")
+			default:
+				holdingDays := resaleDate.Sub(*purchaseDate).Hours() / 24
+				perDay := (*purchasePrice - *resaleValue) * *purchaseRate / holdingDays
+				v.Depreciation = perDay * float64(overlapDays(*purchaseDate, *resaleDate, from, to))
+			}
+		} else {
+			v.Warnings = append(v.Warnings, "No purchase price: depreciation and ROI are not computed")
+		}
+
+		v.NetProfit = v.Revenue - v.Expenses - v.Depreciation
+		if v.PurchasePrice != nil && *v.PurchasePrice > 0 {
+			roi := v.NetProfit / *v.PurchasePrice * 100
+			v.ROI = &roi
+		}
+
+		report.TotalRevenue += v.Revenue
+		report.TotalExpenses += v.Expenses
+		report.TotalDepreciation += v.Depreciation
+		report.Cars = append(report.Cars, v)
+	}
+	report.NetProfit = report.TotalRevenue - report.TotalExpenses - report.TotalDepreciation
+
+	var less func(a, b VehicleProfitability) bool
+	switch c.DefaultQuery("sort", "net_profit") {
+	case "net_profit":
+		less = func(a, b VehicleProfitability) bool { return a.NetProfit > b.NetProfit }
+	case "roi":
+		// Cars without ROI go last
+		less = func(a, b VehicleProfitability) bool {
+			if (a.ROI == nil) != (b.ROI == nil) {
+				return b.ROI == nil
+			}
+			return a.ROI != nil && *a.ROI > *b.ROI
+		}
+	case "utilization":
+		less = func(a, b VehicleProfitability) bool { return a.Utilization > b.Utilization }
+	case "revenue_per_day":
+		less = func(a, b VehicleProfitability) bool { return a.RevenuePerAvailableDay > b.RevenuePerAvailableDay }
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort. Allowed: net_profit, roi, utilization, revenue_per_day"})
+		return
+	}
+	sort.SliceStable(report.Cars, func(i, j int) bool { return less(report.Cars[i], report.Cars[j]) })
+
+	c.JSON(http.StatusOK, report)
+}
+
+// overlapDays counts the days (inclusive) shared by [start, end] and [from, to]
+func overlapDays(start, end, from, to time.Time) int {
+	if start.Before(from) {
+		start = from
+	}
+	if end.After(to) {
+		end = to
+	}
+	if end.Before(start) {
+		return 0
+	}
+	return int(end.Sub(start).Hours()/24) + 1
+}
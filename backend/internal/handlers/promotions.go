@@ -0,0 +1,456 @@
+package handlers
+
+import (
+	"car-rental-backend/internal/audit"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type PromoCode struct {
+	ID                 string     `json:"id"`
+	Code               string     `json:"code"`
+	Description        string     `json:"description"`
+	DiscountType       string     `json:"discount_type"` // percentage, fixed
+	Value              float64    `json:"value"`
+	Currency           *string    `json:"currency"`
+	ValidFrom          *time.Time `json:"valid_from"`
+	ValidUntil         *time.Time `json:"valid_until"`
+	MaxUses            *int       `json:"max_uses"`
+	MaxUsesPerCustomer *int       `json:"max_uses_per_customer"`
+	UsedCount          int        `json:"used_count"`
+	Categories         []string   `json:"categories"`
+	Active             bool       `json:"active"`
+	CreatedAt          time.Time  `json:"created_at"`
+}
+
+type CreatePromoCodeRequest struct {
+	Code               string     `json:"code" binding:"required"`
+	Description        string     `json:"description"`
+	DiscountType       string     `json:"discount_type" binding:"required,oneof=percentage fixed"`
+	Value              float64    `json:"value" binding:"required,gt=0"`
+	Currency           string     `json:"currency"`
+	ValidFrom          *time.Time `json:"valid_from"`
+	ValidUntil         *time.Time `json:"valid_until"`
+	MaxUses            *int       `json:"max_uses"`
+	MaxUsesPerCustomer *int       `json:"max_uses_per_customer"`
+	Categories         []string   `json:"categories"`
+}
+
+type UpdatePromoCodeRequest struct {
+	Description        *string    `json:"description"`
+	ValidFrom          *time.Time `json:"valid_from"`
+	ValidUntil         *time.Time `json:"valid_until"`
+	MaxUses            *int       `json:"max_uses"`
+	MaxUsesPerCustomer *int       `json:"max_uses_per_customer"`
+	Categories         []string   `json:"categories"`
+	Active             *bool      `json:"active"`
+}
+
+// PromoCheck is the result of validating a promo code for a rental
+type PromoCheck struct {
+	Valid       bool    `json:"valid"`
+	Reason      string  `json:"reason,omitempty"`
+	PromoCodeID string  `json:"promo_code_id,omitempty"`
+	Code        string  `json:"code,omitempty"`
+	Discount    float64 `json:"discount"`
+}
+
+type ValidatePromoCodeRequest struct {
+	Code          string `json:"code" binding:"required"`
+	CarID         string `json:"car_id" binding:"required"`
+	PickupDate    string `json:"pickup_date" binding:"required"`
+	ReturnDate    string `json:"return_date" binding:"required"`
+	CustomerPhone string `json:"customer_phone"`
+}
+
+// dbQuerier is implemented by both *pgxpool.Pool and pgx.Tx
+type dbQuerier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+const promoCodeColumns = `id, code, COALESCE(description, ''), discount_type, value, currency, valid_from, valid_until,
+	max_uses, max_uses_per_customer, used_count, categories, active, created_at`
+
+func scanPromoCode(row pgx.Row, p *PromoCode) error {
+	return row.Scan(&p.ID, &p.Code, &p.Description, &p.DiscountType, &p.Value, &p.Currency, &p.ValidFrom, &p.ValidUntil,
+		&p.MaxUses, &p.MaxUsesPerCustomer, &p.UsedCount, &p.Categories, &p.Active, &p.CreatedAt)
+}
+
+// normalizePromoCode makes codes case-insensitive
+func normalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// GetPromoCodes lists the tenant's promo codes
+func GetPromoCodes(c *gin.Context) {
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	rows, err := db.Query(context.Background(),
+		"SELECT "+promoCodeColumns+" FROM promo_codes WHERE tenant_id = $1 ORDER BY active DESC, created_at DESC", tenant.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch promo codes: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	codes := []PromoCode{}
+	for rows.Next() {
+		var p PromoCode
+		if err := scanPromoCode(rows, &p); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan promo code: " + err.Error()})
+			return
+		}
+		codes = append(codes, p)
+	}
+
+	c.JSON(http.StatusOK, codes)
+}
+
+// CreatePromoCode creates a promo code
+func CreatePromoCode(c *gin.Context) {
+	var req CreatePromoCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	code := normalizePromoCode(req.Code)
+	if code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Code is required"})
+		return
+	}
+	if req.DiscountType == "percentage" && req.Value > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A percentage discount cannot exceed 100"})
+		return
+	}
+	if req.ValidFrom != nil && req.ValidUntil != nil && req.ValidUntil.Before(*req.ValidFrom) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "valid_until must be after valid_from"})
+		return
+	}
+	if req.Categories == nil {
+		req.Categories = []string{}
+	}
+
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	// Fixed discounts are in one currency; default to the base currency
+	var currency interface{}
+	if req.DiscountType == "fixed" {
+		currency = strings.ToUpper(req.Currency)
+		if req.Currency == "" {
+			currency = GetBaseCurrency(db, tenant.ID)
+		}
+	}
+
+	var id string
+	err = db.QueryRow(context.Background(),
+		`INSERT INTO promo_codes (tenant_id, code, description, discount_type, value, currency, valid_from, valid_until,
+		                          max_uses, max_uses_per_customer, categories)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		 ON CONFLICT (tenant_id, code) DO NOTHING
+		 RETURNING id`,
+		tenant.ID, code, nullIfEmpty(req.Description), req.DiscountType, req.Value, currency, req.ValidFrom, req.ValidUntil,
+		req.MaxUses, req.MaxUsesPerCustomer, req.Categories).Scan(&id)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "A promo code with this code already exists"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create promo code: " + err.Error()})
+		return
+	}
+
+	audit.LogAudit(c, "CREATE_PROMO_CODE", gin.H{"id": id, "code": code})
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Promo code created successfully", "id": id})
+}
+
+// UpdatePromoCode updates the validity, limits or categories of a promo code. The code and discount are fixed
+// once created, so past redemptions keep matching what was offered.
+func UpdatePromoCode(c *gin.Context) {
+	var req UpdatePromoCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	setClauses := []string{}
+	args := []interface{}{}
+	argIndex := 1
+	add := func(column string, value interface{}) {
+		setClauses = append(setClauses, fmt.Sprintf("%s = $%d", column, argIndex))
+		args = append(args, value)
+		argIndex++
+	}
+	if req.Description != nil {
+		add("description", nullIfEmpty(*req.Description))
+	}
+	if req.ValidFrom != nil {
+		add("valid_from", req.ValidFrom)
+	}
+	if req.ValidUntil != nil {
+		add("valid_until", req.ValidUntil)
+	}
+	if req.MaxUses != nil {
+		// 0 removes the limit
+		if *req.MaxUses == 0 {
+			add("max_uses", nil)
+		} else {
+			add("max_uses", req.MaxUses)
+		}
+	}
+	if req.MaxUsesPerCustomer != nil {
+		if *req.MaxUsesPerCustomer == 0 {
+			add("max_uses_per_customer", nil)
+		} else {
+			add("max_uses_per_customer", req.MaxUsesPerCustomer)
+		}
+	}
+	if req.Categories != nil {
+		add("categories", req.Categories)
+	}
+	if req.Active != nil {
+		add("active", *req.Active)
+	}
+	if len(setClauses) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields to update"})
+		return
+	}
+
+	args = append(args, c.Param("id"), tenant.ID)
+	query := fmt.Sprintf("UPDATE promo_codes SET %s WHERE id = $%d AND tenant_id = $%d",
+		strings.Join(setClauses, ", "), argIndex, argIndex+1)
+	result, err := db.Exec(context.Background(), query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update promo code: " + err.Error()})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promo code not found"})
+		return
+	}
+
+	audit.LogAudit(c, "UPDATE_PROMO_CODE", gin.H{"id": c.Param("id")})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Promo code updated successfully"})
+}
+
+// ValidatePublicPromoCode previews the discount of a promo code on the public booking form
+func ValidatePublicPromoCode(c *gin.Context) {
+	var req ValidatePromoCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tenant, db, ok := portalTenant(c)
+	if !ok {
+		return
+	}
+
+	amount, currency, err := rentalQuote(db, req.CarID, req.PickupDate, req.ReturnDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	check, err := CheckPromoCode(db, tenant.ID, req.Code, req.CarID, "", req.CustomerPhone, amount, currency)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check promo code: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"promo":    check,
+		"amount":   amount,
+		"total":    roundCents(amount - check.Discount),
+		"currency": currency,
+	})
+}
+
+// rentalQuote prices a rental of a car at its daily rate; dates are YYYY-MM-DD and both days are included
+func rentalQuote(db dbQuerier, carID, pickupDate, returnDate string) (float64, string, error) {
+	start, err := time.Parse("2006-01-02", pickupDate)
+	if err != nil {
+		return 0, "", fmt.Errorf("Invalid pickup_date format, expected YYYY-MM-DD")
+	}
+	end, err := time.Parse("2006-01-02", returnDate)
+	if err != nil {
+		return 0, "", fmt.Errorf("Invalid return_date format, expected YYYY-MM-DD")
+	}
+	if end.Before(start) {
+		return 0, "", fmt.Errorf("return_date must be after pickup_date")
+	}
+
+	var pricePerDay float64
+	var currency string
+	err = db.QueryRow(context.Background(),
+		"SELECT price_per_day, COALESCE(currency, 'MAD') FROM cars WHERE id = $1", carID).Scan(&pricePerDay, &currency)
+	if err != nil {
+		return 0, "", fmt.Errorf("Car not found")
+	}
+	days := int(end.Sub(start).Hours()/24) + 1
+	return roundCents(pricePerDay * float64(days)), currency, nil
+}
+
+// CheckPromoCode validates a promo code for renting a car for amount (in currency) and computes the discount.
+// Per-customer limits are matched by customer ID, or by phone for public requests.
+func CheckPromoCode(db dbQuerier, tenantID, code, carID, customerID, phone string, amount float64, currency string) (PromoCheck, error) {
+	check := PromoCheck{Code: normalizePromoCode(code)}
+
+	var p PromoCode
+	err := scanPromoCode(db.QueryRow(context.Background(),
+		"SELECT "+promoCodeColumns+" FROM promo_codes WHERE tenant_id = $1 AND code = $2", tenantID, check.Code), &p)
+	if err == pgx.ErrNoRows || (err == nil && !p.Active) {
+		check.Reason = "Unknown promo code"
+		return check, nil
+	}
+	if err != nil {
+		return check, err
+	}
+	check.PromoCodeID = p.ID
+
+	today := time.Now().Truncate(24 * time.Hour)
+	switch {
+	case p.ValidFrom != nil && today.Before(*p.ValidFrom):
+		check.Reason = "This promo code is not valid yet"
+		return check, nil
+	case p.ValidUntil != nil && today.After(*p.ValidUntil):
+		check.Reason = "This promo code has expired"
+		return check, nil
+	case p.MaxUses != nil && p.UsedCount >= *p.MaxUses:
+		check.Reason = "This promo code has reached its usage limit"
+		return check, nil
+	}
+
+	if len(p.Categories) > 0 {
+		var category string
+		db.QueryRow(context.Background(), "SELECT COALESCE(category, '') FROM cars WHERE id = $1", carID).Scan(&category)
+		if !containsFold(p.Categories, category) {
+			check.Reason = "This promo code does not apply to this car"
+			return check, nil
+		}
+	}
+
+	if p.MaxUsesPerCustomer != nil {
+		if digits := normalizePhone(phone); len(digits) >= phoneMatchDigits {
+			phone = digits[len(digits)-phoneMatchDigits:]
+		} else {
+			phone = ""
+		}
+		if customerID != "" || phone != "" {
+			var uses int
+			err := db.QueryRow(context.Background(), `
+				SELECT COUNT(*) FROM promo_redemptions
+				WHERE promo_code_id = $1 AND (customer_id::text = $2 OR customer_phone = $3)`,
+				p.ID, customerID, phone).Scan(&uses)
+			if err != nil {
+				return check, err
+			}
+			if uses >= *p.MaxUsesPerCustomer {
+				check.Reason = "This promo code has already been used"
+				return check, nil
+			}
+		}
+	}
+
+	if p.DiscountType == "percentage" {
+		check.Discount = roundCents(amount * p.Value / 100)
+	} else {
+		if p.Currency != nil && *p.Currency != currency {
+			check.Reason = fmt.Sprintf("This promo code only applies to bookings in %s", *p.Currency)
+			return check, nil
+		}
+		check.Discount = p.Value
+	}
+	if check.Discount > amount {
+		check.Discount = amount
+	}
+	check.Valid = true
+	return check, nil
+}
+
+// redeemPromoCode records the use of a validated promo code by a booking or a booking request. It returns false
+// when the code reached its usage limit since it was checked.
+func redeemPromoCode(tx pgx.Tx, tenantID string, check PromoCheck, bookingID, requestID, customerID, phone string) (bool, error) {
+	ctx := context.Background()
+	result, err := tx.Exec(ctx,
+		"UPDATE promo_codes SET used_count = used_count + 1 WHERE id = $1 AND (max_uses IS NULL OR used_count < max_uses)",
+		check.PromoCodeID)
+	if err != nil {
+		return false, err
+	}
+	if result.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	if digits := normalizePhone(phone); len(digits) >= phoneMatchDigits {
+		phone = digits[len(digits)-phoneMatchDigits:]
+	}
+	_, err = tx.Exec(ctx,
+		`INSERT INTO promo_redemptions (tenant_id, promo_code_id, booking_id, booking_request_id, customer_id, customer_phone, amount)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		tenantID, check.PromoCodeID, nullIfEmpty(bookingID), nullIfEmpty(requestID), nullIfEmpty(customerID),
+		nullIfEmpty(phone), check.Discount)
+	return err == nil, err
+}
+
+// releasePromoRedemption gives back the promo code use of a cancelled booking or rejected request
+// (column is booking_id or booking_request_id)
+func releasePromoRedemption(db dbQuerier, column, id string) error {
+	_, err := db.Exec(context.Background(), `
+		WITH released AS (DELETE FROM promo_redemptions WHERE `+column+` = $1 RETURNING promo_code_id)
+		UPDATE promo_codes p SET used_count = GREATEST(p.used_count - 1, 0)
+		FROM released r WHERE p.id = r.promo_code_id`, id)
+	return err
+}
+
+// applyPublicPromoCode validates the promo code of a public booking request, writing the error response itself
+func applyPublicPromoCode(c *gin.Context, db dbQuerier, tenantID, code, carID, phone, pickupDate, returnDate string) (PromoCheck, bool) {
+	amount, currency, err := rentalQuote(db, carID, pickupDate, returnDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return PromoCheck{}, false
+	}
+	check, err := CheckPromoCode(db, tenantID, code, carID, "", phone, amount, currency)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check promo code: " + err.Error()})
+		return check, false
+	}
+	if !check.Valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": check.Reason, "promo": check})
+		return check, false
+	}
+	return check, true
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"car-rental-backend/internal/events"
+	"car-rental-backend/internal/middleware"
+	"car-rental-backend/internal/models"
+	"car-rental-backend/internal/realtime"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EventNotification pushes a new notification to its user
+const EventNotification = "notification"
+
+// realtimeEvents are the domain events pushed as they are to every connected user of the tenant
+var realtimeEvents = []string{
+	events.BookingRequestCreated,
+	events.BookingRequestStatusChanged,
+	events.BookingStatusChanged,
+}
+
+// streamHeartbeat keeps idle connections open through proxies
+const streamHeartbeat = 25 * time.Second
+
+var (
+	realtimeHub                    = realtime.NewHub()
+	realtimeBroker realtime.Broker = realtime.LocalBroker{Hub: realtimeHub}
+)
+
+// SetRealtime sets the hub the event stream subscribes to and the broker events are published with; set from
+// main so that several API instances can share events
+func SetRealtime(hub *realtime.Hub, broker realtime.Broker) {
+	realtimeHub = hub
+	realtimeBroker = broker
+}
+
+// SubscribeRealtime pushes the booking activity from the domain events to connected staff; called once from main
+func SubscribeRealtime(bus *events.Bus) {
+	for _, eventType := range realtimeEvents {
+		bus.Subscribe(eventType, func(e events.Event) {
+			publishEvent(e.Tenant.ID, "", e.Type, e.Data)
+		})
+	}
+}
+
+// publishEvent pushes an event to the tenant's connected users (only userID when set). Failures are logged: the
+// change itself is already saved and clients catch up when they refetch.
+func publishEvent(tenantID, userID, eventType string, data interface{}) {
+	e, err := realtime.NewEvent(eventType, tenantID, userID, data)
+	if err == nil {
+		err = realtimeBroker.Publish(e)
+	}
+	if err != nil {
+		log.Printf("[REALTIME] Failed to publish %s: %v", eventType, err)
+	}
+}
+
+// CreateStreamToken returns a short-lived token to open the event stream with. Browsers' EventSource can't send
+// headers, so it is passed as ?access_token=; the login token must never be put in a URL.
+func CreateStreamToken(c *gin.Context) {
+	tenant := c.MustGet("tenant").(*models.Tenant)
+	userID, _ := c.Get("user_id")
+	role, _ := c.Get("role")
+	userIDStr, _ := userID.(string)
+	roleStr, _ := role.(string)
+
+	token, ttl, err := middleware.IssueStreamToken(userIDStr, roleStr, tenant.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token, "expires_in": int(ttl.Seconds())})
+}
+
+// StreamEvents streams the current user's notifications and the tenant's booking activity as server-sent events.
+// Clients without headers pass a token from CreateStreamToken as ?access_token=.
+func StreamEvents(c *gin.Context) {
+	_, tenant, userID, err := getTenantDBForNotifications(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	sub := realtimeHub.Subscribe(tenant.ID, userID)
+	defer realtimeHub.Unsubscribe(sub)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Disable proxy buffering (nginx)
+	c.Status(http.StatusOK)
+	c.Writer.WriteString("retry: 5000\n\n")
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-heartbeat.C:
+			io.WriteString(w, ": ping\n\n")
+			return true
+		case e := <-sub.C:
+			data := e.Data
+			if data == nil {
+				data = json.RawMessage("null")
+			}
+			c.SSEvent(e.Type, data)
+			return true
+		}
+	})
+}
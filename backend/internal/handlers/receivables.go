@@ -0,0 +1,517 @@
+package handlers
+
+import (
+	"car-rental-backend/internal/events"
+	"car-rental-backend/internal/mail"
+	"car-rental-backend/internal/messaging"
+	"car-rental-backend/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Payment represents a payment received against an invoice
+type Payment struct {
+	ID        string    `json:"id"`
+	InvoiceID string    `json:"invoice_id"`
+	Amount    float64   `json:"amount"`
+	Method    string    `json:"method"`
+	Reference string    `json:"reference"`
+	PaidAt    time.Time `json:"paid_at"`
+}
+
+type RecordPaymentRequest struct {
+	Amount    float64   `json:"amount" binding:"required,gt=0"`
+	Method    string    `json:"method"`
+	Reference string    `json:"reference"`
+	PaidAt    time.Time `json:"paid_at"`
+}
+
+// AgingInvoice is an unpaid invoice placed in an aging bucket
+type AgingInvoice struct {
+	ID           string    `json:"id"`
+	BookingID    string    `json:"booking_id"`
+	CustomerName string    `json:"customer_name"`
+	Currency     string    `json:"currency"`
+	Amount       float64   `json:"amount"`
+	Paid         float64   `json:"paid"`
+	Outstanding  float64   `json:"outstanding"`
+	DueDate      time.Time `json:"due_date"`
+	DaysOverdue  int       `json:"days_overdue"`
+	Bucket       string    `json:"bucket"`
+}
+
+type AgingBucket struct {
+	Bucket string  `json:"bucket"`
+	Count  int     `json:"count"`
+	Total  float64 `json:"total"` // In base currency
+}
+
+type AgingReport struct {
+	AsOf             string         `json:"as_of"`
+	BaseCurrency     string         `json:"base_currency"`
+	Buckets          []AgingBucket  `json:"buckets"`
+	TotalOutstanding float64        `json:"total_outstanding"`
+	MissingRates     []string       `json:"missing_rates"` // Currencies without an exchange rate, excluded from totals
+	Invoices         []AgingInvoice `json:"invoices"`
+}
+
+// DunningSettings controls the automated overdue invoice reminders
+type DunningSettings struct {
+	Enabled   bool  `json:"enabled"`
+	Intervals []int `json:"intervals"`
+	SendEmail bool  `json:"send_email"`
+	SendSMS   bool  `json:"send_sms"`
+}
+
+// DunningReminder is a reminder already sent for an overdue invoice
+type DunningReminder struct {
+	ID        string    `json:"id"`
+	InvoiceID string    `json:"invoice_id"`
+	Stage     int       `json:"stage"`
+	Channel   string    `json:"channel"`
+	Recipient string    `json:"recipient"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	SentAt    time.Time `json:"sent_at"`
+}
+
+// agingBuckets lists the aging buckets in display order
+var agingBuckets = []string{"current", "1-30", "31-60", "61-90", "90+"}
+
+// defaultDunningSettings is used when a tenant has not configured dunning yet
+var defaultDunningSettings = DunningSettings{
+	Enabled:   true,
+	Intervals: []int{1, 7, 14, 30},
+	SendEmail: true,
+	SendSMS:   false,
+}
+
+// agingBucketFor returns the aging bucket for a number of days past due
+func agingBucketFor(daysOverdue int) string {
+	switch {
+	case daysOverdue <= 0:
+		return "current"
+	case daysOverdue <= 30:
+		return "1-30"
+	case daysOverdue <= 60:
+		return "31-60"
+	case daysOverdue <= 90:
+		return "61-90"
+	default:
+		return "90+"
+	}
+}
+
+// GetInvoicePayments returns the payments recorded for an invoice
+func GetInvoicePayments(c *gin.Context) {
+	invoiceID := c.Param("id")
+	db, _, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	rows, err := db.Query(context.Background(),
+		`SELECT id, invoice_id, amount, COALESCE(method, ''), COALESCE(reference, ''), paid_at
+		 FROM payments WHERE invoice_id = $1 ORDER BY paid_at, created_at`, invoiceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payments: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var payments []Payment
+	for rows.Next() {
+		var p Payment
+		if err := rows.Scan(&p.ID, &p.InvoiceID, &p.Amount, &p.Method, &p.Reference, &p.PaidAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan payment: " + err.Error()})
+			return
+		}
+		payments = append(payments, p)
+	}
+
+	if payments == nil {
+		payments = []Payment{}
+	}
+
+	c.JSON(http.StatusOK, payments)
+}
+
+// RecordPayment registers a payment against an invoice and updates its status
+func RecordPayment(c *gin.Context) {
+	invoiceID := c.Param("id")
+	var req RecordPaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Method == "" {
+		req.Method = "cash"
+	}
+	if req.PaidAt.IsZero() {
+		req.PaidAt = time.Now()
+	}
+
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	if !ensurePeriodOpen(c, db, tenant.ID, req.PaidAt) {
+		return
+	}
+
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// The invoice row is locked so that concurrent payments are summed one after the other. Amounts are compared
+	// in cents so that float rounding never lets an invoice be overpaid or leaves it a cent short of paid.
+	var invoiceCents int64
+	var bookingID, currency, invoiceStatus string
+	err = tx.QueryRow(ctx,
+		`SELECT ROUND(amount * 100)::bigint, COALESCE(booking_id::text, ''), COALESCE(currency, 'MAD'), COALESCE(status, '')
+		 FROM invoices WHERE id = $1 FOR UPDATE`, invoiceID).Scan(&invoiceCents, &bookingID, &currency, &invoiceStatus)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
+		return
+	}
+	if invoiceStatus == "Cancelled" || invoiceStatus == "Paid" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Payments cannot be recorded on a " + strings.ToLower(invoiceStatus) + " invoice"})
+		return
+	}
+	var paidCents int64
+	err = tx.QueryRow(ctx,
+		"SELECT ROUND(COALESCE(SUM(amount), 0) * 100)::bigint FROM payments WHERE invoice_id = $1", invoiceID).Scan(&paidCents)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payments: " + err.Error()})
+		return
+	}
+	amountCents := int64(math.Round(req.Amount * 100))
+	if amountCents <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payment amount must be at least 0.01"})
+		return
+	}
+	if paidCents+amountCents > invoiceCents {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":       "Payment exceeds the outstanding balance of the invoice",
+			"outstanding": float64(invoiceCents-paidCents) / 100,
+		})
+		return
+	}
+
+	var paymentID string
+	err = tx.QueryRow(ctx,
+		"INSERT INTO payments (tenant_id, invoice_id, amount, method, reference, paid_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		tenant.ID, invoiceID, float64(amountCents)/100, req.Method, req.Reference, req.PaidAt).Scan(&paymentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record payment: " + err.Error()})
+		return
+	}
+
+	status := "Partially Paid"
+	if paidCents+amountCents == invoiceCents {
+		status = "Paid"
+	}
+	_, err = tx.Exec(ctx, "UPDATE invoices SET status = $1 WHERE id = $2", status, invoiceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update invoice status: " + err.Error()})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record payment: " + err.Error()})
+		return
+	}
+
+	// Only the payment that settles the invoice publishes invoice.paid
+	if status == "Paid" {
+		events.Publish(events.Event{
+			Type:     events.InvoicePaid,
+			Tenant:   tenant,
+			DB:       db,
+			ActorID:  c.GetString("user_id"),
+			EntityID: invoiceID,
+			Data: map[string]interface{}{
+				"id":         invoiceID,
+				"booking_id": bookingID,
+				"amount":     float64(invoiceCents) / 100,
+				"paid":       float64(paidCents+amountCents) / 100,
+				"currency":   currency,
+				"payment_id": paymentID,
+			},
+		})
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Payment recorded successfully", "id": paymentID, "invoice_status": status})
+}
+
+// GetReceivablesAging returns outstanding invoice balances grouped by days past due
+func GetReceivablesAging(c *gin.Context) {
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	asOf := time.Now()
+	if v := c.Query("as_of"); v != "" {
+		asOf, err = time.Parse("2006-01-02", v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid as_of date, expected YYYY-MM-DD"})
+			return
+		}
+	}
+	asOfDate := asOf.Format("2006-01-02")
+
+	// Outstanding balance = invoice amount minus payments received up to the report date
+	query := `
+		SELECT i.id, i.booking_id, COALESCE(i.currency, 'MAD'), i.amount,
+		       COALESCE(stored_rate(i.exchange_rate, i.exchange_rate_base, $2), fx_rate(COALESCE(i.currency, 'MAD'), $2, i.created_at::date)) as rate,
+		       COALESCE((SELECT SUM(p.amount) FROM payments p WHERE p.invoice_id = i.id AND p.paid_at <= $1::date), 0) as paid,
+		       i.due_date, ($1::date - i.due_date) as days_overdue,
+		       COALESCE(cust.first_name || ' ' || cust.last_name, 'Unknown') as customer_name
+		FROM invoices i
+		JOIN bookings b ON i.booking_id = b.id
+		LEFT JOIN customers cust ON b.customer_id = cust.id
+		WHERE i.created_at::date <= $1::date AND i.status != 'Cancelled'
+		ORDER BY i.due_date
+	`
+	baseCurrency := GetBaseCurrency(db, tenant.ID)
+	rows, err := db.Query(context.Background(), query, asOfDate, baseCurrency)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoices: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	totals := make(map[string]*AgingBucket)
+	for _, name := range agingBuckets {
+		totals[name] = &AgingBucket{Bucket: name}
+	}
+
+	report := AgingReport{AsOf: asOfDate, BaseCurrency: baseCurrency, MissingRates: []string{}, Invoices: []AgingInvoice{}}
+	missing := map[string]bool{}
+	for rows.Next() {
+		var inv AgingInvoice
+		var rate *float64
+		if err := rows.Scan(&inv.ID, &inv.BookingID, &inv.Currency, &inv.Amount, &rate, &inv.Paid, &inv.DueDate, &inv.DaysOverdue, &inv.CustomerName); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan invoice: " + err.Error()})
+			return
+		}
+		inv.Outstanding = inv.Amount - inv.Paid
+		if inv.Outstanding <= 0 {
+			continue
+		}
+		inv.Bucket = agingBucketFor(inv.DaysOverdue)
+		report.Invoices = append(report.Invoices, inv)
+
+		totals[inv.Bucket].Count++
+		if rate == nil {
+			if !missing[inv.Currency] {
+				missing[inv.Currency] = true
+				report.MissingRates = append(report.MissingRates, inv.Currency)
+			}
+			continue
+		}
+		totals[inv.Bucket].Total += inv.Outstanding * *rate
+		report.TotalOutstanding += inv.Outstanding * *rate
+	}
+
+	for _, name := range agingBuckets {
+		report.Buckets = append(report.Buckets, *totals[name])
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// GetDunningSettings returns the overdue reminder settings for the tenant
+func GetDunningSettings(c *gin.Context) {
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	settings, err := LoadDunningSettings(db, tenant.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch dunning settings: " + err.Error()})
+		return
+	}
+	if settings.Intervals == nil {
+		settings.Intervals = []int{}
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// LoadDunningSettings returns the tenant's dunning settings, or the defaults if none are saved
+func LoadDunningSettings(db *pgxpool.Pool, tenantID string) (DunningSettings, error) {
+	settings := defaultDunningSettings
+	var intervalsJSON []byte
+	err := db.QueryRow(context.Background(),
+		"SELECT enabled, intervals, send_email, send_sms FROM dunning_settings WHERE tenant_id = $1",
+		tenantID).Scan(&settings.Enabled, &intervalsJSON, &settings.SendEmail, &settings.SendSMS)
+	if err == pgx.ErrNoRows {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, err
+	}
+	settings.Intervals = nil
+	if err := json.Unmarshal(intervalsJSON, &settings.Intervals); err != nil {
+		return settings, err
+	}
+	return settings, nil
+}
+
+// PaymentReminder is a reminder of an overdue invoice to send to its customer
+type PaymentReminder struct {
+	InvoiceID    string
+	CustomerID   string
+	CustomerName string
+	Outstanding  float64
+	Currency     string
+	DueDate      time.Time
+	DaysOverdue  int
+}
+
+func (r PaymentReminder) templateData() map[string]interface{} {
+	return map[string]interface{}{
+		"CustomerName": r.CustomerName,
+		"Number":       shortRef(r.InvoiceID),
+		"Outstanding":  formatMoney(r.Outstanding, r.Currency),
+		"DueDate":      r.DueDate.Format("2006-01-02"),
+		"DaysOverdue":  r.DaysOverdue,
+	}
+}
+
+// QueuePaymentReminderEmail saves a branded payment reminder for the customer to the email outbox
+func QueuePaymentReminderEmail(db *pgxpool.Pool, tenant *models.Tenant, to string, reminder PaymentReminder) error {
+	if strings.TrimSpace(to) == "" {
+		return errors.New("no email address")
+	}
+	return queueEmail(db, tenant, mail.TemplatePaymentReminder, to, reminder.CustomerID, reminder.templateData())
+}
+
+// QueuePaymentReminderMessage saves a payment reminder for the customer to the message outbox. Unlike the
+// booking messages, it fails when the tenant's messaging is off so that the reminder is not recorded as sent.
+func QueuePaymentReminderMessage(db *pgxpool.Pool, tenant *models.Tenant, phone string, reminder PaymentReminder) error {
+	settings, err := LoadMessagingSettings(db, tenant.ID)
+	if err != nil {
+		return err
+	}
+	if !settings.Enabled {
+		return errors.New("messaging is not enabled")
+	}
+	to := internationalPhone(phone, settings.CountryCode)
+	if len(to) < 8 {
+		return fmt.Errorf("invalid phone number %q", phone)
+	}
+	body, err := messaging.Render(messaging.TemplatePaymentReminder, tenant.Name, reminder.templateData())
+	if err != nil {
+		return err
+	}
+	_, err = messaging.Enqueue(db, messaging.OutboxMessage{
+		TenantID:   tenant.ID,
+		Template:   messaging.TemplatePaymentReminder,
+		CustomerID: reminder.CustomerID,
+		Message:    messaging.Message{Channel: settings.Channel, To: to, From: settings.Sender, Body: body},
+	})
+	return err
+}
+
+// UpdateDunningSettings updates the overdue reminder settings for the tenant
+func UpdateDunningSettings(c *gin.Context) {
+	var req DunningSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, days := range req.Intervals {
+		if days < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Reminder intervals must be at least 1 day overdue"})
+			return
+		}
+	}
+
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	intervalsJSON, _ := json.Marshal(req.Intervals)
+	if req.Intervals == nil {
+		intervalsJSON = []byte("[]")
+	}
+
+	_, err = db.Exec(context.Background(),
+		`INSERT INTO dunning_settings (tenant_id, enabled, intervals, send_email, send_sms, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, NOW())
+		 ON CONFLICT (tenant_id) DO UPDATE SET
+		 enabled = $2, intervals = $3, send_email = $4, send_sms = $5, updated_at = NOW()`,
+		tenant.ID, req.Enabled, intervalsJSON, req.SendEmail, req.SendSMS)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update dunning settings: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Dunning settings updated successfully"})
+}
+
+// GetDunningReminders returns the reminders sent for overdue invoices
+func GetDunningReminders(c *gin.Context) {
+	db, _, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	query := `
+		SELECT id, invoice_id, stage, channel, COALESCE(recipient, ''), status, COALESCE(error, ''), sent_at
+		FROM dunning_reminders
+		WHERE ($1 = '' OR invoice_id::text = $1)
+		ORDER BY sent_at DESC
+		LIMIT 200
+	`
+	rows, err := db.Query(context.Background(), query, c.Query("invoice_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reminders: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var reminders []DunningReminder
+	for rows.Next() {
+		var r DunningReminder
+		if err := rows.Scan(&r.ID, &r.InvoiceID, &r.Stage, &r.Channel, &r.Recipient, &r.Status, &r.Error, &r.SentAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan reminder: " + err.Error()})
+			return
+		}
+		reminders = append(reminders, r)
+	}
+
+	if reminders == nil {
+		reminders = []DunningReminder{}
+	}
+
+	c.JSON(http.StatusOK, reminders)
+}
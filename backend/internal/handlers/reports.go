@@ -4,23 +4,87 @@ import (
 	"car-rental-backend/internal/database"
 	"car-rental-backend/internal/models"
 	"context"
+	"fmt"
+	"math"
 	"net/http"
+	"sort"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// UtilizationStat is the share of available cars that were rented on a day
 type UtilizationStat struct {
-	Date       string  `json:"date"`
-	Percentage float64 `json:"percentage"`
+	Date          string  `json:"date"`
+	Percentage    float64 `json:"percentage"`
+	RentedCars    int     `json:"rented_cars"`
+	AvailableCars int     `json:"available_cars"` // In service and not blocked
 }
 
+// UtilizationGroup aggregates rented and available car-days for a category
+type UtilizationGroup struct {
+	Category      string  `json:"category"`
+	Cars          int     `json:"cars"`
+	RentedDays    int     `json:"rented_days"`
+	AvailableDays int     `json:"available_days"`
+	Percentage    float64 `json:"percentage"`
+}
+
+// CarUtilization is one car's utilization over the range.
+// An idle streak is a run of consecutive available days without a rental.
+type CarUtilization struct {
+	CarID             string  `json:"car_id"`
+	Make              string  `json:"make"`
+	Model             string  `json:"model"`
+	LicensePlate      string  `json:"license_plate"`
+	Category          string  `json:"category"`
+	RentedDays        int     `json:"rented_days"`
+	BlockedDays       int     `json:"blocked_days"`
+	AvailableDays     int     `json:"available_days"`
+	Percentage        float64 `json:"percentage"`
+	LongestIdleStreak int     `json:"longest_idle_streak"`
+	LongestIdleFrom   string  `json:"longest_idle_from,omitempty"`
+	LongestIdleTo     string  `json:"longest_idle_to,omitempty"`
+	CurrentIdleStreak int     `json:"current_idle_streak"` // Idle days up to the end of the range
+}
+
+type UtilizationReport struct {
+	From          string             `json:"from"`
+	To            string             `json:"to"`
+	RentedDays    int                `json:"rented_days"`
+	AvailableDays int                `json:"available_days"`
+	Percentage    float64            `json:"percentage"`
+	Daily         []UtilizationStat  `json:"daily"`
+	ByCategory    []UtilizationGroup `json:"by_category"`
+	ByCar         []CarUtilization   `json:"by_car"`
+}
+
+// maxUtilizationDays bounds the car x day series computed for one report
+const maxUtilizationDays = 366
+
+// utilizationDaysQuery returns one row per car and day in service between $1 and $2, flagged rented and/or blocked
+const utilizationDaysQuery = `
+	SELECT c.id, c.brand, c.model, c.license_plate, COALESCE(NULLIF(c.category, ''), 'Uncategorized') as category, d.day::date as day,
+	       EXISTS (SELECT 1 FROM bookings b
+	               WHERE b.car_id = c.id AND b.status IN ` + profitableBookingStatuses + `
+	                 AND d.day::date BETWEEN b.start_date AND b.end_date) as rented,
+	       EXISTS (SELECT 1 FROM car_blocks k
+	               WHERE k.car_id = c.id AND d.day::date BETWEEN k.start_date AND k.end_date) as blocked
+	FROM cars c
+	CROSS JOIN generate_series($1::date, $2::date, interval '1 day') d(day)
+	WHERE d.day::date >= COALESCE(c.purchase_date, c.created_at::date)`
+
 type RevenueByCar struct {
-	CarID        string  `json:"car_id"`
-	Make         string  `json:"make"`
-	Model        string  `json:"model"`
-	TotalRevenue float64 `json:"total_revenue"`
-	BookingCount int     `json:"booking_count"`
+	CarID           string  `json:"car_id"`
+	Make            string  `json:"make"`
+	Model           string  `json:"model"`
+	TotalRevenue    float64 `json:"total_revenue"` // In base currency
+	BaseCurrency    string  `json:"base_currency"`
+	OriginalRevenue float64 `json:"original_revenue"` // In the car's currency
+	Currency        string  `json:"currency"`
+	BookingCount    int     `json:"booking_count"`
+	MissingRate     bool    `json:"missing_rate"` // Some bookings were excluded from TotalRevenue for lack of an exchange rate
 }
 
 // Helper to get tenant DB connection (reused logic)
@@ -33,82 +97,265 @@ func getTenantDBForReports(c *gin.Context) (*pgxpool.Pool, error) {
 	return database.GetTenantDB(tenant.DBName)
 }
 
+// GetFleetUtilization computes daily utilization for ?from=&to= (default: the last 7 days) from
+// bookings and car blocks, with a breakdown by car category and by car.
+// A car counts from its purchase (or creation) date; blocked days are not available unless the car was rented anyway.
+// ?format=csv|xlsx downloads one of the breakdowns, chosen with ?view=by_car (default), by_category or daily.
 func GetFleetUtilization(c *gin.Context) {
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
+	view := c.DefaultQuery("view", "by_car")
+	if view != "by_car" && view != "by_category" && view != "daily" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid view. Allowed: by_car, by_category, daily"})
+		return
+	}
+
 	db, err := getTenantDBForReports(c)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
 		return
 	}
 
-	// This is a simplified utilization calculation.
-	// In a real app, we'd check daily availability vs bookings.
-	// Here, we'll just mock some data based on current bookings count vs total cars for the last 7 days.
+	now := time.Now()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	from := to.AddDate(0, 0, -6)
+	if c.Query("from") != "" || c.Query("to") != "" {
+		if from, to, err = parseDateRange(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if int(to.Sub(from).Hours()/24) >= maxUtilizationDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Date range cannot exceed %d days", maxUtilizationDays)})
+		return
+	}
 
-	// 1. Get Total Cars
-	var totalCars int
-	err = db.QueryRow(context.Background(), "SELECT COUNT(*) FROM cars").Scan(&totalCars)
+	rows, err := db.Query(context.Background(), utilizationDaysQuery+" ORDER BY c.id, d.day", from, to)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count cars: " + err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute utilization: " + err.Error()})
 		return
 	}
+	defer rows.Close()
+
+	report := UtilizationReport{From: from.Format("2006-01-02"), To: to.Format("2006-01-02")}
+	daily := map[string]*UtilizationStat{}
+	categories := map[string]*UtilizationGroup{}
+	var cars []*CarUtilization
+	var car *CarUtilization
+	var idleStart string
+
+	for rows.Next() {
+		var carID, brand, model, plate, category string
+		var day time.Time
+		var rented, blocked bool
+		if err := rows.Scan(&carID, &brand, &model, &plate, &category, &day, &rented, &blocked); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan utilization: " + err.Error()})
+			return
+		}
+
+		if car == nil || car.CarID != carID {
+			car = &CarUtilization{CarID: carID, Make: brand, Model: model, LicensePlate: plate, Category: category}
+			cars = append(cars, car)
+			if categories[category] == nil {
+				categories[category] = &UtilizationGroup{Category: category}
+			}
+			categories[category].Cars++
+		}
+
+		date := day.Format("2006-01-02")
+		if daily[date] == nil {
+			daily[date] = &UtilizationStat{Date: date}
+		}
+		available := rented || !blocked
+
+		if blocked && !rented {
+			car.BlockedDays++
+		}
+		if available {
+			car.AvailableDays++
+			daily[date].AvailableCars++
+			categories[category].AvailableDays++
+		}
+		if rented {
+			car.RentedDays++
+			daily[date].RentedCars++
+			categories[category].RentedDays++
+		}
+
+		// Blocked days end an idle streak as well as rentals do
+		if available && !rented {
+			if car.CurrentIdleStreak == 0 {
+				idleStart = date
+			}
+			car.CurrentIdleStreak++
+			if car.CurrentIdleStreak > car.LongestIdleStreak {
+				car.LongestIdleStreak = car.CurrentIdleStreak
+				car.LongestIdleFrom = idleStart
+				car.LongestIdleTo = date
+			}
+		} else {
+			car.CurrentIdleStreak = 0
+		}
+	}
 
-	if totalCars == 0 {
-		c.JSON(http.StatusOK, []UtilizationStat{})
+	report.Daily = []UtilizationStat{}
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		stat := UtilizationStat{Date: d.Format("2006-01-02")}
+		if s, ok := daily[stat.Date]; ok {
+			stat = *s
+		}
+		stat.Percentage = utilizationPercentage(stat.RentedCars, stat.AvailableCars)
+		report.Daily = append(report.Daily, stat)
+	}
+
+	report.ByCategory = []UtilizationGroup{}
+	for _, g := range categories {
+		g.Percentage = utilizationPercentage(g.RentedDays, g.AvailableDays)
+		report.ByCategory = append(report.ByCategory, *g)
+	}
+	sort.Slice(report.ByCategory, func(i, j int) bool {
+		return report.ByCategory[i].Percentage > report.ByCategory[j].Percentage
+	})
+
+	report.ByCar = []CarUtilization{}
+	for _, car := range cars {
+		car.Percentage = utilizationPercentage(car.RentedDays, car.AvailableDays)
+		report.RentedDays += car.RentedDays
+		report.AvailableDays += car.AvailableDays
+		report.ByCar = append(report.ByCar, *car)
+	}
+	// Least used cars first, they are the ones to act on
+	sort.Slice(report.ByCar, func(i, j int) bool {
+		return report.ByCar[i].Percentage < report.ByCar[j].Percentage
+	})
+	report.Percentage = utilizationPercentage(report.RentedDays, report.AvailableDays)
+
+	if format != "" {
+		exportUtilization(c, format, report, view)
 		return
 	}
 
-	// 2. Get Active Bookings for last 7 days (Mock logic for simplicity in this demo)
-	// We will just return a static trend for demonstration purposes as complex date-range SQL is verbose
-	// Real implementation would involve generating a date series and joining with bookings
+	c.JSON(http.StatusOK, report)
+}
 
-	stats := []UtilizationStat{
-		{Date: "2023-11-22", Percentage: 45.0},
-		{Date: "2023-11-23", Percentage: 50.0},
-		{Date: "2023-11-24", Percentage: 65.0},
-		{Date: "2023-11-25", Percentage: 80.0},
-		{Date: "2023-11-26", Percentage: 75.0},
-		{Date: "2023-11-27", Percentage: 60.0},
-		{Date: "2023-11-28", Percentage: 55.0},
+// exportUtilization downloads one breakdown of the utilization report
+func exportUtilization(c *gin.Context, format string, report UtilizationReport, view string) {
+	tenant := c.MustGet("tenant").(*models.Tenant)
+	title := fmt.Sprintf("Fleet utilization %s to %s", report.From, report.To)
+
+	var columns []string
+	var rows [][]interface{}
+	switch view {
+	case "daily":
+		columns = []string{"Date", "Rented cars", "Available cars", "Utilization %"}
+		for _, d := range report.Daily {
+			rows = append(rows, []interface{}{d.Date, d.RentedCars, d.AvailableCars, d.Percentage})
+		}
+	case "by_category":
+		columns = []string{"Category", "Cars", "Rented days", "Available days", "Utilization %"}
+		for _, g := range report.ByCategory {
+			rows = append(rows, []interface{}{g.Category, g.Cars, g.RentedDays, g.AvailableDays, g.Percentage})
+		}
+	default:
+		columns = []string{"Car ID", "Make", "Model", "License plate", "Category", "Rented days", "Blocked days",
+			"Available days", "Utilization %", "Longest idle streak", "Idle from", "Idle to", "Current idle streak"}
+		for _, car := range report.ByCar {
+			rows = append(rows, []interface{}{car.CarID, car.Make, car.Model, car.LicensePlate, car.Category,
+				car.RentedDays, car.BlockedDays, car.AvailableDays, car.Percentage,
+				car.LongestIdleStreak, car.LongestIdleFrom, car.LongestIdleTo, car.CurrentIdleStreak})
+		}
 	}
 
-	c.JSON(http.StatusOK, stats)
+	exp, err := startExport(c, format, tenant, "utilization", title, columns...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start export: " + err.Error()})
+		return
+	}
+	for _, row := range rows {
+		if err := exp.Row(row...); err != nil {
+			return
+		}
+	}
+	finishExport(exp)
 }
 
+// utilizationPercentage returns rented / available as a percentage rounded to one decimal
+func utilizationPercentage(rented, available int) float64 {
+	if available == 0 {
+		return 0
+	}
+	return math.Round(float64(rented)/float64(available)*1000) / 10
+}
+
+// GetRevenueByCar returns the top 10 cars by revenue, or downloads them with ?format=csv|xlsx
 func GetRevenueByCar(c *gin.Context) {
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
+
 	db, err := getTenantDBForReports(c)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
 		return
 	}
+	tenant := c.MustGet("tenant").(*models.Tenant)
+	baseCurrency := GetBaseCurrency(db, tenant.ID)
 
+	// Booking amounts are converted at the rate fixed on the booking, or the rate at booking date
 	query := `
-		SELECT c.id, c.brand, c.model, 
-		       COALESCE(SUM(b.price_per_day * ((b.end_date - b.start_date) + 1)), 0) as total_revenue,
-		       COUNT(b.id) as booking_count
+		SELECT c.id, c.brand, c.model, COALESCE(c.currency, 'MAD'),
+		       COALESCE(SUM(b.price_per_day * ((b.end_date - b.start_date) + 1)
+		                    * COALESCE(stored_rate(b.exchange_rate, b.exchange_rate_base, $1), fx_rate(COALESCE(b.currency, 'MAD'), $1, b.created_at::date))), 0) as total_revenue,
+		       COALESCE(SUM(b.price_per_day * ((b.end_date - b.start_date) + 1)), 0) as original_revenue,
+		       COUNT(b.id) as booking_count,
+		       COALESCE(BOOL_OR(b.id IS NOT NULL AND COALESCE(stored_rate(b.exchange_rate, b.exchange_rate_base, $1),
+		                                                      fx_rate(COALESCE(b.currency, 'MAD'), $1, b.created_at::date)) IS NULL), false) as missing_rate
 		FROM cars c
 		LEFT JOIN bookings b ON c.id = b.car_id AND b.status != 'maintenance'
-		GROUP BY c.id, c.brand, c.model
+		GROUP BY c.id, c.brand, c.model, c.currency
 		ORDER BY total_revenue DESC
 		LIMIT 10
 	`
 
-	rows, err := db.Query(context.Background(), query)
+	rows, err := db.Query(context.Background(), query, baseCurrency)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch revenue stats: " + err.Error()})
 		return
 	}
 	defer rows.Close()
 
+	exp, err := startExport(c, format, tenant, "revenue-by-car", "Revenue by car",
+		"Car ID", "Make", "Model", "Bookings", "Revenue", "Currency", "Revenue ("+baseCurrency+")")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start export: " + err.Error()})
+		return
+	}
+
 	var stats []RevenueByCar
 	for rows.Next() {
 		var s RevenueByCar
-		if err := rows.Scan(&s.CarID, &s.Make, &s.Model, &s.TotalRevenue, &s.BookingCount); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan stats: " + err.Error()})
+		if err := rows.Scan(&s.CarID, &s.Make, &s.Model, &s.Currency, &s.TotalRevenue, &s.OriginalRevenue, &s.BookingCount, &s.MissingRate); err != nil {
+			rowsFailed(c, exp, "Failed to scan stats", err)
 			return
 		}
+		s.BaseCurrency = baseCurrency
+		if exp != nil {
+			if err := exp.Row(s.CarID, s.Make, s.Model, s.BookingCount, s.OriginalRevenue, s.Currency, s.TotalRevenue); err != nil {
+				return
+			}
+			continue
+		}
 		stats = append(stats, s)
 	}
 
+	if exp != nil {
+		finishExport(exp)
+		return
+	}
+
 	if stats == nil {
 		stats = []RevenueByCar{}
 	}
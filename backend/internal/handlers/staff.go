@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"car-rental-backend/internal/database"
+	"car-rental-backend/internal/events"
 	"car-rental-backend/internal/models"
 	"context"
 	"fmt"
@@ -149,6 +150,20 @@ func CreateStaff(c *gin.Context) {
 		return
 	}
 
+	events.Publish(events.Event{
+		Type:     events.StaffAdded,
+		Tenant:   tenantModel,
+		DB:       pool,
+		ActorID:  c.GetString("user_id"),
+		EntityID: userID,
+		Data: map[string]interface{}{
+			"id":      userID,
+			"email":   req.Email,
+			"name":    strings.TrimSpace(req.FirstName + " " + req.LastName),
+			"role_id": req.RoleID,
+		},
+	})
+
 	c.JSON(http.StatusCreated, gin.H{"id": userID, "message": "Staff member created successfully"})
 }
 
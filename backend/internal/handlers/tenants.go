@@ -177,30 +177,6 @@ func GetAllTenants(c *gin.Context) {
 	c.JSON(http.StatusOK, tenants)
 }
 
-func GetAdminStats(c *gin.Context) {
-	var totalTenants int
-	// Exclude the admin tenant from counts
-	err := database.DB.QueryRow(context.Background(), "SELECT COUNT(*) FROM tenants WHERE subdomain != 'admin'").Scan(&totalTenants)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stats"})
-		return
-	}
-
-	// Count tenants created this month (excluding admin)
-	var newThisMonth int
-	err = database.DB.QueryRow(context.Background(),
-		"SELECT COUNT(*) FROM tenants WHERE created_at >= date_trunc('month', CURRENT_DATE) AND subdomain != 'admin'").Scan(&newThisMonth)
-	if err != nil {
-		newThisMonth = 0
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"total_tenants":  totalTenants,
-		"active_tenants": totalTenants, // For now, all are considered active
-		"new_this_month": newThisMonth,
-	})
-}
-
 func ImpersonateTenant(c *gin.Context) {
 	id := c.Param("id")
 
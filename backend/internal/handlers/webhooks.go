@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"car-rental-backend/internal/audit"
+	"car-rental-backend/internal/events"
+	"car-rental-backend/internal/webhooks"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WebhookEndpoint is a tenant URL notified of events. The secret is only returned when the endpoint is created
+// or its secret rotated.
+type WebhookEndpoint struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	Description string    `json:"description"`
+	Events      []string  `json:"events"`
+	Active      bool      `json:"active"`
+	Secret      string    `json:"secret,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type CreateWebhookEndpointRequest struct {
+	URL         string   `json:"url" binding:"required"`
+	Description string   `json:"description"`
+	Events      []string `json:"events" binding:"required,min=1"`
+}
+
+type UpdateWebhookEndpointRequest struct {
+	URL         *string  `json:"url"`
+	Description *string  `json:"description"`
+	Events      []string `json:"events"`
+	Active      *bool    `json:"active"`
+}
+
+// WebhookDelivery is an entry of the delivery log
+type WebhookDelivery struct {
+	ID             string     `json:"id"`
+	EndpointID     string     `json:"endpoint_id"`
+	EventID        string     `json:"event_id"`
+	EventType      string     `json:"event_type"`
+	Payload        string     `json:"payload"`
+	Status         string     `json:"status"` // pending, succeeded, failed
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at"`
+	LastAttemptAt  *time.Time `json:"last_attempt_at"`
+	ResponseStatus *int       `json:"response_status"`
+	ResponseBody   string     `json:"response_body"`
+	DurationMs     *int64     `json:"duration_ms"`
+	LastError      string     `json:"last_error"`
+	ReplayOf       *string    `json:"replay_of"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// SubscribeWebhooks queues the webhook deliveries of the domain events; called once from main
+func SubscribeWebhooks(bus *events.Bus) {
+	for _, eventType := range webhooks.Events {
+		bus.Subscribe(eventType, func(e events.Event) {
+			payload, err := webhooks.NewPayload(e.Type, e.Data, e.OccurredAt)
+			if err == nil {
+				_, err = webhooks.Enqueue(e.DB, e.Tenant.ID, payload)
+			}
+			if err != nil {
+				log.Printf("[WEBHOOKS] Failed to queue %s: %v", e.Type, err)
+			}
+		})
+	}
+}
+
+// validateWebhookEvents checks that every event can be subscribed to and removes duplicates
+func validateWebhookEvents(list []string) ([]string, error) {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, e := range list {
+		e = strings.TrimSpace(e)
+		if !webhooks.IsEvent(e) {
+			return nil, fmt.Errorf("Unknown event: %s", e)
+		}
+		if !seen[e] {
+			seen[e] = true
+			result = append(result, e)
+		}
+	}
+	return result, nil
+}
+
+// GetWebhookEvents lists the events endpoints can subscribe to
+func GetWebhookEvents(c *gin.Context) {
+	c.JSON(http.StatusOK, webhooks.Events)
+}
+
+// GetWebhookEndpoints lists the tenant's webhook endpoints
+func GetWebhookEndpoints(c *gin.Context) {
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT id, url, COALESCE(description, ''), events, active, created_at, updated_at
+		FROM webhook_endpoints
+		WHERE tenant_id = $1
+		ORDER BY created_at`, tenant.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch webhooks: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	endpoints := []WebhookEndpoint{}
+	for rows.Next() {
+		var e WebhookEndpoint
+		if err := rows.Scan(&e.ID, &e.URL, &e.Description, &e.Events, &e.Active, &e.CreatedAt, &e.UpdatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan webhook: " + err.Error()})
+			return
+		}
+		endpoints = append(endpoints, e)
+	}
+
+	c.JSON(http.StatusOK, endpoints)
+}
+
+// CreateWebhookEndpoint registers an endpoint. The response holds the signing secret, which isn't shown again.
+func CreateWebhookEndpoint(c *gin.Context) {
+	var req CreateWebhookEndpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.URL = strings.TrimSpace(req.URL)
+	if err := webhooks.ValidateURL(req.URL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	eventList, err := validateWebhookEvents(req.Events)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+
+	e := WebhookEndpoint{URL: req.URL, Description: req.Description, Events: eventList, Active: true, Secret: secret}
+	err = db.QueryRow(context.Background(),
+		`INSERT INTO webhook_endpoints (tenant_id, url, description, events, secret, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at, updated_at`,
+		tenant.ID, e.URL, nullIfEmpty(e.Description), e.Events, secret, nullIfEmpty(c.GetString("user_id"))).
+		Scan(&e.ID, &e.CreatedAt, &e.UpdatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook: " + err.Error()})
+		return
+	}
+
+	audit.LogAudit(c, "CREATE_WEBHOOK", gin.H{"webhook_id": e.ID, "url": e.URL, "events": e.Events})
+
+	c.JSON(http.StatusCreated, e)
+}
+
+// UpdateWebhookEndpoint changes an endpoint's URL, description, events or active flag
+func UpdateWebhookEndpoint(c *gin.Context) {
+	var req UpdateWebhookEndpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	setClauses := []string{}
+	args := []interface{}{}
+	argIndex := 1
+
+	if req.URL != nil {
+		url := strings.TrimSpace(*req.URL)
+		if err := webhooks.ValidateURL(url); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		setClauses = append(setClauses, fmt.Sprintf("url = $%d", argIndex))
+		args = append(args, url)
+		argIndex++
+	}
+	if req.Description != nil {
+		setClauses = append(setClauses, fmt.Sprintf("description = $%d", argIndex))
+		args = append(args, nullIfEmpty(*req.Description))
+		argIndex++
+	}
+	if req.Events != nil {
+		eventList, err := validateWebhookEvents(req.Events)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(eventList) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "At least one event is required"})
+			return
+		}
+		setClauses = append(setClauses, fmt.Sprintf("events = $%d", argIndex))
+		args = append(args, eventList)
+		argIndex++
+	}
+	if req.Active != nil {
+		setClauses = append(setClauses, fmt.Sprintf("active = $%d", argIndex))
+		args = append(args, *req.Active)
+		argIndex++
+	}
+	if len(setClauses) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields to update"})
+		return
+	}
+
+	db, _, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	query := fmt.Sprintf("UPDATE webhook_endpoints SET %s, updated_at = NOW() WHERE id = $%d",
+		strings.Join(setClauses, ", "), argIndex)
+	args = append(args, c.Param("id"))
+	result, err := db.Exec(context.Background(), query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webhook: " + err.Error()})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+
+	audit.LogAudit(c, "UPDATE_WEBHOOK", gin.H{"webhook_id": c.Param("id")})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook updated successfully"})
+}
+
+// RotateWebhookSecret replaces an endpoint's signing secret and returns the new one
+func RotateWebhookSecret(c *gin.Context) {
+	db, _, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+	result, err := db.Exec(context.Background(),
+		"UPDATE webhook_endpoints SET secret = $1, updated_at = NOW() WHERE id = $2", secret, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate secret: " + err.Error()})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+
+	audit.LogAudit(c, "ROTATE_WEBHOOK_SECRET", gin.H{"webhook_id": c.Param("id")})
+
+	c.JSON(http.StatusOK, gin.H{"secret": secret})
+}
+
+// DeleteWebhookEndpoint removes an endpoint and its delivery log
+func DeleteWebhookEndpoint(c *gin.Context) {
+	db, _, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	result, err := db.Exec(context.Background(), "DELETE FROM webhook_endpoints WHERE id = $1", c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook: " + err.Error()})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+
+	audit.LogAudit(c, "DELETE_WEBHOOK", gin.H{"webhook_id": c.Param("id")})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted successfully"})
+}
+
+// GetWebhookDeliveries returns the delivery log, newest first. Filter with ?endpoint_id=, ?status= and ?event_type=.
+func GetWebhookDeliveries(c *gin.Context) {
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT id, endpoint_id, event_id, event_type, payload, status, attempts,
+		       CASE WHEN status = 'pending' THEN next_attempt_at END, last_attempt_at, response_status,
+		       COALESCE(response_body, ''), duration_ms, COALESCE(last_error, ''), replay_of, delivered_at, created_at
+		FROM webhook_deliveries
+		WHERE tenant_id = $1 AND ($2 = '' OR endpoint_id::text = $2) AND ($3 = '' OR status = $3)
+		  AND ($4 = '' OR event_type = $4)
+		ORDER BY created_at DESC
+		LIMIT 200`, tenant.ID, c.Query("endpoint_id"), c.Query("status"), c.Query("event_type"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deliveries: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.EndpointID, &d.EventID, &d.EventType, &d.Payload, &d.Status, &d.Attempts,
+			&d.NextAttemptAt, &d.LastAttemptAt, &d.ResponseStatus, &d.ResponseBody, &d.DurationMs, &d.LastError,
+			&d.ReplayOf, &d.DeliveredAt, &d.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan delivery: " + err.Error()})
+			return
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// ReplayWebhookDelivery sends a past delivery's payload to its endpoint again, as a new delivery
+func ReplayWebhookDelivery(c *gin.Context) {
+	db, _, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	id, err := webhooks.Replay(db, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to replay delivery: " + err.Error()})
+		return
+	}
+	if id == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+		return
+	}
+
+	audit.LogAudit(c, "REPLAY_WEBHOOK", gin.H{"delivery_id": c.Param("id"), "replay_id": id})
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Delivery queued again", "id": id})
+}
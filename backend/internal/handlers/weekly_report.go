@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"car-rental-backend/internal/mail"
+	"car-rental-backend/internal/models"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// WeeklyReport summarizes one week (Monday to Sunday) of a tenant. Amounts are in the base currency.
+type WeeklyReport struct {
+	From         string `json:"from"`
+	To           string `json:"to"`
+	BaseCurrency string `json:"base_currency"`
+
+	Revenue         float64  `json:"revenue"`
+	PreviousRevenue float64  `json:"previous_revenue"` // The week before
+	RevenueChange   *float64 `json:"revenue_change"`   // Percentage, null when there was no revenue the week before
+
+	NewBookings       int `json:"new_bookings"`       // Bookings created during the week
+	StartedRentals    int `json:"started_rentals"`    // Confirmed rentals picked up during the week
+	CancelledBookings int `json:"cancelled_bookings"` // Cancelled bookings that were due to start during the week
+
+	Utilization float64 `json:"utilization"`
+
+	OverdueInvoices    int     `json:"overdue_invoices"` // As of the end of the week
+	OverdueOutstanding float64 `json:"overdue_outstanding"`
+}
+
+// ReportPreferences are the report emails a user opted in to
+type ReportPreferences struct {
+	WeeklyReport bool `json:"weekly_report"`
+}
+
+// WeekStart returns the Monday of the week containing t
+func WeekStart(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+}
+
+// BuildWeeklyReport computes the report of the week starting on weekStart (a Monday)
+func BuildWeeklyReport(db *pgxpool.Pool, baseCurrency string, weekStart time.Time) (WeeklyReport, error) {
+	ctx := context.Background()
+	weekEnd := weekStart.AddDate(0, 0, 6)
+	r := WeeklyReport{From: weekStart.Format("2006-01-02"), To: weekEnd.Format("2006-01-02"), BaseCurrency: baseCurrency}
+
+	revenueQuery := `
+		SELECT COALESCE(SUM(i.amount * COALESCE(stored_rate(i.exchange_rate, i.exchange_rate_base, $3), fx_rate(COALESCE(i.currency, 'MAD'), $3, i.created_at::date))), 0)
+		FROM invoices i
+		WHERE i.created_at::date BETWEEN $1 AND $2 AND i.status != 'Cancelled'`
+	if err := db.QueryRow(ctx, revenueQuery, weekStart, weekEnd, baseCurrency).Scan(&r.Revenue); err != nil {
+		return r, err
+	}
+	if err := db.QueryRow(ctx, revenueQuery, weekStart.AddDate(0, 0, -7), weekStart.AddDate(0, 0, -1), baseCurrency).Scan(&r.PreviousRevenue); err != nil {
+		return r, err
+	}
+	if r.PreviousRevenue > 0 {
+		change := roundPercent((r.Revenue - r.PreviousRevenue) / r.PreviousRevenue * 100)
+		r.RevenueChange = &change
+	}
+
+	err := db.QueryRow(ctx, `
+		SELECT COUNT(*) FILTER (WHERE created_at::date BETWEEN $1 AND $2),
+		       COUNT(*) FILTER (WHERE start_date BETWEEN $1 AND $2 AND status IN `+profitableBookingStatuses+`),
+		       COUNT(*) FILTER (WHERE start_date BETWEEN $1 AND $2 AND status = 'cancelled')
+		FROM bookings`, weekStart, weekEnd).Scan(&r.NewBookings, &r.StartedRentals, &r.CancelledBookings)
+	if err != nil {
+		return r, err
+	}
+
+	var rented, available int
+	err = db.QueryRow(ctx, `
+		SELECT COUNT(*) FILTER (WHERE rented), COUNT(*) FILTER (WHERE rented OR NOT blocked)
+		FROM (`+utilizationDaysQuery+`) s`, weekStart, weekEnd).Scan(&rented, &available)
+	if err != nil {
+		return r, err
+	}
+	r.Utilization = utilizationPercentage(rented, available)
+
+	err = db.QueryRow(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(o.outstanding * o.rate), 0)
+		FROM (
+			SELECT i.amount - COALESCE((SELECT SUM(p.amount) FROM payments p WHERE p.invoice_id = i.id AND p.paid_at::date <= $1), 0) as outstanding,
+			       COALESCE(stored_rate(i.exchange_rate, i.exchange_rate_base, $2), fx_rate(COALESCE(i.currency, 'MAD'), $2, i.created_at::date)) as rate
+			FROM invoices i
+			WHERE i.due_date < $1 AND i.created_at::date <= $1 AND i.status != 'Cancelled'
+		) o
+		WHERE o.outstanding > 0`, weekEnd, baseCurrency).Scan(&r.OverdueInvoices, &r.OverdueOutstanding)
+	if err != nil {
+		return r, err
+	}
+
+	return r, nil
+}
+
+// QueueWeeklyReportEmail saves the branded email of a weekly report for a user to the email outbox
+func QueueWeeklyReportEmail(db *pgxpool.Pool, tenant *models.Tenant, to string, r WeeklyReport) error {
+	change := ""
+	if r.RevenueChange != nil {
+		change = fmt.Sprintf("%+.1f%%", *r.RevenueChange)
+	}
+	return queueEmail(db, tenant, mail.TemplateWeeklyReport, to, "", map[string]interface{}{
+		"From":               r.From,
+		"To":                 r.To,
+		"Revenue":            formatMoney(r.Revenue, r.BaseCurrency),
+		"RevenueChange":      change,
+		"NewBookings":        r.NewBookings,
+		"StartedRentals":     r.StartedRentals,
+		"CancelledBookings":  r.CancelledBookings,
+		"Utilization":        fmt.Sprintf("%.1f%%", r.Utilization),
+		"OverdueInvoices":    r.OverdueInvoices,
+		"OverdueOutstanding": formatMoney(r.OverdueOutstanding, r.BaseCurrency),
+	})
+}
+
+// GetWeeklyReport previews the weekly report email. ?week=YYYY-MM-DD picks the week containing that day
+// (default: last week).
+func GetWeeklyReport(c *gin.Context) {
+	weekStart := WeekStart(time.Now()).AddDate(0, 0, -7)
+	if v := c.Query("week"); v != "" {
+		day, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid week format, expected YYYY-MM-DD"})
+			return
+		}
+		weekStart = WeekStart(day)
+	}
+
+	db, tenant, err := getTenantDBForFinancials(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	report, err := BuildWeeklyReport(db, GetBaseCurrency(db, tenant.ID), weekStart)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build weekly report: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// GetReportPreferences returns the report emails the current user opted in to
+func GetReportPreferences(c *gin.Context) {
+	db, _, userID, err := getTenantDBForNotifications(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+
+	var prefs ReportPreferences
+	err = db.QueryRow(context.Background(),
+		"SELECT weekly_report FROM report_preferences WHERE user_id = $1", userID).Scan(&prefs.WeeklyReport)
+	if err != nil && err != pgx.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch report preferences: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, prefs)
+}
+
+// UpdateReportPreferences opts the current user in or out of report emails
+func UpdateReportPreferences(c *gin.Context) {
+	var req ReportPreferences
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, tenant, userID, err := getTenantDBForNotifications(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant DB"})
+		return
+	}
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	_, err = db.Exec(context.Background(),
+		`INSERT INTO report_preferences (user_id, tenant_id, weekly_report, updated_at)
+		 VALUES ($1, $2, $3, NOW())
+		 ON CONFLICT (user_id) DO UPDATE SET weekly_report = $3, updated_at = NOW()`,
+		userID, tenant.ID, req.WeeklyReport)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update report preferences: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, req)
+}
@@ -0,0 +1,196 @@
+package jobs
+
+import (
+	"car-rental-backend/internal/events"
+	"car-rental-backend/internal/handlers"
+	"car-rental-backend/internal/models"
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ReminderSender delivers dunning reminders to customers
+type ReminderSender interface {
+	SendEmail(db *pgxpool.Pool, tenant *models.Tenant, to string, reminder handlers.PaymentReminder) error
+	SendSMS(db *pgxpool.Pool, tenant *models.Tenant, to string, reminder handlers.PaymentReminder) error
+}
+
+// OutboxSender queues reminders in the tenant's email and message outboxes, which deliver them with retries
+type OutboxSender struct{}
+
+func (OutboxSender) SendEmail(db *pgxpool.Pool, tenant *models.Tenant, to string, reminder handlers.PaymentReminder) error {
+	return handlers.QueuePaymentReminderEmail(db, tenant, to, reminder)
+}
+
+func (OutboxSender) SendSMS(db *pgxpool.Pool, tenant *models.Tenant, to string, reminder handlers.PaymentReminder) error {
+	return handlers.QueuePaymentReminderMessage(db, tenant, to, reminder)
+}
+
+type overdueInvoice struct {
+	ID           string
+	CustomerID   string
+	Outstanding  float64
+	Currency     string
+	DueDate      time.Time
+	DaysOverdue  int
+	CustomerName string
+	Email        string
+	Phone        string
+}
+
+// StartDunning periodically sends reminders for overdue invoices in every tenant.
+// The interval defaults to one hour and can be changed with DUNNING_INTERVAL.
+func StartDunning(sender ReminderSender) {
+	interval := intervalFromEnv("DUNNING_INTERVAL", time.Hour)
+	log.Printf("[DUNNING] Starting dunning job (every %s)", interval)
+	every(interval, func() {
+		forEachTenant("dunning", func(tenant *models.Tenant, db *pgxpool.Pool) error {
+			return RunDunning(db, tenant, sender, time.Now())
+		})
+	})
+}
+
+// RunDunning sends the reminders due for a single tenant as of now.
+// Each invoice gets at most one reminder per stage and channel, so running it repeatedly is safe.
+func RunDunning(db *pgxpool.Pool, tenant *models.Tenant, sender ReminderSender, now time.Time) error {
+	settings, err := handlers.LoadDunningSettings(db, tenant.ID)
+	if err != nil {
+		return err
+	}
+	if !settings.Enabled || len(settings.Intervals) == 0 {
+		return nil
+	}
+
+	invoices, err := loadOverdueInvoices(db, now)
+	if err != nil {
+		return err
+	}
+
+	for _, inv := range invoices {
+		stage := dunningStage(settings.Intervals, inv.DaysOverdue)
+		if stage == 0 {
+			continue
+		}
+
+		reminder := handlers.PaymentReminder{
+			InvoiceID:    inv.ID,
+			CustomerID:   inv.CustomerID,
+			CustomerName: inv.CustomerName,
+			Outstanding:  inv.Outstanding,
+			Currency:     inv.Currency,
+			DueDate:      inv.DueDate,
+			DaysOverdue:  inv.DaysOverdue,
+		}
+
+		if settings.SendEmail && inv.Email != "" {
+			sendReminder(db, tenant.ID, inv.ID, stage, "email", inv.Email, func() error {
+				return sender.SendEmail(db, tenant, inv.Email, reminder)
+			})
+		}
+		if settings.SendSMS && inv.Phone != "" {
+			sendReminder(db, tenant.ID, inv.ID, stage, "sms", inv.Phone, func() error {
+				return sender.SendSMS(db, tenant, inv.Phone, reminder)
+			})
+		}
+
+		// Staff are told once per stage, whether or not the customer could be reached
+		sendReminder(db, tenant.ID, inv.ID, stage, "staff", "", func() error {
+			events.Publish(events.Event{
+				Type:     events.InvoiceOverdue,
+				Tenant:   tenant,
+				DB:       db,
+				EntityID: inv.ID,
+				Data: map[string]interface{}{
+					"id":            inv.ID,
+					"customer_name": inv.CustomerName,
+					"days_overdue":  inv.DaysOverdue,
+					"outstanding":   fmt.Sprintf("%.2f %s", inv.Outstanding, inv.Currency),
+					"due_date":      inv.DueDate.Format("2006-01-02"),
+					"stage":         stage,
+				},
+			})
+			return nil
+		})
+	}
+
+	return nil
+}
+
+// dunningStage returns how many reminder intervals have elapsed (0 = none yet)
+func dunningStage(intervals []int, daysOverdue int) int {
+	sorted := append([]int(nil), intervals...)
+	sort.Ints(sorted)
+	stage := 0
+	for _, days := range sorted {
+		if daysOverdue >= days {
+			stage++
+		}
+	}
+	return stage
+}
+
+// sendReminder claims the invoice/stage/channel slot and then delivers the reminder. The slot stays pending
+// until the reminder is handed over, so it is only marked sent once queued. If the slot was already claimed by
+// an earlier run nothing is sent, unless that attempt failed, in which case it is tried again.
+func sendReminder(db *pgxpool.Pool, tenantID, invoiceID string, stage int, channel, recipient string, deliver func() error) {
+	var reminderID string
+	err := db.QueryRow(context.Background(),
+		`INSERT INTO dunning_reminders (tenant_id, invoice_id, stage, channel, recipient, status)
+		 VALUES ($1, $2, $3, $4, $5, 'pending')
+		 ON CONFLICT (invoice_id, stage, channel) DO UPDATE SET
+		 recipient = EXCLUDED.recipient, status = 'pending', error = NULL, sent_at = NOW()
+		 WHERE dunning_reminders.status = 'failed'
+		 RETURNING id`,
+		tenantID, invoiceID, stage, channel, recipient).Scan(&reminderID)
+	if err == pgx.ErrNoRows {
+		return
+	}
+	if err != nil {
+		log.Printf("[DUNNING] Failed to record %s reminder for invoice %s: %v", channel, invoiceID, err)
+		return
+	}
+
+	if err := deliver(); err != nil {
+		log.Printf("[DUNNING] Failed to send %s reminder for invoice %s: %v", channel, invoiceID, err)
+		db.Exec(context.Background(),
+			"UPDATE dunning_reminders SET status = 'failed', error = $1 WHERE id = $2", err.Error(), reminderID)
+		return
+	}
+	db.Exec(context.Background(),
+		"UPDATE dunning_reminders SET status = 'sent', sent_at = NOW() WHERE id = $1", reminderID)
+}
+
+func loadOverdueInvoices(db *pgxpool.Pool, now time.Time) ([]overdueInvoice, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT i.id, COALESCE(cust.id::text, ''),
+		       i.amount - COALESCE((SELECT SUM(p.amount) FROM payments p WHERE p.invoice_id = i.id), 0) as outstanding,
+		       COALESCE(i.currency, 'MAD'), i.due_date, ($1::date - i.due_date) as days_overdue,
+		       COALESCE(cust.first_name || ' ' || cust.last_name, 'customer'),
+		       COALESCE(cust.email, ''), COALESCE(cust.phone, '')
+		FROM invoices i
+		JOIN bookings b ON i.booking_id = b.id
+		LEFT JOIN customers cust ON b.customer_id = cust.id
+		WHERE i.due_date < $1::date AND i.status NOT IN ('Paid', 'Cancelled')
+	`, now.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invoices []overdueInvoice
+	for rows.Next() {
+		var inv overdueInvoice
+		if err := rows.Scan(&inv.ID, &inv.CustomerID, &inv.Outstanding, &inv.Currency, &inv.DueDate, &inv.DaysOverdue, &inv.CustomerName, &inv.Email, &inv.Phone); err != nil {
+			return nil, err
+		}
+		if inv.Outstanding > 0 {
+			invoices = append(invoices, inv)
+		}
+	}
+	return invoices, rows.Err()
+}
@@ -0,0 +1,71 @@
+package jobs
+
+import (
+	"car-rental-backend/internal/database"
+	"car-rental-backend/internal/models"
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// forEachTenant runs fn against every shop tenant database.
+// The platform admin tenant is skipped, and a failing tenant does not stop the others.
+func forEachTenant(name string, fn func(tenant *models.Tenant, db *pgxpool.Pool) error) {
+	rows, err := database.DB.Query(context.Background(),
+		"SELECT id, name, subdomain, db_name, subscription_tier FROM tenants WHERE subdomain != 'admin'")
+	if err != nil {
+		log.Printf("[JOBS] %s: failed to list tenants: %v", name, err)
+		return
+	}
+
+	var tenants []models.Tenant
+	for rows.Next() {
+		var t models.Tenant
+		if err := rows.Scan(&t.ID, &t.Name, &t.Subdomain, &t.DBName, &t.SubscriptionTier); err != nil {
+			continue
+		}
+		tenants = append(tenants, t)
+	}
+	rows.Close()
+
+	for i := range tenants {
+		tenant := &tenants[i]
+		db, err := database.GetTenantDB(tenant.DBName)
+		if err != nil {
+			log.Printf("[JOBS] %s: failed to connect to tenant DB %s: %v", name, tenant.DBName, err)
+			continue
+		}
+		if err := fn(tenant, db); err != nil {
+			log.Printf("[JOBS] %s: tenant %s failed: %v", name, tenant.Subdomain, err)
+		}
+	}
+}
+
+// every runs fn immediately and then on each tick of interval, in the background
+func every(interval time.Duration, fn func()) {
+	go func() {
+		fn()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			fn()
+		}
+	}()
+}
+
+// intervalFromEnv reads a duration (e.g. "1h", "30m") from the environment
+func intervalFromEnv(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("[JOBS] Invalid %s=%q, using %s", key, v, def)
+		return def
+	}
+	return d
+}
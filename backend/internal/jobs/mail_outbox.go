@@ -0,0 +1,26 @@
+package jobs
+
+import (
+	"car-rental-backend/internal/mail"
+	"car-rental-backend/internal/models"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// StartMailOutbox delivers the queued emails of every tenant, retrying failed ones with backoff.
+// The interval defaults to one minute and can be changed with MAIL_OUTBOX_INTERVAL.
+func StartMailOutbox(mailer mail.Mailer) {
+	interval := intervalFromEnv("MAIL_OUTBOX_INTERVAL", time.Minute)
+	log.Printf("[MAIL] Starting mail outbox job (every %s)", interval)
+	every(interval, func() {
+		forEachTenant("mail outbox", func(tenant *models.Tenant, db *pgxpool.Pool) error {
+			sent, err := mail.DeliverPending(db, mailer, time.Now())
+			if sent > 0 {
+				log.Printf("[MAIL] %s: sent %d emails", tenant.Subdomain, sent)
+			}
+			return err
+		})
+	})
+}
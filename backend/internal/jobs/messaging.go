@@ -0,0 +1,41 @@
+package jobs
+
+import (
+	"car-rental-backend/internal/database"
+	"car-rental-backend/internal/handlers"
+	"car-rental-backend/internal/messaging"
+	"car-rental-backend/internal/models"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// StartMessaging queues the pickup and return reminders of every tenant and delivers their text messages,
+// retrying failed ones with backoff. The interval defaults to one minute and can be changed with
+// MESSAGE_OUTBOX_INTERVAL.
+func StartMessaging(provider messaging.Provider) {
+	interval := intervalFromEnv("MESSAGE_OUTBOX_INTERVAL", time.Minute)
+	log.Printf("[MESSAGING] Starting messaging job (every %s)", interval)
+	every(interval, func() {
+		if err := messaging.PruneRoutes(database.DB, time.Now()); err != nil {
+			log.Printf("[MESSAGING] Failed to prune message routes: %v", err)
+		}
+		forEachTenant("messaging", func(tenant *models.Tenant, db *pgxpool.Pool) error {
+			now := time.Now()
+			if _, err := handlers.QueueBookingReminders(db, tenant, now); err != nil {
+				return err
+			}
+			// Delivery reports only carry the provider's message ID, so the tenant is recorded for it
+			sent, err := messaging.DeliverPending(db, provider, now, func(providerMessageID string) {
+				if err := messaging.SaveRoute(database.DB, providerMessageID, tenant.ID); err != nil {
+					log.Printf("[MESSAGING] Failed to save route of %s: %v", providerMessageID, err)
+				}
+			})
+			if sent > 0 {
+				log.Printf("[MESSAGING] %s: sent %d messages", tenant.Subdomain, sent)
+			}
+			return err
+		})
+	})
+}
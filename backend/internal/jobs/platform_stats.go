@@ -0,0 +1,19 @@
+package jobs
+
+import (
+	"car-rental-backend/internal/handlers"
+	"log"
+	"time"
+)
+
+// StartPlatformStats refreshes the cross-tenant analytics snapshot shown to super admins.
+// The interval defaults to one hour and can be changed with PLATFORM_STATS_INTERVAL.
+func StartPlatformStats() {
+	interval := intervalFromEnv("PLATFORM_STATS_INTERVAL", time.Hour)
+	log.Printf("[STATS] Starting platform stats job (every %s)", interval)
+	every(interval, func() {
+		if err := handlers.RefreshPlatformStats(); err != nil {
+			log.Printf("[JOBS] platform stats: %v", err)
+		}
+	})
+}
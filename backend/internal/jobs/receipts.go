@@ -0,0 +1,21 @@
+package jobs
+
+import (
+	"car-rental-backend/internal/handlers"
+	"car-rental-backend/internal/models"
+	"log"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// MigrateReceipts moves expense receipts that are still in the public uploads directory into private storage.
+// It runs once, in the background, at startup.
+func MigrateReceipts() {
+	go forEachTenant("receipts migration", func(tenant *models.Tenant, db *pgxpool.Pool) error {
+		moved, err := handlers.MigrateExpenseReceipts(db, tenant)
+		if moved > 0 {
+			log.Printf("[RECEIPTS] %s: moved %d receipts to private storage", tenant.Subdomain, moved)
+		}
+		return err
+	})
+}
@@ -0,0 +1,165 @@
+package jobs
+
+import (
+	"car-rental-backend/internal/events"
+	"car-rental-backend/internal/handlers"
+	"car-rental-backend/internal/models"
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type recurringExpense struct {
+	ID          string
+	CategoryID  *string
+	Category    string
+	CarID       *string
+	Amount      float64
+	Currency    string
+	Description string
+	Frequency   string
+	StartDate   time.Time
+	EndDate     *time.Time
+	NextRunDate time.Time
+}
+
+// StartRecurringExpenses periodically books the recurring expenses that are due in every tenant.
+// The interval defaults to one hour and can be changed with RECURRING_EXPENSES_INTERVAL.
+func StartRecurringExpenses() {
+	interval := intervalFromEnv("RECURRING_EXPENSES_INTERVAL", time.Hour)
+	log.Printf("[RECURRING] Starting recurring expenses job (every %s)", interval)
+	every(interval, func() {
+		forEachTenant("recurring expenses", func(tenant *models.Tenant, db *pgxpool.Pool) error {
+			return RunRecurringExpenses(db, tenant, time.Now())
+		})
+	})
+}
+
+// RunRecurringExpenses creates one expense per missed occurrence of every active recurring expense
+// and moves its next_run_date forward, so a server that was down catches up on the next run.
+// Occurrences in a closed accounting period are booked on the first open day instead, and staff are told.
+func RunRecurringExpenses(db *pgxpool.Pool, tenant *models.Tenant, now time.Time) error {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	var closedUntil *time.Time
+	err := db.QueryRow(context.Background(),
+		"SELECT closed_until FROM accounting_settings WHERE tenant_id = $1", tenant.ID).Scan(&closedUntil)
+	if err != nil && err != pgx.ErrNoRows {
+		return err
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT r.id, r.category_id::text, COALESCE(ec.name, 'Other'), r.car_id::text, r.amount, r.currency,
+		       COALESCE(r.description, ''), r.frequency, r.start_date, r.end_date, r.next_run_date
+		FROM recurring_expenses r
+		LEFT JOIN expense_categories ec ON r.category_id = ec.id
+		WHERE r.active = true AND r.next_run_date <= $1`, today)
+	if err != nil {
+		return err
+	}
+
+	var due []recurringExpense
+	for rows.Next() {
+		var r recurringExpense
+		if err := rows.Scan(&r.ID, &r.CategoryID, &r.Category, &r.CarID, &r.Amount, &r.Currency,
+			&r.Description, &r.Frequency, &r.StartDate, &r.EndDate, &r.NextRunDate); err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, r)
+	}
+	rows.Close()
+
+	for _, r := range due {
+		if err := bookRecurringExpense(db, tenant, r, today, closedUntil); err != nil {
+			log.Printf("[RECURRING] Failed to book recurring expense %s: %v", r.ID, err)
+		}
+	}
+	return nil
+}
+
+// bookRecurringExpense inserts the expenses due up to today and advances next_run_date in one transaction.
+// The series is locked first: when another instance is booking it, or already has, it is skipped.
+func bookRecurringExpense(db *pgxpool.Pool, tenant *models.Tenant, r recurringExpense, today time.Time, closedUntil *time.Time) error {
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	var locked bool
+	err = tx.QueryRow(context.Background(), `
+		SELECT true FROM recurring_expenses
+		WHERE id = $1 AND active = true AND next_run_date = $2
+		FOR UPDATE SKIP LOCKED`, r.ID, r.NextRunDate).Scan(&locked)
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	next := r.NextRunDate
+	active := true
+	moved := 0
+	var firstOpenDay time.Time
+	if closedUntil != nil {
+		firstOpenDay = closedUntil.AddDate(0, 0, 1)
+	}
+	for !next.After(today) {
+		if r.EndDate != nil && next.After(*r.EndDate) {
+			active = false
+			break
+		}
+		date := next
+		if closedUntil != nil && !date.After(*closedUntil) {
+			date = firstOpenDay
+			moved++
+		}
+		_, err := tx.Exec(context.Background(),
+			`INSERT INTO expenses (tenant_id, amount, currency, category_id, category, car_id, date, description, recurring_expense_id)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			tenant.ID, r.Amount, r.Currency, r.CategoryID, r.Category, r.CarID, date, r.Description, r.ID)
+		if err != nil {
+			return err
+		}
+		next = handlers.NextOccurrence(r.Frequency, r.StartDate, next)
+	}
+	if r.EndDate != nil && next.After(*r.EndDate) {
+		active = false
+	}
+
+	_, err = tx.Exec(context.Background(),
+		"UPDATE recurring_expenses SET next_run_date = $1, active = $2, updated_at = NOW() WHERE id = $3",
+		next, active, r.ID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(context.Background()); err != nil {
+		return err
+	}
+
+	if moved > 0 {
+		description := r.Description
+		if description == "" {
+			description = r.Category
+		}
+		events.Publish(events.Event{
+			Type:     events.RecurringExpenseMoved,
+			Tenant:   tenant,
+			DB:       db,
+			EntityID: r.ID,
+			Data: map[string]interface{}{
+				"id":           r.ID,
+				"description":  description,
+				"occurrences":  moved,
+				"booked_on":    firstOpenDay.Format("2006-01-02"),
+				"closed_until": closedUntil.Format("2006-01-02"),
+			},
+		})
+	}
+	return nil
+}
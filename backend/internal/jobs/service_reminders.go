@@ -0,0 +1,23 @@
+package jobs
+
+import (
+	"car-rental-backend/internal/handlers"
+	"car-rental-backend/internal/models"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// StartServiceReminders periodically publishes car.service_due for the cars of every tenant whose service is
+// close. The interval defaults to one hour and can be changed with SERVICE_REMINDER_INTERVAL.
+func StartServiceReminders() {
+	interval := intervalFromEnv("SERVICE_REMINDER_INTERVAL", time.Hour)
+	log.Printf("[SERVICE] Starting service reminder job (every %s)", interval)
+	every(interval, func() {
+		forEachTenant("service reminders", func(tenant *models.Tenant, db *pgxpool.Pool) error {
+			_, err := handlers.PublishServiceDueEvents(db, tenant, time.Now())
+			return err
+		})
+	})
+}
@@ -0,0 +1,26 @@
+package jobs
+
+import (
+	"car-rental-backend/internal/models"
+	"car-rental-backend/internal/webhooks"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// StartWebhooks posts the queued webhook deliveries of every tenant, retrying failed ones with exponential
+// backoff. The interval defaults to 30 seconds and can be changed with WEBHOOKS_INTERVAL.
+func StartWebhooks() {
+	interval := intervalFromEnv("WEBHOOKS_INTERVAL", 30*time.Second)
+	log.Printf("[WEBHOOKS] Starting webhooks job (every %s)", interval)
+	every(interval, func() {
+		forEachTenant("webhooks", func(tenant *models.Tenant, db *pgxpool.Pool) error {
+			delivered, err := webhooks.DeliverPending(db, time.Now())
+			if delivered > 0 {
+				log.Printf("[WEBHOOKS] %s: delivered %d webhooks", tenant.Subdomain, delivered)
+			}
+			return err
+		})
+	})
+}
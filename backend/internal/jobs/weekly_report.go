@@ -0,0 +1,113 @@
+package jobs
+
+import (
+	"car-rental-backend/internal/handlers"
+	"car-rental-backend/internal/models"
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// defaultWeeklyReportHour is the hour on Monday from which last week's report is sent
+const defaultWeeklyReportHour = 7
+
+type reportRecipient struct {
+	UserID string
+	Email  string
+	Name   string
+}
+
+// StartWeeklyReports emails last week's summary every Monday morning to the users who opted in.
+// The check runs every WEEKLY_REPORT_INTERVAL (default 1h); reports go out from WEEKLY_REPORT_HOUR (default 7).
+func StartWeeklyReports() {
+	interval := intervalFromEnv("WEEKLY_REPORT_INTERVAL", time.Hour)
+	hour := defaultWeeklyReportHour
+	if v := os.Getenv("WEEKLY_REPORT_HOUR"); v != "" {
+		if h, err := strconv.Atoi(v); err == nil && h >= 0 && h < 24 {
+			hour = h
+		} else {
+			log.Printf("[JOBS] Invalid WEEKLY_REPORT_HOUR=%q, using %d", v, hour)
+		}
+	}
+	log.Printf("[REPORTS] Starting weekly report job (every %s, Mondays from %02d:00)", interval, hour)
+	every(interval, func() {
+		now := time.Now()
+		if now.Weekday() != time.Monday || now.Hour() < hour {
+			return
+		}
+		forEachTenant("weekly reports", func(tenant *models.Tenant, db *pgxpool.Pool) error {
+			return RunWeeklyReports(db, tenant, now)
+		})
+	})
+}
+
+// RunWeeklyReports queues the report of the week before now in the email outbox for every opted-in user of a
+// tenant. Each user gets the report of a given week at most once, so running it repeatedly is safe.
+func RunWeeklyReports(db *pgxpool.Pool, tenant *models.Tenant, now time.Time) error {
+	recipients, err := loadReportRecipients(db)
+	if err != nil || len(recipients) == 0 {
+		return err
+	}
+
+	weekStart := handlers.WeekStart(now).AddDate(0, 0, -7)
+	report, err := handlers.BuildWeeklyReport(db, handlers.GetBaseCurrency(db, tenant.ID), weekStart)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range recipients {
+		var deliveryID string
+		err := db.QueryRow(context.Background(),
+			`INSERT INTO report_deliveries (tenant_id, user_id, report, period_start, recipient, status)
+			 VALUES ($1, $2, 'weekly', $3, $4, 'pending')
+			 ON CONFLICT (user_id, report, period_start) DO UPDATE SET
+			 recipient = EXCLUDED.recipient, status = 'pending', error = NULL, sent_at = NOW()
+			 WHERE report_deliveries.status = 'failed'
+			 RETURNING id`,
+			tenant.ID, r.UserID, weekStart, r.Email).Scan(&deliveryID)
+		if err == pgx.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			log.Printf("[REPORTS] Failed to record weekly report for %s: %v", r.Email, err)
+			continue
+		}
+
+		if err := handlers.QueueWeeklyReportEmail(db, tenant, r.Email, report); err != nil {
+			log.Printf("[REPORTS] Failed to queue weekly report for %s: %v", r.Email, err)
+			db.Exec(context.Background(),
+				"UPDATE report_deliveries SET status = 'failed', error = $1 WHERE id = $2", err.Error(), deliveryID)
+			continue
+		}
+		db.Exec(context.Background(),
+			"UPDATE report_deliveries SET status = 'sent', sent_at = NOW() WHERE id = $1", deliveryID)
+	}
+	return nil
+}
+
+func loadReportRecipients(db *pgxpool.Pool) ([]reportRecipient, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT u.id, u.email, COALESCE(u.first_name, '')
+		FROM report_preferences p
+		JOIN users u ON p.user_id = u.id
+		WHERE p.weekly_report`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipients []reportRecipient
+	for rows.Next() {
+		var r reportRecipient
+		if err := rows.Scan(&r.UserID, &r.Email, &r.Name); err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, rows.Err()
+}
@@ -0,0 +1,167 @@
+package mail
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is an email with a plain text body and an optional HTML alternative
+type Message struct {
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers emails
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// FromEnv returns an SMTP mailer when SMTP_HOST is set, a FileMailer writing to MAIL_DIR when that is set,
+// and a LogMailer otherwise
+func FromEnv() Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		if dir := os.Getenv("MAIL_DIR"); dir != "" {
+			log.Printf("[MAIL] SMTP_HOST not set, emails will be written to %s", dir)
+			return &FileMailer{Dir: dir, From: os.Getenv("SMTP_FROM")}
+		}
+		log.Println("[MAIL] SMTP_HOST not set, emails will only be logged")
+		return LogMailer{}
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	return &SMTPMailer{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+	}
+}
+
+// SMTPMailer sends emails through an SMTP server (STARTTLS is used when the server offers it)
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPMailer) Send(msg Message) error {
+	if len(msg.To) == 0 {
+		return fmt.Errorf("no recipient")
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	from := s.From
+	if from == "" {
+		from = s.Username
+	}
+	return smtp.SendMail(s.Host+":"+s.Port, auth, from, msg.To, buildMIME(from, msg))
+}
+
+// buildMIME renders the message headers and body, as multipart/alternative when there is an HTML part
+func buildMIME(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(msg.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", encodeHeader(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+
+	if msg.HTML == "" {
+		b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+		b.WriteString(msg.Text)
+		return []byte(b.String())
+	}
+
+	boundary := fmt.Sprintf("boundary-%d", time.Now().UnixNano())
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+	fmt.Fprintf(&b, "--%s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n", boundary, msg.Text)
+	fmt.Fprintf(&b, "--%s\r\nContent-Type: text/html; charset=UTF-8\r\n\r\n%s\r\n", boundary, msg.HTML)
+	fmt.Fprintf(&b, "--%s--\r\n", boundary)
+	return []byte(b.String())
+}
+
+// encodeHeader encodes non-ASCII subjects (RFC 2047)
+func encodeHeader(s string) string {
+	for _, r := range s {
+		if r > 127 {
+			return "=?UTF-8?B?" + base64.StdEncoding.EncodeToString([]byte(s)) + "?="
+		}
+	}
+	return s
+}
+
+// FileMailer writes each email as an .eml file in Dir, to inspect emails during development
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (f *FileMailer) Send(msg Message) error {
+	if len(msg.To) == 0 {
+		return fmt.Errorf("no recipient")
+	}
+	if err := os.MkdirAll(f.Dir, 0755); err != nil {
+		return err
+	}
+	from := f.From
+	if from == "" {
+		from = "no-reply@localhost"
+	}
+	name := fmt.Sprintf("%s_%s.eml", time.Now().Format("20060102-150405.000000000"), safeFileName(msg.To[0]))
+	return os.WriteFile(filepath.Join(f.Dir, name), buildMIME(from, msg), 0644)
+}
+
+// safeFileName keeps letters, digits, dots, dashes and @ of s
+func safeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' || r == '@' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// MemoryMailer keeps sent emails in memory. It is meant for tests and local development.
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (m *MemoryMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns a copy of the emails sent so far
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+// LogMailer only logs emails. It is used until an SMTP server is configured.
+type LogMailer struct{}
+
+func (LogMailer) Send(msg Message) error {
+	log.Printf("[MAIL] email to %s: %s", strings.Join(msg.To, ", "), msg.Subject)
+	return nil
+}
@@ -0,0 +1,136 @@
+package mail
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Emails are not sent inline: they are saved in the email_outbox table and delivered by DeliverPending, so
+// they survive restarts and a failing mail server only delays them.
+
+// MaxAttempts is how many times delivery is tried before an email is marked failed
+const MaxAttempts = 6
+
+// outboxBatchSize is how many emails one DeliverPending call sends at most
+const outboxBatchSize = 50
+
+// retryDelays is the wait after each failed attempt
+var retryDelays = []time.Duration{time.Minute, 5 * time.Minute, 30 * time.Minute, 2 * time.Hour, 6 * time.Hour}
+
+// OutboxEmail links a queued email to what it is about, for the customer timeline and the delivery log
+type OutboxEmail struct {
+	TenantID   string
+	Template   string
+	CustomerID string // Optional
+	Message    Message
+}
+
+// queryRower is implemented by both *pgxpool.Pool and pgx.Tx
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Enqueue saves an email to the outbox; it is sent by the next DeliverPending run
+func Enqueue(db queryRower, email OutboxEmail) (string, error) {
+	var customerID interface{}
+	if email.CustomerID != "" {
+		customerID = email.CustomerID
+	}
+	var id string
+	err := db.QueryRow(context.Background(),
+		`INSERT INTO email_outbox (tenant_id, template, customer_id, recipients, subject, text_body, html_body)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		email.TenantID, email.Template, customerID, email.Message.To, email.Message.Subject,
+		email.Message.Text, email.Message.HTML).Scan(&id)
+	return id, err
+}
+
+// DeliverPending sends the outbox emails that are due. Emails are claimed with SKIP LOCKED, so several
+// instances can run it at the same time. It returns how many emails were sent.
+func DeliverPending(db *pgxpool.Pool, mailer Mailer, now time.Time) (int, error) {
+	ctx := context.Background()
+
+	// Claiming pushes next_attempt_at forward, so an instance that dies mid-batch only delays its emails
+	rows, err := db.Query(ctx, `
+		UPDATE email_outbox SET next_attempt_at = $1::timestamptz + INTERVAL '10 minutes'
+		WHERE id IN (
+			SELECT id FROM email_outbox
+			WHERE status = 'pending' AND next_attempt_at <= $1
+			ORDER BY next_attempt_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, recipients, subject, COALESCE(text_body, ''), COALESCE(html_body, ''), attempts`,
+		now, outboxBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	type claimed struct {
+		id       string
+		msg      Message
+		attempts int
+	}
+	var batch []claimed
+	for rows.Next() {
+		var e claimed
+		if err := rows.Scan(&e.id, &e.msg.To, &e.msg.Subject, &e.msg.Text, &e.msg.HTML, &e.attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, e := range batch {
+		attempts := e.attempts + 1
+		if sendErr := mailer.Send(e.msg); sendErr != nil {
+			status, next := "pending", now.Add(retryDelay(attempts))
+			if attempts >= MaxAttempts {
+				status = "failed"
+			}
+			log.Printf("[MAIL] Delivery of %s failed (attempt %d): %v", e.id, attempts, sendErr)
+			_, err = db.Exec(ctx,
+				"UPDATE email_outbox SET status = $2, attempts = $3, next_attempt_at = $4, last_error = $5 WHERE id = $1",
+				e.id, status, attempts, next, sendErr.Error())
+		} else {
+			sent++
+			_, err = db.Exec(ctx,
+				"UPDATE email_outbox SET status = 'sent', attempts = $2, sent_at = $3, last_error = NULL WHERE id = $1",
+				e.id, attempts, now)
+		}
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// Retry puts a failed email back in the queue
+func Retry(db *pgxpool.Pool, id string) (bool, error) {
+	result, err := db.Exec(context.Background(),
+		"UPDATE email_outbox SET status = 'pending', attempts = 0, next_attempt_at = NOW() WHERE id = $1 AND status = 'failed'", id)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+// retryDelay returns the wait before the next attempt after the given number of attempts
+func retryDelay(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	if attempts > len(retryDelays) {
+		return retryDelays[len(retryDelays)-1]
+	}
+	return retryDelays[attempts-1]
+}
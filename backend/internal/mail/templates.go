@@ -0,0 +1,230 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"regexp"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Templates of the emails sent to customers and staff
+const (
+	TemplateBookingConfirmation = "booking_confirmation"
+	TemplateRequestReceived     = "request_received"
+	TemplateInvoiceIssued       = "invoice_issued"
+	TemplatePasswordReset       = "password_reset"
+	TemplatePaymentReminder     = "payment_reminder"
+	TemplateWeeklyReport        = "weekly_report"
+)
+
+// Branding is the tenant look applied to emails (from the tenant's branding settings)
+type Branding struct {
+	TenantName     string
+	LogoURL        string // Absolute URL
+	PrimaryColor   string
+	SecondaryColor string
+}
+
+const (
+	defaultPrimaryColor   = "#3b82f6"
+	defaultSecondaryColor = "#10b981"
+)
+
+var hexColor = regexp.MustCompile(`^#[0-9a-fA-F]{3}([0-9a-fA-F]{3})?$`)
+
+// Each template defines "<name>_subject" and "<name>_text" (text/template) and "<name>_html" (html/template,
+// rendered inside the branded layout). Templates get the data passed to Render plus .Brand.
+const textTemplates = `
+{{define "booking_confirmation_subject"}}{{.Brand.TenantName}}: your booking is confirmed{{end}}
+{{define "booking_confirmation_text"}}Hello {{.CustomerName}},
+
+Your booking of the {{.Car}} from {{.StartDate}} to {{.EndDate}} is confirmed.{{if .Total}}
+Total: {{.Total}}{{end}}
+
+See you soon,
+{{.Brand.TenantName}}
+{{end}}
+
+{{define "request_received_subject"}}{{.Brand.TenantName}}: we received your booking request{{end}}
+{{define "request_received_text"}}Hello {{.CustomerName}},
+
+Thank you for your request for the {{.Car}} from {{.StartDate}} to {{.EndDate}}.
+We will get back to you shortly to confirm it.
+
+{{.Brand.TenantName}}
+{{end}}
+
+{{define "invoice_issued_subject"}}{{.Brand.TenantName}}: invoice {{.Number}}{{end}}
+{{define "invoice_issued_text"}}Hello {{.CustomerName}},
+
+Here is your invoice {{.Number}}.
+{{range .Lines}}
+{{.Description}}: {{.Amount}}{{end}}
+
+Total: {{.Total}}
+Due date: {{.DueDate}}
+
+{{.Brand.TenantName}}
+{{end}}
+
+{{define "password_reset_subject"}}{{.Brand.TenantName}}: reset your password{{end}}
+{{define "password_reset_text"}}Hello {{.Name}},
+
+Someone asked to reset the password of your account. To choose a new password, open this link
+within {{.ValidFor}}:
+
+{{.Link}}
+
+If it wasn't you, you can ignore this email.
+{{end}}
+
+{{define "payment_reminder_subject"}}{{.Brand.TenantName}}: payment reminder for invoice {{.Number}}{{end}}
+{{define "payment_reminder_text"}}Hello {{.CustomerName}},
+
+Our records show an outstanding balance of {{.Outstanding}} on invoice {{.Number}}, which was due on {{.DueDate}}
+({{.DaysOverdue}} days ago). Please settle it at your earliest convenience.
+
+If you have already paid, please ignore this email.
+
+{{.Brand.TenantName}}
+{{end}}
+
+{{define "weekly_report_subject"}}{{.Brand.TenantName}}: weekly summary {{.From}} - {{.To}}{{end}}
+{{define "weekly_report_text"}}{{.Brand.TenantName}} - week of {{.From}} to {{.To}}
+
+Revenue: {{.Revenue}}{{with .RevenueChange}} ({{.}} vs previous week){{end}}
+New bookings: {{.NewBookings}}
+Rentals started: {{.StartedRentals}}
+Cancellations: {{.CancelledBookings}}
+Fleet utilization: {{.Utilization}}
+Overdue invoices: {{.OverdueInvoices}} ({{.OverdueOutstanding}} outstanding)
+{{end}}
+`
+
+const htmlTemplates = `
+{{define "layout"}}<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Subject}}</title></head>
+<body style="margin: 0; padding: 0; background: #f3f4f6; font-family: Arial, sans-serif; color: #111827">
+<table width="100%" cellpadding="0" cellspacing="0"><tr><td align="center" style="padding: 24px">
+<table width="600" cellpadding="0" cellspacing="0" style="background: #ffffff; border-radius: 8px; overflow: hidden">
+<tr><td style="background: {{.Brand.PrimaryColor}}; padding: 20px; color: #ffffff; font-size: 20px; font-weight: bold">
+{{if .Brand.LogoURL}}<img src="{{.Brand.LogoURL}}" alt="{{.Brand.TenantName}}" height="40" style="vertical-align: middle">{{else}}{{.Brand.TenantName}}{{end}}
+</td></tr>
+<tr><td style="padding: 24px; font-size: 15px; line-height: 1.5">{{.Body}}</td></tr>
+<tr><td style="padding: 16px 24px; border-top: 3px solid {{.Brand.SecondaryColor}}; color: #6b7280; font-size: 12px">{{.Brand.TenantName}}</td></tr>
+</table>
+</td></tr></table>
+</body></html>{{end}}
+
+{{define "button"}}<p style="margin: 24px 0"><a href="{{.URL}}" style="background: {{.Color}}; color: #ffffff; padding: 12px 20px; border-radius: 6px; text-decoration: none">{{.Label}}</a></p>{{end}}
+
+{{define "booking_confirmation_html"}}
+<p>Hello {{.CustomerName}},</p>
+<p>Your booking is <b>confirmed</b>:</p>
+<table cellpadding="6" style="border-collapse: collapse">
+<tr><td style="color: #6b7280">Car</td><td>{{.Car}}</td></tr>
+<tr><td style="color: #6b7280">From</td><td>{{.StartDate}}</td></tr>
+<tr><td style="color: #6b7280">To</td><td>{{.EndDate}}</td></tr>
+{{if .Total}}<tr><td style="color: #6b7280">Total</td><td><b>{{.Total}}</b></td></tr>{{end}}
+</table>
+<p>See you soon!</p>
+{{end}}
+
+{{define "request_received_html"}}
+<p>Hello {{.CustomerName}},</p>
+<p>Thank you for your request for the <b>{{.Car}}</b> from {{.StartDate}} to {{.EndDate}}.</p>
+<p>We will get back to you shortly to confirm it.</p>
+{{end}}
+
+{{define "invoice_issued_html"}}
+<p>Hello {{.CustomerName}},</p>
+<p>Here is your invoice <b>{{.Number}}</b>.</p>
+<table width="100%" cellpadding="6" style="border-collapse: collapse">
+{{range .Lines}}<tr><td style="border-bottom: 1px solid #e5e7eb">{{.Description}}</td><td align="right" style="border-bottom: 1px solid #e5e7eb">{{.Amount}}</td></tr>{{end}}
+<tr><td><b>Total</b></td><td align="right"><b>{{.Total}}</b></td></tr>
+</table>
+<p>Due date: {{.DueDate}}</p>
+{{end}}
+
+{{define "password_reset_html"}}
+<p>Hello {{.Name}},</p>
+<p>Someone asked to reset the password of your account. To choose a new password, use this link within {{.ValidFor}}:</p>
+{{template "button" (button .Link "Reset my password" .Brand.PrimaryColor)}}
+<p style="color: #6b7280">If it wasn't you, you can ignore this email.</p>
+{{end}}
+
+{{define "payment_reminder_html"}}
+<p>Hello {{.CustomerName}},</p>
+<p>Our records show an outstanding balance of <b>{{.Outstanding}}</b> on invoice <b>{{.Number}}</b>, which was due on {{.DueDate}} ({{.DaysOverdue}} days ago).</p>
+<p>Please settle it at your earliest convenience.</p>
+<p style="color: #6b7280">If you have already paid, please ignore this email.</p>
+{{end}}
+
+{{define "weekly_report_html"}}
+<p><b>Week of {{.From}} to {{.To}}</b></p>
+<table cellpadding="6" style="border-collapse: collapse">
+<tr><td style="color: #6b7280">Revenue</td><td><b>{{.Revenue}}</b>{{with .RevenueChange}} ({{.}} vs previous week){{end}}</td></tr>
+<tr><td style="color: #6b7280">New bookings</td><td>{{.NewBookings}}</td></tr>
+<tr><td style="color: #6b7280">Rentals started</td><td>{{.StartedRentals}}</td></tr>
+<tr><td style="color: #6b7280">Cancellations</td><td>{{.CancelledBookings}}</td></tr>
+<tr><td style="color: #6b7280">Fleet utilization</td><td>{{.Utilization}}</td></tr>
+<tr><td style="color: #6b7280">Overdue invoices</td><td>{{.OverdueInvoices}} ({{.OverdueOutstanding}} outstanding)</td></tr>
+</table>
+{{end}}
+`
+
+var (
+	texts = texttemplate.Must(texttemplate.New("mail").Parse(textTemplates))
+	htmls = htmltemplate.Must(htmltemplate.New("mail").Funcs(htmltemplate.FuncMap{
+		"button": func(url, label, color string) map[string]string {
+			return map[string]string{"URL": url, "Label": label, "Color": color}
+		},
+	}).Parse(htmlTemplates))
+)
+
+// Render builds the email of a template for a tenant. data holds the template's fields; the recipients are left
+// for the caller to set.
+func Render(name string, brand Branding, data map[string]interface{}) (Message, error) {
+	if htmls.Lookup(name+"_html") == nil {
+		return Message{}, fmt.Errorf("unknown email template %q", name)
+	}
+	if !hexColor.MatchString(brand.PrimaryColor) {
+		brand.PrimaryColor = defaultPrimaryColor
+	}
+	if !hexColor.MatchString(brand.SecondaryColor) {
+		brand.SecondaryColor = defaultSecondaryColor
+	}
+
+	values := map[string]interface{}{}
+	for k, v := range data {
+		values[k] = v
+	}
+	values["Brand"] = brand
+
+	var subject, text, body, page bytes.Buffer
+	if err := texts.ExecuteTemplate(&subject, name+"_subject", values); err != nil {
+		return Message{}, err
+	}
+	if err := texts.ExecuteTemplate(&text, name+"_text", values); err != nil {
+		return Message{}, err
+	}
+	if err := htmls.ExecuteTemplate(&body, name+"_html", values); err != nil {
+		return Message{}, err
+	}
+	err := htmls.ExecuteTemplate(&page, "layout", map[string]interface{}{
+		"Subject": strings.TrimSpace(subject.String()),
+		"Brand":   brand,
+		"Body":    htmltemplate.HTML(body.String()),
+	})
+	if err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    page.String(),
+	}, nil
+}
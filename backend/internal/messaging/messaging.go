@@ -0,0 +1,299 @@
+package messaging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Channels messages can be sent on
+const (
+	ChannelSMS      = "sms"
+	ChannelWhatsApp = "whatsapp"
+)
+
+// Message is a text message to a phone number
+type Message struct {
+	Channel string
+	To      string // International number, digits only
+	From    string // Optional SMS sender ID or WhatsApp phone number ID; the provider default is used when empty
+	Body    string
+}
+
+// Provider delivers messages. Send returns the provider's message ID, which delivery reports refer to.
+type Provider interface {
+	Send(msg Message) (string, error)
+}
+
+// StatusUpdate is a delivery report from a provider
+type StatusUpdate struct {
+	ProviderMessageID string
+	Status            string // sent, delivered or failed
+	Error             string
+}
+
+// StatusParser is implemented by providers that report delivery status to a webhook
+type StatusParser interface {
+	ParseStatus(body []byte) ([]StatusUpdate, error)
+}
+
+// Router sends each message with the provider of its channel
+type Router struct {
+	Providers map[string]Provider
+}
+
+func (r *Router) Send(msg Message) (string, error) {
+	p, ok := r.Providers[msg.Channel]
+	if !ok {
+		return "", fmt.Errorf("no provider for channel %q", msg.Channel)
+	}
+	return p.Send(msg)
+}
+
+// Provider returns the provider of a channel, or nil
+func (r *Router) Provider(channel string) Provider {
+	return r.Providers[channel]
+}
+
+// Configured reports whether a channel has a real provider, not a FakeProvider that only logs
+func (r *Router) Configured(channel string) bool {
+	p, ok := r.Providers[channel]
+	if !ok {
+		return false
+	}
+	_, fake := p.(*FakeProvider)
+	return !fake
+}
+
+// FromEnv returns a router with an SMS gateway when SMS_GATEWAY_URL is set and the WhatsApp Business API when
+// WHATSAPP_TOKEN and WHATSAPP_PHONE_NUMBER_ID are set. Channels without configuration use a FakeProvider.
+func FromEnv() *Router {
+	router := &Router{Providers: map[string]Provider{}}
+
+	if url := os.Getenv("SMS_GATEWAY_URL"); url != "" {
+		router.Providers[ChannelSMS] = &HTTPSMSProvider{
+			URL:    url,
+			APIKey: os.Getenv("SMS_GATEWAY_API_KEY"),
+			From:   os.Getenv("SMS_FROM"),
+		}
+	} else {
+		log.Println("[MESSAGING] SMS_GATEWAY_URL not set, SMS will only be logged")
+		router.Providers[ChannelSMS] = &FakeProvider{}
+	}
+
+	token, phoneNumberID := os.Getenv("WHATSAPP_TOKEN"), os.Getenv("WHATSAPP_PHONE_NUMBER_ID")
+	if token != "" && phoneNumberID != "" {
+		router.Providers[ChannelWhatsApp] = &WhatsAppProvider{
+			APIURL:        os.Getenv("WHATSAPP_API_URL"),
+			Token:         token,
+			PhoneNumberID: phoneNumberID,
+		}
+	} else {
+		log.Println("[MESSAGING] WHATSAPP_TOKEN not set, WhatsApp messages will only be logged")
+		router.Providers[ChannelWhatsApp] = &FakeProvider{}
+	}
+
+	return router
+}
+
+var httpClient = &http.Client{Timeout: 15 * time.Second}
+
+// postJSON sends a JSON request and decodes the JSON response. Non-2xx responses are returned as errors.
+func postJSON(url, bearer string, body, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("provider returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// HTTPSMSProvider sends SMS through a JSON HTTP gateway. Messages are posted as {"to", "from", "text"} and the
+// gateway answers with the message ID in "id" or "message_id". Its delivery reports use the same ID fields with
+// a "status" and an optional "error".
+type HTTPSMSProvider struct {
+	URL    string
+	APIKey string
+	From   string // Default sender ID
+}
+
+func (p *HTTPSMSProvider) Send(msg Message) (string, error) {
+	from := msg.From
+	if from == "" {
+		from = p.From
+	}
+	var resp struct {
+		ID        string `json:"id"`
+		MessageID string `json:"message_id"`
+	}
+	err := postJSON(p.URL, p.APIKey, map[string]string{"to": "+" + msg.To, "from": from, "text": msg.Body}, &resp)
+	if err != nil {
+		return "", err
+	}
+	if resp.ID != "" {
+		return resp.ID, nil
+	}
+	return resp.MessageID, nil
+}
+
+func (p *HTTPSMSProvider) ParseStatus(body []byte) ([]StatusUpdate, error) {
+	var report struct {
+		ID        string `json:"id"`
+		MessageID string `json:"message_id"`
+		Status    string `json:"status"`
+		Error     string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &report); err != nil {
+		return nil, err
+	}
+	id := report.ID
+	if id == "" {
+		id = report.MessageID
+	}
+	if id == "" {
+		return nil, fmt.Errorf("missing message id")
+	}
+	return []StatusUpdate{{ProviderMessageID: id, Status: normalizeStatus(report.Status), Error: report.Error}}, nil
+}
+
+// WhatsAppProvider sends text messages with the WhatsApp Business Cloud API. WhatsApp only delivers free-form
+// text within 24 hours of the customer's last message; other conversations need templates approved by Meta.
+type WhatsAppProvider struct {
+	APIURL        string // Default https://graph.facebook.com/v19.0
+	Token         string
+	PhoneNumberID string // Default business phone number ID
+}
+
+func (p *WhatsAppProvider) Send(msg Message) (string, error) {
+	base := p.APIURL
+	if base == "" {
+		base = "https://graph.facebook.com/v19.0"
+	}
+	phoneNumberID := msg.From
+	if phoneNumberID == "" {
+		phoneNumberID = p.PhoneNumberID
+	}
+	var resp struct {
+		Messages []struct {
+			ID string `json:"id"`
+		} `json:"messages"`
+	}
+	err := postJSON(strings.TrimRight(base, "/")+"/"+phoneNumberID+"/messages", p.Token, map[string]interface{}{
+		"messaging_product": "whatsapp",
+		"to":                msg.To,
+		"type":              "text",
+		"text":              map[string]string{"body": msg.Body},
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Messages) == 0 {
+		return "", fmt.Errorf("no message id in WhatsApp response")
+	}
+	return resp.Messages[0].ID, nil
+}
+
+// ParseStatus reads the statuses of a WhatsApp webhook notification
+func (p *WhatsAppProvider) ParseStatus(body []byte) ([]StatusUpdate, error) {
+	var notification struct {
+		Entry []struct {
+			Changes []struct {
+				Value struct {
+					Statuses []struct {
+						ID     string `json:"id"`
+						Status string `json:"status"`
+						Errors []struct {
+							Title   string `json:"title"`
+							Message string `json:"message"`
+						} `json:"errors"`
+					} `json:"statuses"`
+				} `json:"value"`
+			} `json:"changes"`
+		} `json:"entry"`
+	}
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return nil, err
+	}
+	var updates []StatusUpdate
+	for _, entry := range notification.Entry {
+		for _, change := range entry.Changes {
+			for _, s := range change.Value.Statuses {
+				u := StatusUpdate{ProviderMessageID: s.ID, Status: normalizeStatus(s.Status)}
+				if len(s.Errors) > 0 {
+					u.Error = strings.TrimSpace(s.Errors[0].Title + ": " + s.Errors[0].Message)
+				}
+				updates = append(updates, u)
+			}
+		}
+	}
+	return updates, nil
+}
+
+// normalizeStatus maps provider statuses to sent, delivered or failed
+func normalizeStatus(status string) string {
+	switch strings.ToLower(status) {
+	case "delivered", "read":
+		return "delivered"
+	case "failed", "undelivered", "rejected", "expired":
+		return "failed"
+	default:
+		return "sent"
+	}
+}
+
+// FakeProvider logs messages and keeps them in memory. It is used for local development and until a provider
+// is configured.
+type FakeProvider struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (p *FakeProvider) Send(msg Message) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.messages = append(p.messages, msg)
+	log.Printf("[MESSAGING] %s to %s: %s", msg.Channel, msg.To, msg.Body)
+	return fmt.Sprintf("fake-%d", len(p.messages)), nil
+}
+
+// Messages returns a copy of the messages sent so far
+func (p *FakeProvider) Messages() []Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Message(nil), p.messages...)
+}
+
+// ParseStatus accepts the delivery reports of HTTPSMSProvider, to try the status webhook locally
+func (p *FakeProvider) ParseStatus(body []byte) ([]StatusUpdate, error) {
+	return (&HTTPSMSProvider{}).ParseStatus(body)
+}
@@ -0,0 +1,201 @@
+package messaging
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Like emails, messages are saved in the message_outbox table and delivered by DeliverPending. After sending,
+// the provider's delivery reports move them from sent to delivered or failed.
+
+// MaxAttempts is how many times sending is tried before a message is marked failed
+const MaxAttempts = 5
+
+// outboxBatchSize is how many messages one DeliverPending call sends at most
+const outboxBatchSize = 50
+
+// retryDelays is the wait after each failed attempt
+var retryDelays = []time.Duration{time.Minute, 5 * time.Minute, 30 * time.Minute, 2 * time.Hour}
+
+// OutboxMessage links a queued message to what it is about
+type OutboxMessage struct {
+	TenantID         string
+	Template         string
+	CustomerID       string // Optional
+	BookingID        string // Optional
+	BookingRequestID string // Optional
+	Message          Message
+}
+
+// queryRower is implemented by both *pgxpool.Pool and pgx.Tx
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Enqueue saves a message to the outbox; it is sent by the next DeliverPending run. Reminders are queued once
+// per booking and channel: a duplicate is skipped and an empty ID returned.
+func Enqueue(db queryRower, m OutboxMessage) (string, error) {
+	var id string
+	err := db.QueryRow(context.Background(),
+		`INSERT INTO message_outbox (tenant_id, channel, template, customer_id, booking_id, booking_request_id, recipient, sender, body)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		 ON CONFLICT DO NOTHING RETURNING id`,
+		m.TenantID, m.Message.Channel, m.Template, nullable(m.CustomerID), nullable(m.BookingID),
+		nullable(m.BookingRequestID), m.Message.To, nullable(m.Message.From), m.Message.Body).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	return id, err
+}
+
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// DeliverPending sends the outbox messages that are due. Messages are claimed with SKIP LOCKED, so several
+// instances can run it at the same time. It returns how many messages were sent. onSent, when set, gets the
+// provider ID of each message sent.
+func DeliverPending(db *pgxpool.Pool, provider Provider, now time.Time, onSent func(providerMessageID string)) (int, error) {
+	ctx := context.Background()
+
+	// Claiming pushes next_attempt_at forward, so an instance that dies mid-batch only delays its messages
+	rows, err := db.Query(ctx, `
+		UPDATE message_outbox SET next_attempt_at = $1::timestamptz + INTERVAL '10 minutes'
+		WHERE id IN (
+			SELECT id FROM message_outbox
+			WHERE status = 'pending' AND next_attempt_at <= $1
+			ORDER BY next_attempt_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, channel, recipient, COALESCE(sender, ''), body, attempts`,
+		now, outboxBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	type claimed struct {
+		id       string
+		msg      Message
+		attempts int
+	}
+	var batch []claimed
+	for rows.Next() {
+		var m claimed
+		if err := rows.Scan(&m.id, &m.msg.Channel, &m.msg.To, &m.msg.From, &m.msg.Body, &m.attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, m := range batch {
+		attempts := m.attempts + 1
+		providerID, sendErr := provider.Send(m.msg)
+		if sendErr != nil {
+			status, next := "pending", now.Add(retryDelay(attempts))
+			if attempts >= MaxAttempts {
+				status = "failed"
+			}
+			log.Printf("[MESSAGING] Delivery of %s failed (attempt %d): %v", m.id, attempts, sendErr)
+			_, err = db.Exec(ctx,
+				"UPDATE message_outbox SET status = $2, attempts = $3, next_attempt_at = $4, last_error = $5 WHERE id = $1",
+				m.id, status, attempts, next, sendErr.Error())
+		} else {
+			sent++
+			_, err = db.Exec(ctx,
+				`UPDATE message_outbox SET status = 'sent', attempts = $2, sent_at = $3, provider_message_id = $4, last_error = NULL
+				 WHERE id = $1`,
+				m.id, attempts, now, nullable(providerID))
+			if err == nil && providerID != "" && onSent != nil {
+				onSent(providerID)
+			}
+		}
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// UpdateStatus applies a delivery report. Only sent messages change, so reports arriving out of order don't
+// undo a final status. It returns false when no message of this database has the provider ID.
+func UpdateStatus(db *pgxpool.Pool, update StatusUpdate) (bool, error) {
+	if update.Status != "delivered" && update.Status != "failed" {
+		return false, nil
+	}
+	result, err := db.Exec(context.Background(), `
+		UPDATE message_outbox SET status = $2,
+		       delivered_at = CASE WHEN $2 = 'delivered' THEN NOW() ELSE delivered_at END,
+		       last_error = NULLIF($3, '')
+		WHERE provider_message_id = $1 AND status = 'sent'`,
+		update.ProviderMessageID, update.Status, update.Error)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+// routeRetention is how long delivery reports are expected for a sent message
+const routeRetention = 30 * 24 * time.Hour
+
+// SaveRoute records in the main database which tenant sent a provider message
+func SaveRoute(master *pgxpool.Pool, providerMessageID, tenantID string) error {
+	_, err := master.Exec(context.Background(),
+		`INSERT INTO message_routes (provider_message_id, tenant_id) VALUES ($1, $2)
+		 ON CONFLICT (provider_message_id) DO UPDATE SET tenant_id = $2, created_at = NOW()`,
+		providerMessageID, tenantID)
+	return err
+}
+
+// LookupRoute returns the tenant that sent a provider message, or "" when it is unknown
+func LookupRoute(master *pgxpool.Pool, providerMessageID string) (string, error) {
+	var tenantID string
+	err := master.QueryRow(context.Background(),
+		"SELECT tenant_id FROM message_routes WHERE provider_message_id = $1", providerMessageID).Scan(&tenantID)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	return tenantID, err
+}
+
+// PruneRoutes forgets the routes of messages too old to still get delivery reports
+func PruneRoutes(master *pgxpool.Pool, now time.Time) error {
+	_, err := master.Exec(context.Background(),
+		"DELETE FROM message_routes WHERE created_at < $1", now.Add(-routeRetention))
+	return err
+}
+
+// Retry puts a failed message back in the queue
+func Retry(db *pgxpool.Pool, id string) (bool, error) {
+	result, err := db.Exec(context.Background(),
+		`UPDATE message_outbox SET status = 'pending', attempts = 0, next_attempt_at = NOW(), provider_message_id = NULL
+		 WHERE id = $1 AND status = 'failed'`, id)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+// retryDelay returns the wait before the next attempt after the given number of attempts
+func retryDelay(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	if attempts > len(retryDelays) {
+		return retryDelays[len(retryDelays)-1]
+	}
+	return retryDelays[attempts-1]
+}
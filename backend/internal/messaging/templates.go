@@ -0,0 +1,47 @@
+package messaging
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Templates of the messages sent to customers
+const (
+	TemplateRequestReceived  = "request_received"
+	TemplateBookingConfirmed = "booking_confirmed"
+	TemplatePickupReminder   = "pickup_reminder"
+	TemplateReturnReminder   = "return_reminder"
+	TemplatePaymentReminder  = "payment_reminder"
+)
+
+// Messages are kept short so that an SMS fits in one or two parts. Templates get the data passed to Render,
+// with .TenantName.
+const messageTemplates = `
+{{define "request_received"}}{{.TenantName}}: hello {{.CustomerName}}, we received your request for the {{.Car}} from {{.StartDate}} to {{.EndDate}}. We will confirm it shortly.{{end}}
+{{define "booking_confirmed"}}{{.TenantName}}: hello {{.CustomerName}}, your booking of the {{.Car}} from {{.StartDate}} to {{.EndDate}} is confirmed.{{if .Total}} Total: {{.Total}}.{{end}}{{end}}
+{{define "pickup_reminder"}}{{.TenantName}}: reminder, you pick up the {{.Car}} tomorrow ({{.StartDate}}). Don't forget your driving licence and ID.{{end}}
+{{define "return_reminder"}}{{.TenantName}}: reminder, the {{.Car}} is due back tomorrow ({{.EndDate}}). Thank you!{{end}}
+{{define "payment_reminder"}}{{.TenantName}}: hello {{.CustomerName}}, invoice {{.Number}} (due {{.DueDate}}) still has {{.Outstanding}} outstanding. Please settle it at your earliest convenience.{{end}}
+`
+
+var templates = template.Must(template.New("messaging").Parse(messageTemplates))
+
+// Render builds the text of a message template
+func Render(name, tenantName string, data map[string]interface{}) (string, error) {
+	if templates.Lookup(name) == nil {
+		return "", fmt.Errorf("unknown message template %q", name)
+	}
+	values := map[string]interface{}{}
+	for k, v := range data {
+		values[k] = v
+	}
+	values["TenantName"] = tenantName
+
+	var body bytes.Buffer
+	if err := templates.ExecuteTemplate(&body, name, values); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(body.String()), nil
+}
@@ -41,23 +41,50 @@ func AuthMiddleware() gin.HandlerFunc {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
 			return
 		}
-
-		c.Set("user_id", claims["sub"])
-
-		// Extract role if present
-		if role, ok := claims["role"]; ok {
-			c.Set("role", role)
+		if isCustomerToken(claims) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Customer tokens cannot access staff routes"})
+			return
 		}
-
-		// Extract tenant_id if present
-		if tenantID, ok := claims["tenant_id"]; ok {
-			c.Set("token_tenant_id", tenantID)
+		if hasAudience(claims, StreamAudience) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Stream tokens can only open the event stream"})
+			return
 		}
 
+		setStaffClaims(c, claims)
 		c.Next()
 	}
 }
 
+// setStaffClaims stores the user, role and tenant of a staff token in the context
+func setStaffClaims(c *gin.Context, claims jwt.MapClaims) {
+	c.Set("user_id", claims["sub"])
+
+	// Extract role if present
+	if role, ok := claims["role"]; ok {
+		c.Set("role", role)
+	}
+
+	// Extract tenant_id if present
+	if tenantID, ok := claims["tenant_id"]; ok {
+		c.Set("token_tenant_id", tenantID)
+	}
+}
+
+// RoleMiddleware only lets through staff whose role is one of roles (case-insensitive)
+func RoleMiddleware(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, _ := c.Get("role")
+		roleStr, _ := role.(string)
+		for _, r := range roles {
+			if roleStr != "" && strings.EqualFold(roleStr, r) {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden: " + strings.Join(roles, " or ") + " role required"})
+	}
+}
+
 func SuperAdminMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		role, exists := c.Get("role")
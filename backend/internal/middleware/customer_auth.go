@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"car-rental-backend/internal/database"
+	"car-rental-backend/internal/models"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// CustomerAudience is the JWT audience of customer portal tokens. Staff routes refuse these tokens
+// and portal routes refuse everything else.
+const CustomerAudience = "customer-portal"
+
+// customerTokenTTL is how long a portal session lasts
+const customerTokenTTL = 12 * time.Hour
+
+func jwtSecret() string {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		secret = "default_secret_key"
+	}
+	return secret
+}
+
+// IssueCustomerToken signs a portal token for a customer identified by a verified contact
+// (contactType is "email" or "phone")
+func IssueCustomerToken(tenantID, contactType, contact string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"aud":          CustomerAudience,
+		"sub":          contactType + ":" + contact,
+		"tenant_id":    tenantID,
+		"contact_type": contactType,
+		"contact":      contact,
+		"exp":          time.Now().Add(customerTokenTTL).Unix(),
+	})
+	return token.SignedString([]byte(jwtSecret()))
+}
+
+// CustomerAuthMiddleware authenticates customer portal tokens and loads their tenant.
+// When the X-Subdomain header is sent it must match the token's tenant.
+func CustomerAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+			return
+		}
+		tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
+
+		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return []byte(jwtSecret()), nil
+		}, jwt.WithAudience(CustomerAudience))
+		if err != nil || !token.Valid {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return
+		}
+
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+			return
+		}
+		contactType, _ := claims["contact_type"].(string)
+		contact, _ := claims["contact"].(string)
+		tenantID, _ := claims["tenant_id"].(string)
+		if contact == "" || (contactType != "email" && contactType != "phone") {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+			return
+		}
+
+		var tenant models.Tenant
+		err = database.DB.QueryRow(context.Background(),
+			"SELECT id, name, subdomain, db_name, subscription_tier FROM tenants WHERE id = $1",
+			tenantID).Scan(&tenant.ID, &tenant.Name, &tenant.Subdomain, &tenant.DBName, &tenant.SubscriptionTier)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+			return
+		}
+		if subdomain := c.GetHeader("X-Subdomain"); subdomain != "" && subdomain != tenant.Subdomain {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Token issued for another tenant"})
+			return
+		}
+
+		c.Set("tenant", &tenant)
+		c.Set("portal_contact_type", contactType)
+		c.Set("portal_contact", contact)
+		c.Next()
+	}
+}
+
+// isCustomerToken reports whether claims belong to a customer portal token
+func isCustomerToken(claims jwt.MapClaims) bool {
+	return hasAudience(claims, CustomerAudience)
+}
+
+// hasAudience reports whether claims list the given audience
+func hasAudience(claims jwt.MapClaims, audience string) bool {
+	aud, err := claims.GetAudience()
+	if err != nil {
+		return false
+	}
+	for _, a := range aud {
+		if a == audience {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Broker carries published events to the hubs that have subscribers for them
+type Broker interface {
+	Publish(e Event) error
+}
+
+// LocalBroker dispatches events to the hub of this process. It is enough with a single API instance.
+type LocalBroker struct {
+	Hub *Hub
+}
+
+func (b LocalBroker) Publish(e Event) error {
+	b.Hub.Dispatch(e)
+	return nil
+}
+
+// notifyChannel is the Postgres channel events travel on
+const notifyChannel = "realtime_events"
+
+// maxNotifyPayload stays under the 8000 byte limit of NOTIFY payloads
+const maxNotifyPayload = 7900
+
+// PostgresBroker sends events through Postgres LISTEN/NOTIFY on the master database, so every API instance
+// receives them and dispatches them to its own hub.
+type PostgresBroker struct {
+	DB  *pgxpool.Pool
+	Hub *Hub
+}
+
+// Publish sends an event to every instance, including this one. Events too large for NOTIFY are sent without
+// their data; clients then refetch what changed.
+func (b *PostgresBroker) Publish(e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxNotifyPayload {
+		e.Data = nil
+		if payload, err = json.Marshal(e); err != nil {
+			return err
+		}
+	}
+	_, err = b.DB.Exec(context.Background(), "SELECT pg_notify($1, $2)", notifyChannel, string(payload))
+	return err
+}
+
+// Listen dispatches the events of all instances to the hub until ctx is done, reconnecting when the
+// connection is lost. Run it in its own goroutine.
+func (b *PostgresBroker) Listen(ctx context.Context) {
+	delay := time.Second
+	for ctx.Err() == nil {
+		err := b.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("[REALTIME] Listener stopped: %v (reconnecting in %s)", err, delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay < time.Minute {
+			delay *= 2
+		}
+	}
+}
+
+func (b *PostgresBroker) listen(ctx context.Context) error {
+	pooled, err := b.DB.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// The connection is taken out of the pool, so it is never reused while LISTENing
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+notifyChannel); err != nil {
+		return err
+	}
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var e Event
+		if err := json.Unmarshal([]byte(n.Payload), &e); err != nil {
+			log.Printf("[REALTIME] Ignoring invalid event: %v", err)
+			continue
+		}
+		b.Hub.Dispatch(e)
+	}
+}
+
+// FromEnv returns a PostgresBroker, already listening, when REALTIME_BACKEND is "postgres", and a LocalBroker
+// otherwise
+func FromEnv(db *pgxpool.Pool, hub *Hub) Broker {
+	if os.Getenv("REALTIME_BACKEND") == "postgres" {
+		log.Println("[REALTIME] Using Postgres LISTEN/NOTIFY")
+		b := &PostgresBroker{DB: db, Hub: hub}
+		go b.Listen(context.Background())
+		return b
+	}
+	return LocalBroker{Hub: hub}
+}
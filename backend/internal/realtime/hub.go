@@ -0,0 +1,91 @@
+package realtime
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Event is pushed to the connected users of a tenant
+type Event struct {
+	Type     string          `json:"type"` // notification, booking_request.created, booking.status_changed, ...
+	TenantID string          `json:"tenant_id"`
+	UserID   string          `json:"user_id,omitempty"` // Only this user receives it; empty for every user of the tenant
+	Data     json.RawMessage `json:"data"`
+}
+
+// NewEvent builds an event, encoding its data as JSON
+func NewEvent(eventType, tenantID, userID string, data interface{}) (Event, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{Type: eventType, TenantID: tenantID, UserID: userID, Data: raw}, nil
+}
+
+// subscriptionBuffer is how many events a slow client can fall behind before events are dropped for it
+const subscriptionBuffer = 64
+
+// Subscription receives the events of one connected user
+type Subscription struct {
+	C        chan Event
+	tenantID string
+	userID   string
+}
+
+// Hub fans events out to the subscriptions of this process
+type Hub struct {
+	mu   sync.RWMutex
+	subs map[string]map[*Subscription]struct{} // By tenant ID
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: map[string]map[*Subscription]struct{}{}}
+}
+
+// Subscribe registers a user of a tenant. Call Unsubscribe when the connection closes.
+func (h *Hub) Subscribe(tenantID, userID string) *Subscription {
+	s := &Subscription{C: make(chan Event, subscriptionBuffer), tenantID: tenantID, userID: userID}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs[tenantID] == nil {
+		h.subs[tenantID] = map[*Subscription]struct{}{}
+	}
+	h.subs[tenantID][s] = struct{}{}
+	return s
+}
+
+func (h *Hub) Unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs[s.tenantID], s)
+	if len(h.subs[s.tenantID]) == 0 {
+		delete(h.subs, s.tenantID)
+	}
+}
+
+// Dispatch sends an event to the matching subscriptions. It never blocks: a client whose buffer is full misses
+// the event, and is expected to refetch when it reconnects.
+func (h *Hub) Dispatch(e Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for s := range h.subs[e.TenantID] {
+		if e.UserID != "" && e.UserID != s.userID {
+			continue
+		}
+		select {
+		case s.C <- e:
+		default:
+		}
+	}
+}
+
+// Subscribers returns how many connections are open, for monitoring
+func (h *Hub) Subscribers() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	n := 0
+	for _, subs := range h.subs {
+		n += len(subs)
+	}
+	return n
+}
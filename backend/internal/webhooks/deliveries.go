@@ -0,0 +1,148 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Each event is saved as one webhook_deliveries row per subscribed endpoint and posted by DeliverPending, so a
+// slow or failing endpoint never delays the request that caused the event.
+
+// MaxAttempts is how many times a delivery is tried before it is marked failed
+const MaxAttempts = 8
+
+// deliveryBatchSize is how many deliveries one DeliverPending call posts at most
+const deliveryBatchSize = 50
+
+// The wait between attempts doubles from 30 seconds, up to 12 hours
+const (
+	baseRetryDelay = 30 * time.Second
+	maxRetryDelay  = 12 * time.Hour
+)
+
+// Enqueue queues an event for the tenant's active endpoints subscribed to it and returns how many deliveries
+// were queued
+func Enqueue(db *pgxpool.Pool, tenantID string, payload Payload) (int64, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	result, err := db.Exec(context.Background(), `
+		INSERT INTO webhook_deliveries (tenant_id, endpoint_id, event_id, event_type, payload)
+		SELECT $1, e.id, $2, $3, $4
+		FROM webhook_endpoints e
+		WHERE e.tenant_id = $1 AND e.active AND $3 = ANY(e.events)`,
+		tenantID, payload.ID, payload.Type, string(body))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// DeliverPending posts the deliveries that are due. Deliveries are claimed with SKIP LOCKED, so several
+// instances can run it at the same time. It returns how many succeeded.
+func DeliverPending(db *pgxpool.Pool, now time.Time) (int, error) {
+	ctx := context.Background()
+
+	// Claiming pushes next_attempt_at forward, so an instance that dies mid-batch only delays its deliveries
+	rows, err := db.Query(ctx, `
+		UPDATE webhook_deliveries d SET next_attempt_at = $1::timestamptz + INTERVAL '10 minutes'
+		FROM webhook_endpoints e
+		WHERE e.id = d.endpoint_id AND d.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= $1
+			ORDER BY next_attempt_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING d.id, e.url, e.secret, d.payload, d.attempts`,
+		now, deliveryBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	type claimed struct {
+		id, url, secret, payload string
+		attempts                 int
+	}
+	var batch []claimed
+	for rows.Next() {
+		var d claimed
+		if err := rows.Scan(&d.id, &d.url, &d.secret, &d.payload, &d.attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	succeeded := 0
+	for _, d := range batch {
+		attempts := d.attempts + 1
+		result, sendErr := Send(ctx, d.url, d.secret, d.id, []byte(d.payload), now)
+		var responseStatus interface{}
+		if result.StatusCode != 0 {
+			responseStatus = result.StatusCode
+		}
+		if sendErr != nil {
+			status, next := "pending", now.Add(retryDelay(attempts))
+			if attempts >= MaxAttempts {
+				status = "failed"
+			}
+			log.Printf("[WEBHOOKS] Delivery %s failed (attempt %d): %v", d.id, attempts, sendErr)
+			_, err = db.Exec(ctx, `
+				UPDATE webhook_deliveries SET status = $2, attempts = $3, next_attempt_at = $4, last_error = $5,
+				       response_status = $6, response_body = $7, duration_ms = $8, last_attempt_at = $9
+				WHERE id = $1`,
+				d.id, status, attempts, next, sendErr.Error(), responseStatus, result.Body, result.Duration.Milliseconds(), now)
+		} else {
+			succeeded++
+			_, err = db.Exec(ctx, `
+				UPDATE webhook_deliveries SET status = 'succeeded', attempts = $2, last_error = NULL,
+				       response_status = $3, response_body = $4, duration_ms = $5, last_attempt_at = $6, delivered_at = $6
+				WHERE id = $1`,
+				d.id, attempts, responseStatus, result.Body, result.Duration.Milliseconds(), now)
+		}
+		if err != nil {
+			return succeeded, err
+		}
+	}
+	return succeeded, nil
+}
+
+// Replay queues a new delivery of a past delivery's payload to the same endpoint and returns its ID. The payload
+// keeps its event ID, so receivers can recognise events they already processed.
+func Replay(db *pgxpool.Pool, deliveryID string) (string, error) {
+	var id string
+	err := db.QueryRow(context.Background(), `
+		INSERT INTO webhook_deliveries (tenant_id, endpoint_id, event_id, event_type, payload, replay_of)
+		SELECT d.tenant_id, d.endpoint_id, d.event_id, d.event_type, d.payload, d.id
+		FROM webhook_deliveries d
+		JOIN webhook_endpoints e ON e.id = d.endpoint_id
+		WHERE d.id = $1
+		RETURNING id`, deliveryID).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	return id, err
+}
+
+// retryDelay returns the wait before the next attempt after the given number of attempts
+func retryDelay(attempts int) time.Duration {
+	delay := baseRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
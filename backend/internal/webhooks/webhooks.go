@@ -0,0 +1,177 @@
+package webhooks
+
+import (
+	"bytes"
+	"car-rental-backend/internal/events"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Events tenants can subscribe their endpoints to
+var Events = []string{
+	events.BookingCreated,
+	events.BookingStatusChanged,
+	events.BookingRequestCreated,
+	events.InvoicePaid,
+	events.CarUpdated,
+}
+
+// IsEvent tells whether an event type can be subscribed to
+func IsEvent(eventType string) bool {
+	for _, e := range Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Payload is the JSON body posted to endpoints
+type Payload struct {
+	ID        string                 `json:"id"` // Same for every endpoint and replay of the event
+	Type      string                 `json:"type"`
+	CreatedAt time.Time              `json:"created_at"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// NewPayload builds the payload of an event with a new ID
+func NewPayload(eventType string, data map[string]interface{}, now time.Time) (Payload, error) {
+	id, err := newUUID()
+	if err != nil {
+		return Payload{}, err
+	}
+	return Payload{ID: id, Type: eventType, CreatedAt: now.UTC(), Data: data}, nil
+}
+
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40 // Version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// NewSecret returns a signing secret for an endpoint
+func NewSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature header of a body sent at a time: "t=<unix time>,v1=<hex HMAC-SHA256>". The HMAC
+// covers "<unix time>.<body>", so receivers can reject old requests as replays.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t + "."))
+	mac.Write(body)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidateURL checks an endpoint URL: http(s) with a host
+func ValidateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("URL must be an http(s) address")
+	}
+	return nil
+}
+
+var errPrivateAddress = errors.New("endpoint resolves to a private address")
+
+// client posts deliveries. Unless WEBHOOKS_ALLOW_PRIVATE is true, it refuses to connect to loopback, private and
+// link-local addresses, so that tenants can't use webhooks to reach internal services.
+var client = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		// No proxy: through one, the address check below would only see the proxy's address
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				if os.Getenv("WEBHOOKS_ALLOW_PRIVATE") == "true" {
+					return nil
+				}
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				ip := net.ParseIP(host)
+				if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+					ip.IsUnspecified() {
+					return errPrivateAddress
+				}
+				return nil
+			},
+		}).DialContext,
+	},
+	// Redirects are not followed: the endpoint must answer itself
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// Result is the outcome of one delivery attempt
+type Result struct {
+	StatusCode int
+	Body       string // Start of the response body
+	Duration   time.Duration
+}
+
+// maxResponseBody is how much of the response is kept in the delivery log
+const maxResponseBody = 2048
+
+// Send posts a payload to an endpoint. Any 2xx response is a success; other statuses are returned as errors
+// along with the result.
+func Send(ctx context.Context, endpointURL, secret, deliveryID string, body []byte, now time.Time) (Result, error) {
+	var result Result
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpointURL, bytes.NewReader(body))
+	if err != nil {
+		return result, err
+	}
+	var payload struct {
+		Type string `json:"type"`
+	}
+	json.Unmarshal(body, &payload)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "CarRental-Webhooks/1.0")
+	req.Header.Set("X-Webhook-Event", payload.Type)
+	req.Header.Set("X-Webhook-Delivery", deliveryID)
+	req.Header.Set("X-Webhook-Signature", Sign(secret, now, body))
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	result.Duration = time.Since(start)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	result.StatusCode = resp.StatusCode
+	buf := make([]byte, maxResponseBody)
+	n, _ := io.ReadFull(resp.Body, buf) // A shorter body is fine
+	result.Body = string(buf[:n])
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return result, fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return result, nil
+}
@@ -5,28 +5,44 @@ import '../config/api_config.dart';
 
 class CustomersState {
   final List<Customer> customers;
+  final String? nextCursor;
   final bool isLoading;
   final String? error;
 
   CustomersState({
     this.customers = const [],
+    this.nextCursor,
     this.isLoading = false,
     this.error,
   });
 
   CustomersState copyWith({
     List<Customer>? customers,
+    String? Function()? nextCursor,
     bool? isLoading,
     String? error,
   }) {
     return CustomersState(
       customers: customers ?? this.customers,
+      nextCursor: nextCursor != null ? nextCursor() : this.nextCursor,
       isLoading: isLoading ?? this.isLoading,
       error: error,
     );
   }
 }
 
+/// Thrown when the backend refuses to create a customer because another
+/// customer already has the same email or phone number.
+class DuplicateCustomerException implements Exception {
+  final String message;
+  final List<Customer> duplicates;
+
+  DuplicateCustomerException(this.message, this.duplicates);
+
+  @override
+  String toString() => message;
+}
+
 final customersProvider = StateNotifierProvider<CustomersNotifier, CustomersState>((ref) {
   final api = ref.read(apiServiceProvider);
   return CustomersNotifier(api);
@@ -37,22 +53,45 @@ class CustomersNotifier extends StateNotifier<CustomersState> {
 
   CustomersNotifier(this._api) : super(CustomersState());
 
-  Future<void> fetchCustomers() async {
+  /// Loads the first page of customers, or the next page when [more] is set.
+  Future<void> fetchCustomers({bool more = false}) async {
+    if (more && state.nextCursor == null) return;
     state = state.copyWith(isLoading: true, error: null);
     try {
-      final response = await _api.getList(ApiConfig.customers);
-      final customers = response.map((json) => Customer.fromJson(json)).toList();
-      state = state.copyWith(customers: customers, isLoading: false);
+      final endpoint = more
+          ? '${ApiConfig.customers}?cursor=${Uri.encodeQueryComponent(state.nextCursor!)}'
+          : ApiConfig.customers;
+      final page = await _api.get(endpoint);
+      final data = (page['data'] as List<dynamic>?) ?? [];
+      final customers = data.map((json) => Customer.fromJson(json)).toList();
+      state = state.copyWith(
+        customers: more ? [...state.customers, ...customers] : customers,
+        nextCursor: () => page['next_cursor'] as String?,
+        isLoading: false,
+      );
     } catch (e) {
       state = state.copyWith(isLoading: false, error: e.toString());
     }
   }
 
-  Future<void> createCustomer(Map<String, dynamic> customerData) async {
+  /// Creates a customer. Throws [DuplicateCustomerException] when the email or
+  /// phone is already used, unless [force] is set.
+  Future<void> createCustomer(Map<String, dynamic> customerData, {bool force = false}) async {
     state = state.copyWith(isLoading: true, error: null);
     try {
-      await _api.post(ApiConfig.customers, customerData);
+      final endpoint = force ? '${ApiConfig.customers}?force=true' : ApiConfig.customers;
+      await _api.post(endpoint, customerData);
       await fetchCustomers();
+    } on ApiException catch (e) {
+      if (e.statusCode == 409) {
+        state = state.copyWith(isLoading: false);
+        final duplicates = ((e.body['duplicates'] as List<dynamic>?) ?? [])
+            .map((json) => Customer.fromJson(json))
+            .toList();
+        throw DuplicateCustomerException(e.message, duplicates);
+      }
+      state = state.copyWith(isLoading: false, error: e.toString());
+      rethrow;
     } catch (e) {
       state = state.copyWith(isLoading: false, error: e.toString());
       rethrow;
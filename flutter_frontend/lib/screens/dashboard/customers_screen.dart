@@ -130,6 +130,16 @@ class _CustomersScreenState extends ConsumerState<CustomersScreen> {
                             },
                           ),
           ),
+          if (customersState.nextCursor != null && !customersState.isLoading)
+            Center(
+              child: Padding(
+                padding: const EdgeInsets.all(16),
+                child: TextButton(
+                  onPressed: () => ref.read(customersProvider.notifier).fetchCustomers(more: true),
+                  child: const Text('Load more'),
+                ),
+              ),
+            ),
         ],
       ),
     );
@@ -293,6 +303,20 @@ class _CustomersScreenState extends ConsumerState<CustomersScreen> {
               try {
                 await ref.read(customersProvider.notifier).createCustomer(data);
                 if (context.mounted) Navigator.pop(context);
+              } on DuplicateCustomerException catch (e) {
+                if (!context.mounted) return;
+                final force = await _confirmDuplicate(context, e);
+                if (force != true) return;
+                try {
+                  await ref.read(customersProvider.notifier).createCustomer(data, force: true);
+                  if (context.mounted) Navigator.pop(context);
+                } catch (e) {
+                  if (context.mounted) {
+                    ScaffoldMessenger.of(context).showSnackBar(
+                      SnackBar(content: Text('Error: $e')),
+                    );
+                  }
+                }
               } catch (e) {
                 if (context.mounted) {
                   ScaffoldMessenger.of(context).showSnackBar(
@@ -307,4 +331,32 @@ class _CustomersScreenState extends ConsumerState<CustomersScreen> {
       ),
     );
   }
+
+  Future<bool?> _confirmDuplicate(BuildContext context, DuplicateCustomerException e) {
+    return showDialog<bool>(
+      context: context,
+      builder: (context) => AlertDialog(
+        title: const Text('Possible duplicate'),
+        content: Column(
+          mainAxisSize: MainAxisSize.min,
+          crossAxisAlignment: CrossAxisAlignment.start,
+          children: [
+            Text(e.message),
+            const SizedBox(height: 12),
+            ...e.duplicates.map((d) => Text('• ${d.fullName} — ${d.email}${d.phone != null && d.phone!.isNotEmpty ? ' / ${d.phone}' : ''}')),
+          ],
+        ),
+        actions: [
+          TextButton(
+            onPressed: () => Navigator.pop(context, false),
+            child: const Text('Cancel'),
+          ),
+          ElevatedButton(
+            onPressed: () => Navigator.pop(context, true),
+            child: const Text('Create anyway'),
+          ),
+        ],
+      ),
+    );
+  }
 }
@@ -89,6 +89,7 @@ class ApiService {
       throw ApiException(
         statusCode: response.statusCode,
         message: error['error'] ?? 'Request failed with status ${response.statusCode}',
+        body: error,
       );
     }
   }
@@ -97,8 +98,9 @@ class ApiService {
 class ApiException implements Exception {
   final int statusCode;
   final String message;
+  final Map<String, dynamic> body;
 
-  ApiException({required this.statusCode, required this.message});
+  ApiException({required this.statusCode, required this.message, this.body = const {}});
 
   @override
   String toString() => message;
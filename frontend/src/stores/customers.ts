@@ -10,10 +10,17 @@ export interface Customer {
   phone: string
   license_number: string
   address: string
+  created_at?: string
+}
+
+interface CustomerPage {
+  data: Customer[]
+  next_cursor: string | null
 }
 
 export const useCustomersStore = defineStore('customers', () => {
   const customers = ref<Customer[]>([])
+  const nextCursor = ref<string | null>(null)
   const isLoading = ref(false)
   const error = ref<string | null>(null)
   const authStore = useAuthStore()
@@ -30,11 +37,14 @@ export const useCustomersStore = defineStore('customers', () => {
 
   const API_URL = 'http://localhost:8080/api/v1'
 
-  async function fetchCustomers() {
+  async function fetchCustomers(query = '', more = false) {
     isLoading.value = true
     error.value = null
     try {
-      const response = await fetch(`${API_URL}/customers`, {
+      const params = new URLSearchParams()
+      if (query) params.set('q', query)
+      if (more && nextCursor.value) params.set('cursor', nextCursor.value)
+      const response = await fetch(`${API_URL}/customers?${params}`, {
         headers: getHeaders(),
       })
 
@@ -42,7 +52,9 @@ export const useCustomersStore = defineStore('customers', () => {
         throw new Error('Failed to fetch customers')
       }
 
-      customers.value = await response.json()
+      const page: CustomerPage = await response.json()
+      customers.value = more ? [...customers.value, ...page.data] : page.data
+      nextCursor.value = page.next_cursor
     } catch (e: any) {
       error.value = e.message
     } finally {
@@ -76,6 +88,7 @@ export const useCustomersStore = defineStore('customers', () => {
 
   return {
     customers,
+    nextCursor,
     isLoading,
     error,
     fetchCustomers,
@@ -7,16 +7,21 @@ export interface Notification {
   title: string
   message: string
   type: 'info' | 'warning' | 'success' | 'error'
+  event_type: string | null
+  entity_id: string | null
   is_read: boolean
+  is_archived: boolean
   created_at: string
 }
 
 export const useNotificationsStore = defineStore('notifications', () => {
   const notifications = ref<Notification[]>([])
+  const nextCursor = ref<string | null>(null)
+  const unreadCount = ref(0)
   const isLoading = ref(false)
   const authStore = useAuthStore()
 
-  const unreadCount = computed(() => notifications.value.filter(n => !n.is_read).length)
+  const hasMore = computed(() => nextCursor.value !== null)
 
   const getHeaders = () => {
     const headers: HeadersInit = {
@@ -30,14 +35,19 @@ export const useNotificationsStore = defineStore('notifications', () => {
 
   const API_URL = `http://${window.location.hostname}:8080/api/v1`
 
-  async function fetchNotifications() {
+  async function fetchNotifications(loadMore = false) {
     isLoading.value = true
     try {
-      const response = await fetch(`${API_URL}/notifications`, {
+      const params = new URLSearchParams()
+      if (loadMore && nextCursor.value) params.set('cursor', nextCursor.value)
+      const response = await fetch(`${API_URL}/notifications?${params}`, {
         headers: getHeaders(),
       })
       if (!response.ok) throw new Error('Failed to fetch notifications')
-      notifications.value = await response.json()
+      const page = await response.json()
+      notifications.value = loadMore ? [...notifications.value, ...page.data] : page.data
+      nextCursor.value = page.next_cursor
+      await fetchUnreadCount()
     } catch (e) {
       console.error(e)
     } finally {
@@ -45,11 +55,22 @@ export const useNotificationsStore = defineStore('notifications', () => {
     }
   }
 
+  async function fetchUnreadCount() {
+    const response = await fetch(`${API_URL}/notifications/unread-count`, {
+      headers: getHeaders(),
+    })
+    if (!response.ok) throw new Error('Failed to fetch unread count')
+    unreadCount.value = (await response.json()).count
+  }
+
   async function markAsRead(id: string) {
     try {
       // Optimistic update
       const notif = notifications.value.find(n => n.id === id)
-      if (notif) notif.is_read = true
+      if (notif && !notif.is_read) {
+        notif.is_read = true
+        unreadCount.value = Math.max(0, unreadCount.value - 1)
+      }
 
       const response = await fetch(`${API_URL}/notifications/${id}/read`, {
         method: 'PUT',
@@ -62,11 +83,51 @@ export const useNotificationsStore = defineStore('notifications', () => {
     }
   }
 
+  async function markAllAsRead() {
+    try {
+      notifications.value.forEach(n => (n.is_read = true))
+      unreadCount.value = 0
+
+      const response = await fetch(`${API_URL}/notifications/read-all`, {
+        method: 'PUT',
+        headers: getHeaders(),
+      })
+      if (!response.ok) throw new Error('Failed to mark all as read')
+    } catch (e) {
+      console.error(e)
+    }
+  }
+
+  async function archive(id: string) {
+    await removeFromInbox(id, 'PUT', `${API_URL}/notifications/${id}/archive`)
+  }
+
+  async function remove(id: string) {
+    await removeFromInbox(id, 'DELETE', `${API_URL}/notifications/${id}`)
+  }
+
+  async function removeFromInbox(id: string, method: string, url: string) {
+    try {
+      const response = await fetch(url, { method, headers: getHeaders() })
+      if (!response.ok) throw new Error('Failed to update notification')
+      const notif = notifications.value.find(n => n.id === id)
+      if (notif && !notif.is_read) unreadCount.value = Math.max(0, unreadCount.value - 1)
+      notifications.value = notifications.value.filter(n => n.id !== id)
+    } catch (e) {
+      console.error(e)
+    }
+  }
+
   return {
     notifications,
     unreadCount,
+    hasMore,
     isLoading,
     fetchNotifications,
-    markAsRead
+    fetchUnreadCount,
+    markAsRead,
+    markAllAsRead,
+    archive,
+    remove
   }
 })
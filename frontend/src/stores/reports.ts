@@ -40,7 +40,8 @@ export const useReportsStore = defineStore('reports', () => {
         headers: getHeaders(),
       })
       if (!response.ok) throw new Error('Failed to fetch utilization')
-      utilization.value = await response.json()
+      const report = await response.json()
+      utilization.value = report.daily
     } catch (e) {
       console.error(e)
     } finally {